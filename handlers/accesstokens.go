@@ -0,0 +1,105 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/wisdommatt/todo-list-api/internal/tenant"
+	"github.com/wisdommatt/todo-list-api/services/users"
+)
+
+type createAccessTokenInput struct {
+	Name   string   `json:"name" validate:"required,max=100"`
+	Scopes []string `json:"scopes" validate:"required,max=10"`
+	// ExpiresInDays is the lifetime of the token, it never expires when zero.
+	ExpiresInDays int `json:"expiresInDays" validate:"min=0,max=365"`
+}
+
+type accessTokenApiResponse struct {
+	Status      string             `json:"status"`
+	Message     string             `json:"message"`
+	AccessToken *users.AccessToken `json:"accessToken"`
+	Token       string             `json:"token,omitempty"`
+}
+
+type getAccessTokensResponse struct {
+	Status       string              `json:"status"`
+	Message      string              `json:"message"`
+	AccessTokens []users.AccessToken `json:"accessTokens"`
+}
+
+// HandleCreateAccessTokenEndpoint is the http endpoint handler for
+// generating a personal access token for the current workspace, used by
+// scripts and integrations.
+func HandleCreateAccessTokenEndpoint(usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		user, ok := getSelfUser(rw, r, usersService)
+		if !ok {
+			return
+		}
+		var payload createAccessTokenInput
+		if !decodeJSON(rw, r, &payload) {
+			return
+		}
+		var expiresAt time.Time
+		if payload.ExpiresInDays > 0 {
+			expiresAt = time.Now().AddDate(0, 0, payload.ExpiresInDays)
+		}
+		payload.Name = strings.TrimSpace(payload.Name)
+		accessToken, token, err := usersService.CreateAccessToken(r.Context(), user.ID, tenant.WorkspaceID(r.Context()), payload.Name, payload.Scopes, expiresAt)
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(accessTokenApiResponse{
+			Status:      "success",
+			Message:     "access token created successfully, it will not be shown again",
+			AccessToken: accessToken,
+			Token:       token,
+		})
+	}
+}
+
+// HandleGetAccessTokensEndpoint is the http endpoint handler for listing the user personal access tokens.
+func HandleGetAccessTokensEndpoint(usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		user, ok := getSelfUser(rw, r, usersService)
+		if !ok {
+			return
+		}
+		accessTokens := user.AccessTokens
+		if accessTokens == nil {
+			accessTokens = []users.AccessToken{}
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(getAccessTokensResponse{
+			Status:       "success",
+			Message:      "access tokens retrieved successfully",
+			AccessTokens: accessTokens,
+		})
+	}
+}
+
+// HandleDeleteAccessTokenEndpoint is the http endpoint handler for revoking a personal access token.
+func HandleDeleteAccessTokenEndpoint(usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		user, ok := getSelfUser(rw, r, usersService)
+		if !ok {
+			return
+		}
+		err := usersService.DeleteAccessToken(r.Context(), user.ID, chi.URLParam(r, "tokenId"))
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(accessTokenApiResponse{
+			Status:  "success",
+			Message: "access token revoked successfully",
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/wisdommatt/todo-list-api/services/tasks"
+	"github.com/wisdommatt/todo-list-api/services/users"
+)
+
+// Agenda views.
+const (
+	agendaViewDay   = "day"
+	agendaViewWeek  = "week"
+	agendaViewMonth = "month"
+)
+
+type agendaResponse struct {
+	Status  string            `json:"status"`
+	Message string            `json:"message"`
+	View    string            `json:"view"`
+	From    time.Time         `json:"from"`
+	To      time.Time         `json:"to"`
+	Days    []tasks.AgendaDay `json:"days"`
+}
+
+// HandleGetAgendaEndpoint is the http endpoint handler for the day, week and
+// month views of the user calendar.
+func HandleGetAgendaEndpoint(tasksService *tasks.Service, usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		userID := chi.URLParam(r, "userId")
+		if userID != AuthUserID(r.Context()) {
+			ErrorResponse(rw, "you can only view your own agenda", http.StatusForbidden)
+			return
+		}
+		user, err := usersService.GetUser(r.Context(), userID)
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		loc, err := userLocation(r, user)
+		if err != nil {
+			ErrorResponse(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		date := time.Now().In(loc)
+		if value := r.URL.Query().Get("date"); value != "" {
+			date, err = time.ParseInLocation("2006-01-02", value, loc)
+			if err != nil {
+				ErrorResponse(rw, "date must be a valid date e.g 2022-02-18", http.StatusBadRequest)
+				return
+			}
+		}
+		view := r.URL.Query().Get("view")
+		if view == "" {
+			view = agendaViewDay
+		}
+		from, to, ok := agendaRange(view, date, user.GetPreferences().FirstWeekday())
+		if !ok {
+			ErrorResponse(rw, "view must be one of day, week or month", http.StatusBadRequest)
+			return
+		}
+		days, err := tasksService.GetAgenda(r.Context(), userID, from, to, loc)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(agendaResponse{
+			Status:  "success",
+			Message: "agenda retrieved successfully",
+			View:    view,
+			From:    from,
+			To:      to,
+			Days:    days,
+		})
+	}
+}
+
+// agendaRange returns the day, week or month containing date, weeks start
+// on weekStart. Ranges are built from wall clock dates so they follow
+// daylight saving time changes.
+func agendaRange(view string, date time.Time, weekStart time.Weekday) (time.Time, time.Time, bool) {
+	year, month, day := date.Date()
+	loc := date.Location()
+	switch view {
+	case agendaViewDay:
+		return time.Date(year, month, day, 0, 0, 0, 0, loc), time.Date(year, month, day+1, 0, 0, 0, 0, loc), true
+	case agendaViewWeek:
+		offset := (int(date.Weekday()) - int(weekStart) + 7) % 7
+		return time.Date(year, month, day-offset, 0, 0, 0, 0, loc), time.Date(year, month, day-offset+7, 0, 0, 0, 0, loc), true
+	case agendaViewMonth:
+		return time.Date(year, month, 1, 0, 0, 0, 0, loc), time.Date(year, month+1, 1, 0, 0, 0, 0, loc), true
+	}
+	return time.Time{}, time.Time{}, false
+}
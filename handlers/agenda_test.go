@@ -0,0 +1,139 @@
+package httphandlers
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestAgendaRange(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatalf("LoadLocation() error = %v", err)
+	}
+	tests := []struct {
+		name      string
+		view      string
+		date      time.Time
+		weekStart time.Weekday
+		wantFrom  string
+		wantTo    string
+		// wantHours is the length of the range.
+		wantHours float64
+	}{
+		{
+			name:      "day",
+			view:      agendaViewDay,
+			date:      time.Date(2022, 3, 9, 15, 30, 0, 0, time.UTC),
+			wantFrom:  "2022-03-09T00:00:00Z",
+			wantTo:    "2022-03-10T00:00:00Z",
+			wantHours: 24,
+		},
+		{
+			name:      "week starting on monday",
+			view:      agendaViewWeek,
+			date:      time.Date(2022, 3, 9, 15, 30, 0, 0, time.UTC),
+			weekStart: time.Monday,
+			wantFrom:  "2022-03-07T00:00:00Z",
+			wantTo:    "2022-03-14T00:00:00Z",
+			wantHours: 7 * 24,
+		},
+		{
+			name:      "week starting on sunday",
+			view:      agendaViewWeek,
+			date:      time.Date(2022, 3, 9, 15, 30, 0, 0, time.UTC),
+			weekStart: time.Sunday,
+			wantFrom:  "2022-03-06T00:00:00Z",
+			wantTo:    "2022-03-13T00:00:00Z",
+			wantHours: 7 * 24,
+		},
+		{
+			name:      "week starting on the date",
+			view:      agendaViewWeek,
+			date:      time.Date(2022, 3, 7, 0, 0, 0, 0, time.UTC),
+			weekStart: time.Monday,
+			wantFrom:  "2022-03-07T00:00:00Z",
+			wantTo:    "2022-03-14T00:00:00Z",
+			wantHours: 7 * 24,
+		},
+		{
+			name:      "week across years",
+			view:      agendaViewWeek,
+			date:      time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC),
+			weekStart: time.Monday,
+			wantFrom:  "2022-12-26T00:00:00Z",
+			wantTo:    "2023-01-02T00:00:00Z",
+			wantHours: 7 * 24,
+		},
+		{
+			name:      "month",
+			view:      agendaViewMonth,
+			date:      time.Date(2022, 2, 14, 10, 0, 0, 0, time.UTC),
+			wantFrom:  "2022-02-01T00:00:00Z",
+			wantTo:    "2022-03-01T00:00:00Z",
+			wantHours: 28 * 24,
+		},
+		{
+			name:      "december",
+			view:      agendaViewMonth,
+			date:      time.Date(2022, 12, 31, 23, 0, 0, 0, time.UTC),
+			wantFrom:  "2022-12-01T00:00:00Z",
+			wantTo:    "2023-01-01T00:00:00Z",
+			wantHours: 31 * 24,
+		},
+		{
+			name:      "spring forward day",
+			view:      agendaViewDay,
+			date:      time.Date(2022, 3, 27, 12, 0, 0, 0, london),
+			wantFrom:  "2022-03-27T00:00:00Z",
+			wantTo:    "2022-03-28T00:00:00+01:00",
+			wantHours: 23,
+		},
+		{
+			name:      "fall back day",
+			view:      agendaViewDay,
+			date:      time.Date(2022, 10, 30, 12, 0, 0, 0, london),
+			wantFrom:  "2022-10-30T00:00:00+01:00",
+			wantTo:    "2022-10-31T00:00:00Z",
+			wantHours: 25,
+		},
+		{
+			name:      "week across the spring forward",
+			view:      agendaViewWeek,
+			date:      time.Date(2022, 3, 27, 12, 0, 0, 0, london),
+			weekStart: time.Monday,
+			wantFrom:  "2022-03-21T00:00:00Z",
+			wantTo:    "2022-03-28T00:00:00+01:00",
+			wantHours: 7*24 - 1,
+		},
+		{
+			name:      "month across the fall back",
+			view:      agendaViewMonth,
+			date:      time.Date(2022, 10, 30, 12, 0, 0, 0, london),
+			wantFrom:  "2022-10-01T00:00:00+01:00",
+			wantTo:    "2022-11-01T00:00:00Z",
+			wantHours: 31*24 + 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to, ok := agendaRange(tt.view, tt.date, tt.weekStart)
+			if !ok {
+				t.Fatalf("agendaRange() ok = false, want true")
+			}
+			if got := from.Format(time.RFC3339); got != tt.wantFrom {
+				t.Errorf("agendaRange() from = %s, want %s", got, tt.wantFrom)
+			}
+			if got := to.Format(time.RFC3339); got != tt.wantTo {
+				t.Errorf("agendaRange() to = %s, want %s", got, tt.wantTo)
+			}
+			if got := to.Sub(from).Hours(); got != tt.wantHours {
+				t.Errorf("agendaRange() length = %vh, want %vh", got, tt.wantHours)
+			}
+		})
+	}
+
+	if _, _, ok := agendaRange("year", time.Now(), time.Monday); ok {
+		t.Errorf("agendaRange() ok = true for an unknown view, want false")
+	}
+}
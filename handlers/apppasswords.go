@@ -0,0 +1,96 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/wisdommatt/todo-list-api/internal/tenant"
+	"github.com/wisdommatt/todo-list-api/services/users"
+)
+
+type createAppPasswordInput struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+type appPasswordApiResponse struct {
+	Status      string             `json:"status"`
+	Message     string             `json:"message"`
+	AppPassword *users.AppPassword `json:"appPassword"`
+	Password    string             `json:"password,omitempty"`
+}
+
+type getAppPasswordsResponse struct {
+	Status       string              `json:"status"`
+	Message      string              `json:"message"`
+	AppPasswords []users.AppPassword `json:"appPasswords"`
+}
+
+// HandleCreateAppPasswordEndpoint is the http endpoint handler for
+// generating an app password for the current workspace, used by CalDAV clients.
+func HandleCreateAppPasswordEndpoint(usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		user, ok := getSelfUser(rw, r, usersService)
+		if !ok {
+			return
+		}
+		var payload createAppPasswordInput
+		if !decodeJSON(rw, r, &payload) {
+			return
+		}
+		payload.Name = strings.TrimSpace(payload.Name)
+		appPassword, password, err := usersService.CreateAppPassword(r.Context(), user.ID, tenant.WorkspaceID(r.Context()), payload.Name)
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(appPasswordApiResponse{
+			Status:      "success",
+			Message:     "app password created successfully, it will not be shown again",
+			AppPassword: appPassword,
+			Password:    password,
+		})
+	}
+}
+
+// HandleGetAppPasswordsEndpoint is the http endpoint handler for listing the user app passwords.
+func HandleGetAppPasswordsEndpoint(usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		user, ok := getSelfUser(rw, r, usersService)
+		if !ok {
+			return
+		}
+		appPasswords := user.AppPasswords
+		if appPasswords == nil {
+			appPasswords = []users.AppPassword{}
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(getAppPasswordsResponse{
+			Status:       "success",
+			Message:      "app passwords retrieved successfully",
+			AppPasswords: appPasswords,
+		})
+	}
+}
+
+// HandleDeleteAppPasswordEndpoint is the http endpoint handler for revoking an app password.
+func HandleDeleteAppPasswordEndpoint(usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		user, ok := getSelfUser(rw, r, usersService)
+		if !ok {
+			return
+		}
+		err := usersService.DeleteAppPassword(r.Context(), user.ID, chi.URLParam(r, "appPasswordId"))
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(appPasswordApiResponse{
+			Status:  "success",
+			Message: "app password revoked successfully",
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/wisdommatt/todo-list-api/services/attachments"
+	"github.com/wisdommatt/todo-list-api/services/tasks"
+)
+
+// maxMultipartMemory is the part of an upload kept in memory, the rest is buffered on disk.
+const maxMultipartMemory = 1 << 20
+
+type attachmentApiResponse struct {
+	Status     string                  `json:"status"`
+	Message    string                  `json:"message"`
+	Attachment *attachments.Attachment `json:"attachment"`
+}
+
+type getAttachmentsResponse struct {
+	Status      string                   `json:"status"`
+	Message     string                   `json:"message"`
+	Attachments []attachments.Attachment `json:"attachments"`
+}
+
+// HandleUploadAttachmentEndpoint is the http endpoint handler for uploading a task attachment.
+//
+// The file is sent as multipart form data in the "file" field.
+func HandleUploadAttachmentEndpoint(tasksService *tasks.Service, attachmentsService *attachments.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		taskID := chi.URLParam(r, "taskId")
+		authUserID := AuthUserID(r.Context())
+		task, err := tasksService.GetVisibleTask(r.Context(), taskID, authUserID)
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		if !tasksService.CanEditTask(r.Context(), task, authUserID) {
+			ErrorResponse(rw, "you are not allowed to add attachments to this task", http.StatusForbidden)
+			return
+		}
+		r.Body = http.MaxBytesReader(rw, r.Body, attachments.MaxSize+maxMultipartMemory)
+		err = r.ParseMultipartForm(maxMultipartMemory)
+		if err != nil {
+			ErrorResponse(rw, "invalid multipart payload or file too large", http.StatusBadRequest)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			ErrorResponse(rw, "file must be provided", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		if header.Size > attachments.MaxSize {
+			errMsg := fmt.Sprintf("file cannot be larger than %d bytes", attachments.MaxSize)
+			ErrorResponse(rw, errMsg, http.StatusRequestEntityTooLarge)
+			return
+		}
+		contentType, err := sniffContentType(file)
+		if err != nil || !attachments.AllowedContentTypes[contentType] {
+			ErrorResponse(rw, "file type is not allowed", http.StatusUnsupportedMediaType)
+			return
+		}
+		attachment, err := attachmentsService.CreateAttachment(r.Context(), attachments.Attachment{
+			TaskID:      taskID,
+			UserID:      authUserID,
+			FileName:    filepath.Base(header.Filename),
+			ContentType: contentType,
+			Size:        header.Size,
+		}, file)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(attachmentApiResponse{
+			Status:     "success",
+			Message:    "attachment uploaded successfully",
+			Attachment: attachment,
+		})
+	}
+}
+
+// HandleGetAttachmentsEndpoint is the http endpoint handler for retrieving task attachments.
+func HandleGetAttachmentsEndpoint(tasksService *tasks.Service, attachmentsService *attachments.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		taskID := chi.URLParam(r, "taskId")
+		_, err := tasksService.GetVisibleTask(r.Context(), taskID, AuthUserID(r.Context()))
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		taskAttachments, err := attachmentsService.GetAttachments(r.Context(), taskID)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(getAttachmentsResponse{
+			Status:      "success",
+			Message:     "attachments retrieved successfully",
+			Attachments: taskAttachments,
+		})
+	}
+}
+
+// HandleDownloadAttachmentEndpoint is the http endpoint handler for downloading a task attachment.
+func HandleDownloadAttachmentEndpoint(tasksService *tasks.Service, attachmentsService *attachments.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		attachment, ok := getTaskAttachment(rw, r, tasksService, attachmentsService)
+		if !ok {
+			return
+		}
+		content, err := attachmentsService.OpenAttachment(r.Context(), attachment)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		defer content.Close()
+		rw.Header().Set("Content-Type", attachment.ContentType)
+		rw.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+		rw.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": attachment.FileName,
+		}))
+		rw.Header().Set("X-Content-Type-Options", "nosniff")
+		rw.WriteHeader(http.StatusOK)
+		io.Copy(rw, content)
+	}
+}
+
+// HandleDeleteAttachmentEndpoint is the http endpoint handler for deleting a task attachment.
+func HandleDeleteAttachmentEndpoint(tasksService *tasks.Service, attachmentsService *attachments.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		attachment, ok := getTaskAttachment(rw, r, tasksService, attachmentsService)
+		if !ok {
+			return
+		}
+		task, err := tasksService.GetTask(r.Context(), attachment.TaskID)
+		if err != nil || !tasksService.CanEditTask(r.Context(), task, AuthUserID(r.Context())) {
+			ErrorResponse(rw, "you are not allowed to delete attachments of this task", http.StatusForbidden)
+			return
+		}
+		attachment, err = attachmentsService.DeleteAttachment(r.Context(), attachment.ID)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(attachmentApiResponse{
+			Status:     "success",
+			Message:    "attachment deleted successfully",
+			Attachment: attachment,
+		})
+	}
+}
+
+// getTaskAttachment retrieves the attachment in the url if the authenticated
+// user can see its task, writing an error response otherwise.
+func getTaskAttachment(rw http.ResponseWriter, r *http.Request, tasksService *tasks.Service, attachmentsService *attachments.Service) (*attachments.Attachment, bool) {
+	taskID := chi.URLParam(r, "taskId")
+	_, err := tasksService.GetVisibleTask(r.Context(), taskID, AuthUserID(r.Context()))
+	if err != nil {
+		problemResponse(rw, err)
+		return nil, false
+	}
+	attachment, err := attachmentsService.GetAttachment(r.Context(), chi.URLParam(r, "attachmentId"))
+	if err != nil {
+		problemResponse(rw, err)
+		return nil, false
+	}
+	if attachment.TaskID != taskID {
+		problemResponse(rw, attachments.ErrAttachmentNotFound)
+		return nil, false
+	}
+	return attachment, true
+}
+
+// sniffContentType detects the media type from the first bytes of the file
+// and rewinds it so it can be read again.
+func sniffContentType(file io.ReadSeeker) (string, error) {
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(buf[:n]))
+	return mediaType, err
+}
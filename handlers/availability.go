@@ -0,0 +1,155 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/wisdommatt/todo-list-api/services/tasks"
+	"github.com/wisdommatt/todo-list-api/services/users"
+)
+
+// maxAvailabilityRange bounds the time range free slots can be searched in.
+const maxAvailabilityRange = 31 * 24 * time.Hour
+
+// suggestedSlotsLimit is the number of free slots suggested when a new task overlaps.
+const suggestedSlotsLimit = 3
+
+type availabilityResponse struct {
+	Status  string           `json:"status"`
+	Message string           `json:"message"`
+	Slots   []tasks.TimeSlot `json:"slots"`
+}
+
+type overlappingTaskResponse struct {
+	problem
+	SuggestedSlots []tasks.TimeSlot `json:"suggestedSlots"`
+}
+
+// HandleGetAvailabilityEndpoint is the http endpoint handler for finding the
+// free slots of a user calendar.
+func HandleGetAvailabilityEndpoint(tasksService *tasks.Service, usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		userID := chi.URLParam(r, "userId")
+		if userID != AuthUserID(r.Context()) {
+			ErrorResponse(rw, "you can only view your own availability", http.StatusForbidden)
+			return
+		}
+		user, err := usersService.GetUser(r.Context(), userID)
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		opts, err := parseAvailabilityOptions(r, user.GetPreferences())
+		if err != nil {
+			ErrorResponse(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		from, to, err := parseTimeRangeParams(r, opts.Location)
+		if err != nil {
+			ErrorResponse(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if to.Sub(from) > maxAvailabilityRange {
+			ErrorResponse(rw, "the time range can not be longer than 31 days", http.StatusBadRequest)
+			return
+		}
+		duration, err := time.ParseDuration(r.URL.Query().Get("duration"))
+		if err != nil || duration <= 0 {
+			ErrorResponse(rw, "duration must be a valid duration e.g 30m or 1h30m", http.StatusBadRequest)
+			return
+		}
+		slots, err := tasksService.FindFreeSlots(r.Context(), []string{userID}, from, to, duration, opts)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(availabilityResponse{
+			Status:  "success",
+			Message: "availability retrieved successfully",
+			Slots:   slots,
+		})
+	}
+}
+
+// parseAvailabilityOptions returns the availability options of the user
+// preferences, overridden by the working hours, gap and time zone query params.
+func parseAvailabilityOptions(r *http.Request, preferences users.Preferences) (tasks.AvailabilityOptions, error) {
+	opts := tasks.UserAvailabilityOptions(preferences)
+	query := r.URL.Query()
+	if value := query.Get("tz"); value != "" {
+		loc, err := time.LoadLocation(value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid time zone")
+		}
+		opts.Location = loc
+	}
+	if query.Get("workStart") != "" || query.Get("workEnd") != "" {
+		hours, err := parseWorkingHoursParams(r)
+		if err != nil {
+			return opts, err
+		}
+		for day := range opts.WorkingHours {
+			opts.WorkingHours[day] = hours
+		}
+	}
+	if query.Get("weekends") == "true" {
+		hours, err := parseWorkingHoursParams(r)
+		if err != nil {
+			return opts, err
+		}
+		opts.WorkingHours[time.Saturday] = hours
+		opts.WorkingHours[time.Sunday] = hours
+	}
+	if value := query.Get("gap"); value != "" {
+		gap, err := time.ParseDuration(value)
+		if err != nil || gap < 0 {
+			return opts, fmt.Errorf("gap must be a valid duration e.g 15m")
+		}
+		opts.MinGap = gap
+	}
+	return opts, nil
+}
+
+// parseWorkingHoursParams reads the workStart and workEnd query params,
+// defaulting to 09:00 and 17:00.
+func parseWorkingHoursParams(r *http.Request) (tasks.WorkingHours, error) {
+	hours := tasks.WorkingHours{StartMinute: 9 * 60, EndMinute: 17 * 60}
+	var err error
+	if value := r.URL.Query().Get("workStart"); value != "" {
+		hours.StartMinute, err = users.ParseClock(value)
+		if err != nil {
+			return hours, fmt.Errorf("workStart must be a valid time e.g 09:00")
+		}
+	}
+	if value := r.URL.Query().Get("workEnd"); value != "" {
+		hours.EndMinute, err = users.ParseClock(value)
+		if err != nil {
+			return hours, fmt.Errorf("workEnd must be a valid time e.g 17:00")
+		}
+	}
+	if hours.EndMinute <= hours.StartMinute {
+		return hours, fmt.Errorf("workEnd must be after workStart")
+	}
+	return hours, nil
+}
+
+// overlappingTaskErrorResponse writes the error response of a task overlapping
+// with another one, suggesting the free slots closest to the requested time.
+func overlappingTaskErrorResponse(rw http.ResponseWriter, r *http.Request, tasksService *tasks.Service, payload tasks.Task, overlappingTask *tasks.Task, opts tasks.AvailabilityOptions) {
+	suggestedSlots := []tasks.TimeSlot{}
+	if duration := payload.EndTime.Sub(payload.StartTime); duration > 0 {
+		slots, err := tasksService.SuggestSlots(r.Context(), payload.Participants(), payload.StartTime, duration, opts, suggestedSlotsLimit)
+		if err == nil {
+			suggestedSlots = slots
+		}
+	}
+	message := fmt.Sprintf("this task if overlapping with %s, pick another time", overlappingTask.Title)
+	writeProblem(rw, http.StatusConflict, overlappingTaskResponse{
+		problem:        newProblem(http.StatusConflict, tasks.ErrTaskOverlap.Code, message),
+		SuggestedSlots: suggestedSlots,
+	})
+}
@@ -0,0 +1,697 @@
+package httphandlers
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/wisdommatt/todo-list-api/internal/caldav"
+	"github.com/wisdommatt/todo-list-api/internal/ical"
+	"github.com/wisdommatt/todo-list-api/internal/tenant"
+	"github.com/wisdommatt/todo-list-api/services/projects"
+	"github.com/wisdommatt/todo-list-api/services/shares"
+	"github.com/wisdommatt/todo-list-api/services/tasks"
+	"github.com/wisdommatt/todo-list-api/services/users"
+)
+
+// CalDAVRoot is the path the CalDAV server is mounted on.
+const CalDAVRoot = "/caldav/"
+
+// caldavUserCollection is the name of the collection of the user tasks
+// outside of projects, the other collections are named after the projects.
+const caldavUserCollection = "tasks"
+
+const caldavAllowedMethods = "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, PROPPATCH, REPORT"
+
+// CalDAVMethods are the WebDAV methods used by CalDAV clients, they must
+// be registered with the router.
+var CalDAVMethods = []string{"PROPFIND", "PROPPATCH", "REPORT", "MKCOL", "MKCALENDAR"}
+
+// caldavCollection is a calendar collection, either the user collection or a project.
+type caldavCollection struct {
+	name        string
+	projectID   string
+	ownerID     string
+	displayName string
+	writable    bool
+}
+
+// caldavObject is a task as a calendar object resource.
+type caldavObject struct {
+	task tasks.Task
+	name string
+	data []byte
+	etag string
+}
+
+type caldavHandler struct {
+	tasksService    *tasks.Service
+	usersService    *users.Service
+	projectsService *projects.Service
+}
+
+// CalDAVAuthMiddleware authenticates CalDAV clients with the user email and
+// an app password using basic auth. The request context is scoped to the
+// workspace of the app password.
+func CalDAVAuthMiddleware(usersService *users.Service) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			email, password, ok := r.BasicAuth()
+			if !ok {
+				caldavUnauthorized(rw)
+				return
+			}
+			user, appPassword, err := usersService.AuthenticateAppPassword(r.Context(), email, password)
+			if err != nil || !user.IsWorkspaceMember(appPassword.WorkspaceID) || !user.IsEmailVerified() {
+				caldavUnauthorized(rw)
+				return
+			}
+			ctx := context.WithValue(r.Context(), authUserIDKey, user.ID)
+			ctx = tenant.WithWorkspace(ctx, appPassword.WorkspaceID)
+			h.ServeHTTP(rw, r.WithContext(ctx))
+		})
+	}
+}
+
+// HandleCalDAV is the http handler of the CalDAV server, exposing the user
+// tasks and the projects of the user as calendar collections:
+//
+//	/caldav/principals/{userId}/                         the user principal
+//	/caldav/calendars/{userId}/                          the calendar home
+//	/caldav/calendars/{userId}/{collection}/             a calendar collection
+//	/caldav/calendars/{userId}/{collection}/{object}.ics a task
+func HandleCalDAV(tasksService *tasks.Service, usersService *users.Service, projectsService *projects.Service) http.HandlerFunc {
+	h := &caldavHandler{
+		tasksService:    tasksService,
+		usersService:    usersService,
+		projectsService: projectsService,
+	}
+	return h.serveHTTP
+}
+
+func (h *caldavHandler) serveHTTP(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("DAV", "1, 3, calendar-access")
+	if r.Method == http.MethodOptions {
+		rw.Header().Set("Allow", caldavAllowedMethods)
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+	user, err := h.usersService.GetUser(r.Context(), AuthUserID(r.Context()))
+	if err != nil {
+		http.Error(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+		return
+	}
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(CalDAVRoot, "/")), "/"), "/")
+	if segments[0] == "" {
+		segments = nil
+	}
+	if len(segments) >= 2 && segments[1] != user.ID {
+		http.NotFound(rw, r)
+		return
+	}
+	switch {
+	case len(segments) == 0:
+		h.serveResource(rw, r, h.rootProps(user))
+	case len(segments) == 2 && segments[0] == "principals":
+		h.serveResource(rw, r, h.principalProps(user))
+	case len(segments) == 2 && segments[0] == "calendars":
+		h.serveCalendarHome(rw, r, user)
+	case len(segments) == 3 && segments[0] == "calendars":
+		collection, ok := h.getCollection(r.Context(), user, segments[2])
+		if !ok {
+			http.NotFound(rw, r)
+			return
+		}
+		h.serveCollection(rw, r, user, collection)
+	case len(segments) == 4 && segments[0] == "calendars":
+		collection, ok := h.getCollection(r.Context(), user, segments[2])
+		if !ok {
+			http.NotFound(rw, r)
+			return
+		}
+		h.serveObject(rw, r, user, collection, segments[3])
+	default:
+		http.NotFound(rw, r)
+	}
+}
+
+// serveResource serves the PROPFIND requests of the root and principal resources.
+func (h *caldavHandler) serveResource(rw http.ResponseWriter, r *http.Request, props caldav.Props) {
+	if r.Method != "PROPFIND" {
+		caldavMethodNotAllowed(rw)
+		return
+	}
+	propfind, ok := parsePropfind(rw, r)
+	if !ok {
+		return
+	}
+	caldav.WriteMultistatus(rw, []caldav.Response{caldav.PropfindResponse(r.URL.EscapedPath(), props, propfind)}, "")
+}
+
+func (h *caldavHandler) serveCalendarHome(rw http.ResponseWriter, r *http.Request, user *users.User) {
+	if r.Method != "PROPFIND" {
+		caldavMethodNotAllowed(rw)
+		return
+	}
+	propfind, ok := parsePropfind(rw, r)
+	if !ok {
+		return
+	}
+	responses := []caldav.Response{caldav.PropfindResponse(caldavHomePath(user.ID), h.homeProps(user), propfind)}
+	if caldav.Depth(r) != 0 {
+		collections, err := h.getCollections(r.Context(), user)
+		if err != nil {
+			http.Error(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		for _, collection := range collections {
+			props, err := h.collectionProps(r.Context(), user, collection)
+			if err != nil {
+				http.Error(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+				return
+			}
+			responses = append(responses, caldav.PropfindResponse(caldavCollectionPath(user.ID, collection.name), props, propfind))
+		}
+	}
+	caldav.WriteMultistatus(rw, responses, "")
+}
+
+func (h *caldavHandler) serveCollection(rw http.ResponseWriter, r *http.Request, user *users.User, collection caldavCollection) {
+	switch r.Method {
+	case "PROPFIND":
+		propfind, ok := parsePropfind(rw, r)
+		if !ok {
+			return
+		}
+		objects, err := h.getObjects(r.Context(), user, collection)
+		if err != nil {
+			http.Error(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		props, err := h.collectionPropsWithObjects(r.Context(), user, collection, objects)
+		if err != nil {
+			http.Error(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		collectionPath := caldavCollectionPath(user.ID, collection.name)
+		responses := []caldav.Response{caldav.PropfindResponse(collectionPath, props, propfind)}
+		if caldav.Depth(r) != 0 {
+			for _, object := range objects {
+				responses = append(responses, caldav.PropfindResponse(collectionPath+url.PathEscape(object.name), objectProps(object, !propfind.AllProp), propfind))
+			}
+		}
+		caldav.WriteMultistatus(rw, responses, "")
+	case "REPORT":
+		h.serveReport(rw, r, user, collection)
+	case "PROPPATCH":
+		h.serveProppatch(rw, r)
+	case "MKCOL", "MKCALENDAR", http.MethodPut, http.MethodDelete:
+		caldav.WriteError(rw, http.StatusForbidden, "<d:need-privileges/>")
+	default:
+		caldavMethodNotAllowed(rw)
+	}
+}
+
+// serveProppatch refuses the changes of collection properties e.g colors,
+// which are not stored.
+func (h *caldavHandler) serveProppatch(rw http.ResponseWriter, r *http.Request) {
+	root, err := caldav.ParseBody(r.Body)
+	if err != nil || !root.Is(caldav.NamespaceDAV, "propertyupdate") {
+		http.Error(rw, "invalid propertyupdate body", http.StatusBadRequest)
+		return
+	}
+	var props []caldav.Prop
+	for _, change := range root.Children {
+		for _, name := range change.Child(caldav.NamespaceDAV, "prop").Names() {
+			props = append(props, caldav.Prop{Name: name})
+		}
+	}
+	caldav.WriteMultistatus(rw, []caldav.Response{{
+		Href:      r.URL.EscapedPath(),
+		Propstats: []caldav.Propstat{{Status: http.StatusForbidden, Props: props}},
+	}}, "")
+}
+
+func (h *caldavHandler) serveReport(rw http.ResponseWriter, r *http.Request, user *users.User, collection caldavCollection) {
+	root, err := caldav.ParseBody(r.Body)
+	if err != nil || root == nil {
+		http.Error(rw, "invalid report body", http.StatusBadRequest)
+		return
+	}
+	propfind := caldav.RequestedProps(root)
+	objects, err := h.getObjects(r.Context(), user, collection)
+	if err != nil {
+		http.Error(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+		return
+	}
+	collectionPath := caldavCollectionPath(user.ID, collection.name)
+	var responses []caldav.Response
+	switch {
+	case root.Is(caldav.NamespaceCalDAV, "calendar-query"):
+		filter := root.Child(caldav.NamespaceCalDAV, "filter").Child(caldav.NamespaceCalDAV, "comp-filter")
+		loc := user.GetPreferences().Location()
+		for _, object := range objects {
+			if matchesCalendarFilter(object.task, filter, loc) {
+				responses = append(responses, caldav.PropfindResponse(collectionPath+url.PathEscape(object.name), objectProps(object, true), propfind))
+			}
+		}
+	case root.Is(caldav.NamespaceCalDAV, "calendar-multiget"):
+		objectsByName := map[string]caldavObject{}
+		for _, object := range objects {
+			objectsByName[object.name] = object
+		}
+		for _, child := range root.Children {
+			if !child.Is(caldav.NamespaceDAV, "href") {
+				continue
+			}
+			href := strings.TrimSpace(child.Content)
+			object, ok := objectsByName[caldavObjectName(href, collectionPath)]
+			if !ok {
+				responses = append(responses, caldav.Response{Href: href, Status: http.StatusNotFound})
+				continue
+			}
+			responses = append(responses, caldav.PropfindResponse(href, objectProps(object, true), propfind))
+		}
+	case root.Is(caldav.NamespaceDAV, "sync-collection"):
+		h.serveSyncCollection(rw, r, user, collection, objects, root, propfind)
+		return
+	default:
+		caldav.WriteError(rw, http.StatusForbidden, "<d:supported-report/>")
+		return
+	}
+	caldav.WriteMultistatus(rw, responses, "")
+}
+
+// serveSyncCollection reports the objects changed and removed since the
+// sync token of the request, every object is reported without sync token.
+func (h *caldavHandler) serveSyncCollection(rw http.ResponseWriter, r *http.Request, user *users.User, collection caldavCollection, objects []caldavObject, root *caldav.Node, propfind caldav.Propfind) {
+	previousETags := map[string]string{}
+	if token := strings.TrimSpace(root.Child(caldav.NamespaceDAV, "sync-token").Content); token != "" {
+		var err error
+		previousETags, err = h.tasksService.GetSyncState(r.Context(), user.ID, collection.name, token)
+		if err != nil {
+			caldav.WriteError(rw, http.StatusForbidden, "<d:valid-sync-token/>")
+			return
+		}
+	}
+	collectionPath := caldavCollectionPath(user.ID, collection.name)
+	etags := make(map[string]string, len(objects))
+	var responses []caldav.Response
+	for _, object := range objects {
+		etags[object.name] = object.etag
+		if previousETags[object.name] != object.etag {
+			responses = append(responses, caldav.PropfindResponse(collectionPath+url.PathEscape(object.name), objectProps(object, true), propfind))
+		}
+	}
+	for name := range previousETags {
+		if _, ok := etags[name]; !ok {
+			responses = append(responses, caldav.Response{Href: collectionPath + url.PathEscape(name), Status: http.StatusNotFound})
+		}
+	}
+	token, err := h.tasksService.SaveSyncState(r.Context(), user.ID, collection.name, etags)
+	if err != nil {
+		http.Error(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+		return
+	}
+	caldav.WriteMultistatus(rw, responses, token)
+}
+
+func (h *caldavHandler) serveObject(rw http.ResponseWriter, r *http.Request, user *users.User, collection caldavCollection, name string) {
+	loc := user.GetPreferences().Location()
+	var object *caldavObject
+	task, err := h.tasksService.GetTaskByObjectName(r.Context(), name)
+	if err == nil && h.tasksService.InCollection(r.Context(), task, user.ID, collection.projectID) {
+		data, etag, err := tasks.EncodeCalendarObject(*task, loc)
+		if err != nil {
+			http.Error(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		object = &caldavObject{task: *task, name: name, data: data, etag: etag}
+	}
+	if r.Method == http.MethodPut {
+		h.putObject(rw, r, user, collection, name, object)
+		return
+	}
+	if object == nil {
+		http.NotFound(rw, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		rw.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		rw.Header().Set("ETag", `"`+object.etag+`"`)
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(object.data)
+	case "PROPFIND":
+		propfind, ok := parsePropfind(rw, r)
+		if !ok {
+			return
+		}
+		caldav.WriteMultistatus(rw, []caldav.Response{caldav.PropfindResponse(r.URL.EscapedPath(), objectProps(*object, !propfind.AllProp), propfind)}, "")
+	case http.MethodDelete:
+		if !ifMatch(r, object) {
+			rw.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		if !h.tasksService.CanManageTask(r.Context(), &object.task, user.ID) {
+			caldav.WriteError(rw, http.StatusForbidden, "<d:need-privileges/>")
+			return
+		}
+		_, err = h.tasksService.DeleteTask(r.Context(), object.task.ID)
+		if err != nil {
+			http.Error(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	default:
+		caldavMethodNotAllowed(rw)
+	}
+}
+
+// putObject creates or replaces the task of a calendar object. Created
+// tasks are owned by the user, and belong to the project of the collection.
+func (h *caldavHandler) putObject(rw http.ResponseWriter, r *http.Request, user *users.User, collection caldavCollection, name string, object *caldavObject) {
+	if r.Header.Get("If-None-Match") == "*" && object != nil || !ifMatch(r, object) {
+		rw.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	if object == nil && !collection.writable || object != nil && !h.tasksService.CanEditTask(r.Context(), &object.task, user.ID) {
+		caldav.WriteError(rw, http.StatusForbidden, "<d:need-privileges/>")
+		return
+	}
+	r.Body = http.MaxBytesReader(rw, r.Body, maxImportSize)
+	calendar, err := ical.Parse(r.Body)
+	if err != nil || calendar.Name != "VCALENDAR" {
+		caldav.WriteError(rw, http.StatusForbidden, "<c:valid-calendar-data/>")
+		return
+	}
+	loc := user.GetPreferences().Location()
+	task, todo, err := tasks.TaskFromICalendar(calendar, loc)
+	if err != nil {
+		caldav.WriteError(rw, http.StatusForbidden, "<c:valid-calendar-object-resource/>")
+		return
+	}
+	collectionPath := caldavCollectionPath(user.ID, collection.name)
+	sameUIDTask, err := h.tasksService.GetTaskByICalUID(r.Context(), user.ID, task.UID)
+	if err == nil && (object == nil || sameUIDTask.ID != object.task.ID) {
+		href := caldav.Href(collectionPath + url.PathEscape(sameUIDTask.CalendarObjectName()))
+		caldav.WriteError(rw, http.StatusForbidden, "<c:no-uid-conflict>"+href+"</c:no-uid-conflict>")
+		return
+	}
+	if !todo && !task.AllDay {
+		participants, excludeTaskID := []string{user.ID}, ""
+		if object != nil {
+			participants, excludeTaskID = object.task.Participants(), object.task.ID
+		}
+		overlappingTask, err := h.tasksService.GetOverlappingTask(r.Context(), participants, task.StartTime, task.EndTime, excludeTaskID)
+		if err == nil {
+			http.Error(rw, fmt.Sprintf("the task overlaps with %s", overlappingTask.Title), http.StatusConflict)
+			return
+		}
+	}
+
+	// no entity tag is returned as the stored object differs from the
+	// request, clients fetch the object again.
+	if object != nil {
+		_, err = h.tasksService.UpdateFromICalendar(r.Context(), &object.task, task, todo)
+		if err != nil {
+			http.Error(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+	task.UserID = user.ID
+	task.CreatedBy = user.ID
+	task.ProjectID = collection.projectID
+	_, err = h.tasksService.CreateFromICalendar(r.Context(), task, name)
+	if err != nil {
+		http.Error(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusCreated)
+}
+
+// getCollection returns the collection with the name if the user can access it.
+func (h *caldavHandler) getCollection(ctx context.Context, user *users.User, name string) (caldavCollection, bool) {
+	if name == caldavUserCollection {
+		return caldavCollection{
+			name:        caldavUserCollection,
+			ownerID:     user.ID,
+			displayName: "Tasks",
+			writable:    true,
+		}, true
+	}
+	project, err := h.projectsService.GetProject(ctx, name)
+	if err != nil {
+		return caldavCollection{}, false
+	}
+	role := h.projectsService.ProjectRole(ctx, project, user.ID)
+	if role == "" {
+		return caldavCollection{}, false
+	}
+	return caldavCollection{
+		name:        project.ID,
+		projectID:   project.ID,
+		ownerID:     project.UserID,
+		displayName: project.Name,
+		writable:    shares.RoleAtLeast(role, shares.RoleEditor),
+	}, true
+}
+
+// getCollections returns the user collection and the collections of the projects the user can access.
+func (h *caldavHandler) getCollections(ctx context.Context, user *users.User) ([]caldavCollection, error) {
+	userCollection, _ := h.getCollection(ctx, user, caldavUserCollection)
+	collections := []caldavCollection{userCollection}
+	userProjects, err := h.projectsService.GetProjects(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, project := range userProjects {
+		collection, ok := h.getCollection(ctx, user, project.ID)
+		if ok {
+			collections = append(collections, collection)
+		}
+	}
+	return collections, nil
+}
+
+// getObjects returns the calendar objects of the collection, written in
+// the user preferred time zone.
+func (h *caldavHandler) getObjects(ctx context.Context, user *users.User, collection caldavCollection) ([]caldavObject, error) {
+	collectionTasks, err := h.tasksService.GetCollectionTasks(ctx, user.ID, collection.projectID)
+	if err != nil {
+		return nil, err
+	}
+	loc := user.GetPreferences().Location()
+	objects := make([]caldavObject, 0, len(collectionTasks))
+	for _, task := range collectionTasks {
+		data, etag, err := tasks.EncodeCalendarObject(task, loc)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, caldavObject{task: task, name: task.CalendarObjectName(), data: data, etag: etag})
+	}
+	return objects, nil
+}
+
+func (h *caldavHandler) rootProps(user *users.User) caldav.Props {
+	return caldav.Props{
+		davName("resourcetype"):           "<d:collection/>",
+		davName("current-user-principal"): caldav.Href(caldavPrincipalPath(user.ID)),
+	}
+}
+
+func (h *caldavHandler) principalProps(user *users.User) caldav.Props {
+	return caldav.Props{
+		davName("resourcetype"):                 "<d:principal/>",
+		davName("displayname"):                  caldav.Escape(strings.TrimSpace(user.FirstName + " " + user.LastName)),
+		davName("current-user-principal"):       caldav.Href(caldavPrincipalPath(user.ID)),
+		davName("principal-URL"):                caldav.Href(caldavPrincipalPath(user.ID)),
+		calDAVName("calendar-home-set"):         caldav.Href(caldavHomePath(user.ID)),
+		calDAVName("calendar-user-address-set"): caldav.Href("mailto:" + user.Email),
+	}
+}
+
+func (h *caldavHandler) homeProps(user *users.User) caldav.Props {
+	return caldav.Props{
+		davName("resourcetype"):           "<d:collection/>",
+		davName("current-user-principal"): caldav.Href(caldavPrincipalPath(user.ID)),
+		davName("owner"):                  caldav.Href(caldavPrincipalPath(user.ID)),
+	}
+}
+
+func (h *caldavHandler) collectionProps(ctx context.Context, user *users.User, collection caldavCollection) (caldav.Props, error) {
+	objects, err := h.getObjects(ctx, user, collection)
+	if err != nil {
+		return nil, err
+	}
+	return h.collectionPropsWithObjects(ctx, user, collection, objects)
+}
+
+// collectionPropsWithObjects returns the collection properties, its
+// collection tag and sync token are the token of the objects snapshot.
+func (h *caldavHandler) collectionPropsWithObjects(ctx context.Context, user *users.User, collection caldavCollection, objects []caldavObject) (caldav.Props, error) {
+	etags := make(map[string]string, len(objects))
+	for _, object := range objects {
+		etags[object.name] = object.etag
+	}
+	token, err := h.tasksService.SaveSyncState(ctx, user.ID, collection.name, etags)
+	if err != nil {
+		return nil, err
+	}
+	privileges := "<d:privilege><d:read/></d:privilege>"
+	if collection.writable {
+		privileges += "<d:privilege><d:write/></d:privilege><d:privilege><d:write-content/></d:privilege>" +
+			"<d:privilege><d:bind/></d:privilege><d:privilege><d:unbind/></d:privilege>"
+	}
+	reports := ""
+	for _, report := range []string{"<c:calendar-query/>", "<c:calendar-multiget/>", "<d:sync-collection/>"} {
+		reports += "<d:supported-report><d:report>" + report + "</d:report></d:supported-report>"
+	}
+	return caldav.Props{
+		davName("resourcetype"):                        "<d:collection/><c:calendar/>",
+		davName("displayname"):                         caldav.Escape(collection.displayName),
+		davName("current-user-principal"):              caldav.Href(caldavPrincipalPath(user.ID)),
+		davName("owner"):                               caldav.Href(caldavPrincipalPath(collection.ownerID)),
+		davName("current-user-privilege-set"):          privileges,
+		davName("supported-report-set"):                reports,
+		davName("sync-token"):                          caldav.Escape(token),
+		calendarServerName("getctag"):                  caldav.Escape(token),
+		calDAVName("supported-calendar-component-set"): `<c:comp name="VEVENT"/><c:comp name="VTODO"/>`,
+		calDAVName("supported-calendar-data"):          `<c:calendar-data content-type="text/calendar" version="2.0"/>`,
+		calDAVName("calendar-description"):             caldav.Escape(collection.displayName),
+	}, nil
+}
+
+// objectProps returns the properties of a calendar object, its data is
+// only included when withData is set as it is not part of allprop.
+func objectProps(object caldavObject, withData bool) caldav.Props {
+	component := "vevent"
+	if object.task.StartTime.IsZero() {
+		component = "vtodo"
+	}
+	props := caldav.Props{
+		davName("resourcetype"):   "",
+		davName("getetag"):        caldav.Escape(`"` + object.etag + `"`),
+		davName("getcontenttype"): "text/calendar; charset=utf-8; component=" + component,
+	}
+	if withData {
+		props[calDAVName("calendar-data")] = caldav.Escape(string(object.data))
+	}
+	return props
+}
+
+// matchesCalendarFilter reports whether the task matches the VCALENDAR
+// comp-filter of a calendar-query, only component and time range filters
+// are evaluated.
+func matchesCalendarFilter(task tasks.Task, filter *caldav.Node, loc *time.Location) bool {
+	if filter == nil {
+		return true
+	}
+	if filter.Attr("name") != "VCALENDAR" {
+		return false
+	}
+	component := "VEVENT"
+	if task.StartTime.IsZero() {
+		component = "VTODO"
+	}
+	for _, child := range filter.Children {
+		if !child.Is(caldav.NamespaceCalDAV, "comp-filter") {
+			continue
+		}
+		matches := child.Attr("name") == component
+		if child.Child(caldav.NamespaceCalDAV, "is-not-defined") != nil {
+			matches = !matches
+		} else if timeRange := child.Child(caldav.NamespaceCalDAV, "time-range"); matches && timeRange != nil {
+			from, _ := time.Parse(ical.UTCDateTimeFormat, timeRange.Attr("start"))
+			to, _ := time.Parse(ical.UTCDateTimeFormat, timeRange.Attr("end"))
+			matches = task.OccursBetween(from, to, loc)
+		}
+		if !matches {
+			return false
+		}
+	}
+	return true
+}
+
+// ifMatch evaluates the If-Match header of a request against the object entity tag.
+func ifMatch(r *http.Request, object *caldavObject) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	if object == nil {
+		return false
+	}
+	for _, etag := range strings.Split(header, ",") {
+		etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+		if etag == "*" || etag == `"`+object.etag+`"` {
+			return true
+		}
+	}
+	return false
+}
+
+func parsePropfind(rw http.ResponseWriter, r *http.Request) (caldav.Propfind, bool) {
+	root, err := caldav.ParseBody(r.Body)
+	if err != nil {
+		http.Error(rw, "invalid propfind body", http.StatusBadRequest)
+		return caldav.Propfind{}, false
+	}
+	propfind, err := caldav.ParsePropfind(root)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return caldav.Propfind{}, false
+	}
+	return propfind, true
+}
+
+// caldavObjectName returns the name of the object of an href in the
+// collection, or an empty string when the href is outside of the collection.
+func caldavObjectName(href, collectionPath string) string {
+	hrefURL, err := url.Parse(href)
+	if err != nil || path.Dir(hrefURL.Path)+"/" != collectionPath {
+		return ""
+	}
+	return path.Base(hrefURL.Path)
+}
+
+func caldavPrincipalPath(userID string) string {
+	return CalDAVRoot + "principals/" + userID + "/"
+}
+
+func caldavHomePath(userID string) string {
+	return CalDAVRoot + "calendars/" + userID + "/"
+}
+
+func caldavCollectionPath(userID, collection string) string {
+	return caldavHomePath(userID) + url.PathEscape(collection) + "/"
+}
+
+func davName(local string) xml.Name {
+	return xml.Name{Space: caldav.NamespaceDAV, Local: local}
+}
+
+func calDAVName(local string) xml.Name {
+	return xml.Name{Space: caldav.NamespaceCalDAV, Local: local}
+}
+
+func calendarServerName(local string) xml.Name {
+	return xml.Name{Space: caldav.NamespaceCalendarServer, Local: local}
+}
+
+func caldavUnauthorized(rw http.ResponseWriter) {
+	rw.Header().Set("WWW-Authenticate", `Basic realm="todo-list-api", charset="UTF-8"`)
+	http.Error(rw, "use your email and an app password", http.StatusUnauthorized)
+}
+
+func caldavMethodNotAllowed(rw http.ResponseWriter) {
+	rw.Header().Set("Allow", caldavAllowedMethods)
+	rw.WriteHeader(http.StatusMethodNotAllowed)
+}
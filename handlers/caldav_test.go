@@ -0,0 +1,109 @@
+package httphandlers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wisdommatt/todo-list-api/internal/caldav"
+	"github.com/wisdommatt/todo-list-api/services/tasks"
+)
+
+func parseCalendarFilter(t *testing.T, body string) *caldav.Node {
+	t.Helper()
+	root, err := caldav.ParseBody(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("ParseBody() error = %v", err)
+	}
+	return root.Child(caldav.NamespaceCalDAV, "filter").Child(caldav.NamespaceCalDAV, "comp-filter")
+}
+
+func TestMatchesCalendarFilter(t *testing.T) {
+	eventsFilter := parseCalendarFilter(t, `<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <C:filter>
+    <C:comp-filter name="VCALENDAR">
+      <C:comp-filter name="VEVENT">
+        <C:time-range start="20220307T000000Z" end="20220308T000000Z"/>
+      </C:comp-filter>
+    </C:comp-filter>
+  </C:filter>
+</C:calendar-query>`)
+	todosFilter := parseCalendarFilter(t, `<C:calendar-query xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <C:filter><C:comp-filter name="VCALENDAR"><C:comp-filter name="VTODO"/></C:comp-filter></C:filter>
+</C:calendar-query>`)
+	notEventsFilter := parseCalendarFilter(t, `<C:calendar-query xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <C:filter><C:comp-filter name="VCALENDAR"><C:comp-filter name="VEVENT"><C:is-not-defined/></C:comp-filter></C:comp-filter></C:filter>
+</C:calendar-query>`)
+
+	monday := time.Date(2022, 3, 7, 10, 0, 0, 0, time.UTC)
+	event := tasks.Task{StartTime: monday, EndTime: monday.Add(time.Hour)}
+	laterEvent := tasks.Task{StartTime: monday.AddDate(0, 0, 2), EndTime: monday.AddDate(0, 0, 2).Add(time.Hour)}
+	weeklyEvent := tasks.Task{StartTime: monday.AddDate(0, 0, -14), EndTime: monday.AddDate(0, 0, -14).Add(time.Hour), Recurrence: "FREQ=WEEKLY"}
+	todo := tasks.Task{Flexible: true}
+
+	tests := []struct {
+		name   string
+		task   tasks.Task
+		filter *caldav.Node
+		want   bool
+	}{
+		{name: "no filter", task: event, filter: nil, want: true},
+		{name: "event in the time range", task: event, filter: eventsFilter, want: true},
+		{name: "event after the time range", task: laterEvent, filter: eventsFilter, want: false},
+		{name: "occurrence of a recurring event in the time range", task: weeklyEvent, filter: eventsFilter, want: true},
+		{name: "to-do with an events filter", task: todo, filter: eventsFilter, want: false},
+		{name: "to-do with a to-dos filter", task: todo, filter: todosFilter, want: true},
+		{name: "event with a to-dos filter", task: event, filter: todosFilter, want: false},
+		{name: "to-do without events", task: todo, filter: notEventsFilter, want: true},
+		{name: "event without events", task: event, filter: notEventsFilter, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesCalendarFilter(tt.task, tt.filter, time.UTC); got != tt.want {
+				t.Errorf("matchesCalendarFilter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	object := &caldavObject{etag: "abc"}
+	tests := []struct {
+		header string
+		object *caldavObject
+		want   bool
+	}{
+		{header: "", object: nil, want: true},
+		{header: `"abc"`, object: object, want: true},
+		{header: `W/"abc"`, object: object, want: true},
+		{header: `"xyz", "abc"`, object: object, want: true},
+		{header: "*", object: object, want: true},
+		{header: `"xyz"`, object: object, want: false},
+		{header: "*", object: nil, want: false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("PUT", "/caldav/", nil)
+		if tt.header != "" {
+			r.Header.Set("If-Match", tt.header)
+		}
+		if got := ifMatch(r, tt.object); got != tt.want {
+			t.Errorf("ifMatch(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestCaldavObjectName(t *testing.T) {
+	collection := caldavCollectionPath("6212c3112e46aabc11bbee1d", "tasks")
+	tests := map[string]string{
+		collection + "abc.ics":                       "abc.ics",
+		"https://example.com" + collection + "x.ics": "x.ics",
+		collection + "nested/abc.ics":                "",
+		caldavHomePath("other") + "tasks/abc.ics":    "",
+	}
+	for href, want := range tests {
+		if got := caldavObjectName(href, collection); got != want {
+			t.Errorf("caldavObjectName(%q) = %q, want %q", href, got, want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package httphandlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/wisdommatt/todo-list-api/internal/tenant"
+	"github.com/wisdommatt/todo-list-api/services/tasks"
+	"github.com/wisdommatt/todo-list-api/services/users"
+)
+
+// calendarHistory is how far in the past tasks are included in calendar exports.
+const calendarHistory = 90 * 24 * time.Hour
+
+type calendarFeedResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	URL     string `json:"url"`
+}
+
+// HandleGetCalendarEndpoint is the http endpoint handler for exporting the
+// user calendar as an iCalendar file.
+func HandleGetCalendarEndpoint(tasksService *tasks.Service, usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		user, ok := getSelfUser(rw, r, usersService)
+		if !ok {
+			return
+		}
+		writeUserCalendar(rw, r, tasksService, user)
+	}
+}
+
+// HandleCalendarFeedEndpoint is the http endpoint handler for the calendar
+// subscription feed, authenticated by the secret token in the url.
+func HandleCalendarFeedEndpoint(tasksService *tasks.Service, usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		user, err := usersService.GetUserByCalendarFeedToken(r.Context(), chi.URLParam(r, "token"))
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		if user.CalendarFeed == nil || !user.IsWorkspaceMember(user.CalendarFeed.WorkspaceID) {
+			problemResponse(rw, users.ErrCalendarFeedNotFound)
+			return
+		}
+		ctx := tenant.WithWorkspace(r.Context(), user.CalendarFeed.WorkspaceID)
+		writeUserCalendar(rw, r.WithContext(ctx), tasksService, user)
+	}
+}
+
+// HandleCreateCalendarFeedEndpoint is the http endpoint handler for
+// generating the calendar subscription url of the current workspace, the
+// previous url stops working.
+func HandleCreateCalendarFeedEndpoint(usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		user, ok := getSelfUser(rw, r, usersService)
+		if !ok {
+			return
+		}
+		token, err := usersService.GenerateCalendarFeedToken(r.Context(), user.ID, tenant.WorkspaceID(r.Context()))
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		scheme := "http"
+		if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(calendarFeedResponse{
+			Status:  "success",
+			Message: "calendar feed created successfully, keep the url secret",
+			URL:     fmt.Sprintf("%s://%s/calendar/feeds/%s.ics", scheme, r.Host, token),
+		})
+	}
+}
+
+// HandleRevokeCalendarFeedEndpoint is the http endpoint handler for
+// disabling the calendar subscription url.
+func HandleRevokeCalendarFeedEndpoint(usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		user, ok := getSelfUser(rw, r, usersService)
+		if !ok {
+			return
+		}
+		err := usersService.RevokeCalendarFeedToken(r.Context(), user.ID)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(calendarFeedResponse{
+			Status:  "success",
+			Message: "calendar feed revoked successfully",
+		})
+	}
+}
+
+// writeUserCalendar writes the user calendar tasks as an iCalendar file in
+// the user preferred time zone.
+func writeUserCalendar(rw http.ResponseWriter, r *http.Request, tasksService *tasks.Service, user *users.User) {
+	calendarTasks, err := tasksService.GetCalendarTasks(r.Context(), user.ID, time.Now().Add(-calendarHistory))
+	if err != nil {
+		ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+		return
+	}
+	var calendar bytes.Buffer
+	name := fmt.Sprintf("%s %s tasks", user.FirstName, user.LastName)
+	err = tasks.EncodeICalendar(&calendar, calendarTasks, name, user.GetPreferences().Location())
+	if err != nil {
+		ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	rw.Header().Set("Content-Disposition", `attachment; filename="calendar.ics"`)
+	rw.Header().Set("Cache-Control", "private, max-age=300")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(calendar.Bytes())
+}
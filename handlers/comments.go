@@ -0,0 +1,169 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/wisdommatt/todo-list-api/services/comments"
+	"github.com/wisdommatt/todo-list-api/services/tasks"
+)
+
+type commentPayload struct {
+	ParentID string `json:"parentId"`
+	Body     string `json:"body" validate:"required,max=10000"`
+}
+
+type commentApiResponse struct {
+	Status  string            `json:"status"`
+	Message string            `json:"message"`
+	Comment *comments.Comment `json:"comment"`
+}
+
+type getCommentsResponse struct {
+	Status   string             `json:"status"`
+	Message  string             `json:"message"`
+	Comments []comments.Comment `json:"comments"`
+}
+
+// HandleCreateCommentEndpoint is the http endpoint handler for commenting on a task.
+func HandleCreateCommentEndpoint(tasksService *tasks.Service, commentsService *comments.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		taskID := chi.URLParam(r, "taskId")
+		authUserID := AuthUserID(r.Context())
+		_, err := tasksService.GetVisibleTask(r.Context(), taskID, authUserID)
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		var payload commentPayload
+		if !decodeJSON(rw, r, &payload) {
+			return
+		}
+		if payload.ParentID != "" {
+			parent, err := commentsService.GetComment(r.Context(), payload.ParentID)
+			if err != nil && err != comments.ErrCommentNotFound {
+				problemResponse(rw, err)
+				return
+			}
+			if err != nil || parent.TaskID != taskID {
+				ErrorResponse(rw, "parent comment does not exist", http.StatusBadRequest)
+				return
+			}
+		}
+		comment, err := commentsService.CreateComment(r.Context(), comments.Comment{
+			TaskID:   taskID,
+			ParentID: payload.ParentID,
+			UserID:   authUserID,
+			Body:     payload.Body,
+		})
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(commentApiResponse{
+			Status:  "success",
+			Message: "comment created successfully",
+			Comment: comment,
+		})
+	}
+}
+
+// HandleGetCommentsEndpoint is the http endpoint handler for retrieving task comments.
+func HandleGetCommentsEndpoint(tasksService *tasks.Service, commentsService *comments.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		taskID := chi.URLParam(r, "taskId")
+		_, err := tasksService.GetVisibleTask(r.Context(), taskID, AuthUserID(r.Context()))
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		lastID := r.URL.Query().Get("lastId")
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		taskComments, err := commentsService.GetComments(r.Context(), taskID, lastID, limit)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(getCommentsResponse{
+			Status:   "success",
+			Message:  "comments retrieved successfully",
+			Comments: taskComments,
+		})
+	}
+}
+
+// HandleUpdateCommentEndpoint is the http endpoint handler for editing a comment.
+func HandleUpdateCommentEndpoint(tasksService *tasks.Service, commentsService *comments.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		comment, ok := getAuthorComment(rw, r, tasksService, commentsService)
+		if !ok {
+			return
+		}
+		var payload commentPayload
+		if !decodeJSON(rw, r, &payload) {
+			return
+		}
+		comment, err := commentsService.UpdateComment(r.Context(), comment.ID, payload.Body)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(commentApiResponse{
+			Status:  "success",
+			Message: "comment updated successfully",
+			Comment: comment,
+		})
+	}
+}
+
+// HandleDeleteCommentEndpoint is the http endpoint handler for deleting a comment.
+func HandleDeleteCommentEndpoint(tasksService *tasks.Service, commentsService *comments.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		comment, ok := getAuthorComment(rw, r, tasksService, commentsService)
+		if !ok {
+			return
+		}
+		comment, err := commentsService.DeleteComment(r.Context(), comment.ID)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(commentApiResponse{
+			Status:  "success",
+			Message: "comment deleted successfully",
+			Comment: comment,
+		})
+	}
+}
+
+// getAuthorComment retrieves the comment in the url and makes sure it
+// belongs to the authenticated user, writing an error response otherwise.
+func getAuthorComment(rw http.ResponseWriter, r *http.Request, tasksService *tasks.Service, commentsService *comments.Service) (*comments.Comment, bool) {
+	taskID := chi.URLParam(r, "taskId")
+	authUserID := AuthUserID(r.Context())
+	_, err := tasksService.GetVisibleTask(r.Context(), taskID, authUserID)
+	if err != nil {
+		problemResponse(rw, err)
+		return nil, false
+	}
+	comment, err := commentsService.GetComment(r.Context(), chi.URLParam(r, "commentId"))
+	if err != nil {
+		problemResponse(rw, err)
+		return nil, false
+	}
+	if comment.TaskID != taskID || comment.Deleted {
+		problemResponse(rw, comments.ErrCommentNotFound)
+		return nil, false
+	}
+	if comment.UserID != authUserID {
+		ErrorResponse(rw, "you can only modify your own comments", http.StatusForbidden)
+		return nil, false
+	}
+	return comment, true
+}
@@ -2,16 +2,87 @@ package httphandlers
 
 import (
 	"encoding/json"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/wisdommatt/todo-list-api/internal/apperror"
 )
 
 var errSomethingWentWrongMsg = "an error occured, please try again later"
 
-func ErrorResponse(rw http.ResponseWriter, status, message string, statusCode int) {
-	rw.Header().Set("Content-Type", "application/json")
-	rw.WriteHeader(http.StatusInternalServerError)
-	json.NewEncoder(rw).Encode(map[string]string{
-		"status":  status,
-		"message": message,
-	})
+// problemContentType is the content type of error responses (RFC 7807).
+const problemContentType = "application/problem+json"
+
+// problem is the body of error responses. Code is a stable identifier of the
+// error clients can rely on, Message repeats Detail for older clients.
+type problem struct {
+	Type    string `json:"type"`
+	Title   string `json:"title"`
+	Status  int    `json:"status"`
+	Detail  string `json:"detail"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// kindStatusCodes are the http status codes of the domain error kinds.
+var kindStatusCodes = map[apperror.Kind]int{
+	apperror.NotFound:        http.StatusNotFound,
+	apperror.Conflict:        http.StatusConflict,
+	apperror.Forbidden:       http.StatusForbidden,
+	apperror.Invalid:         http.StatusBadRequest,
+	apperror.Unauthenticated: http.StatusUnauthorized,
+	apperror.RateLimited:     http.StatusTooManyRequests,
+}
+
+func newProblem(statusCode int, code, detail string) problem {
+	return problem{
+		Type:    "about:blank",
+		Title:   http.StatusText(statusCode),
+		Status:  statusCode,
+		Detail:  detail,
+		Code:    code,
+		Message: detail,
+	}
+}
+
+// statusCodeName returns the error code of a status code e.g "not_found".
+func statusCodeName(statusCode int) string {
+	return strings.ToLower(strings.ReplaceAll(http.StatusText(statusCode), " ", "_"))
+}
+
+// writeProblem writes the error response body v, which is a problem or a
+// struct embedding one with extension members.
+func writeProblem(rw http.ResponseWriter, statusCode int, v interface{}) {
+	rw.Header().Set("Content-Type", problemContentType)
+	rw.WriteHeader(statusCode)
+	json.NewEncoder(rw).Encode(v)
+}
+
+// ErrorResponse writes an error response with the message, its code is
+// derived from the status code.
+func ErrorResponse(rw http.ResponseWriter, message string, statusCode int) {
+	writeProblem(rw, statusCode, newProblem(statusCode, statusCodeName(statusCode), message))
+}
+
+// errorProblem returns the problem of an error returned by a service. Domain
+// errors are mapped to the status code of their kind, other errors are
+// unexpected and their details are not exposed.
+func errorProblem(err error) problem {
+	appErr, ok := apperror.As(err)
+	if !ok {
+		return newProblem(http.StatusInternalServerError, statusCodeName(http.StatusInternalServerError), errSomethingWentWrongMsg)
+	}
+	return newProblem(kindStatusCodes[appErr.Kind], appErr.Code, appErr.Message)
+}
+
+// problemResponse writes the error response of an error returned by a
+// service, with the Retry-After header for rate limited requests.
+func problemResponse(rw http.ResponseWriter, err error) {
+	if appErr, ok := apperror.As(err); ok && appErr.RetryAfter > 0 {
+		rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(appErr.RetryAfter.Seconds()))))
+	}
+	p := errorProblem(err)
+	writeProblem(rw, p.Status, p)
 }
@@ -0,0 +1,110 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/wisdommatt/todo-list-api/internal/ical"
+	"github.com/wisdommatt/todo-list-api/services/projects"
+	"github.com/wisdommatt/todo-list-api/services/shares"
+	"github.com/wisdommatt/todo-list-api/services/tasks"
+	"github.com/wisdommatt/todo-list-api/services/users"
+)
+
+// maxImportSize is the maximum size of an imported calendar file.
+const maxImportSize = 5 << 20
+
+type importResponse struct {
+	Status  string               `json:"status"`
+	Message string               `json:"message"`
+	Results []tasks.ImportResult `json:"results"`
+}
+
+type importAbortedResponse struct {
+	problem
+	Results []tasks.ImportResult `json:"results"`
+}
+
+// HandleImportCalendarEndpoint is the http endpoint handler for importing
+// the events and to-dos of an iCalendar file as tasks of the user.
+//
+// The file is sent as multipart form data in the "file" field or as the
+// request body. The "overlap" query param selects what happens to events
+// overlapping with existing tasks: skip (default), fail or allow.
+func HandleImportCalendarEndpoint(tasksService *tasks.Service, usersService *users.Service, projectsService *projects.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		user, ok := getSelfUser(rw, r, usersService)
+		if !ok {
+			return
+		}
+		overlapPolicy := r.URL.Query().Get("overlap")
+		if overlapPolicy == "" {
+			overlapPolicy = tasks.ImportOverlapSkip
+		}
+		if !tasks.IsValidImportOverlapPolicy(overlapPolicy) {
+			ErrorResponse(rw, "overlap must be one of skip, fail or allow", http.StatusBadRequest)
+			return
+		}
+		projectID := r.URL.Query().Get("projectId")
+		if projectID != "" {
+			project, err := projectsService.GetProject(r.Context(), projectID)
+			if err != nil && err != projects.ErrProjectNotFound {
+				problemResponse(rw, err)
+				return
+			}
+			if err != nil || !shares.RoleAtLeast(projectsService.ProjectRole(r.Context(), project, user.ID), shares.RoleEditor) {
+				ErrorResponse(rw, "project does not exist", http.StatusBadRequest)
+				return
+			}
+		}
+		loc, err := userLocation(r, user)
+		if err != nil {
+			ErrorResponse(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(rw, r.Body, maxImportSize+maxMultipartMemory)
+		var body io.Reader = r.Body
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			err = r.ParseMultipartForm(maxMultipartMemory)
+			if err != nil {
+				ErrorResponse(rw, "invalid multipart payload or file too large", http.StatusBadRequest)
+				return
+			}
+			defer r.MultipartForm.RemoveAll()
+			file, _, err := r.FormFile("file")
+			if err != nil {
+				ErrorResponse(rw, "file must be provided", http.StatusBadRequest)
+				return
+			}
+			defer file.Close()
+			body = file
+		}
+		calendar, err := ical.Parse(body)
+		if err != nil || calendar.Name != "VCALENDAR" {
+			ErrorResponse(rw, "invalid calendar file", http.StatusBadRequest)
+			return
+		}
+
+		results, err := tasksService.ImportICalendar(r.Context(), user.ID, projectID, calendar, overlapPolicy, loc)
+		if err == tasks.ErrImportAborted {
+			writeProblem(rw, http.StatusConflict, importAbortedResponse{
+				problem: errorProblem(err),
+				Results: results,
+			})
+			return
+		}
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(importResponse{
+			Status:  "success",
+			Message: "calendar imported successfully",
+			Results: results,
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/wisdommatt/todo-list-api/services/users"
+	"github.com/wisdommatt/todo-list-api/services/workspaces"
+)
+
+type mfaCodeInput struct {
+	// Code is a code of the authenticator app or a recovery code.
+	Code string `json:"code" validate:"required,max=50"`
+}
+
+type completeMFALoginInput struct {
+	MFAToken string `json:"mfaToken" validate:"required,max=500"`
+	Code     string `json:"code" validate:"required,max=50"`
+}
+
+type mfaChallengeResponse struct {
+	Status      string `json:"status"`
+	Message     string `json:"message"`
+	MFARequired bool   `json:"mfaRequired"`
+	MFAToken    string `json:"mfaToken"`
+}
+
+type mfaEnrollmentResponse struct {
+	Status     string               `json:"status"`
+	Message    string               `json:"message"`
+	Enrollment *users.MFAEnrollment `json:"enrollment"`
+}
+
+type recoveryCodesResponse struct {
+	Status        string   `json:"status"`
+	Message       string   `json:"message"`
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type mfaPolicyInput struct {
+	RequiredRoles []string `json:"requiredRoles" validate:"max=10"`
+}
+
+type mfaPolicyResponse struct {
+	Status  string           `json:"status"`
+	Message string           `json:"message"`
+	Policy  *users.MFAPolicy `json:"policy"`
+}
+
+// HandleCompleteMFALoginEndpoint is the http endpoint handler for the second
+// step of the login of users with two-factor authentication.
+func HandleCompleteMFALoginEndpoint(usersService *users.Service, workspacesService *workspaces.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var payload completeMFALoginInput
+		if !decodeJSON(rw, r, &payload) {
+			return
+		}
+		user, authToken, err := usersService.CompleteMFAChallenge(r.Context(), payload.MFAToken, payload.Code, requestClient(r))
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		loginResponse(rw, r, usersService, workspacesService, user, authToken)
+	}
+}
+
+// HandleEnrollMFAEndpoint is the http endpoint handler for setting up
+// two-factor authentication with an authenticator app.
+func HandleEnrollMFAEndpoint(usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		user, ok := getSelfUser(rw, r, usersService)
+		if !ok {
+			return
+		}
+		enrollment, err := usersService.EnrollMFA(r.Context(), user)
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(mfaEnrollmentResponse{
+			Status:     "success",
+			Message:    "scan the qr code with your authenticator app and verify a code to enable two-factor authentication",
+			Enrollment: enrollment,
+		})
+	}
+}
+
+// HandleVerifyMFAEndpoint is the http endpoint handler for enabling
+// two-factor authentication with a first code of the authenticator app.
+func HandleVerifyMFAEndpoint(usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		user, ok := getSelfUser(rw, r, usersService)
+		if !ok {
+			return
+		}
+		var payload mfaCodeInput
+		if !decodeJSON(rw, r, &payload) {
+			return
+		}
+		recoveryCodes, err := usersService.VerifyMFA(r.Context(), user, payload.Code)
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(recoveryCodesResponse{
+			Status:        "success",
+			Message:       "two-factor authentication enabled successfully, store the recovery codes safely as they will not be shown again",
+			RecoveryCodes: recoveryCodes,
+		})
+	}
+}
+
+// HandleDisableMFAEndpoint is the http endpoint handler for turning
+// two-factor authentication off.
+func HandleDisableMFAEndpoint(usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		user, ok := getSelfUser(rw, r, usersService)
+		if !ok {
+			return
+		}
+		var payload mfaCodeInput
+		if !decodeJSON(rw, r, &payload) {
+			return
+		}
+		err := usersService.DisableMFA(r.Context(), user, payload.Code)
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		user.MFA = nil
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(userApiResponse{
+			Status:  "success",
+			Message: "two-factor authentication disabled successfully",
+			User:    user,
+		})
+	}
+}
+
+// HandleRegenerateRecoveryCodesEndpoint is the http endpoint handler for
+// replacing the recovery codes of the user.
+func HandleRegenerateRecoveryCodesEndpoint(usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		user, ok := getSelfUser(rw, r, usersService)
+		if !ok {
+			return
+		}
+		var payload mfaCodeInput
+		if !decodeJSON(rw, r, &payload) {
+			return
+		}
+		recoveryCodes, err := usersService.RegenerateRecoveryCodes(r.Context(), user, payload.Code)
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(recoveryCodesResponse{
+			Status:        "success",
+			Message:       "recovery codes generated successfully, the previous ones cannot be used anymore",
+			RecoveryCodes: recoveryCodes,
+		})
+	}
+}
+
+// HandleGetMFAPolicyEndpoint is the http endpoint handler for retrieving the
+// roles which must use two-factor authentication.
+func HandleGetMFAPolicyEndpoint(usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		policy, err := usersService.GetMFAPolicy(r.Context())
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(mfaPolicyResponse{
+			Status:  "success",
+			Message: "mfa policy retrieved successfully",
+			Policy:  policy,
+		})
+	}
+}
+
+// HandleUpdateMFAPolicyEndpoint is the http endpoint handler for admins to
+// set the roles which must use two-factor authentication.
+func HandleUpdateMFAPolicyEndpoint(usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var payload mfaPolicyInput
+		if !decodeJSON(rw, r, &payload) {
+			return
+		}
+		policy, err := usersService.UpdateMFAPolicy(r.Context(), users.MFAPolicy{RequiredRoles: payload.RequiredRoles})
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(mfaPolicyResponse{
+			Status:  "success",
+			Message: "mfa policy updated successfully",
+			Policy:  policy,
+		})
+	}
+}
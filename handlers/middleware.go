@@ -0,0 +1,232 @@
+package httphandlers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/wisdommatt/todo-list-api/internal/jwt"
+	"github.com/wisdommatt/todo-list-api/internal/tenant"
+	"github.com/wisdommatt/todo-list-api/services/users"
+)
+
+type contextKey string
+
+const (
+	authUserIDKey    contextKey = "authUserId"
+	sessionIDKey     contextKey = "sessionId"
+	requiredScopeKey contextKey = "requiredScope"
+)
+
+// workspaceHeader selects the workspace of a request, the workspace in the
+// auth token is used when it is not set.
+const workspaceHeader = "X-Workspace-ID"
+
+// IsLoggedInMiddleware rejects requests without a valid auth token or
+// personal access token and stores the authenticated user id in the request
+// context. Access tokens are rejected unless AccessTokenScopesMiddleware set
+// a scope they have.
+//
+// The request context is also scoped to the selected workspace, which the
+// user must be a member of. Users whose role requires two-factor
+// authentication are rejected until they enable it.
+func IsLoggedInMiddleware(usersService *users.Service) func(http.Handler) http.Handler {
+	return isLoggedInMiddleware(usersService, true)
+}
+
+// IsLoggedInForMFAMiddleware is IsLoggedInMiddleware without the two-factor
+// authentication requirement, for the endpoints enabling it.
+func IsLoggedInForMFAMiddleware(usersService *users.Service) func(http.Handler) http.Handler {
+	return isLoggedInMiddleware(usersService, false)
+}
+
+func isLoggedInMiddleware(usersService *users.Service, enforceMFA bool) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			authToken := r.Header.Get("Authorization")
+			authToken = strings.ReplaceAll(authToken, "Bearer ", "")
+			authenticate := authenticateAuthToken
+			if strings.HasPrefix(authToken, users.AccessTokenPrefix) {
+				authenticate = authenticateAccessToken
+			}
+			user, workspaceID, sessionID, ok := authenticate(rw, r, usersService, authToken)
+			if !ok {
+				return
+			}
+			if enforceMFA && !user.IsMFAEnabled() {
+				required, err := usersService.IsMFARequired(r.Context(), user)
+				if err != nil {
+					problemResponse(rw, err)
+					return
+				}
+				if required {
+					writeProblem(rw, http.StatusForbidden, newProblem(http.StatusForbidden, "mfa_enrollment_required", "please enable two-factor authentication to proceed"))
+					return
+				}
+			}
+			ctx := context.WithValue(r.Context(), authUserIDKey, user.ID)
+			ctx = context.WithValue(ctx, sessionIDKey, sessionID)
+			ctx = tenant.WithWorkspace(ctx, workspaceID)
+			h.ServeHTTP(rw, r.WithContext(ctx))
+		})
+	}
+}
+
+// authenticateAuthToken returns the user of the auth token, the selected
+// workspace and the session, an error response is written when it is not
+// valid or its session was revoked.
+func authenticateAuthToken(rw http.ResponseWriter, r *http.Request, usersService *users.Service, authToken string) (*users.User, string, string, bool) {
+	payload, err := jwt.Decode([]byte(os.Getenv("JWT_SECRET")), authToken)
+	if err != nil {
+		unauthorizedResponse(rw)
+		return nil, "", "", false
+	}
+	workspaceID := r.Header.Get(workspaceHeader)
+	if workspaceID == "" {
+		workspaceID = payload.WorkspaceID
+	}
+	user, err := usersService.GetUser(r.Context(), payload.UserID)
+	if err != nil && err != users.ErrUserNotFound {
+		problemResponse(rw, err)
+		return nil, "", "", false
+	}
+	if err != nil || workspaceID == "" || !user.IsWorkspaceMember(workspaceID) || !user.IsAuthTokenValid(payload.IssuedAt) {
+		unauthorizedResponse(rw)
+		return nil, "", "", false
+	}
+	// tokens issued before sessions were added have none and cannot be
+	// revoked, their users must log in again.
+	if payload.SessionID == "" {
+		unauthorizedResponse(rw)
+		return nil, "", "", false
+	}
+	err = usersService.CheckSession(r.Context(), user.ID, payload.SessionID)
+	if err == users.ErrSessionNotFound {
+		unauthorizedResponse(rw)
+		return nil, "", "", false
+	}
+	if err != nil {
+		problemResponse(rw, err)
+		return nil, "", "", false
+	}
+	return user, workspaceID, payload.SessionID, true
+}
+
+// authenticateAccessToken returns the user of the personal access token and
+// its workspace, an error response is written when it is not valid or does
+// not have the scope the request requires.
+func authenticateAccessToken(rw http.ResponseWriter, r *http.Request, usersService *users.Service, token string) (*users.User, string, string, bool) {
+	user, accessToken, err := usersService.AuthenticateAccessToken(r.Context(), token)
+	if err != nil && err != users.ErrInvalidCredentials {
+		problemResponse(rw, err)
+		return nil, "", "", false
+	}
+	// access tokens only give access to the workspace they were created for.
+	workspaceID := r.Header.Get(workspaceHeader)
+	if err != nil || (workspaceID != "" && workspaceID != accessToken.WorkspaceID) || !user.IsWorkspaceMember(accessToken.WorkspaceID) {
+		unauthorizedResponse(rw)
+		return nil, "", "", false
+	}
+	scope, _ := r.Context().Value(requiredScopeKey).(string)
+	if scope == "" || !accessToken.HasScope(scope) {
+		writeProblem(rw, http.StatusForbidden, newProblem(http.StatusForbidden, "insufficient_scope", "the access token does not have the scope required by this endpoint"))
+		return nil, "", "", false
+	}
+	return user, accessToken.WorkspaceID, "", true
+}
+
+// AccessTokenScopesMiddleware sets the scope personal access tokens need,
+// readScope for GET and HEAD requests and writeScope for the others. It must
+// be used before IsLoggedInMiddleware.
+func AccessTokenScopesMiddleware(readScope, writeScope string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			scope := writeScope
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				scope = readScope
+			}
+			h.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), requiredScopeKey, scope)))
+		})
+	}
+}
+
+// IsVerifiedMiddleware rejects requests of users who did not verify their
+// email, it must be used after IsLoggedInMiddleware. It guards what reaches
+// other users or outside systems e.g invitations, shares and app passwords.
+func IsVerifiedMiddleware(usersService *users.Service) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			user, err := usersService.GetUser(r.Context(), AuthUserID(r.Context()))
+			if err != nil {
+				problemResponse(rw, err)
+				return
+			}
+			if !user.IsEmailVerified() {
+				ErrorResponse(rw, "please verify your email to proceed", http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// IsAdminMiddleware rejects requests of users who are not admins of the
+// api, it must be used after IsLoggedInMiddleware.
+func IsAdminMiddleware(usersService *users.Service) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			user, err := usersService.GetUser(tenant.WithWorkspace(r.Context(), ""), AuthUserID(r.Context()))
+			if err != nil {
+				problemResponse(rw, err)
+				return
+			}
+			if !user.IsAdmin() {
+				ErrorResponse(rw, "only admins can proceed", http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// clientIP returns the ip of the client making the request. Behind a proxy,
+// TRUST_PROXY_HEADERS must be true to use the address the proxy appended to
+// the X-Forwarded-For header, the other addresses are set by clients.
+func clientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY_HEADERS") == "true" {
+		if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
+			addresses := strings.Split(forwardedFor, ",")
+			return strings.TrimSpace(addresses[len(addresses)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// requestClient returns the client making the request, saved with the
+// sessions it logs in.
+func requestClient(r *http.Request) users.Client {
+	return users.Client{IP: clientIP(r), UserAgent: r.UserAgent()}
+}
+
+// AuthUserID returns the id of the authenticated user making the request.
+func AuthUserID(ctx context.Context) string {
+	userID, _ := ctx.Value(authUserIDKey).(string)
+	return userID
+}
+
+// SessionID returns the session of the auth token of the request, it is
+// empty for personal access tokens.
+func SessionID(ctx context.Context) string {
+	sessionID, _ := ctx.Value(sessionIDKey).(string)
+	return sessionID
+}
+
+func unauthorizedResponse(rw http.ResponseWriter) {
+	writeProblem(rw, http.StatusUnauthorized, newProblem(http.StatusUnauthorized, "unauthenticated", "you are not authorized to proceed"))
+}
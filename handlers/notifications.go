@@ -0,0 +1,68 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/wisdommatt/todo-list-api/services/notifications"
+)
+
+type notificationApiResponse struct {
+	Status       string                      `json:"status"`
+	Message      string                      `json:"message"`
+	Notification *notifications.Notification `json:"notification"`
+}
+
+type getNotificationsResponse struct {
+	Status        string                       `json:"status"`
+	Message       string                       `json:"message"`
+	Notifications []notifications.Notification `json:"notifications"`
+}
+
+// HandleGetNotificationsEndpoint is the http endpoint handler for retrieving user notifications.
+func HandleGetNotificationsEndpoint(notificationsService *notifications.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		userID := chi.URLParam(r, "userId")
+		if userID != AuthUserID(r.Context()) {
+			ErrorResponse(rw, "you can only view your own notifications", http.StatusForbidden)
+			return
+		}
+		lastID := r.URL.Query().Get("lastId")
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		userNotifications, err := notificationsService.GetNotifications(r.Context(), userID, lastID, limit)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(getNotificationsResponse{
+			Status:        "success",
+			Message:       "notifications retrieved successfully",
+			Notifications: userNotifications,
+		})
+	}
+}
+
+// HandleReadNotificationEndpoint is the http endpoint handler for marking a notification as read.
+func HandleReadNotificationEndpoint(notificationsService *notifications.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		userID := chi.URLParam(r, "userId")
+		if userID != AuthUserID(r.Context()) {
+			ErrorResponse(rw, "you can only update your own notifications", http.StatusForbidden)
+			return
+		}
+		notification, err := notificationsService.MarkAsRead(r.Context(), userID, chi.URLParam(r, "notificationId"))
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(notificationApiResponse{
+			Status:       "success",
+			Message:      "notification updated successfully",
+			Notification: notification,
+		})
+	}
+}
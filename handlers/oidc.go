@@ -0,0 +1,53 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/wisdommatt/todo-list-api/services/users"
+	"github.com/wisdommatt/todo-list-api/services/workspaces"
+)
+
+// HandleStartOIDCLoginEndpoint is the http endpoint handler redirecting to
+// the login page of an identity provider.
+func HandleStartOIDCLoginEndpoint(usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		authURL, err := usersService.StartOIDCLogin(r.Context(), chi.URLParam(r, "provider"))
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		http.Redirect(rw, r, authURL, http.StatusFound)
+	}
+}
+
+// HandleOIDCCallbackEndpoint is the http endpoint handler the identity
+// provider redirects to once the user logged in.
+func HandleOIDCCallbackEndpoint(usersService *users.Service, workspacesService *workspaces.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		// the provider sends an error instead of a code when the user
+		// refused the login.
+		if query.Get("error") != "" || query.Get("code") == "" {
+			problemResponse(rw, users.ErrOIDCLoginFailed)
+			return
+		}
+		user, authToken, err := usersService.CompleteOIDCLogin(r.Context(), chi.URLParam(r, "provider"), query.Get("state"), query.Get("code"), requestClient(r))
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		if user.IsMFAEnabled() {
+			rw.WriteHeader(http.StatusOK)
+			json.NewEncoder(rw).Encode(mfaChallengeResponse{
+				Status:      "success",
+				Message:     "two-factor authentication code required",
+				MFARequired: true,
+				MFAToken:    authToken,
+			})
+			return
+		}
+		loginResponse(rw, r, usersService, workspacesService, user, authToken)
+	}
+}
@@ -0,0 +1,194 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/wisdommatt/todo-list-api/services/overlays"
+	"github.com/wisdommatt/todo-list-api/services/users"
+)
+
+type createOverlayInput struct {
+	Name string `json:"name" validate:"required,max=100"`
+	URL  string `json:"url" validate:"required,max=2000"`
+}
+
+type overlayApiResponse struct {
+	Status  string            `json:"status"`
+	Message string            `json:"message"`
+	Overlay *overlays.Overlay `json:"overlay"`
+}
+
+type getOverlaysResponse struct {
+	Status   string             `json:"status"`
+	Message  string             `json:"message"`
+	Overlays []overlays.Overlay `json:"overlays"`
+}
+
+// HandleCreateOverlayEndpoint is the http endpoint handler for adding an
+// external calendar whose events make the user busy.
+//
+// The calendar is either a url sent as json, refreshed in the background,
+// or a file sent as multipart form data in the "file" field with its name
+// in the "name" field.
+func HandleCreateOverlayEndpoint(overlaysService *overlays.Service, usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		user, ok := getSelfUser(rw, r, usersService)
+		if !ok {
+			return
+		}
+		overlay := overlays.Overlay{
+			UserID:   user.ID,
+			TimeZone: user.GetPreferences().TimeZone,
+		}
+		var upload io.Reader
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			r.Body = http.MaxBytesReader(rw, r.Body, overlays.MaxSize+maxMultipartMemory)
+			err := r.ParseMultipartForm(maxMultipartMemory)
+			if err != nil {
+				ErrorResponse(rw, "invalid multipart payload or file too large", http.StatusBadRequest)
+				return
+			}
+			defer r.MultipartForm.RemoveAll()
+			file, _, err := r.FormFile("file")
+			if err != nil {
+				ErrorResponse(rw, "file must be provided", http.StatusBadRequest)
+				return
+			}
+			defer file.Close()
+			upload = file
+			overlay.Name = r.FormValue("name")
+			overlay.Source = overlays.SourceUpload
+		} else {
+			var payload createOverlayInput
+			if !decodeJSON(rw, r, &payload) {
+				return
+			}
+			err := overlays.ValidateURL(payload.URL)
+			if err != nil {
+				ErrorResponse(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+			overlay.Name = payload.Name
+			overlay.URL = payload.URL
+			overlay.Source = overlays.SourceURL
+		}
+		overlay.Name = strings.TrimSpace(overlay.Name)
+		if overlay.Name == "" {
+			ErrorResponse(rw, "name must be provided", http.StatusBadRequest)
+			return
+		}
+
+		newOverlay, err := overlaysService.CreateOverlay(r.Context(), overlay, upload)
+		if errors.Is(err, overlays.ErrInvalidCalendar) {
+			ErrorResponse(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(overlayApiResponse{
+			Status:  "success",
+			Message: "overlay created successfully",
+			Overlay: newOverlay,
+		})
+	}
+}
+
+// HandleGetOverlaysEndpoint is the http endpoint handler for listing the user overlays.
+func HandleGetOverlaysEndpoint(overlaysService *overlays.Service, usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		user, ok := getSelfUser(rw, r, usersService)
+		if !ok {
+			return
+		}
+		userOverlays, err := overlaysService.GetOverlays(r.Context(), user.ID)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(getOverlaysResponse{
+			Status:   "success",
+			Message:  "overlays retrieved successfully",
+			Overlays: userOverlays,
+		})
+	}
+}
+
+// HandleRefreshOverlayEndpoint is the http endpoint handler for fetching the
+// calendar of a url overlay again without waiting for the background refresh.
+func HandleRefreshOverlayEndpoint(overlaysService *overlays.Service, usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		overlay, ok := getUserOverlay(rw, r, overlaysService, usersService)
+		if !ok {
+			return
+		}
+		if overlay.Source != overlays.SourceURL {
+			ErrorResponse(rw, "only url overlays can be refreshed", http.StatusBadRequest)
+			return
+		}
+		err := overlaysService.RefreshOverlay(r.Context(), overlay)
+		if errors.Is(err, overlays.ErrInvalidCalendar) {
+			ErrorResponse(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(overlayApiResponse{
+			Status:  "success",
+			Message: "overlay refreshed successfully",
+			Overlay: overlay,
+		})
+	}
+}
+
+// HandleDeleteOverlayEndpoint is the http endpoint handler for removing an
+// overlay and its events.
+func HandleDeleteOverlayEndpoint(overlaysService *overlays.Service, usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		overlay, ok := getUserOverlay(rw, r, overlaysService, usersService)
+		if !ok {
+			return
+		}
+		deletedOverlay, err := overlaysService.DeleteOverlay(r.Context(), overlay.ID)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(overlayApiResponse{
+			Status:  "success",
+			Message: "overlay deleted successfully",
+			Overlay: deletedOverlay,
+		})
+	}
+}
+
+// getUserOverlay returns the overlay of the overlayId url param when it
+// belongs to the user of the userId url param, who must be the logged in user.
+func getUserOverlay(rw http.ResponseWriter, r *http.Request, overlaysService *overlays.Service, usersService *users.Service) (*overlays.Overlay, bool) {
+	user, ok := getSelfUser(rw, r, usersService)
+	if !ok {
+		return nil, false
+	}
+	overlay, err := overlaysService.GetOverlay(r.Context(), chi.URLParam(r, "overlayId"))
+	if err != nil {
+		problemResponse(rw, err)
+		return nil, false
+	}
+	if overlay.UserID != user.ID {
+		problemResponse(rw, overlays.ErrOverlayNotFound)
+		return nil, false
+	}
+	return overlay, true
+}
@@ -0,0 +1,60 @@
+package httphandlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/wisdommatt/todo-list-api/services/users"
+)
+
+type forgotPasswordInput struct {
+	Email string `json:"email" validate:"required,max=254"`
+}
+
+type resetPasswordInput struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,password"`
+}
+
+// HandleForgotPasswordEndpoint is the http endpoint handler for requesting a
+// password reset email. The response is the same whether or not an account
+// exists for the email.
+func HandleForgotPasswordEndpoint(usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var payload forgotPasswordInput
+		if !decodeJSON(rw, r, &payload) {
+			return
+		}
+		// the email is sent in the background, so the response time does not
+		// tell whether the account exists either.
+		go usersService.RequestPasswordReset(context.Background(), payload.Email)
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(map[string]string{
+			"status":  "success",
+			"message": "if an account exists for this email, a password reset email was sent to it",
+		})
+	}
+}
+
+// HandleResetPasswordEndpoint is the http endpoint handler for choosing a
+// new password with a password reset token, it signs the user out everywhere.
+func HandleResetPasswordEndpoint(usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var payload resetPasswordInput
+		if !decodeJSON(rw, r, &payload) {
+			return
+		}
+		user, err := usersService.ResetPassword(r.Context(), payload.Token, payload.Password)
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(userApiResponse{
+			Status:  "success",
+			Message: "password reset successfully, please login again",
+			User:    user,
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/wisdommatt/todo-list-api/services/users"
+)
+
+type preferencesResponse struct {
+	Status      string            `json:"status"`
+	Message     string            `json:"message"`
+	Preferences users.Preferences `json:"preferences"`
+}
+
+// HandleGetPreferencesEndpoint is the http endpoint handler for retrieving
+// the user calendar preferences.
+func HandleGetPreferencesEndpoint(usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		user, ok := getSelfUser(rw, r, usersService)
+		if !ok {
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(preferencesResponse{
+			Status:      "success",
+			Message:     "preferences retrieved successfully",
+			Preferences: user.GetPreferences(),
+		})
+	}
+}
+
+// HandleUpdatePreferencesEndpoint is the http endpoint handler for replacing
+// the user calendar preferences, omitted preferences are reset to their default.
+func HandleUpdatePreferencesEndpoint(usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		user, ok := getSelfUser(rw, r, usersService)
+		if !ok {
+			return
+		}
+		var payload users.Preferences
+		if !decodeJSON(rw, r, &payload) {
+			return
+		}
+		preferences := users.User{Preferences: payload}.GetPreferences()
+		err := preferences.Validate()
+		if err != nil {
+			ErrorResponse(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		user, err = usersService.UpdatePreferences(r.Context(), user.ID, preferences)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(preferencesResponse{
+			Status:      "success",
+			Message:     "preferences updated successfully",
+			Preferences: user.GetPreferences(),
+		})
+	}
+}
+
+// getSelfUser retrieves the user in the url when it is the authenticated
+// user, writing an error response otherwise.
+func getSelfUser(rw http.ResponseWriter, r *http.Request, usersService *users.Service) (*users.User, bool) {
+	userID := chi.URLParam(r, "userId")
+	if userID != AuthUserID(r.Context()) {
+		ErrorResponse(rw, "you can only manage your own account", http.StatusForbidden)
+		return nil, false
+	}
+	user, err := usersService.GetUser(r.Context(), userID)
+	if err != nil {
+		problemResponse(rw, err)
+		return nil, false
+	}
+	return user, true
+}
+
+// userLocation returns the time zone of the tz query param, defaulting to
+// the user preferred time zone.
+func userLocation(r *http.Request, user *users.User) (*time.Location, error) {
+	value := r.URL.Query().Get("tz")
+	if value == "" {
+		return user.GetPreferences().Location(), nil
+	}
+	loc, err := time.LoadLocation(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone")
+	}
+	return loc, nil
+}
@@ -0,0 +1,132 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/wisdommatt/todo-list-api/services/projects"
+	"github.com/wisdommatt/todo-list-api/services/shares"
+	"github.com/wisdommatt/todo-list-api/services/tasks"
+)
+
+type createProjectPayload struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+type projectApiResponse struct {
+	Status  string            `json:"status"`
+	Message string            `json:"message"`
+	Project *projects.Project `json:"project"`
+}
+
+type getProjectsResponse struct {
+	Status   string             `json:"status"`
+	Message  string             `json:"message"`
+	Projects []projects.Project `json:"projects"`
+}
+
+// HandleCreateProjectEndpoint is the http endpoint handler for creating a new project.
+func HandleCreateProjectEndpoint(projectsService *projects.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var payload createProjectPayload
+		if !decodeJSON(rw, r, &payload) {
+			return
+		}
+		project, err := projectsService.CreateProject(r.Context(), projects.Project{
+			Name:   payload.Name,
+			UserID: AuthUserID(r.Context()),
+		})
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(projectApiResponse{
+			Status:  "success",
+			Message: "project created successfully",
+			Project: project,
+		})
+	}
+}
+
+// HandleGetProjectEndpoint is the http endpoint handler to get project details.
+func HandleGetProjectEndpoint(projectsService *projects.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		project, err := projectsService.GetProject(r.Context(), chi.URLParam(r, "projectId"))
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		if projectsService.ProjectRole(r.Context(), project, AuthUserID(r.Context())) == "" {
+			problemResponse(rw, projects.ErrProjectNotFound)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(projectApiResponse{
+			Status:  "success",
+			Message: "project retrieved successfully",
+			Project: project,
+		})
+	}
+}
+
+// HandleGetProjectsEndpoint is the http endpoint handler for retrieving the
+// projects owned by or shared with a user.
+func HandleGetProjectsEndpoint(projectsService *projects.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		userID := chi.URLParam(r, "userId")
+		if userID != AuthUserID(r.Context()) {
+			ErrorResponse(rw, "you can only view your own projects", http.StatusForbidden)
+			return
+		}
+		userProjects, err := projectsService.GetProjects(r.Context(), userID)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(getProjectsResponse{
+			Status:   "success",
+			Message:  "projects retrieved successfully",
+			Projects: userProjects,
+		})
+	}
+}
+
+// HandleDeleteProjectEndpoint is the http endpoint handler for deleting a
+// project, the project tasks are kept but no longer belong to the project.
+func HandleDeleteProjectEndpoint(projectsService *projects.Service, tasksService *tasks.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		projectID := chi.URLParam(r, "projectId")
+		project, err := projectsService.GetProject(r.Context(), projectID)
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		if projectsService.ProjectRole(r.Context(), project, AuthUserID(r.Context())) == "" {
+			problemResponse(rw, projects.ErrProjectNotFound)
+			return
+		}
+		if projectsService.ProjectRole(r.Context(), project, AuthUserID(r.Context())) != shares.RoleOwner {
+			ErrorResponse(rw, "only the project owners can delete the project", http.StatusForbidden)
+			return
+		}
+		err = tasksService.RemoveProjectTasks(r.Context(), projectID)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		project, err = projectsService.DeleteProject(r.Context(), projectID)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(projectApiResponse{
+			Status:  "success",
+			Message: "project deleted successfully",
+			Project: project,
+		})
+	}
+}
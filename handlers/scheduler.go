@@ -0,0 +1,102 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/wisdommatt/todo-list-api/services/tasks"
+	"github.com/wisdommatt/todo-list-api/services/users"
+)
+
+type schedulePlanResponse struct {
+	Status  string              `json:"status"`
+	Message string              `json:"message"`
+	Plan    *tasks.SchedulePlan `json:"plan"`
+}
+
+type commitSchedulePlanPayload struct {
+	PlanID string `json:"planId" validate:"required"`
+}
+
+// HandlePlanScheduleEndpoint is the http endpoint handler for previewing the
+// placement of the user flexible tasks in free time.
+func HandlePlanScheduleEndpoint(tasksService *tasks.Service, usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		userID := chi.URLParam(r, "userId")
+		if userID != AuthUserID(r.Context()) {
+			ErrorResponse(rw, "you can only plan your own schedule", http.StatusForbidden)
+			return
+		}
+		user, err := usersService.GetUser(r.Context(), userID)
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		opts, err := parseAvailabilityOptions(r, user.GetPreferences())
+		if err != nil {
+			ErrorResponse(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		horizon := tasks.DefaultScheduleHorizon
+		if value := r.URL.Query().Get("horizonDays"); value != "" {
+			maxDays := int(tasks.MaxScheduleHorizon / (24 * time.Hour))
+			days, err := strconv.Atoi(value)
+			if err != nil || days <= 0 || days > maxDays {
+				ErrorResponse(rw, fmt.Sprintf("horizonDays must be a number between 1 and %d", maxDays), http.StatusBadRequest)
+				return
+			}
+			horizon = time.Duration(days) * 24 * time.Hour
+		}
+		plan, err := tasksService.PlanSchedule(r.Context(), userID, horizon, opts)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(schedulePlanResponse{
+			Status:  "success",
+			Message: "schedule planned successfully, commit the plan to apply it",
+			Plan:    plan,
+		})
+	}
+}
+
+// HandleCommitSchedulePlanEndpoint is the http endpoint handler for applying
+// a previewed schedule plan.
+func HandleCommitSchedulePlanEndpoint(tasksService *tasks.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		userID := chi.URLParam(r, "userId")
+		if userID != AuthUserID(r.Context()) {
+			ErrorResponse(rw, "you can only commit your own schedule", http.StatusForbidden)
+			return
+		}
+		var payload commitSchedulePlanPayload
+		if !decodeJSON(rw, r, &payload) {
+			return
+		}
+		plan, err := tasksService.GetSchedulePlan(r.Context(), payload.PlanID)
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		if plan.UserID != userID {
+			problemResponse(rw, tasks.ErrSchedulePlanNotFound)
+			return
+		}
+		plan, err = tasksService.CommitSchedulePlan(r.Context(), plan)
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(schedulePlanResponse{
+			Status:  "success",
+			Message: "schedule plan committed successfully",
+			Plan:    plan,
+		})
+	}
+}
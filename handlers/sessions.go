@@ -0,0 +1,61 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/wisdommatt/todo-list-api/services/users"
+)
+
+type getSessionsResponse struct {
+	Status   string          `json:"status"`
+	Message  string          `json:"message"`
+	Sessions []users.Session `json:"sessions"`
+}
+
+// HandleGetSessionsEndpoint is the http endpoint handler for listing the
+// devices the user is logged in on.
+func HandleGetSessionsEndpoint(usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		user, ok := getSelfUser(rw, r, usersService)
+		if !ok {
+			return
+		}
+		sessions, err := usersService.GetSessions(r.Context(), user.ID)
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		for i := range sessions {
+			sessions[i].Current = sessions[i].ID == SessionID(r.Context())
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(getSessionsResponse{
+			Status:   "success",
+			Message:  "sessions retrieved successfully",
+			Sessions: sessions,
+		})
+	}
+}
+
+// HandleDeleteSessionEndpoint is the http endpoint handler for logging the
+// user out of a device, the auth tokens of the session are rejected at once.
+func HandleDeleteSessionEndpoint(usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		user, ok := getSelfUser(rw, r, usersService)
+		if !ok {
+			return
+		}
+		err := usersService.DeleteSession(r.Context(), user.ID, chi.URLParam(r, "sessionId"))
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(getSessionsResponse{
+			Status:  "success",
+			Message: "session revoked successfully",
+		})
+	}
+}
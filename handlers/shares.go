@@ -0,0 +1,235 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/wisdommatt/todo-list-api/services/projects"
+	"github.com/wisdommatt/todo-list-api/services/shares"
+	"github.com/wisdommatt/todo-list-api/services/tasks"
+	"github.com/wisdommatt/todo-list-api/services/users"
+)
+
+// resourceURLParams maps shared resource types to the url param holding their id.
+var resourceURLParams = map[string]string{
+	shares.ResourceTask:    "taskId",
+	shares.ResourceProject: "projectId",
+}
+
+type createSharePayload struct {
+	UserID string `json:"userId"`
+	Email  string `json:"email" validate:"email"`
+	Role   string `json:"role" validate:"required"`
+}
+
+type shareApiResponse struct {
+	Status  string        `json:"status"`
+	Message string        `json:"message"`
+	Share   *shares.Share `json:"share"`
+}
+
+type getSharesResponse struct {
+	Status  string         `json:"status"`
+	Message string         `json:"message"`
+	Shares  []shares.Share `json:"shares"`
+}
+
+// HandleCreateShareEndpoint is the http endpoint handler for inviting a user
+// to a task or project, only owners can share a resource.
+func HandleCreateShareEndpoint(resourceType string, tasksService *tasks.Service, projectsService *projects.Service, sharesService *shares.Service, usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		resourceID, ok := getOwnedResource(rw, r, resourceType, tasksService, projectsService)
+		if !ok {
+			return
+		}
+		var payload createSharePayload
+		if !decodeJSON(rw, r, &payload) {
+			return
+		}
+		if !shares.IsValidRole(payload.Role) {
+			ErrorResponse(rw, "role must be one of viewer, editor or owner", http.StatusBadRequest)
+			return
+		}
+		var user *users.User
+		var err error
+		if payload.UserID != "" {
+			user, err = usersService.GetUser(r.Context(), payload.UserID)
+		} else {
+			user, err = usersService.GetUserByEmail(r.Context(), payload.Email)
+		}
+		if err == users.ErrUserNotFound {
+			ErrorResponse(rw, "user does not exist", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		if user.ID == AuthUserID(r.Context()) {
+			ErrorResponse(rw, "you cannot share with yourself", http.StatusBadRequest)
+			return
+		}
+		share, err := sharesService.CreateShare(r.Context(), shares.Share{
+			ResourceType: resourceType,
+			ResourceID:   resourceID,
+			UserID:       user.ID,
+			Role:         payload.Role,
+			InvitedBy:    AuthUserID(r.Context()),
+		})
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(shareApiResponse{
+			Status:  "success",
+			Message: "invitation sent successfully",
+			Share:   share,
+		})
+	}
+}
+
+// HandleGetSharesEndpoint is the http endpoint handler for retrieving the shares of a task or project.
+func HandleGetSharesEndpoint(resourceType string, tasksService *tasks.Service, projectsService *projects.Service, sharesService *shares.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		resourceID, ok := getOwnedResource(rw, r, resourceType, tasksService, projectsService)
+		if !ok {
+			return
+		}
+		resourceShares, err := sharesService.GetResourceShares(r.Context(), resourceType, resourceID)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(getSharesResponse{
+			Status:  "success",
+			Message: "shares retrieved successfully",
+			Shares:  resourceShares,
+		})
+	}
+}
+
+// HandleDeleteShareEndpoint is the http endpoint handler for revoking a share of a task or project.
+func HandleDeleteShareEndpoint(resourceType string, tasksService *tasks.Service, projectsService *projects.Service, sharesService *shares.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		resourceID, ok := getOwnedResource(rw, r, resourceType, tasksService, projectsService)
+		if !ok {
+			return
+		}
+		share, err := sharesService.GetShare(r.Context(), chi.URLParam(r, "shareId"))
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		if share.ResourceType != resourceType || share.ResourceID != resourceID {
+			problemResponse(rw, shares.ErrShareNotFound)
+			return
+		}
+		share, err = sharesService.DeleteShare(r.Context(), share.ID)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(shareApiResponse{
+			Status:  "success",
+			Message: "share deleted successfully",
+			Share:   share,
+		})
+	}
+}
+
+// HandleGetUserSharesEndpoint is the http endpoint handler for retrieving the
+// shares and invitations received by a user, filtered by the status url parameter.
+func HandleGetUserSharesEndpoint(sharesService *shares.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		userID := chi.URLParam(r, "userId")
+		if userID != AuthUserID(r.Context()) {
+			ErrorResponse(rw, "you can only view your own invitations", http.StatusForbidden)
+			return
+		}
+		userShares, err := sharesService.GetUserShares(r.Context(), userID, r.URL.Query().Get("status"))
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(getSharesResponse{
+			Status:  "success",
+			Message: "shares retrieved successfully",
+			Shares:  userShares,
+		})
+	}
+}
+
+// HandleRespondToShareEndpoint is the http endpoint handler for accepting or declining an invitation.
+func HandleRespondToShareEndpoint(sharesService *shares.Service, accept bool) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		userID := chi.URLParam(r, "userId")
+		share, err := sharesService.GetShare(r.Context(), chi.URLParam(r, "shareId"))
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		if userID != AuthUserID(r.Context()) || share.UserID != userID {
+			problemResponse(rw, shares.ErrShareNotFound)
+			return
+		}
+		share, err = sharesService.RespondToShare(r.Context(), share.ID, accept)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		message := "invitation declined successfully"
+		if accept {
+			message = "invitation accepted successfully"
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(shareApiResponse{
+			Status:  "success",
+			Message: message,
+			Share:   share,
+		})
+	}
+}
+
+// getOwnedResource returns the id of the task or project in the url when the
+// authenticated user owns it, writing an error response otherwise.
+func getOwnedResource(rw http.ResponseWriter, r *http.Request, resourceType string, tasksService *tasks.Service, projectsService *projects.Service) (string, bool) {
+	resourceID := chi.URLParam(r, resourceURLParams[resourceType])
+	authUserID := AuthUserID(r.Context())
+	role := ""
+	var err, errNotFound error
+	switch resourceType {
+	case shares.ResourceTask:
+		errNotFound = tasks.ErrTaskNotFound
+		var task *tasks.Task
+		task, err = tasksService.GetTask(r.Context(), resourceID)
+		if err == nil {
+			role = tasksService.TaskRole(r.Context(), task, authUserID)
+		}
+
+	case shares.ResourceProject:
+		errNotFound = projects.ErrProjectNotFound
+		var project *projects.Project
+		project, err = projectsService.GetProject(r.Context(), resourceID)
+		if err == nil {
+			role = projectsService.ProjectRole(r.Context(), project, authUserID)
+		}
+	}
+	if err != nil && err != errNotFound {
+		problemResponse(rw, err)
+		return "", false
+	}
+	if role == "" {
+		problemResponse(rw, errNotFound)
+		return "", false
+	}
+	if role != shares.RoleOwner {
+		ErrorResponse(rw, "only owners can manage the "+resourceType+" shares", http.StatusForbidden)
+		return "", false
+	}
+	return resourceID, true
+}
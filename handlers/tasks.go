@@ -5,8 +5,12 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi"
+	"github.com/wisdommatt/todo-list-api/internal/rrule"
+	"github.com/wisdommatt/todo-list-api/services/projects"
+	"github.com/wisdommatt/todo-list-api/services/shares"
 	"github.com/wisdommatt/todo-list-api/services/tasks"
 	"github.com/wisdommatt/todo-list-api/services/users"
 )
@@ -23,34 +27,142 @@ type getTasksResponse struct {
 	Tasks   []tasks.Task `json:"tasks"`
 }
 
+type createTaskPayload struct {
+	Title     string    `json:"title" validate:"required,max=200"`
+	StartTime time.Time `json:"startTime" validate:"required_unless=Flexible"`
+	EndTime   time.Time `json:"endTime" validate:"required_with=StartTime,after=StartTime"`
+	// UserID is the owner of the task, the authenticated user by default.
+	UserID           string    `json:"userId"`
+	Assignees        []string  `json:"assignees" validate:"max=50"`
+	ProjectID        string    `json:"projectId"`
+	Status           string    `json:"status" validate:"max=50"`
+	Tags             []string  `json:"tags" validate:"max=50"`
+	AllDay           bool      `json:"allDay"`
+	Recurrence       string    `json:"recurrence" validate:"max=500"`
+	Flexible         bool      `json:"flexible"`
+	EstimatedMinutes int       `json:"estimatedMinutes" validate:"min=0"`
+	Deadline         time.Time `json:"deadline"`
+	Priority         int       `json:"priority"`
+	DependsOn        []string  `json:"dependsOn" validate:"max=50"`
+}
+
 type updateTaskPayload struct {
-	Status string `json:"status"`
+	Status string `json:"status" validate:"required,max=50"`
 }
 
 // HandleCreateTaskEndpoint is the http endpoint handler for creating a new task.
-func HandleCreateTaskEndpoint(tasksService *tasks.Service, usersService *users.Service) http.HandlerFunc {
+func HandleCreateTaskEndpoint(tasksService *tasks.Service, usersService *users.Service, projectsService *projects.Service) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
-		var payload tasks.Task
-		err := json.NewDecoder(r.Body).Decode(&payload)
-		if err != nil {
-			ErrorResponse(rw, "error", "invalid json payload", http.StatusBadRequest)
+		var input createTaskPayload
+		if !decodeJSON(rw, r, &input) {
+			return
+		}
+		payload := tasks.Task{
+			Title:            input.Title,
+			StartTime:        input.StartTime,
+			EndTime:          input.EndTime,
+			UserID:           input.UserID,
+			Assignees:        input.Assignees,
+			ProjectID:        input.ProjectID,
+			Status:           input.Status,
+			Tags:             input.Tags,
+			AllDay:           input.AllDay,
+			Recurrence:       input.Recurrence,
+			Flexible:         input.Flexible,
+			EstimatedMinutes: input.EstimatedMinutes,
+			Deadline:         input.Deadline,
+			Priority:         input.Priority,
+			DependsOn:        input.DependsOn,
+		}
+		payload.CreatedBy = AuthUserID(r.Context())
+		if payload.UserID == "" {
+			payload.UserID = payload.CreatedBy
+		}
+		owner, err := usersService.GetUser(r.Context(), payload.UserID)
+		if err == users.ErrUserNotFound {
+			ErrorResponse(rw, "user does not exist", http.StatusBadRequest)
 			return
 		}
-		_, err = usersService.GetUser(r.Context(), payload.UserID)
 		if err != nil {
-			ErrorResponse(rw, "error", "user does not exist", http.StatusBadRequest)
+			problemResponse(rw, err)
 			return
 		}
-		// checking if the new task is overlapping with another existing task.
-		overlappingTask, _ := tasksService.GetTaskWithinTimeRange(r.Context(), payload.UserID, payload.StartTime, payload.EndTime)
+		participants := []*users.User{owner}
+		for _, assignee := range payload.Assignees {
+			user, err := usersService.GetUser(r.Context(), assignee)
+			if err == users.ErrUserNotFound {
+				ErrorResponse(rw, "assignee does not exist", http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				problemResponse(rw, err)
+				return
+			}
+			participants = append(participants, user)
+		}
+		if payload.Recurrence != "" {
+			rule, err := rrule.Parse(payload.Recurrence)
+			if err != nil {
+				ErrorResponse(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if payload.StartTime.IsZero() {
+				ErrorResponse(rw, "recurring tasks must have a start time", http.StatusBadRequest)
+				return
+			}
+			payload.Recurrence = rule.String()
+		}
+		if !payload.StartTime.IsZero() && !payload.AllDay {
+			for _, participant := range participants {
+				preferences := participant.GetPreferences()
+				if preferences.RejectOutsideWorkingHours &&
+					!tasks.WithinWorkingHours(payload.StartTime, payload.EndTime, tasks.UserAvailabilityOptions(preferences)) {
+					errMsg := fmt.Sprintf("this task is outside of the working hours of %s %s", participant.FirstName, participant.LastName)
+					ErrorResponse(rw, errMsg, http.StatusBadRequest)
+					return
+				}
+			}
+		}
+		if payload.ProjectID != "" {
+			project, err := projectsService.GetProject(r.Context(), payload.ProjectID)
+			if err != nil && err != projects.ErrProjectNotFound {
+				problemResponse(rw, err)
+				return
+			}
+			if err != nil || !shares.RoleAtLeast(projectsService.ProjectRole(r.Context(), project, AuthUserID(r.Context())), shares.RoleEditor) {
+				ErrorResponse(rw, "project does not exist", http.StatusBadRequest)
+				return
+			}
+		}
+		if payload.Flexible && payload.EstimatedMinutes <= 0 {
+			ErrorResponse(rw, "flexible tasks must have an estimated duration", http.StatusBadRequest)
+			return
+		}
+		for _, dependencyID := range payload.DependsOn {
+			_, err := tasksService.GetVisibleTask(r.Context(), dependencyID, AuthUserID(r.Context()))
+			if err == tasks.ErrTaskNotFound {
+				ErrorResponse(rw, "dependency task does not exist", http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				problemResponse(rw, err)
+				return
+			}
+		}
+		// checking if the new task is overlapping with another existing task
+		// of the owner or any of the assignees, unscheduled flexible tasks
+		// are placed later by the scheduler and all-day tasks do not make users busy.
+		var overlappingTask *tasks.Task
+		if (!payload.Flexible || !payload.StartTime.IsZero()) && !payload.AllDay {
+			overlappingTask, _ = tasksService.GetOverlappingTask(r.Context(), payload.Participants(), payload.StartTime, payload.EndTime, "")
+		}
 		if overlappingTask != nil {
-			errMsg := fmt.Sprintf("this task if overlapping with %s, pick another time", overlappingTask.Title)
-			ErrorResponse(rw, "error", errMsg, http.StatusBadRequest)
+			overlappingTaskErrorResponse(rw, r, tasksService, payload, overlappingTask, tasks.UserAvailabilityOptions(owner.GetPreferences()))
 			return
 		}
 		task, err := tasksService.CreateTask(r.Context(), payload)
 		if err != nil {
-			ErrorResponse(rw, "error", errSomethingWentWrongMsg, http.StatusInternalServerError)
+			problemResponse(rw, err)
 			return
 		}
 		rw.WriteHeader(http.StatusOK)
@@ -66,9 +178,9 @@ func HandleCreateTaskEndpoint(tasksService *tasks.Service, usersService *users.S
 func HandleGetTaskEndpoint(tasksService *tasks.Service) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		taskID := chi.URLParam(r, "taskId")
-		task, err := tasksService.GetTask(r.Context(), taskID)
+		task, err := tasksService.GetVisibleTask(r.Context(), taskID, AuthUserID(r.Context()))
 		if err != nil {
-			ErrorResponse(rw, "error", "task does not exist", http.StatusBadRequest)
+			problemResponse(rw, err)
 			return
 		}
 		rw.WriteHeader(http.StatusOK)
@@ -84,11 +196,15 @@ func HandleGetTaskEndpoint(tasksService *tasks.Service) http.HandlerFunc {
 func HandleGetTasksEndpoint(tasksService *tasks.Service) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		userID := chi.URLParam(r, "userId")
+		if userID != AuthUserID(r.Context()) {
+			ErrorResponse(rw, "you can only view your own tasks", http.StatusForbidden)
+			return
+		}
 		lastID := r.URL.Query().Get("lastId")
 		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
 		tasks, err := tasksService.GetTasks(r.Context(), userID, lastID, limit)
 		if err != nil {
-			ErrorResponse(rw, "error", errSomethingWentWrongMsg, http.StatusInternalServerError)
+			problemResponse(rw, err)
 			return
 		}
 		rw.WriteHeader(http.StatusOK)
@@ -104,14 +220,19 @@ func HandleGetTasksEndpoint(tasksService *tasks.Service) http.HandlerFunc {
 func HandleDeleteTaskEndpoint(tasksService *tasks.Service) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		taskID := chi.URLParam(r, "taskId")
-		_, err := tasksService.GetTask(r.Context(), taskID)
+		authUserID := AuthUserID(r.Context())
+		task, err := tasksService.GetVisibleTask(r.Context(), taskID, authUserID)
 		if err != nil {
-			ErrorResponse(rw, "error", "invalid task id", http.StatusBadRequest)
+			problemResponse(rw, err)
 			return
 		}
-		task, err := tasksService.DeleteTask(r.Context(), taskID)
+		if !tasksService.CanManageTask(r.Context(), task, authUserID) {
+			ErrorResponse(rw, "only the task owners can delete the task", http.StatusForbidden)
+			return
+		}
+		task, err = tasksService.DeleteTask(r.Context(), taskID)
 		if err != nil {
-			ErrorResponse(rw, "error", errSomethingWentWrongMsg, http.StatusInternalServerError)
+			problemResponse(rw, err)
 			return
 		}
 		rw.WriteHeader(http.StatusOK)
@@ -127,17 +248,25 @@ func HandleDeleteTaskEndpoint(tasksService *tasks.Service) http.HandlerFunc {
 func HandleUpdateTaskEndpoint(tasksService *tasks.Service) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		taskID := chi.URLParam(r, "taskId")
-		var payload updateTaskPayload
-		err := json.NewDecoder(r.Body).Decode(&payload)
+		authUserID := AuthUserID(r.Context())
+		task, err := tasksService.GetVisibleTask(r.Context(), taskID, authUserID)
 		if err != nil {
-			ErrorResponse(rw, "error", "invalid json payload", http.StatusBadRequest)
+			problemResponse(rw, err)
 			return
 		}
-		task, err := tasksService.UpdateTask(r.Context(), taskID, tasks.Task{
+		if !tasksService.CanEditTask(r.Context(), task, authUserID) {
+			problemResponse(rw, tasks.ErrTaskForbidden)
+			return
+		}
+		var payload updateTaskPayload
+		if !decodeJSON(rw, r, &payload) {
+			return
+		}
+		task, err = tasksService.UpdateTask(r.Context(), taskID, tasks.Task{
 			Status: payload.Status,
 		})
 		if err != nil {
-			ErrorResponse(rw, "error", errSomethingWentWrongMsg, http.StatusInternalServerError)
+			problemResponse(rw, err)
 			return
 		}
 		rw.WriteHeader(http.StatusOK)
@@ -148,3 +277,159 @@ func HandleUpdateTaskEndpoint(tasksService *tasks.Service) http.HandlerFunc {
 		})
 	}
 }
+
+type bulkTasksPayload struct {
+	Atomic     bool                  `json:"atomic"`
+	Operations []tasks.BulkOperation `json:"operations" validate:"required,max=500"`
+}
+
+type bulkTasksResponse struct {
+	Status  string             `json:"status"`
+	Message string             `json:"message"`
+	Results []tasks.BulkResult `json:"results"`
+}
+
+type bulkTasksAbortedResponse struct {
+	problem
+	Results []tasks.BulkResult `json:"results"`
+}
+
+// HandleBulkTasksEndpoint is the http endpoint handler for running bulk task operations.
+func HandleBulkTasksEndpoint(tasksService *tasks.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var payload bulkTasksPayload
+		if !decodeJSON(rw, r, &payload) {
+			return
+		}
+		results, err := tasksService.BulkTasks(r.Context(), AuthUserID(r.Context()), payload.Operations, payload.Atomic)
+		if err == tasks.ErrBulkAborted {
+			writeProblem(rw, http.StatusConflict, bulkTasksAbortedResponse{
+				problem: errorProblem(err),
+				Results: results,
+			})
+			return
+		}
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(bulkTasksResponse{
+			Status:  "success",
+			Message: "bulk operations processed",
+			Results: results,
+		})
+	}
+}
+
+type assignTaskPayload struct {
+	UserID string `json:"userId" validate:"required"`
+}
+
+// HandleAssignTaskEndpoint is the http endpoint handler for assigning a task to a user.
+func HandleAssignTaskEndpoint(tasksService *tasks.Service, usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		task, ok := getEditableTask(rw, r, tasksService)
+		if !ok {
+			return
+		}
+		var payload assignTaskPayload
+		if !decodeJSON(rw, r, &payload) {
+			return
+		}
+		assignee, err := usersService.GetUser(r.Context(), payload.UserID)
+		if err == users.ErrUserNotFound {
+			ErrorResponse(rw, "user does not exist", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		preferences := assignee.GetPreferences()
+		if preferences.RejectOutsideWorkingHours && !task.StartTime.IsZero() &&
+			!tasks.WithinWorkingHours(task.StartTime, task.EndTime, tasks.UserAvailabilityOptions(preferences)) {
+			ErrorResponse(rw, "this task is outside of the assignee working hours", http.StatusBadRequest)
+			return
+		}
+		// checking if the task is overlapping with another task in the assignee calendar.
+		overlappingTask, _ := tasksService.GetOverlappingTask(r.Context(), []string{payload.UserID}, task.StartTime, task.EndTime, task.ID)
+		if overlappingTask != nil {
+			errMsg := fmt.Sprintf("this task if overlapping with %s in the assignee calendar", overlappingTask.Title)
+			ErrorResponse(rw, errMsg, http.StatusBadRequest)
+			return
+		}
+		task, err = tasksService.AssignTask(r.Context(), task.ID, payload.UserID, AuthUserID(r.Context()))
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(taskApiResponse{
+			Status:  "success",
+			Message: "task assigned successfully",
+			Task:    task,
+		})
+	}
+}
+
+// HandleUnassignTaskEndpoint is the http endpoint handler for removing a task assignee.
+func HandleUnassignTaskEndpoint(tasksService *tasks.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		task, ok := getEditableTask(rw, r, tasksService)
+		if !ok {
+			return
+		}
+		task, err := tasksService.UnassignTask(r.Context(), task.ID, chi.URLParam(r, "userId"))
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(taskApiResponse{
+			Status:  "success",
+			Message: "task unassigned successfully",
+			Task:    task,
+		})
+	}
+}
+
+// HandleGetAssignedTasksEndpoint is the http endpoint handler for retrieving the tasks assigned to a user.
+func HandleGetAssignedTasksEndpoint(tasksService *tasks.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		userID := chi.URLParam(r, "userId")
+		if userID != AuthUserID(r.Context()) {
+			ErrorResponse(rw, "you can only view your own tasks", http.StatusForbidden)
+			return
+		}
+		lastID := r.URL.Query().Get("lastId")
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		tasks, err := tasksService.GetAssignedTasks(r.Context(), userID, lastID, limit)
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(getTasksResponse{
+			Status:  "success",
+			Message: "assigned tasks retrieved successfully",
+			Tasks:   tasks,
+		})
+	}
+}
+
+// getEditableTask retrieves the task in the url when the authenticated user
+// can modify it, writing an error response otherwise.
+func getEditableTask(rw http.ResponseWriter, r *http.Request, tasksService *tasks.Service) (*tasks.Task, bool) {
+	authUserID := AuthUserID(r.Context())
+	task, err := tasksService.GetVisibleTask(r.Context(), chi.URLParam(r, "taskId"), authUserID)
+	if err != nil {
+		problemResponse(rw, err)
+		return nil, false
+	}
+	if !tasksService.CanEditTask(r.Context(), task, authUserID) {
+		problemResponse(rw, tasks.ErrTaskForbidden)
+		return nil, false
+	}
+	return task, true
+}
@@ -0,0 +1,321 @@
+package httphandlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/wisdommatt/todo-list-api/services/tasks"
+	"github.com/wisdommatt/todo-list-api/services/timeentries"
+	"github.com/wisdommatt/todo-list-api/services/users"
+)
+
+type timeEntryPayload struct {
+	StartTime time.Time `json:"startTime" validate:"required"`
+	EndTime   time.Time `json:"endTime" validate:"required,after=StartTime"`
+	Note      string    `json:"note" validate:"max=1000"`
+}
+
+type timeEntryApiResponse struct {
+	Status    string                 `json:"status"`
+	Message   string                 `json:"message"`
+	TimeEntry *timeentries.TimeEntry `json:"timeEntry"`
+}
+
+type taskTimeSummary struct {
+	TrackedSeconds int64 `json:"trackedSeconds"`
+	PlannedSeconds int64 `json:"plannedSeconds"`
+	// DifferenceSeconds is the tracked time minus the planned time.
+	DifferenceSeconds int64 `json:"differenceSeconds"`
+}
+
+type getTimeEntriesResponse struct {
+	Status      string                  `json:"status"`
+	Message     string                  `json:"message"`
+	Summary     taskTimeSummary         `json:"summary"`
+	TimeEntries []timeentries.TimeEntry `json:"timeEntries"`
+}
+
+type timesheetResponse struct {
+	Status  string                     `json:"status"`
+	Message string                     `json:"message"`
+	Days    []timeentries.TimesheetDay `json:"days"`
+}
+
+// HandleStartTimerEndpoint is the http endpoint handler for starting a timer on a task.
+func HandleStartTimerEndpoint(tasksService *tasks.Service, timeEntriesService *timeentries.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		task, ok := getEditableTask(rw, r, tasksService)
+		if !ok {
+			return
+		}
+		entry, err := timeEntriesService.StartTimer(r.Context(), task.ID, task.ProjectID, AuthUserID(r.Context()))
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(timeEntryApiResponse{
+			Status:    "success",
+			Message:   "timer started successfully",
+			TimeEntry: entry,
+		})
+	}
+}
+
+// HandleStopTimerEndpoint is the http endpoint handler for stopping the running timer on a task.
+func HandleStopTimerEndpoint(tasksService *tasks.Service, timeEntriesService *timeentries.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		task, ok := getEditableTask(rw, r, tasksService)
+		if !ok {
+			return
+		}
+		entry, err := timeEntriesService.StopTimer(r.Context(), task.ID, AuthUserID(r.Context()))
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(timeEntryApiResponse{
+			Status:    "success",
+			Message:   "timer stopped successfully",
+			TimeEntry: entry,
+		})
+	}
+}
+
+// HandleCreateTimeEntryEndpoint is the http endpoint handler for adding a manual time entry to a task.
+func HandleCreateTimeEntryEndpoint(tasksService *tasks.Service, timeEntriesService *timeentries.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		task, ok := getEditableTask(rw, r, tasksService)
+		if !ok {
+			return
+		}
+		payload, ok := decodeTimeEntryPayload(rw, r)
+		if !ok {
+			return
+		}
+		entry, err := timeEntriesService.CreateTimeEntry(r.Context(), timeentries.TimeEntry{
+			TaskID:    task.ID,
+			UserID:    AuthUserID(r.Context()),
+			ProjectID: task.ProjectID,
+			StartTime: payload.StartTime,
+			EndTime:   payload.EndTime,
+			Note:      payload.Note,
+		})
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(timeEntryApiResponse{
+			Status:    "success",
+			Message:   "time entry created successfully",
+			TimeEntry: entry,
+		})
+	}
+}
+
+// HandleGetTimeEntriesEndpoint is the http endpoint handler for retrieving the
+// time entries of a task with the tracked time compared to the planned time.
+func HandleGetTimeEntriesEndpoint(tasksService *tasks.Service, timeEntriesService *timeentries.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		task, err := tasksService.GetVisibleTask(r.Context(), chi.URLParam(r, "taskId"), AuthUserID(r.Context()))
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		entries, err := timeEntriesService.GetTaskTimeEntries(r.Context(), task.ID)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		var summary taskTimeSummary
+		now := time.Now()
+		for _, entry := range entries {
+			summary.TrackedSeconds += entry.ElapsedSeconds(now)
+		}
+		if task.EndTime.After(task.StartTime) {
+			summary.PlannedSeconds = int64(task.EndTime.Sub(task.StartTime).Seconds())
+		}
+		summary.DifferenceSeconds = summary.TrackedSeconds - summary.PlannedSeconds
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(getTimeEntriesResponse{
+			Status:      "success",
+			Message:     "time entries retrieved successfully",
+			Summary:     summary,
+			TimeEntries: entries,
+		})
+	}
+}
+
+// HandleUpdateTimeEntryEndpoint is the http endpoint handler for editing a time entry.
+func HandleUpdateTimeEntryEndpoint(tasksService *tasks.Service, timeEntriesService *timeentries.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		entry, ok := getUserTimeEntry(rw, r, tasksService, timeEntriesService)
+		if !ok {
+			return
+		}
+		if entry.Running {
+			ErrorResponse(rw, "stop the timer before editing it", http.StatusBadRequest)
+			return
+		}
+		payload, ok := decodeTimeEntryPayload(rw, r)
+		if !ok {
+			return
+		}
+		entry, err := timeEntriesService.UpdateTimeEntry(r.Context(), entry.ID, payload.StartTime, payload.EndTime, payload.Note)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(timeEntryApiResponse{
+			Status:    "success",
+			Message:   "time entry updated successfully",
+			TimeEntry: entry,
+		})
+	}
+}
+
+// HandleDeleteTimeEntryEndpoint is the http endpoint handler for deleting a time entry.
+func HandleDeleteTimeEntryEndpoint(tasksService *tasks.Service, timeEntriesService *timeentries.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		entry, ok := getUserTimeEntry(rw, r, tasksService, timeEntriesService)
+		if !ok {
+			return
+		}
+		entry, err := timeEntriesService.DeleteTimeEntry(r.Context(), entry.ID)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(timeEntryApiResponse{
+			Status:    "success",
+			Message:   "time entry deleted successfully",
+			TimeEntry: entry,
+		})
+	}
+}
+
+// HandleGetTimesheetEndpoint is the http endpoint handler for the user
+// timesheet report, the report is returned as csv when format=csv.
+func HandleGetTimesheetEndpoint(timeEntriesService *timeentries.Service, usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		userID := chi.URLParam(r, "userId")
+		if userID != AuthUserID(r.Context()) {
+			ErrorResponse(rw, "you can only view your own timesheet", http.StatusForbidden)
+			return
+		}
+		user, err := usersService.GetUser(r.Context(), userID)
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		loc, err := userLocation(r, user)
+		if err != nil {
+			ErrorResponse(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		from, to, err := parseTimeRangeParams(r, loc)
+		if err != nil {
+			ErrorResponse(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		days, err := timeEntriesService.GetTimesheet(r.Context(), userID, from, to, loc)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Query().Get("format") == "csv" || strings.Contains(r.Header.Get("Accept"), "text/csv") {
+			writeTimesheetCSV(rw, days)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(timesheetResponse{
+			Status:  "success",
+			Message: "timesheet retrieved successfully",
+			Days:    days,
+		})
+	}
+}
+
+func writeTimesheetCSV(rw http.ResponseWriter, days []timeentries.TimesheetDay) {
+	rw.Header().Set("Content-Type", "text/csv")
+	rw.Header().Set("Content-Disposition", `attachment; filename="timesheet.csv"`)
+	rw.WriteHeader(http.StatusOK)
+	writer := csv.NewWriter(rw)
+	writer.Write([]string{"date", "projectId", "seconds", "hours"})
+	for _, day := range days {
+		for _, project := range day.Projects {
+			writer.Write([]string{
+				day.Date,
+				project.ProjectID,
+				fmt.Sprint(project.Seconds),
+				fmt.Sprintf("%.2f", float64(project.Seconds)/3600),
+			})
+		}
+	}
+	writer.Flush()
+}
+
+// getUserTimeEntry retrieves the time entry in the url when it belongs to
+// the authenticated user, writing an error response otherwise.
+func getUserTimeEntry(rw http.ResponseWriter, r *http.Request, tasksService *tasks.Service, timeEntriesService *timeentries.Service) (*timeentries.TimeEntry, bool) {
+	task, ok := getEditableTask(rw, r, tasksService)
+	if !ok {
+		return nil, false
+	}
+	entry, err := timeEntriesService.GetTimeEntry(r.Context(), chi.URLParam(r, "entryId"))
+	if err != nil {
+		problemResponse(rw, err)
+		return nil, false
+	}
+	if entry.TaskID != task.ID || entry.UserID != AuthUserID(r.Context()) {
+		problemResponse(rw, timeentries.ErrTimeEntryNotFound)
+		return nil, false
+	}
+	return entry, true
+}
+
+func decodeTimeEntryPayload(rw http.ResponseWriter, r *http.Request) (*timeEntryPayload, bool) {
+	var payload timeEntryPayload
+	if !decodeJSON(rw, r, &payload) {
+		return nil, false
+	}
+	return &payload, true
+}
+
+// parseTimeRangeParams parses the from and to url parameters, which are
+// either RFC 3339 times or YYYY-MM-DD dates in loc. A date used as the
+// end of the range includes the whole day.
+func parseTimeRangeParams(r *http.Request, loc *time.Location) (time.Time, time.Time, error) {
+	from, err := parseTimeParam(r.URL.Query().Get("from"), loc, false)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must be a valid date or time")
+	}
+	to, err := parseTimeParam(r.URL.Query().Get("to"), loc, true)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("to must be a valid date or time")
+	}
+	if !to.After(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("to must be after from")
+	}
+	return from, to, nil
+}
+
+func parseTimeParam(value string, loc *time.Location, endOfDay bool) (time.Time, error) {
+	date, err := time.ParseInLocation("2006-01-02", value, loc)
+	if err == nil {
+		if endOfDay {
+			date = date.AddDate(0, 0, 1)
+		}
+		return date, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -2,19 +2,24 @@ package httphandlers
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi"
+	"github.com/wisdommatt/todo-list-api/internal/jwt"
+	"github.com/wisdommatt/todo-list-api/internal/tenant"
 	"github.com/wisdommatt/todo-list-api/services/users"
+	"github.com/wisdommatt/todo-list-api/services/workspaces"
 )
 
 type createUserInput struct {
-	FirstName string `json:"firstName" bson:"firstName,omitempty"`
-	LastName  string `json:"lastName" bson:"lastName,omitempty"`
-	Email     string `json:"email" bson:"email,omitempty"`
-	Password  string `json:"password" bson:"password,omitempty"`
+	FirstName string `json:"firstName" bson:"firstName,omitempty" validate:"required,max=100"`
+	LastName  string `json:"lastName" bson:"lastName,omitempty" validate:"max=100"`
+	Email     string `json:"email" bson:"email,omitempty" validate:"required,email,max=254"`
+	Handle    string `json:"handle" bson:"handle,omitempty" validate:"max=50"`
+	Password  string `json:"password" bson:"password,omitempty" validate:"required,password"`
 }
 
 type userApiResponse struct {
@@ -29,9 +34,21 @@ type getUsersResponse struct {
 	Users   []users.User `json:"users"`
 }
 
+type updateUserInput struct {
+	FirstName       string `json:"firstName" validate:"max=100"`
+	LastName        string `json:"lastName" validate:"max=100"`
+	Email           string `json:"email" validate:"email,max=254"`
+	CurrentPassword string `json:"currentPassword"`
+}
+
+type changePasswordInput struct {
+	CurrentPassword string `json:"currentPassword" validate:"required"`
+	NewPassword     string `json:"newPassword" validate:"required,password"`
+}
+
 type loginUserInput struct {
-	Email    string `json:"email" bson:"email,omitempty"`
-	Password string `json:"password" bson:"password,omitempty"`
+	Email    string `json:"email" bson:"email,omitempty" validate:"required"`
+	Password string `json:"password" bson:"password,omitempty" validate:"required"`
 }
 
 type loginUserResponse struct {
@@ -41,34 +58,41 @@ type loginUserResponse struct {
 	AuthToken string      `json:"authToken"`
 }
 
-func HandleCreateUserEndpoint(usersService *users.Service) http.HandlerFunc {
+// HandleCreateUserEndpoint is the http endpoint handler for user sign up,
+// every new user gets a personal workspace and a verification email.
+func HandleCreateUserEndpoint(usersService *users.Service, workspacesService *workspaces.Service) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		var payload createUserInput
-		err := json.NewDecoder(r.Body).Decode(&payload)
-		if err != nil {
-			ErrorResponse(rw, "error", "invalid json payload", http.StatusBadRequest)
-			return
-		}
-		userWithEmail, _ := usersService.GetUserByEmail(r.Context(), payload.Email)
-		if userWithEmail != nil {
-			errMsg := fmt.Sprintf("user with email %s already exist", payload.Email)
-			ErrorResponse(rw, "error", errMsg, http.StatusBadRequest)
+		if !decodeJSON(rw, r, &payload) {
 			return
 		}
 		user, err := usersService.CreateUser(r.Context(), users.User{
 			FirstName: payload.FirstName,
 			LastName:  payload.LastName,
 			Email:     payload.Email,
+			Handle:    payload.Handle,
 			Password:  payload.Password,
 		})
 		if err != nil {
-			ErrorResponse(rw, "error", errSomethingWentWrongMsg, http.StatusInternalServerError)
+			problemResponse(rw, err)
+			return
+		}
+		workspace, err := workspacesService.CreateWorkspace(r.Context(), user.FirstName+"'s workspace", user)
+		if err != nil {
+			problemResponse(rw, err)
 			return
 		}
+		user.WorkspaceIDs = []string{workspace.ID}
+		message := "user created successfully, check your email to verify it"
+		// the account is created even when the email fails, the user can
+		// ask for it again.
+		if usersService.SendVerificationEmail(r.Context(), user) != nil {
+			message = "user created successfully, the verification email could not be sent"
+		}
 		rw.WriteHeader(http.StatusOK)
 		json.NewEncoder(rw).Encode(userApiResponse{
 			Status:  "success",
-			Message: "user created successfully",
+			Message: message,
 			User:    user,
 		})
 	}
@@ -80,7 +104,7 @@ func HandleGetUserEndpoint(usersService *users.Service) http.HandlerFunc {
 		userID := chi.URLParam(r, "userId")
 		user, err := usersService.GetUser(r.Context(), userID)
 		if err != nil {
-			ErrorResponse(rw, "error", "user does not exist", http.StatusBadRequest)
+			problemResponse(rw, err)
 			return
 		}
 		rw.WriteHeader(http.StatusOK)
@@ -99,7 +123,7 @@ func HandleGetUsersEndpoint(usersService *users.Service) http.HandlerFunc {
 		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
 		users, err := usersService.GetUsers(r.Context(), lastID, limit)
 		if err != nil {
-			ErrorResponse(rw, "error", errSomethingWentWrongMsg, http.StatusInternalServerError)
+			problemResponse(rw, err)
 			return
 		}
 		rw.WriteHeader(http.StatusOK)
@@ -111,18 +135,92 @@ func HandleGetUsersEndpoint(usersService *users.Service) http.HandlerFunc {
 	}
 }
 
+// HandleUpdateUserEndpoint is the http endpoint handler for updating the
+// user name and email, a new email must be verified again.
+func HandleUpdateUserEndpoint(usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		user, ok := getSelfUser(rw, r, usersService)
+		if !ok {
+			return
+		}
+		var payload updateUserInput
+		if !decodeJSON(rw, r, &payload) {
+			return
+		}
+		payload.FirstName = strings.TrimSpace(payload.FirstName)
+		payload.LastName = strings.TrimSpace(payload.LastName)
+		emailChanged := payload.Email != "" && payload.Email != user.Email
+		user, err := usersService.UpdateUser(r.Context(), user.ID, payload.CurrentPassword, users.User{
+			FirstName: payload.FirstName,
+			LastName:  payload.LastName,
+			Email:     payload.Email,
+		})
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		message := "user updated successfully"
+		if emailChanged {
+			message = "user updated successfully, check your email to verify it"
+			if usersService.SendVerificationEmail(r.Context(), user) != nil {
+				message = "user updated successfully, the verification email could not be sent"
+			}
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(userApiResponse{
+			Status:  "success",
+			Message: message,
+			User:    user,
+		})
+	}
+}
+
+// HandleChangePasswordEndpoint is the http endpoint handler for changing the
+// user password. The other auth tokens of the user are revoked and a new
+// one is returned.
+func HandleChangePasswordEndpoint(usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		user, ok := getSelfUser(rw, r, usersService)
+		if !ok {
+			return
+		}
+		var payload changePasswordInput
+		if !decodeJSON(rw, r, &payload) {
+			return
+		}
+		user, err := usersService.ChangePassword(r.Context(), user.ID, SessionID(r.Context()), payload.CurrentPassword, payload.NewPassword)
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		authToken, err := usersService.GenerateAuthToken(r.Context(), user.ID, tenant.WorkspaceID(r.Context()), SessionID(r.Context()))
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(loginUserResponse{
+			Status:    "success",
+			Message:   "password changed successfully",
+			User:      user,
+			AuthToken: authToken,
+		})
+	}
+}
+
 // HandleDeleteUserEndpoint is the http endpoint handler for deleting user.
+// Users can only delete their own account, members are removed from a
+// workspace with the workspace members endpoints.
 func HandleDeleteUserEndpoint(usersService *users.Service) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		userID := chi.URLParam(r, "userId")
-		_, err := usersService.GetUser(r.Context(), userID)
-		if err != nil {
-			ErrorResponse(rw, "error", "user does not exist", http.StatusBadRequest)
+		if userID != AuthUserID(r.Context()) {
+			ErrorResponse(rw, "you can only delete your own account", http.StatusForbidden)
 			return
 		}
 		user, err := usersService.DeleteUser(r.Context(), userID)
 		if err != nil {
-			ErrorResponse(rw, "error", errSomethingWentWrongMsg, http.StatusInternalServerError)
+			problemResponse(rw, err)
 			return
 		}
 		rw.WriteHeader(http.StatusOK)
@@ -135,25 +233,78 @@ func HandleDeleteUserEndpoint(usersService *users.Service) http.HandlerFunc {
 }
 
 // HandleUserLoginEndpoint is the http endpoint handler for user login.
-func HandleUserLoginEndpoint(usersService *users.Service) http.HandlerFunc {
+func HandleUserLoginEndpoint(usersService *users.Service, workspacesService *workspaces.Service) http.HandlerFunc {
 	return func(rw http.ResponseWriter, r *http.Request) {
 		var payload loginUserInput
-		err := json.NewDecoder(r.Body).Decode(&payload)
+		if !decodeJSON(rw, r, &payload) {
+			return
+		}
+		user, authToken, err := usersService.LoginUser(r.Context(), payload.Email, payload.Password, requestClient(r))
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		if user.IsMFAEnabled() {
+			// the user is only returned once the second factor is checked.
+			rw.WriteHeader(http.StatusOK)
+			json.NewEncoder(rw).Encode(mfaChallengeResponse{
+				Status:      "success",
+				Message:     "two-factor authentication code required",
+				MFARequired: true,
+				MFAToken:    authToken,
+			})
+			return
+		}
+		loginResponse(rw, r, usersService, workspacesService, user, authToken)
+	}
+}
+
+// loginResponse writes the auth token of a logged in user.
+func loginResponse(rw http.ResponseWriter, r *http.Request, usersService *users.Service, workspacesService *workspaces.Service, user *users.User, authToken string) {
+	// users created before workspaces existed get a personal workspace on login.
+	if len(user.WorkspaceIDs) == 0 {
+		workspace, err := workspacesService.CreateWorkspace(r.Context(), user.FirstName+"'s workspace", user)
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		user.WorkspaceIDs = []string{workspace.ID}
+		// the new token belongs to the session of the login.
+		payload, err := jwt.Decode([]byte(os.Getenv("JWT_SECRET")), authToken)
 		if err != nil {
-			ErrorResponse(rw, "error", "invalid json payload", http.StatusBadRequest)
+			problemResponse(rw, err)
 			return
 		}
-		user, authToken, err := usersService.LoginUser(r.Context(), payload.Email, payload.Password)
+		authToken, err = usersService.GenerateAuthToken(r.Context(), user.ID, workspace.ID, payload.SessionID)
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+	}
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(loginUserResponse{
+		Status:    "success",
+		Message:   "user login successfully",
+		User:      user,
+		AuthToken: authToken,
+	})
+}
+
+// HandleUnlockUserEndpoint is the http endpoint handler for admins to unlock
+// an account locked after too many failed login attempts.
+func HandleUnlockUserEndpoint(usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		// admins can unlock the users of every workspace.
+		user, err := usersService.UnlockUser(tenant.WithWorkspace(r.Context(), ""), chi.URLParam(r, "userId"))
 		if err != nil {
-			ErrorResponse(rw, "error", errSomethingWentWrongMsg, http.StatusInternalServerError)
+			problemResponse(rw, err)
 			return
 		}
 		rw.WriteHeader(http.StatusOK)
-		json.NewEncoder(rw).Encode(loginUserResponse{
-			Status:    "success",
-			Message:   "user login successfully",
-			User:      user,
-			AuthToken: authToken,
+		json.NewEncoder(rw).Encode(userApiResponse{
+			Status:  "success",
+			Message: "user unlocked successfully",
+			User:    user,
 		})
 	}
 }
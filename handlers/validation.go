@@ -0,0 +1,84 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/wisdommatt/todo-list-api/internal/validation"
+)
+
+type validationErrorResponse struct {
+	problem
+	Errors validation.Errors `json:"errors"`
+}
+
+// decodeJSON decodes the json body of the request into v and validates it
+// against its validate tags, unknown fields are rejected. When it fails, an
+// error response listing every invalid field is written and false is returned.
+func decodeJSON(rw http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err != nil {
+		validationErrorsResponse(rw, validation.Errors{jsonFieldError(err)})
+		return false
+	}
+	err = validation.Validate(v)
+	if err != nil {
+		validationErrorsResponse(rw, err.(validation.Errors))
+		return false
+	}
+	return true
+}
+
+// jsonFieldError returns the invalid field of a json decoding error.
+func jsonFieldError(err error) validation.FieldError {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return validation.FieldError{
+			Field:   typeErr.Field,
+			Code:    validation.CodeInvalidType,
+			Message: fmt.Sprintf("%s must be a %s", typeErr.Field, jsonTypeName(typeErr.Type.Kind().String())),
+		}
+	}
+	// the json package has no error type for unknown fields.
+	if strings.HasPrefix(err.Error(), "json: unknown field ") {
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return validation.FieldError{
+			Field:   field,
+			Code:    validation.CodeUnknownField,
+			Message: fmt.Sprintf("%s is not a known field", field),
+		}
+	}
+	message := "invalid json payload"
+	if err == io.EOF {
+		message = "a json payload must be provided"
+	}
+	return validation.FieldError{Field: "body", Code: validation.CodeInvalidJSON, Message: message}
+}
+
+// jsonTypeName returns the json name of a go kind.
+func jsonTypeName(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "number"
+	case kind == "bool":
+		return "boolean"
+	case kind == "slice", kind == "array":
+		return "list"
+	case kind == "struct", kind == "map":
+		return "object"
+	}
+	return kind
+}
+
+func validationErrorsResponse(rw http.ResponseWriter, errs validation.Errors) {
+	writeProblem(rw, http.StatusBadRequest, validationErrorResponse{
+		problem: newProblem(http.StatusBadRequest, "invalid_input", "invalid input: "+errs.Error()),
+		Errors:  errs,
+	})
+}
@@ -0,0 +1,129 @@
+package httphandlers
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wisdommatt/todo-list-api/internal/validation"
+)
+
+// validatedInputs are the inputs decoded by the handlers with validate tags.
+var validatedInputs = []interface{}{
+	createAccessTokenInput{},
+	createAppPasswordInput{},
+	commitSchedulePlanPayload{},
+	commentPayload{},
+	mfaCodeInput{},
+	completeMFALoginInput{},
+	mfaPolicyInput{},
+	createOverlayInput{},
+	forgotPasswordInput{},
+	resetPasswordInput{},
+	createProjectPayload{},
+	createSharePayload{},
+	createTaskPayload{},
+	updateTaskPayload{},
+	bulkTasksPayload{},
+	assignTaskPayload{},
+	timeEntryPayload{},
+	createUserInput{},
+	updateUserInput{},
+	changePasswordInput{},
+	loginUserInput{},
+	verifyEmailInput{},
+	createWorkspacePayload{},
+	inviteMemberPayload{},
+}
+
+// TestValidateInputs runs every rule of the handler inputs, so that unknown
+// rules or rules used on the wrong field types fail here rather than
+// panicking in a request.
+func TestValidateInputs(t *testing.T) {
+	for _, input := range validatedInputs {
+		inputType := reflect.TypeOf(input)
+		t.Run(inputType.Name(), func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("Validate() panicked: %v", r)
+				}
+			}()
+			// empty fields only run the required rules.
+			validation.Validate(reflect.New(inputType).Interface())
+			filled := reflect.New(inputType)
+			fill(filled.Elem())
+			validation.Validate(filled.Interface())
+		})
+	}
+}
+
+// fill sets every field of value to a non empty value.
+func fill(value reflect.Value) {
+	if _, ok := value.Interface().(time.Time); ok {
+		value.Set(reflect.ValueOf(time.Now()))
+		return
+	}
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString("value")
+	case reflect.Bool:
+		value.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value.SetInt(1)
+	case reflect.Float32, reflect.Float64:
+		value.SetFloat(1)
+	case reflect.Ptr:
+		value.Set(reflect.New(value.Type().Elem()))
+		fill(value.Elem())
+	case reflect.Slice:
+		value.Set(reflect.MakeSlice(value.Type(), 1, 1))
+		fill(value.Index(0))
+	case reflect.Map:
+		value.Set(reflect.MakeMap(value.Type()))
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if value.Type().Field(i).PkgPath == "" {
+				fill(value.Field(i))
+			}
+		}
+	}
+}
+
+// TestValidatedInputsListed makes sure new inputs with validate tags are
+// added to validatedInputs.
+func TestValidatedInputsListed(t *testing.T) {
+	listed := map[string]bool{}
+	for _, input := range validatedInputs {
+		listed[reflect.TypeOf(input).Name()] = true
+	}
+	packages, err := parser.ParseDir(token.NewFileSet(), ".", func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatalf("ParseDir() error = %v", err)
+	}
+	for _, pkg := range packages {
+		ast.Inspect(pkg, func(node ast.Node) bool {
+			typeSpec, ok := node.(*ast.TypeSpec)
+			if !ok {
+				return true
+			}
+			structType, ok := typeSpec.Type.(*ast.StructType)
+			if !ok {
+				return true
+			}
+			for _, field := range structType.Fields.List {
+				if field.Tag != nil && strings.Contains(field.Tag.Value, `validate:"`) && !listed[typeSpec.Name.Name] {
+					t.Errorf("%s has validate tags but is not in validatedInputs", typeSpec.Name.Name)
+					break
+				}
+			}
+			return true
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/wisdommatt/todo-list-api/services/users"
+)
+
+type verifyEmailInput struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// HandleVerifyEmailEndpoint is the http endpoint handler for verifying a
+// user email with the token sent to it.
+func HandleVerifyEmailEndpoint(usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var payload verifyEmailInput
+		if !decodeJSON(rw, r, &payload) {
+			return
+		}
+		user, err := usersService.VerifyEmail(r.Context(), payload.Token)
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(userApiResponse{
+			Status:  "success",
+			Message: "email verified successfully",
+			User:    user,
+		})
+	}
+}
+
+// HandleResendVerificationEmailEndpoint is the http endpoint handler for
+// sending the verification email again, it is throttled.
+func HandleResendVerificationEmailEndpoint(usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		user, ok := getSelfUser(rw, r, usersService)
+		if !ok {
+			return
+		}
+		err := usersService.SendVerificationEmail(r.Context(), user)
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(userApiResponse{
+			Status:  "success",
+			Message: "verification email sent successfully",
+			User:    user,
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/wisdommatt/todo-list-api/services/users"
+	"github.com/wisdommatt/todo-list-api/services/workspaces"
+)
+
+type createWorkspacePayload struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+type inviteMemberPayload struct {
+	Email string `json:"email" validate:"required,email,max=254"`
+	Role  string `json:"role"`
+}
+
+type workspaceApiResponse struct {
+	Status    string                `json:"status"`
+	Message   string                `json:"message"`
+	Workspace *workspaces.Workspace `json:"workspace"`
+}
+
+type getWorkspacesResponse struct {
+	Status     string                 `json:"status"`
+	Message    string                 `json:"message"`
+	Workspaces []workspaces.Workspace `json:"workspaces"`
+}
+
+type memberApiResponse struct {
+	Status  string             `json:"status"`
+	Message string             `json:"message"`
+	Member  *workspaces.Member `json:"member"`
+}
+
+type getMembersResponse struct {
+	Status  string              `json:"status"`
+	Message string              `json:"message"`
+	Members []workspaces.Member `json:"members"`
+}
+
+// HandleCreateWorkspaceEndpoint is the http endpoint handler for creating a new workspace.
+func HandleCreateWorkspaceEndpoint(workspacesService *workspaces.Service, usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		var payload createWorkspacePayload
+		if !decodeJSON(rw, r, &payload) {
+			return
+		}
+		user, err := usersService.GetUser(r.Context(), AuthUserID(r.Context()))
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		workspace, err := workspacesService.CreateWorkspace(r.Context(), payload.Name, user)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(workspaceApiResponse{
+			Status:    "success",
+			Message:   "workspace created successfully",
+			Workspace: workspace,
+		})
+	}
+}
+
+// HandleGetWorkspacesEndpoint is the http endpoint handler for retrieving the workspaces of a user.
+func HandleGetWorkspacesEndpoint(workspacesService *workspaces.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		userID := chi.URLParam(r, "userId")
+		if userID != AuthUserID(r.Context()) {
+			ErrorResponse(rw, "you can only view your own workspaces", http.StatusForbidden)
+			return
+		}
+		userWorkspaces, err := workspacesService.GetUserWorkspaces(r.Context(), userID)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(getWorkspacesResponse{
+			Status:     "success",
+			Message:    "workspaces retrieved successfully",
+			Workspaces: userWorkspaces,
+		})
+	}
+}
+
+// HandleGetWorkspaceMembersEndpoint is the http endpoint handler for retrieving workspace members.
+func HandleGetWorkspaceMembersEndpoint(workspacesService *workspaces.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		workspaceID := chi.URLParam(r, "workspaceId")
+		_, ok := getAuthMember(rw, r, workspacesService, workspaceID)
+		if !ok {
+			return
+		}
+		members, err := workspacesService.GetMembers(r.Context(), workspaceID)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(getMembersResponse{
+			Status:  "success",
+			Message: "workspace members retrieved successfully",
+			Members: members,
+		})
+	}
+}
+
+// HandleInviteWorkspaceMemberEndpoint is the http endpoint handler for
+// inviting a user to a workspace by email, only admins can invite members.
+func HandleInviteWorkspaceMemberEndpoint(workspacesService *workspaces.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		workspaceID := chi.URLParam(r, "workspaceId")
+		authMember, ok := getAuthMember(rw, r, workspacesService, workspaceID)
+		if !ok {
+			return
+		}
+		var payload inviteMemberPayload
+		if !decodeJSON(rw, r, &payload) {
+			return
+		}
+		if payload.Role == "" {
+			payload.Role = workspaces.RoleMember
+		}
+		if !workspaces.IsValidRole(payload.Role) {
+			ErrorResponse(rw, "role must be one of member, admin or owner", http.StatusBadRequest)
+			return
+		}
+		if !workspaces.RoleAtLeast(authMember.Role, workspaces.RoleAdmin) || !workspaces.RoleAtLeast(authMember.Role, payload.Role) {
+			ErrorResponse(rw, "you are not allowed to invite members with this role", http.StatusForbidden)
+			return
+		}
+		member, err := workspacesService.InviteMember(r.Context(), workspaceID, payload.Email, payload.Role, authMember.UserID)
+		if err != nil {
+			ErrorResponse(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(memberApiResponse{
+			Status:  "success",
+			Message: "invitation sent successfully",
+			Member:  member,
+		})
+	}
+}
+
+// HandleRemoveWorkspaceMemberEndpoint is the http endpoint handler for
+// removing a member from a workspace, admins can remove members and every
+// member can leave the workspace.
+func HandleRemoveWorkspaceMemberEndpoint(workspacesService *workspaces.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		workspaceID := chi.URLParam(r, "workspaceId")
+		authMember, ok := getAuthMember(rw, r, workspacesService, workspaceID)
+		if !ok {
+			return
+		}
+		member, err := workspacesService.GetMemberByID(r.Context(), workspaceID, chi.URLParam(r, "memberId"))
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		if member.Role == workspaces.RoleOwner {
+			ErrorResponse(rw, "the workspace owner cannot be removed", http.StatusBadRequest)
+			return
+		}
+		if member.ID != authMember.ID && !workspaces.RoleAtLeast(authMember.Role, workspaces.RoleAdmin) {
+			ErrorResponse(rw, "you are not allowed to remove members", http.StatusForbidden)
+			return
+		}
+		member, err = workspacesService.RemoveMember(r.Context(), workspaceID, member.ID)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(memberApiResponse{
+			Status:  "success",
+			Message: "member removed successfully",
+			Member:  member,
+		})
+	}
+}
+
+// HandleGetWorkspaceInvitationsEndpoint is the http endpoint handler for
+// retrieving the pending workspace invitations of a user.
+func HandleGetWorkspaceInvitationsEndpoint(workspacesService *workspaces.Service, usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		userID := chi.URLParam(r, "userId")
+		user, err := usersService.GetUser(r.Context(), userID)
+		if err != nil || userID != AuthUserID(r.Context()) {
+			ErrorResponse(rw, "you can only view your own invitations", http.StatusForbidden)
+			return
+		}
+		invitations, err := workspacesService.GetInvitations(r.Context(), user.Email)
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(getMembersResponse{
+			Status:  "success",
+			Message: "invitations retrieved successfully",
+			Members: invitations,
+		})
+	}
+}
+
+// HandleAcceptWorkspaceInvitationEndpoint is the http endpoint handler for joining a workspace.
+func HandleAcceptWorkspaceInvitationEndpoint(workspacesService *workspaces.Service, usersService *users.Service) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		user, err := usersService.GetUser(r.Context(), AuthUserID(r.Context()))
+		if err != nil {
+			ErrorResponse(rw, errSomethingWentWrongMsg, http.StatusInternalServerError)
+			return
+		}
+		member, err := workspacesService.AcceptInvitation(r.Context(), chi.URLParam(r, "workspaceId"), user)
+		if err != nil {
+			problemResponse(rw, err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(memberApiResponse{
+			Status:  "success",
+			Message: "invitation accepted successfully",
+			Member:  member,
+		})
+	}
+}
+
+// getAuthMember returns the membership of the authenticated user in the
+// workspace, writing an error response when the user is not a member.
+func getAuthMember(rw http.ResponseWriter, r *http.Request, workspacesService *workspaces.Service, workspaceID string) (*workspaces.Member, bool) {
+	member, err := workspacesService.GetMember(r.Context(), workspaceID, AuthUserID(r.Context()))
+	if err == workspaces.ErrMemberNotFound {
+		// workspaces are not disclosed to users outside of them.
+		problemResponse(rw, workspaces.ErrWorkspaceNotFound)
+		return nil, false
+	}
+	if err != nil {
+		problemResponse(rw, err)
+		return nil, false
+	}
+	return member, true
+}
@@ -0,0 +1,64 @@
+// Package apperror defines the domain errors returned by the services, they
+// carry a kind the handlers map to an http status and a stable code for clients.
+package apperror
+
+import (
+	"errors"
+	"time"
+)
+
+// Kind is the category of a domain error.
+type Kind string
+
+// Kinds of domain errors.
+const (
+	NotFound        Kind = "not_found"
+	Conflict        Kind = "conflict"
+	Forbidden       Kind = "forbidden"
+	Invalid         Kind = "invalid"
+	Unauthenticated Kind = "unauthenticated"
+	RateLimited     Kind = "rate_limited"
+)
+
+// Error is a domain error, Code is stable e.g "task_not_found" and Message
+// can be shown to users.
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+	// RetryAfter is how long to wait before retrying a rate limited request.
+	RetryAfter time.Duration
+}
+
+// New returns a domain error, services declare them as sentinel errors.
+func New(kind Kind, code, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// WithRetryAfter returns a copy of the error with RetryAfter set, it matches
+// the error with errors.Is.
+func (e *Error) WithRetryAfter(retryAfter time.Duration) *Error {
+	copied := *e
+	copied.RetryAfter = retryAfter
+	return &copied
+}
+
+// Is reports whether target is a domain error with the same code.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// As returns the domain error in the chain of err, it returns false for
+// unexpected errors e.g db errors.
+func As(err error) (*Error, bool) {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr, true
+	}
+	return nil, false
+}
@@ -0,0 +1,42 @@
+package blobstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrNotFound is returned when a blob does not exist in the store.
+var ErrNotFound = fmt.Errorf("blob not found")
+
+// BlobStore stores binary objects by key.
+type BlobStore interface {
+	// Put stores size bytes read from r under key, replacing any existing blob.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the blob stored under key, the caller must close it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob stored under key, deleting a missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// NewFromEnv returns the blob store configured by the BLOB_STORE environment
+// variable, "local" (the default) or "s3".
+func NewFromEnv() (BlobStore, error) {
+	switch os.Getenv("BLOB_STORE") {
+	case "", "local":
+		return NewLocalStore(os.Getenv("BLOB_LOCAL_DIR"))
+
+	case "s3":
+		return NewS3Store(S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          os.Getenv("S3_REGION"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		})
+
+	default:
+		return nil, fmt.Errorf("unsupported blob store %q", os.Getenv("BLOB_STORE"))
+	}
+}
@@ -0,0 +1,279 @@
+// Package caldav implements the WebDAV and CalDAV (RFC 4918, RFC 4791 and
+// RFC 6578) request parsing and multistatus responses.
+package caldav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// XML namespaces of the properties.
+const (
+	NamespaceDAV            = "DAV:"
+	NamespaceCalDAV         = "urn:ietf:params:xml:ns:caldav"
+	NamespaceCalendarServer = "http://calendarserver.org/ns/"
+	NamespaceAppleICal      = "http://apple.com/ns/ical/"
+)
+
+// prefixes are the namespace prefixes declared on multistatus responses,
+// property values are written with them.
+var prefixes = map[string]string{
+	NamespaceDAV:            "d",
+	NamespaceCalDAV:         "c",
+	NamespaceCalendarServer: "cs",
+	NamespaceAppleICal:      "ical",
+}
+
+// Node is an element of a request body.
+type Node struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Content  string     `xml:",chardata"`
+	Children []Node     `xml:",any"`
+}
+
+// ParseBody parses the XML body of a request, nil is returned for empty bodies.
+func ParseBody(r io.Reader) (*Node, error) {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, nil
+	}
+	var node Node
+	err = xml.Unmarshal(body, &node)
+	if err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// Is reports whether the node is the element space:local.
+func (n *Node) Is(space, local string) bool {
+	return n != nil && n.XMLName.Space == space && n.XMLName.Local == local
+}
+
+// Child returns the first child element space:local, or nil.
+func (n *Node) Child(space, local string) *Node {
+	if n == nil {
+		return nil
+	}
+	for i := range n.Children {
+		if n.Children[i].Is(space, local) {
+			return &n.Children[i]
+		}
+	}
+	return nil
+}
+
+// Attr returns the value of the attribute, or an empty string.
+func (n *Node) Attr(local string) string {
+	for _, attr := range n.Attrs {
+		if attr.Name.Local == local {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// Names returns the names of the child elements e.g the properties of a prop element.
+func (n *Node) Names() []xml.Name {
+	if n == nil {
+		return nil
+	}
+	names := make([]xml.Name, 0, len(n.Children))
+	for _, child := range n.Children {
+		names = append(names, child.XMLName)
+	}
+	return names
+}
+
+// Propfind is a PROPFIND request, or the properties requested by a REPORT.
+type Propfind struct {
+	// Props are the requested properties, all properties are requested when
+	// AllProp is set and only their names when PropName is set.
+	Props    []xml.Name
+	AllProp  bool
+	PropName bool
+}
+
+// ParsePropfind returns the properties requested by a PROPFIND body, an
+// empty body requests all properties.
+func ParsePropfind(root *Node) (Propfind, error) {
+	if root == nil {
+		return Propfind{AllProp: true}, nil
+	}
+	if !root.Is(NamespaceDAV, "propfind") {
+		return Propfind{}, fmt.Errorf("expected a propfind element")
+	}
+	return RequestedProps(root), nil
+}
+
+// RequestedProps returns the properties requested by the prop, allprop
+// or propname child of a request element.
+func RequestedProps(root *Node) Propfind {
+	switch {
+	case root.Child(NamespaceDAV, "allprop") != nil:
+		return Propfind{AllProp: true}
+	case root.Child(NamespaceDAV, "propname") != nil:
+		return Propfind{PropName: true}
+	}
+	return Propfind{Props: root.Child(NamespaceDAV, "prop").Names()}
+}
+
+// Depth returns the Depth header of a request, infinity (the default) is
+// returned as -1.
+func Depth(r *http.Request) int {
+	switch r.Header.Get("Depth") {
+	case "0":
+		return 0
+	case "1":
+		return 1
+	}
+	return -1
+}
+
+// Prop is a property of a resource, Value is its inner XML written with
+// the namespace prefixes of the response.
+type Prop struct {
+	Name  xml.Name
+	Value string
+}
+
+// Props maps the properties of a resource to their inner XML.
+type Props map[xml.Name]string
+
+// Response is the status of a resource in a multistatus response, either
+// the status of the resource or its properties grouped by status.
+type Response struct {
+	Href      string
+	Status    int
+	Propstats []Propstat
+}
+
+// Propstat is a group of properties of a resource with the same status.
+type Propstat struct {
+	Status int
+	Props  []Prop
+}
+
+// PropfindResponse answers the propfind for a resource with the props,
+// requested properties it does not have are reported as not found.
+func PropfindResponse(href string, props Props, propfind Propfind) Response {
+	response := Response{Href: href}
+	var found, missing []Prop
+	switch {
+	case propfind.AllProp || propfind.PropName:
+		for name, value := range props {
+			if propfind.PropName {
+				value = ""
+			}
+			found = append(found, Prop{Name: name, Value: value})
+		}
+		sort.Slice(found, func(i, j int) bool {
+			return found[i].Name.Space+found[i].Name.Local < found[j].Name.Space+found[j].Name.Local
+		})
+	default:
+		for _, name := range propfind.Props {
+			if value, ok := props[name]; ok {
+				found = append(found, Prop{Name: name, Value: value})
+			} else {
+				missing = append(missing, Prop{Name: name})
+			}
+		}
+	}
+	if len(found) > 0 || len(missing) == 0 {
+		response.Propstats = append(response.Propstats, Propstat{Status: http.StatusOK, Props: found})
+	}
+	if len(missing) > 0 {
+		response.Propstats = append(response.Propstats, Propstat{Status: http.StatusNotFound, Props: missing})
+	}
+	return response
+}
+
+// WriteMultistatus writes a 207 multistatus response, with the sync token
+// of a sync-collection report when it is set.
+func WriteMultistatus(rw http.ResponseWriter, responses []Response, syncToken string) {
+	var body strings.Builder
+	body.WriteString(xml.Header)
+	body.WriteString(`<d:multistatus` + namespaceDeclarations() + `>`)
+	for _, response := range responses {
+		body.WriteString("<d:response>")
+		body.WriteString(Href(response.Href))
+		if response.Status != 0 {
+			body.WriteString(status(response.Status))
+		}
+		for _, propstat := range response.Propstats {
+			body.WriteString("<d:propstat><d:prop>")
+			for _, prop := range propstat.Props {
+				writeProp(&body, prop)
+			}
+			body.WriteString("</d:prop>")
+			body.WriteString(status(propstat.Status))
+			body.WriteString("</d:propstat>")
+		}
+		body.WriteString("</d:response>")
+	}
+	if syncToken != "" {
+		body.WriteString("<d:sync-token>" + Escape(syncToken) + "</d:sync-token>")
+	}
+	body.WriteString("</d:multistatus>")
+	rw.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	rw.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(rw, body.String())
+}
+
+// WriteError writes an error response with the failed precondition e.g
+// "<c:supported-report/>" in the body.
+func WriteError(rw http.ResponseWriter, statusCode int, precondition string) {
+	rw.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	rw.WriteHeader(statusCode)
+	io.WriteString(rw, xml.Header+`<d:error`+namespaceDeclarations()+`>`+precondition+`</d:error>`)
+}
+
+// Href returns an href element with the escaped path.
+func Href(path string) string {
+	return "<d:href>" + Escape(path) + "</d:href>"
+}
+
+// Escape escapes text for XML content and attributes.
+func Escape(text string) string {
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(text))
+	return escaped.String()
+}
+
+func writeProp(body *strings.Builder, prop Prop) {
+	name, declaration := prop.Name.Local, ""
+	if prefix, ok := prefixes[prop.Name.Space]; ok {
+		name = prefix + ":" + name
+	} else if prop.Name.Space != "" {
+		name = "x:" + name
+		declaration = ` xmlns:x="` + Escape(prop.Name.Space) + `"`
+	}
+	if prop.Value == "" {
+		body.WriteString("<" + name + declaration + "/>")
+		return
+	}
+	body.WriteString("<" + name + declaration + ">" + prop.Value + "</" + name + ">")
+}
+
+func status(statusCode int) string {
+	return fmt.Sprintf("<d:status>HTTP/1.1 %d %s</d:status>", statusCode, http.StatusText(statusCode))
+}
+
+func namespaceDeclarations() string {
+	var declarations []string
+	for namespace, prefix := range prefixes {
+		declarations = append(declarations, fmt.Sprintf(` xmlns:%s="%s"`, prefix, namespace))
+	}
+	sort.Strings(declarations)
+	return strings.Join(declarations, "")
+}
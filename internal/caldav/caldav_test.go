@@ -0,0 +1,232 @@
+package caldav
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// multistatus is a multistatus response as read by clients.
+type multistatus struct {
+	XMLName   xml.Name `xml:"DAV: multistatus"`
+	SyncToken string   `xml:"DAV: sync-token"`
+	Responses []struct {
+		Href      string `xml:"DAV: href"`
+		Status    string `xml:"DAV: status"`
+		Propstats []struct {
+			Status string `xml:"DAV: status"`
+			Prop   struct {
+				Props []struct {
+					XMLName xml.Name
+					Inner   string `xml:",innerxml"`
+				} `xml:",any"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+func TestParsePropfind(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    Propfind
+		wantErr bool
+	}{
+		{
+			name: "empty body",
+			body: "",
+			want: Propfind{AllProp: true},
+		},
+		{
+			name: "allprop",
+			body: `<?xml version="1.0" encoding="utf-8" ?><D:propfind xmlns:D="DAV:"><D:allprop/></D:propfind>`,
+			want: Propfind{AllProp: true},
+		},
+		{
+			name: "propname",
+			body: `<?xml version="1.0" encoding="utf-8" ?><propfind xmlns="DAV:"><propname/></propfind>`,
+			want: Propfind{PropName: true},
+		},
+		{
+			// the properties asked by clients discovering a calendar home.
+			name: "props of several namespaces",
+			body: `<?xml version="1.0" encoding="utf-8" ?>
+<D:propfind xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav" xmlns:CS="http://calendarserver.org/ns/">
+  <D:prop>
+    <D:resourcetype/>
+    <C:calendar-home-set/>
+    <CS:getctag/>
+  </D:prop>
+</D:propfind>`,
+			want: Propfind{Props: []xml.Name{
+				{Space: NamespaceDAV, Local: "resourcetype"},
+				{Space: NamespaceCalDAV, Local: "calendar-home-set"},
+				{Space: NamespaceCalendarServer, Local: "getctag"},
+			}},
+		},
+		{
+			name:    "not a propfind",
+			body:    `<D:propertyupdate xmlns:D="DAV:"/>`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := ParseBody(strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("ParseBody() error = %v", err)
+			}
+			got, err := ParsePropfind(root)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePropfind() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParsePropfind() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBodyInvalid(t *testing.T) {
+	if _, err := ParseBody(strings.NewReader("<D:propfind xmlns:D=\"DAV:\">")); err == nil {
+		t.Errorf("ParseBody() of an unterminated element error = nil, want an error")
+	}
+}
+
+func TestNodeNavigation(t *testing.T) {
+	// a calendar-query of RFC 4791 section 7.8.1.
+	root, err := ParseBody(strings.NewReader(`<?xml version="1.0" encoding="utf-8" ?>
+<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><D:getetag/></D:prop>
+  <C:filter>
+    <C:comp-filter name="VCALENDAR">
+      <C:comp-filter name="VEVENT">
+        <C:time-range start="20060104T000000Z" end="20060105T000000Z"/>
+      </C:comp-filter>
+    </C:comp-filter>
+  </C:filter>
+</C:calendar-query>`))
+	if err != nil {
+		t.Fatalf("ParseBody() error = %v", err)
+	}
+	if !root.Is(NamespaceCalDAV, "calendar-query") {
+		t.Fatalf("root = %v, want a calendar-query", root.XMLName)
+	}
+	timeRange := root.Child(NamespaceCalDAV, "filter").
+		Child(NamespaceCalDAV, "comp-filter").
+		Child(NamespaceCalDAV, "comp-filter").
+		Child(NamespaceCalDAV, "time-range")
+	if timeRange.Attr("start") != "20060104T000000Z" || timeRange.Attr("end") != "20060105T000000Z" {
+		t.Errorf("time-range = %+v, want the query range", timeRange)
+	}
+	if root.Child(NamespaceDAV, "missing").Child(NamespaceDAV, "prop") != nil {
+		t.Errorf("children of a missing element were found")
+	}
+	if props := RequestedProps(root); !reflect.DeepEqual(props.Props, []xml.Name{{Space: NamespaceDAV, Local: "getetag"}}) {
+		t.Errorf("RequestedProps() = %+v, want getetag", props)
+	}
+}
+
+func TestDepth(t *testing.T) {
+	for header, want := range map[string]int{"0": 0, "1": 1, "infinity": -1, "": -1} {
+		r := httptest.NewRequest("PROPFIND", "/dav/", nil)
+		if header != "" {
+			r.Header.Set("Depth", header)
+		}
+		if got := Depth(r); got != want {
+			t.Errorf("Depth(%q) = %d, want %d", header, got, want)
+		}
+	}
+}
+
+func TestWriteMultistatus(t *testing.T) {
+	props := Props{
+		{Space: NamespaceDAV, Local: "getetag"}:      `"abc"`,
+		{Space: NamespaceDAV, Local: "resourcetype"}: "<d:collection/><c:calendar/>",
+		{Space: "urn:example", Local: "color"}:       "red",
+	}
+	propfind := Propfind{Props: []xml.Name{
+		{Space: NamespaceDAV, Local: "getetag"},
+		{Space: "urn:example", Local: "color"},
+		{Space: NamespaceDAV, Local: "displayname"},
+	}}
+	rec := httptest.NewRecorder()
+	WriteMultistatus(rec, []Response{
+		PropfindResponse("/dav/calendars/1/tasks/a & b.ics", props, propfind),
+		{Href: "/dav/calendars/1/tasks/deleted.ics", Status: http.StatusNotFound},
+	}, "https://example.com/sync/1")
+
+	if rec.Code != http.StatusMultiStatus {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusMultiStatus)
+	}
+	var got multistatus
+	if err := xml.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("response is not valid xml: %v\n%s", err, rec.Body.String())
+	}
+	if got.SyncToken != "https://example.com/sync/1" {
+		t.Errorf("sync-token = %q, want the sync token", got.SyncToken)
+	}
+	if len(got.Responses) != 2 {
+		t.Fatalf("responses = %d, want 2", len(got.Responses))
+	}
+	first := got.Responses[0]
+	if first.Href != "/dav/calendars/1/tasks/a & b.ics" {
+		t.Errorf("href = %q, want the unescaped path", first.Href)
+	}
+	if len(first.Propstats) != 2 {
+		t.Fatalf("propstats = %d, want found and not found properties", len(first.Propstats))
+	}
+	found, missing := first.Propstats[0], first.Propstats[1]
+	if found.Status != "HTTP/1.1 200 OK" || len(found.Prop.Props) != 2 {
+		t.Errorf("found propstat = %+v, want getetag and color with a 200 status", found)
+	} else {
+		if name := found.Prop.Props[0].XMLName; name.Space != NamespaceDAV || name.Local != "getetag" || found.Prop.Props[0].Inner != `"abc"` {
+			t.Errorf("first property = %v %q, want the getetag", name, found.Prop.Props[0].Inner)
+		}
+		if name := found.Prop.Props[1].XMLName; name.Space != "urn:example" || name.Local != "color" {
+			t.Errorf("second property = %v, want the color of the unknown namespace", name)
+		}
+	}
+	if missing.Status != "HTTP/1.1 404 Not Found" || len(missing.Prop.Props) != 1 || missing.Prop.Props[0].XMLName.Local != "displayname" {
+		t.Errorf("missing propstat = %+v, want displayname with a 404 status", missing)
+	}
+	if got.Responses[1].Status != "HTTP/1.1 404 Not Found" || len(got.Responses[1].Propstats) != 0 {
+		t.Errorf("deleted response = %+v, want a 404 status", got.Responses[1])
+	}
+}
+
+func TestPropfindResponsePropName(t *testing.T) {
+	props := Props{
+		{Space: NamespaceDAV, Local: "getetag"}:     `"abc"`,
+		{Space: NamespaceDAV, Local: "displayname"}: "Tasks",
+	}
+	response := PropfindResponse("/dav/", props, Propfind{PropName: true})
+	if len(response.Propstats) != 1 {
+		t.Fatalf("propstats = %+v, want a single propstat", response.Propstats)
+	}
+	want := []Prop{
+		{Name: xml.Name{Space: NamespaceDAV, Local: "displayname"}},
+		{Name: xml.Name{Space: NamespaceDAV, Local: "getetag"}},
+	}
+	if !reflect.DeepEqual(response.Propstats[0].Props, want) {
+		t.Errorf("props = %+v, want the sorted names without values", response.Propstats[0].Props)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	WriteError(rec, http.StatusForbidden, "<c:supported-report/>")
+	var got struct {
+		XMLName xml.Name  `xml:"DAV: error"`
+		Report  *struct{} `xml:"urn:ietf:params:xml:ns:caldav supported-report"`
+	}
+	if err := xml.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("response is not valid xml: %v", err)
+	}
+	if rec.Code != http.StatusForbidden || got.Report == nil {
+		t.Errorf("response = %d %s, want a 403 with the supported-report precondition", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,241 @@
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxLineLength bounds the length of an unfolded content line.
+const maxLineLength = 1 << 20
+
+var durationRegex = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// Property is a content line of a component.
+type Property struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// Component is an iCalendar component e.g VCALENDAR, VEVENT or VTODO.
+type Component struct {
+	Name       string
+	Properties []Property
+	Components []*Component
+}
+
+// Get returns the first property with the name, or nil.
+func (c *Component) Get(name string) *Property {
+	for i := range c.Properties {
+		if c.Properties[i].Name == name {
+			return &c.Properties[i]
+		}
+	}
+	return nil
+}
+
+// Text returns the unescaped value of the first property with the name.
+func (c *Component) Text(name string) string {
+	property := c.Get(name)
+	if property == nil {
+		return ""
+	}
+	return UnescapeText(property.Value)
+}
+
+// Parse reads an iCalendar stream and returns its top level component.
+func Parse(r io.Reader) (*Component, error) {
+	lines, err := unfoldLines(r)
+	if err != nil {
+		return nil, err
+	}
+	var root *Component
+	var stack []*Component
+	for number, line := range lines {
+		if line == "" {
+			continue
+		}
+		property, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", number+1, err)
+		}
+		switch property.Name {
+		case "BEGIN":
+			component := &Component{Name: strings.ToUpper(property.Value)}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Components = append(parent.Components, component)
+			} else if root == nil {
+				root = component
+			} else {
+				return nil, fmt.Errorf("line %d: multiple top level components", number+1)
+			}
+			stack = append(stack, component)
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(property.Value) {
+				return nil, fmt.Errorf("line %d: unexpected END:%s", number+1, property.Value)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("line %d: property outside of a component", number+1)
+			}
+			current := stack[len(stack)-1]
+			current.Properties = append(current.Properties, property)
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("no calendar found")
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("missing END:%s", stack[len(stack)-1].Name)
+	}
+	return root, nil
+}
+
+// unfoldLines splits the stream in content lines, joining folded lines.
+func unfoldLines(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// parseLine parses a content line: name *(";" param) ":" value.
+func parseLine(line string) (Property, error) {
+	property := Property{Params: map[string]string{}}
+	i := strings.IndexAny(line, ";:")
+	if i <= 0 {
+		return property, fmt.Errorf("invalid content line")
+	}
+	property.Name = strings.ToUpper(line[:i])
+	for line[i] == ';' {
+		line = line[i+1:]
+		eq := strings.IndexByte(line, '=')
+		if eq <= 0 {
+			return property, fmt.Errorf("invalid property parameter")
+		}
+		name := strings.ToUpper(line[:eq])
+		line = line[eq+1:]
+		var value string
+		if strings.HasPrefix(line, `"`) {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				return property, fmt.Errorf("unterminated quoted parameter")
+			}
+			value = line[1 : end+1]
+			line = line[end+2:]
+			i = 0
+		} else {
+			i = strings.IndexAny(line, ";:")
+			if i < 0 {
+				return property, fmt.Errorf("invalid content line")
+			}
+			value = line[:i]
+		}
+		if len(line) <= i {
+			return property, fmt.Errorf("invalid content line")
+		}
+		property.Params[name] = value
+	}
+	if line[i] != ':' {
+		return property, fmt.Errorf("invalid content line")
+	}
+	property.Value = line[i+1:]
+	return property, nil
+}
+
+// UnescapeText unescapes a TEXT value.
+func UnescapeText(value string) string {
+	var unescaped strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i == len(value)-1 {
+			unescaped.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'n', 'N':
+			unescaped.WriteByte('\n')
+		default:
+			unescaped.WriteByte(value[i])
+		}
+	}
+	return unescaped.String()
+}
+
+// SplitText splits a multi-valued TEXT value on unescaped commas and unescapes the values.
+func SplitText(value string) []string {
+	var values []string
+	start := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case ',':
+			values = append(values, UnescapeText(value[start:i]))
+			start = i + 1
+		}
+	}
+	return append(values, UnescapeText(value[start:]))
+}
+
+// ParseTime parses a DATE or DATE-TIME property. Dates are returned as UTC
+// midnights with allDay set, floating times and times in unknown time
+// zones are interpreted in defaultLoc.
+func ParseTime(property *Property, defaultLoc *time.Location) (t time.Time, allDay bool, err error) {
+	value := property.Value
+	if property.Params["VALUE"] == "DATE" || len(value) == len(DateFormat) {
+		t, err = time.Parse(DateFormat, value)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err = time.Parse(UTCDateTimeFormat, value)
+		return t, false, err
+	}
+	loc := defaultLoc
+	if tzid := property.Params["TZID"]; tzid != "" {
+		if tzLoc, err := time.LoadLocation(strings.TrimPrefix(tzid, "/")); err == nil {
+			loc = tzLoc
+		}
+	}
+	t, err = time.ParseInLocation(DateTimeFormat, value, loc)
+	return t, false, err
+}
+
+// ParseDuration parses a DURATION value e.g PT1H30M or P1D.
+func ParseDuration(value string) (time.Duration, error) {
+	matches := durationRegex.FindStringSubmatch(value)
+	if matches == nil || value == "P" || value == "PT" {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var duration time.Duration
+	for i, unit := range units {
+		if matches[i+2] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(matches[i+2])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		duration += time.Duration(n) * unit
+	}
+	if matches[1] == "-" {
+		duration = -duration
+	}
+	return duration, nil
+}
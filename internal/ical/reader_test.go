@@ -0,0 +1,158 @@
+package ical
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+// exampleCalendar is based on the examples of RFC 5545, with CRLF line
+// endings and a folded description.
+const exampleCalendar = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//hacksw/handcal//NONSGML v1.0//EN\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:19970610T172345Z-AF23B2@example.com\r\n" +
+	"DTSTAMP:19970610T172345Z\r\n" +
+	"DTSTART;TZID=America/New_York:19970714T170000\r\n" +
+	"DTEND;TZID=America/New_York:19970715T040000\r\n" +
+	"SUMMARY:Bastille Day Party\\, with friends\r\n" +
+	"DESCRIPTION:This is a lo\r\n" +
+	" ng description\\nthat exists on a long line.\r\n" +
+	"CATEGORIES:PARTY,BASTILLE\\, DAY\r\n" +
+	"ATTENDEE;CN=\"Doe, John\";ROLE=REQ-PARTICIPANT:mailto:john@example.com\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"TRIGGER:-PT15M\r\n" +
+	"ACTION:DISPLAY\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParse(t *testing.T) {
+	calendar, err := Parse(strings.NewReader(exampleCalendar))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if calendar.Name != "VCALENDAR" || calendar.Text("VERSION") != "2.0" {
+		t.Errorf("calendar = %s version %q, want VCALENDAR version 2.0", calendar.Name, calendar.Text("VERSION"))
+	}
+	if len(calendar.Components) != 1 || calendar.Components[0].Name != "VEVENT" {
+		t.Fatalf("calendar components = %v, want a single VEVENT", calendar.Components)
+	}
+	event := calendar.Components[0]
+	if got, want := event.Text("SUMMARY"), "Bastille Day Party, with friends"; got != want {
+		t.Errorf("SUMMARY = %q, want %q", got, want)
+	}
+	if got, want := event.Text("DESCRIPTION"), "This is a long description\nthat exists on a long line."; got != want {
+		t.Errorf("DESCRIPTION = %q, want %q", got, want)
+	}
+	if got, want := SplitText(event.Get("CATEGORIES").Value), []string{"PARTY", "BASTILLE, DAY"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("CATEGORIES = %q, want %q", got, want)
+	}
+	attendee := event.Get("ATTENDEE")
+	if attendee.Params["CN"] != "Doe, John" || attendee.Params["ROLE"] != "REQ-PARTICIPANT" || attendee.Value != "mailto:john@example.com" {
+		t.Errorf("ATTENDEE = %+v, want the quoted CN, the ROLE and the mailto value", attendee)
+	}
+	if event.Get("LOCATION") != nil || event.Text("LOCATION") != "" {
+		t.Errorf("missing LOCATION property was found")
+	}
+	if len(event.Components) != 1 || event.Components[0].Text("TRIGGER") != "-PT15M" {
+		t.Errorf("event components = %v, want a VALARM", event.Components)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "empty", input: ""},
+		{name: "missing end", input: "BEGIN:VCALENDAR\nBEGIN:VEVENT\nEND:VEVENT\n"},
+		{name: "mismatched end", input: "BEGIN:VCALENDAR\nBEGIN:VEVENT\nEND:VCALENDAR\n"},
+		{name: "property outside of a component", input: "VERSION:2.0\nBEGIN:VCALENDAR\nEND:VCALENDAR\n"},
+		{name: "multiple top level components", input: "BEGIN:VCALENDAR\nEND:VCALENDAR\nBEGIN:VCALENDAR\nEND:VCALENDAR\n"},
+		{name: "line without value", input: "BEGIN:VCALENDAR\nSUMMARY\nEND:VCALENDAR\n"},
+		{name: "unterminated quoted parameter", input: "BEGIN:VCALENDAR\nATTENDEE;CN=\"Doe:mailto:a@b.c\nEND:VCALENDAR\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(strings.NewReader(tt.input)); err == nil {
+				t.Errorf("Parse() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	newYork, _ := time.LoadLocation("America/New_York")
+	tests := []struct {
+		name       string
+		property   Property
+		want       time.Time
+		wantAllDay bool
+	}{
+		{
+			name:       "date",
+			property:   Property{Params: map[string]string{"VALUE": "DATE"}, Value: "19970714"},
+			want:       time.Date(1997, 7, 14, 0, 0, 0, 0, time.UTC),
+			wantAllDay: true,
+		},
+		{
+			name:     "utc date-time",
+			property: Property{Params: map[string]string{}, Value: "19980119T070000Z"},
+			want:     time.Date(1998, 1, 19, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "date-time with a time zone",
+			property: Property{Params: map[string]string{"TZID": "America/New_York"}, Value: "19980119T020000"},
+			want:     time.Date(1998, 1, 19, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "floating date-time",
+			property: Property{Params: map[string]string{}, Value: "19980118T230000"},
+			want:     time.Date(1998, 1, 19, 4, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "unknown time zone",
+			property: Property{Params: map[string]string{"TZID": "Custom Zone"}, Value: "19980118T230000"},
+			want:     time.Date(1998, 1, 19, 4, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, allDay, err := ParseTime(&tt.property, newYork)
+			if err != nil {
+				t.Fatalf("ParseTime() error = %v", err)
+			}
+			if !got.Equal(tt.want) || allDay != tt.wantAllDay {
+				t.Errorf("ParseTime() = %v, %v, want %v, %v", got, allDay, tt.want, tt.wantAllDay)
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "P15DT5H0M20S", want: 15*24*time.Hour + 5*time.Hour + 20*time.Second},
+		{value: "P7W", want: 7 * 7 * 24 * time.Hour},
+		{value: "PT1H30M", want: 90 * time.Minute},
+		{value: "-PT15M", want: -15 * time.Minute},
+		{value: "+P1D", want: 24 * time.Hour},
+		{value: "P", wantErr: true},
+		{value: "PT", wantErr: true},
+		{value: "1H", wantErr: true},
+		{value: "PT1.5H", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseDuration(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseDuration(%q) = %v, %v, want %v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// Package ical reads and writes RFC 5545 iCalendar data.
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxLineOctets is the maximum length of a content line before folding.
+const maxLineOctets = 75
+
+// Date and date-time value formats.
+const (
+	DateFormat        = "20060102"
+	DateTimeFormat    = "20060102T150405"
+	UTCDateTimeFormat = "20060102T150405Z"
+)
+
+// Writer writes iCalendar content lines, folding and terminating them with CRLF.
+type Writer struct {
+	w   *bufio.Writer
+	err error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Begin starts a component e.g VCALENDAR or VEVENT.
+func (w *Writer) Begin(component string) {
+	w.Property("BEGIN", component)
+}
+
+// End ends a component.
+func (w *Writer) End(component string) {
+	w.Property("END", component)
+}
+
+// Property writes a property whose value is already formatted, params are
+// written as is e.g TZID=Europe/London.
+func (w *Writer) Property(name, value string, params ...string) {
+	line := name
+	for _, param := range params {
+		line += ";" + param
+	}
+	w.writeLine(line + ":" + value)
+}
+
+// Text writes a text property, escaping the value.
+func (w *Writer) Text(name, value string) {
+	w.Property(name, EscapeText(value))
+}
+
+// DateTime writes a date-time property, in UTC when loc is nil or UTC and
+// as a local time with a TZID parameter otherwise.
+func (w *Writer) DateTime(name string, t time.Time, loc *time.Location) {
+	if loc == nil || loc == time.UTC {
+		w.Property(name, t.UTC().Format(UTCDateTimeFormat))
+		return
+	}
+	w.Property(name, t.In(loc).Format(DateTimeFormat), "TZID="+loc.String())
+}
+
+// Date writes a date property.
+func (w *Writer) Date(name string, t time.Time) {
+	w.Property(name, t.Format(DateFormat), "VALUE=DATE")
+}
+
+// Timezone writes a VTIMEZONE component describing the offsets of loc
+// between from and to.
+func (w *Writer) Timezone(loc *time.Location, from, to time.Time) {
+	w.Begin("VTIMEZONE")
+	w.Property("TZID", loc.String())
+	name, offset := from.In(loc).Zone()
+	transitions := zoneTransitions(loc, from, to)
+	if len(transitions) == 0 {
+		w.Begin("STANDARD")
+		w.Property("DTSTART", "19700101T000000")
+		w.Property("TZOFFSETFROM", formatOffset(offset))
+		w.Property("TZOFFSETTO", formatOffset(offset))
+		w.Text("TZNAME", name)
+		w.End("STANDARD")
+	}
+	for _, transition := range transitions {
+		toName, toOffset := transition.In(loc).Zone()
+		component := "STANDARD"
+		if toOffset > offset {
+			component = "DAYLIGHT"
+		}
+		w.Begin(component)
+		// the onset is expressed in the local time in effect before the transition.
+		w.Property("DTSTART", transition.In(time.FixedZone("", offset)).Format(DateTimeFormat))
+		w.Property("TZOFFSETFROM", formatOffset(offset))
+		w.Property("TZOFFSETTO", formatOffset(toOffset))
+		w.Text("TZNAME", toName)
+		w.End(component)
+		offset = toOffset
+	}
+	w.End("VTIMEZONE")
+}
+
+// Flush writes the buffered lines and returns the first write error.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
+
+// writeLine writes a content line folded at 75 octets, continuation lines
+// start with a space and utf-8 characters are never split.
+func (w *Writer) writeLine(line string) {
+	if w.err != nil {
+		return
+	}
+	var folded strings.Builder
+	limit := maxLineOctets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		folded.WriteString(line[:cut])
+		folded.WriteString("\r\n ")
+		line = line[cut:]
+		// the leading space counts toward the continuation line length.
+		limit = maxLineOctets - 1
+	}
+	folded.WriteString(line)
+	folded.WriteString("\r\n")
+	_, w.err = w.w.WriteString(folded.String())
+}
+
+// EscapeText escapes a TEXT value.
+func EscapeText(value string) string {
+	replacer := strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+		"\r", `\n`,
+	)
+	return replacer.Replace(value)
+}
+
+func formatOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	value := fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds%3600/60)
+	if seconds%60 != 0 {
+		value += fmt.Sprintf("%02d", seconds%60)
+	}
+	return value
+}
+
+// zoneTransitions returns the instants between from and to at which the
+// offset of loc changes.
+func zoneTransitions(loc *time.Location, from, to time.Time) []time.Time {
+	var transitions []time.Time
+	_, offset := from.In(loc).Zone()
+	for day := from; day.Before(to); day = day.Add(24 * time.Hour) {
+		next := day.Add(24 * time.Hour)
+		_, nextOffset := next.In(loc).Zone()
+		if nextOffset == offset {
+			continue
+		}
+		// narrowing down the transition to the second.
+		low, high := day, next
+		for high.Sub(low) > time.Second {
+			mid := low.Add(high.Sub(low) / 2)
+			if _, midOffset := mid.In(loc).Zone(); midOffset == offset {
+				low = mid
+			} else {
+				high = mid
+			}
+		}
+		// offsets change on whole seconds, so the transition is the first whole second after low.
+		transitions = append(transitions, low.Truncate(time.Second).Add(time.Second))
+		offset = nextOffset
+	}
+	return transitions
+}
@@ -0,0 +1,121 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestWriterFolding(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	description := strings.Repeat("Réunion d'équipe, ordre du jour; ", 10)
+	w.Begin("VEVENT")
+	w.Text("DESCRIPTION", description)
+	w.End("VEVENT")
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	output := buf.String()
+	if !strings.HasSuffix(output, "\r\n") {
+		t.Errorf("output does not end with CRLF")
+	}
+	lines := strings.Split(strings.TrimSuffix(output, "\r\n"), "\r\n")
+	for i, line := range lines {
+		if len(line) > maxLineOctets {
+			t.Errorf("line %d is %d octets long, want at most %d", i, len(line), maxLineOctets)
+		}
+		if !utf8.ValidString(line) {
+			t.Errorf("line %d splits a utf-8 character: %q", i, line)
+		}
+	}
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], " ") {
+		t.Fatalf("description was not folded: %q", lines)
+	}
+
+	component, err := Parse(&buf)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got := component.Text("DESCRIPTION"); got != description {
+		t.Errorf("DESCRIPTION read back = %q, want %q", got, description)
+	}
+}
+
+func TestEscapeText(t *testing.T) {
+	value := "Meeting; agenda, notes\\drafts\nsecond line\r\nthird line"
+	escaped := EscapeText(value)
+	if want := `Meeting\; agenda\, notes\\drafts\nsecond line\nthird line`; escaped != want {
+		t.Errorf("EscapeText() = %q, want %q", escaped, want)
+	}
+	if got, want := UnescapeText(escaped), strings.Replace(value, "\r\n", "\n", 1); got != want {
+		t.Errorf("UnescapeText(EscapeText()) = %q, want %q", got, want)
+	}
+}
+
+func TestWriterTimes(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatalf("LoadLocation() error = %v", err)
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	instant := time.Date(2022, 7, 14, 16, 0, 0, 0, time.UTC)
+	w.DateTime("DTSTART", instant, nil)
+	w.DateTime("DTEND", instant, london)
+	w.Date("DUE", instant)
+	w.Flush()
+	want := "DTSTART:20220714T160000Z\r\n" +
+		"DTEND;TZID=Europe/London:20220714T170000\r\n" +
+		"DUE;VALUE=DATE:20220714\r\n"
+	if buf.String() != want {
+		t.Errorf("output = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestWriterTimezone(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatalf("LoadLocation() error = %v", err)
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Timezone(london, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	w.Flush()
+	want := "BEGIN:VTIMEZONE\r\n" +
+		"TZID:Europe/London\r\n" +
+		"BEGIN:DAYLIGHT\r\n" +
+		"DTSTART:20220327T010000\r\n" +
+		"TZOFFSETFROM:+0000\r\n" +
+		"TZOFFSETTO:+0100\r\n" +
+		"TZNAME:BST\r\n" +
+		"END:DAYLIGHT\r\n" +
+		"BEGIN:STANDARD\r\n" +
+		"DTSTART:20221030T020000\r\n" +
+		"TZOFFSETFROM:+0100\r\n" +
+		"TZOFFSETTO:+0000\r\n" +
+		"TZNAME:GMT\r\n" +
+		"END:STANDARD\r\n" +
+		"END:VTIMEZONE\r\n"
+	if buf.String() != want {
+		t.Errorf("output = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestFormatOffset(t *testing.T) {
+	tests := map[int]string{
+		0:      "+0000",
+		3600:   "+0100",
+		19800:  "+0530",
+		-12600: "-0330",
+		-18030: "-050030",
+	}
+	for seconds, want := range tests {
+		if got := formatOffset(seconds); got != want {
+			t.Errorf("formatOffset(%d) = %q, want %q", seconds, got, want)
+		}
+	}
+}
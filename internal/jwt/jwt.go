@@ -2,12 +2,20 @@ package jwt
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 )
 
 type Payload struct {
-	UserID string
+	UserID      string
+	WorkspaceID string
+	// SessionID is the session the token was issued for, it is empty for
+	// tokens issued before sessions were added, which are not accepted.
+	SessionID string
+	// IssuedAt is the time the token was issued at, it is zero for tokens
+	// issued before it was added.
+	IssuedAt time.Time
 }
 
 // Encode encodes a jwt token using data gotten from payload.
@@ -16,7 +24,14 @@ func Encode(secretKey []byte, payload Payload) (tokenString string, err error) {
 		return "", fmt.Errorf("secret key must be provided")
 	}
 	claims := jwt.MapClaims{
-		"userid": payload.UserID,
+		"userid":      payload.UserID,
+		"workspaceid": payload.WorkspaceID,
+	}
+	if !payload.IssuedAt.IsZero() {
+		claims["iat"] = payload.IssuedAt.Unix()
+	}
+	if payload.SessionID != "" {
+		claims["sid"] = payload.SessionID
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenString, err = token.SignedString(secretKey)
@@ -45,7 +60,12 @@ func Decode(secretKey []byte, tokenString string) (payload *Payload, err error)
 	if token.Valid {
 		claims := token.Claims.(jwt.MapClaims)
 		payload = &Payload{
-			UserID: interfaceToStr(claims["userid"]),
+			UserID:      interfaceToStr(claims["userid"]),
+			WorkspaceID: interfaceToStr(claims["workspaceid"]),
+			SessionID:   interfaceToStr(claims["sid"]),
+		}
+		if iat, ok := claims["iat"].(float64); ok {
+			payload.IssuedAt = time.Unix(int64(iat), 0)
 		}
 		return payload, nil
 	}
@@ -0,0 +1,27 @@
+package mailer
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LogMailer is a Mailer that writes emails to the log instead of sending
+// them, for development.
+type LogMailer struct {
+	log *logrus.Logger
+}
+
+// NewLogMailer creates a mailer writing emails to log.
+func NewLogMailer(log *logrus.Logger) *LogMailer {
+	return &LogMailer{log: log}
+}
+
+func (m *LogMailer) Send(ctx context.Context, message Message) error {
+	m.log.WithContext(ctx).
+		WithField("to", message.To).
+		WithField("subject", message.Subject).
+		WithField("body", message.Body).
+		Info("email not sent, the log mailer is used")
+	return nil
+}
@@ -0,0 +1,42 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails.
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// NewFromEnv returns the mailer configured by the MAILER environment
+// variable, "log" (the default) or "smtp".
+func NewFromEnv(log *logrus.Logger) (Mailer, error) {
+	switch os.Getenv("MAILER") {
+	case "", "log":
+		return NewLogMailer(log), nil
+
+	case "smtp":
+		return NewSMTPMailer(SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		})
+
+	default:
+		return nil, fmt.Errorf("unsupported mailer %q", os.Getenv("MAILER"))
+	}
+}
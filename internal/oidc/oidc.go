@@ -0,0 +1,337 @@
+// Package oidc logs users in with OpenID Connect identity providers, using
+// the authorization code flow with PKCE (RFC 7636).
+//
+// The provider endpoints are found with OpenID Connect discovery and ID
+// tokens must be signed with RS256 by a key of the provider JWKS.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// keysRefreshInterval is the minimum time between two downloads of the
+// provider keys, they are downloaded again when an ID token is signed with
+// an unknown key e.g after a key rotation.
+const keysRefreshInterval = time.Minute
+
+// maxResponseSize limits the size of the provider responses.
+const maxResponseSize = 1 << 20
+
+// Config configures an identity provider.
+type Config struct {
+	// Issuer is the url the discovery document is fetched from, e.g
+	// https://accounts.google.com or http://localhost:9000 for a local mock
+	// provider.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the url of the api callback endpoint registered with
+	// the provider.
+	RedirectURL string
+	// Scopes are requested in addition to openid, email and profile.
+	Scopes []string
+	// HTTPClient is used to send requests, http.DefaultClient is used when nil.
+	HTTPClient *http.Client
+}
+
+// Claims are the claims of a verified ID token identifying the user.
+type Claims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	GivenName     string
+	FamilyName    string
+}
+
+// Provider is an OpenID Connect identity provider, its discovery document
+// and keys are fetched on first use and cached.
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu            sync.Mutex
+	metadata      *metadata
+	keys          map[string]*rsa.PublicKey
+	keysFetchedAt time.Time
+}
+
+// metadata is the part of the discovery document used by the api.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewProvider creates an identity provider, nothing is fetched until it is used.
+func NewProvider(config Config) (*Provider, error) {
+	if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, fmt.Errorf("oidc issuer, client id and redirect url must be provided")
+	}
+	config.Issuer = strings.TrimSuffix(config.Issuer, "/")
+	client := config.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Provider{config: config, client: client}, nil
+}
+
+// ProvidersFromEnv returns the identity providers named in the comma
+// separated OIDC_PROVIDERS environment variable, each one configured by the
+// OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET and
+// OIDC_<NAME>_REDIRECT_URL environment variables.
+func ProvidersFromEnv() (map[string]*Provider, error) {
+	providers := map[string]*Provider{}
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		provider, err := NewProvider(Config{
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("oidc provider %s: %w", name, err)
+		}
+		providers[name] = provider
+	}
+	return providers, nil
+}
+
+// RandomString returns a random url safe string, for states, nonces and
+// PKCE code verifiers.
+func RandomString() (string, error) {
+	random := make([]byte, 32)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// CodeChallenge returns the S256 PKCE challenge of the code verifier.
+func CodeChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthURL returns the url of the provider login page, which redirects to
+// the callback with a code and the state once the user logged in.
+func (p *Provider) AuthURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	m, err := p.getMetadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid", "email", "profile"}, p.config.Scopes...), " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {CodeChallenge(codeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(m.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return m.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange exchanges the code of the callback for an ID token and returns
+// its claims once verified, nonce must be the one of the auth url.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Claims, error) {
+	m, err := p.getMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	if p.config.ClientSecret == "" {
+		form.Set("client_id", p.config.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	err = p.doJSON(req, &tokens)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("token response has no id token")
+	}
+	return p.verifyIDToken(ctx, m, tokens.IDToken, nonce)
+}
+
+// verifyIDToken checks the signature, issuer, audience, expiry and nonce of
+// the ID token and returns its claims.
+func (p *Provider) verifyIDToken(ctx context.Context, m *metadata, idToken, nonce string) (*Claims, error) {
+	token, err := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodRS256 {
+			return nil, fmt.Errorf("unsupported id token algorithm %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.getKey(ctx, m, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %w", err)
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	now := time.Now().Unix()
+	switch {
+	case !claims.VerifyIssuer(m.Issuer, true):
+		return nil, fmt.Errorf("invalid id token issuer")
+	case !claims.VerifyAudience(p.config.ClientID, true):
+		return nil, fmt.Errorf("invalid id token audience")
+	case !claims.VerifyExpiresAt(now, true):
+		return nil, fmt.Errorf("id token expired")
+	case claims["nonce"] != nonce:
+		return nil, fmt.Errorf("invalid id token nonce")
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("id token has no subject")
+	}
+	email, _ := claims["email"].(string)
+	givenName, _ := claims["given_name"].(string)
+	familyName, _ := claims["family_name"].(string)
+	return &Claims{
+		Subject: subject,
+		Email:   email,
+		// some providers send the boolean as a string.
+		EmailVerified: claims["email_verified"] == true || claims["email_verified"] == "true",
+		GivenName:     givenName,
+		FamilyName:    familyName,
+	}, nil
+}
+
+// getMetadata returns the discovery document of the provider.
+func (p *Provider) getMetadata(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.config.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var m metadata
+	err = p.doJSON(req, &m)
+	if err != nil {
+		return nil, fmt.Errorf("discovery failed: %w", err)
+	}
+	// the issuer of the document must be the configured one (OpenID
+	// Connect Discovery 1.0 section 4.3).
+	if strings.TrimSuffix(m.Issuer, "/") != p.config.Issuer {
+		return nil, fmt.Errorf("discovery issuer %q does not match %q", m.Issuer, p.config.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document is missing endpoints")
+	}
+	p.metadata = &m
+	return p.metadata, nil
+}
+
+// getKey returns the provider key with the id, the keys are downloaded
+// again when it is unknown.
+func (p *Provider) getKey(ctx context.Context, m *metadata, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetchedAt) < keysRefreshInterval {
+		return nil, fmt.Errorf("unknown id token key %q", kid)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	err = p.doJSON(req, &jwks)
+	if err != nil {
+		return nil, fmt.Errorf("jwks request failed: %w", err)
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown id token key %q", kid)
+}
+
+// doJSON sends the request and decodes the json response into v.
+func (p *Provider) doJSON(req *http.Request, v interface{}) error {
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
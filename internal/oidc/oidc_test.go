@@ -0,0 +1,292 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	testClientID     = "todo-list-api"
+	testClientSecret = "client secret"
+	testRedirectURL  = "https://api.example.com/auth/oidc/test/callback"
+)
+
+// mockProvider is an identity provider serving discovery, its JWKS and a
+// token endpoint returning IDToken.
+type mockProvider struct {
+	*httptest.Server
+	t *testing.T
+
+	mu           sync.Mutex
+	keys         map[string]*rsa.PrivateKey
+	jwksRequests int
+	tokenForm    url.Values
+	tokenAuth    [2]string
+	IDToken      string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	p := &mockProvider{t: t, keys: map[string]*rsa.PrivateKey{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(rw http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(rw).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(rw http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.jwksRequests++
+		keys := []map[string]string{}
+		for kid, key := range p.keys {
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"kid": kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(rw).Encode(map[string]interface{}{"keys": keys})
+	})
+	mux.HandleFunc("/token", func(rw http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		r.ParseForm()
+		p.tokenForm = r.PostForm
+		user, password, _ := r.BasicAuth()
+		p.tokenAuth = [2]string{user, password}
+		json.NewEncoder(rw).Encode(map[string]string{"access_token": "access", "id_token": p.IDToken})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// rotateKey generates a key with the id, served in the JWKS.
+func (p *mockProvider) rotateKey(kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		p.t.Fatalf("GenerateKey() error = %v", err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = map[string]*rsa.PrivateKey{kid: key}
+}
+
+// sign returns an ID token with the claims signed by the key with the id.
+func (p *mockProvider) sign(kid string, claims jwt.MapClaims) string {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+	if !ok {
+		var err error
+		if key, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			p.t.Fatalf("GenerateKey() error = %v", err)
+		}
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		p.t.Fatalf("SignedString() error = %v", err)
+	}
+	return signed
+}
+
+// claims returns valid ID token claims for the nonce.
+func (p *mockProvider) claims(nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            p.URL,
+		"sub":            "248289761001",
+		"aud":            testClientID,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          nonce,
+		"email":          "jane@example.com",
+		"email_verified": "true",
+		"given_name":     "Jane",
+		"family_name":    "Doe",
+	}
+}
+
+func newTestProvider(t *testing.T, issuer string) *Provider {
+	t.Helper()
+	provider, err := NewProvider(Config{
+		Issuer:       issuer,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+	})
+	if err != nil {
+		t.Fatalf("NewProvider() error = %v", err)
+	}
+	return provider
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// the example of RFC 7636 appendix B.
+	got := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Errorf("CodeChallenge() = %s, want %s", got, want)
+	}
+}
+
+func TestAuthURL(t *testing.T) {
+	mock := newMockProvider(t)
+	provider := newTestProvider(t, mock.URL+"/")
+	authURL, err := provider.AuthURL(context.Background(), "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatalf("AuthURL() error = %v", err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil || !strings.HasPrefix(authURL, mock.URL+"/authorize?") {
+		t.Fatalf("AuthURL() = %s, want the authorization endpoint", authURL)
+	}
+	query := parsed.Query()
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          testRedirectURL,
+		"scope":                 "openid email profile",
+		"state":                 "state",
+		"nonce":                 "nonce",
+		"code_challenge":        CodeChallenge("verifier"),
+		"code_challenge_method": "S256",
+	}
+	for name, value := range want {
+		if got := query.Get(name); got != value {
+			t.Errorf("AuthURL() %s = %q, want %q", name, got, value)
+		}
+	}
+}
+
+func TestExchange(t *testing.T) {
+	mock := newMockProvider(t)
+	mock.rotateKey("key-1")
+	mock.IDToken = mock.sign("key-1", mock.claims("nonce"))
+	provider := newTestProvider(t, mock.URL)
+
+	claims, err := provider.Exchange(context.Background(), "code", "verifier", "nonce")
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+	want := Claims{Subject: "248289761001", Email: "jane@example.com", EmailVerified: true, GivenName: "Jane", FamilyName: "Doe"}
+	if *claims != want {
+		t.Errorf("Exchange() = %+v, want %+v", *claims, want)
+	}
+	form := mock.tokenForm
+	if form.Get("grant_type") != "authorization_code" || form.Get("code") != "code" ||
+		form.Get("code_verifier") != "verifier" || form.Get("redirect_uri") != testRedirectURL {
+		t.Errorf("token request form = %v, want the code, its PKCE verifier and the redirect url", form)
+	}
+	if mock.tokenAuth != [2]string{testClientID, url.QueryEscape(testClientSecret)} {
+		t.Errorf("token request basic auth = %q, want the client credentials", mock.tokenAuth)
+	}
+}
+
+func TestExchangeInvalidIDToken(t *testing.T) {
+	mock := newMockProvider(t)
+	mock.rotateKey("key-1")
+	provider := newTestProvider(t, mock.URL)
+	with := func(name string, value interface{}) jwt.MapClaims {
+		claims := mock.claims("nonce")
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+	hmacToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, mock.claims("nonce")).SignedString([]byte(testClientSecret))
+
+	tests := []struct {
+		name    string
+		idToken string
+	}{
+		{name: "nonce mismatch", idToken: mock.sign("key-1", with("nonce", "other nonce"))},
+		{name: "missing nonce", idToken: mock.sign("key-1", with("nonce", nil))},
+		{name: "wrong audience", idToken: mock.sign("key-1", with("aud", "other-client"))},
+		{name: "wrong issuer", idToken: mock.sign("key-1", with("iss", "https://attacker.example.com"))},
+		{name: "expired", idToken: mock.sign("key-1", with("exp", time.Now().Add(-time.Minute).Unix()))},
+		{name: "missing subject", idToken: mock.sign("key-1", with("sub", nil))},
+		{name: "signed by an unknown key", idToken: mock.sign("key-2", mock.claims("nonce"))},
+		{name: "signed with hmac", idToken: hmacToken},
+		{name: "not a jwt", idToken: "not a jwt"},
+		{name: "missing", idToken: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.IDToken = tt.idToken
+			if claims, err := provider.Exchange(context.Background(), "code", "verifier", "nonce"); err == nil {
+				t.Errorf("Exchange() = %+v, want an error", claims)
+			}
+		})
+	}
+}
+
+func TestExchangeKeyRotation(t *testing.T) {
+	mock := newMockProvider(t)
+	mock.rotateKey("key-1")
+	provider := newTestProvider(t, mock.URL)
+	mock.IDToken = mock.sign("key-1", mock.claims("nonce"))
+	if _, err := provider.Exchange(context.Background(), "code", "verifier", "nonce"); err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+
+	mock.rotateKey("key-2")
+	mock.IDToken = mock.sign("key-2", mock.claims("nonce"))
+	// the keys were just downloaded, an unknown key does not download
+	// them again.
+	if _, err := provider.Exchange(context.Background(), "code", "verifier", "nonce"); err == nil {
+		t.Errorf("Exchange() right after the keys were downloaded error = nil, want an unknown key error")
+	}
+	if mock.jwksRequests != 1 {
+		t.Errorf("jwks requests = %d, want 1", mock.jwksRequests)
+	}
+
+	provider.keysFetchedAt = time.Now().Add(-keysRefreshInterval)
+	if _, err := provider.Exchange(context.Background(), "code", "verifier", "nonce"); err != nil {
+		t.Fatalf("Exchange() with the rotated key error = %v", err)
+	}
+	if mock.jwksRequests != 2 {
+		t.Errorf("jwks requests = %d, want 2", mock.jwksRequests)
+	}
+	// the rotated key is cached.
+	if _, err := provider.Exchange(context.Background(), "code", "verifier", "nonce"); err != nil || mock.jwksRequests != 2 {
+		t.Errorf("Exchange() = %v with %d jwks requests, want the cached key", err, mock.jwksRequests)
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(rw).Encode(map[string]string{
+			"issuer":                 "https://attacker.example.com",
+			"authorization_endpoint": "https://attacker.example.com/authorize",
+			"token_endpoint":         "https://attacker.example.com/token",
+			"jwks_uri":               "https://attacker.example.com/jwks",
+		})
+	}))
+	defer server.Close()
+	provider := newTestProvider(t, server.URL)
+	if authURL, err := provider.AuthURL(context.Background(), "state", "nonce", "verifier"); err == nil {
+		t.Errorf("AuthURL() = %s, want an issuer mismatch error", authURL)
+	}
+}
@@ -0,0 +1,251 @@
+package qrcode
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestFormatBits(t *testing.T) {
+	// the format information of the M level in ISO/IEC 18004 annex C.
+	want := []int{
+		0x5412, // 101010000010010
+		0x5125, // 101000100100101
+		0x5e7c, // 101111001111100
+		0x5b4b, // 101101101001011
+		0x45f9, // 100010111111001
+		0x40ce, // 100000011001110
+		0x4f97, // 100111110010111
+		0x4aa0, // 100101010100000
+	}
+	for mask, bits := range want {
+		if got := formatBits(mask); got != bits {
+			t.Errorf("formatBits(%d) = %015b, want %015b", mask, got, bits)
+		}
+	}
+}
+
+func TestVersionBits(t *testing.T) {
+	// the version information of ISO/IEC 18004 annex D.
+	want := map[int]int{
+		7:  0x07c94,
+		8:  0x085bc,
+		9:  0x09a99,
+		10: 0x0a4d3,
+		11: 0x0bbf6,
+		12: 0x0c762,
+		13: 0x0d847,
+		14: 0x0e60d,
+		15: 0x0f928,
+		16: 0x10b78,
+		17: 0x1145d,
+		18: 0x12a17,
+		19: 0x13532,
+		20: 0x149a6,
+	}
+	for version, bits := range want {
+		if got := versionBits(version); got != bits {
+			t.Errorf("versionBits(%d) = %018b, want %018b", version, got, bits)
+		}
+	}
+}
+
+func TestMediumBlocks(t *testing.T) {
+	// the total codewords of every version are fixed by its size.
+	for version := 1; version <= maxVersion; version++ {
+		size := version*4 + 17
+		code := &Code{Size: size, version: version}
+		code.modules = newGrid(size)
+		code.isFunction = newGrid(size)
+		code.drawFunctionPatterns()
+		dataModules := 0
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				if !code.isFunction[y][x] && x != 6 {
+					dataModules++
+				}
+			}
+		}
+		blocks := mediumBlocks[version]
+		total := blocks.dataCodewords() + blocks.ecCodewords*(blocks.group1Blocks+blocks.group2Blocks)
+		if total != dataModules/8 {
+			t.Errorf("version %d has %d codewords, want %d", version, total, dataModules/8)
+		}
+	}
+}
+
+// The reference codes were made by an independent encoder, which picked
+// the same masks.
+const (
+	referenceV2 = `
+#######....###..#.#######
+#.....#...#..####.#.....#
+#.###.#.##.#..#...#.###.#
+#.###.#.#....###..#.###.#
+#.###.#.###..#..#.#.###.#
+#.....#.#..#..##..#.....#
+#######.#.#.#.#.#.#######
+........#.....#.#........
+#.#####.....#.....#####..
+.#..##..#.##.#...#.#...#.
+#####.#.##...####..#.#.##
+##.###..#.##.#.##.##....#
+.###..#....##.##.##.#.###
+#####...#.#.....#..#.#.#.
+#.....##..###..#..####.##
+#..#...#...#..#######...#
+#.#..##.####....#####.#..
+........##..#####...##...
+#######......##.#.#.#.###
+#.....#.##..##..#...##.#.
+#.###.#.###.#.#######.#.#
+#.###.#.#......#.##.#####
+#.###.#.#####..#.....##.#
+#.....#....#..#.##.###..#
+#######.##.#.....########`
+
+	referenceV8 = `
+#######.....##..#........#####..#.#.....#.#######
+#.....#..#..####..###.##....#.####.#..###.#.....#
+#.###.#.##.###...#.#.#..##.##.#.####...##.#.###.#
+#.###.#.##..######...#.#.#...###...###.#..#.###.#
+#.###.#.#..#.#.#.#.########.#.#....###....#.###.#
+#.....#.###.#.#.#...#.#...##......#...#...#.....#
+#######.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#######
+........###.###..####.#...#########.....#........
+#.#####..#.#..#....########...##...###.##.#####..
+.###....##..#.##.###.#...#.#####...#......##.#.#.
+.#.#.###.####..###.#.#.#.##.....###.#.##...#.#.##
+...##...###..######.#....#.##.#.#.....#...###..##
+..#####..#.##.#..###.##.##.....#.#.###.##.##.##.#
+####...##.########...#..##..###.....##...##..#...
+###.#.#..###....####...####.......#...#.##...#.##
+.###.#.#.#.#####.#...#...##...##.#..#.##.##.#...#
+.##...###.#..#.....##...####.##.....#..#####.##..
+#..###.####...#..###.#..##....##.#.#.#...###.....
+...##.#.#.##..#####.##.#..##.##.....#.##...#....#
+..#.....##...#####.........##.#.##...#.#.##.#..##
+##....##.#.#..#..#..#.####....#...###..##....####
+.#...#....###.#.##.#.#.##..#######.......####....
+#..########.###.##.##.#####.#..####.#.########..#
+.#..#...##.......#...##...#.#...#.##....#...##.#.
+#..##.#.####.#.#.#.####.#.#...##..###..##.#.###.#
+#####...##.#...#...#..#...#...###....#.##...##...
+.#..#####..#.##.##....#####....#.##.###.#####..##
+.#.#.#.#..###..#.........#.####.##...##.#..##...#
+.#.##.#..#....#.#....#.##....##...######..#.###..
+#.#.#...#.#####.#######.#.###.####..##.#.###..#..
+..###.#.#..#.#.#..#.#.###...#....####.###.#....##
+..#..#....#...#.##.##...#.##.#....##....#..#...##
+.#...####..#.#.#....#.#.....#..#######.#.##.###.#
+####.#.##.####..#.##...##.####..#.##.#...#....#..
+##.##.#.#..###.#..#..#####..#.####....##.##..#.##
+.#.#.#..#..#####.....#.##.####..##...##....#....#
+..#####.#.#..#.####.###..#....##.#.##..####.#####
+.###.....#.#.#.#.#.#.####....##.....#.....##.#.#.
+.#...###.......######.####.....#.##.#.#####.##.##
+.###...###..####..###.#.#.###.###.##.#..##.##..#.
+###...#######.#####.#.#####...##.####.#########..
+........##.##.###....##...#.###..#.###..#...#.#..
+#######..#..##..#.#..##.#.##.#...####.#.#.#.#####
+#.....#.#..###..#.##.##...###.####.#.##.#...#...#
+#.###.#.#.######...#..#####...##.#.##.#.#########
+#.###.#.#.##.##...#..##..##.####....#..####.#.#.#
+#.###.#.##.....##..#..###.#.#...#.#.#.##.###..#..
+#.....#..#####.#.##..##.###...##.#...##..##.....#
+#######.##.#....##..##..#..#.##....##.##.#.######`
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{name: "version 2", data: "https://example.com", want: referenceV2},
+		{
+			name: "version 8 with several blocks and version bits",
+			data: "hello world, this is a longer input that needs a version with several blocks and version bits to be encoded in byte mode!! thanks",
+			want: referenceV8,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Encode([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			want := strings.Split(strings.TrimSpace(tt.want), "\n")
+			if code.Size != len(want) {
+				t.Fatalf("Encode() size = %d, want %d", code.Size, len(want))
+			}
+			for y, row := range want {
+				var got strings.Builder
+				for x := 0; x < code.Size; x++ {
+					if code.Dark(x, y) {
+						got.WriteByte('#')
+					} else {
+						got.WriteByte('.')
+					}
+				}
+				if got.String() != row {
+					t.Errorf("Encode() row %d = %s, want %s", y, got.String(), row)
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeVersions(t *testing.T) {
+	tests := []struct {
+		length      int
+		wantVersion int
+	}{
+		{length: 14, wantVersion: 1},
+		{length: 15, wantVersion: 2},
+		// the character count grows to 16 bits from version 10.
+		{length: 180, wantVersion: 9},
+		{length: 181, wantVersion: 10},
+		{length: 213, wantVersion: 10},
+		{length: 214, wantVersion: 11},
+		{length: 666, wantVersion: 20},
+	}
+	for _, tt := range tests {
+		code, err := Encode(bytes.Repeat([]byte("a"), tt.length))
+		if err != nil {
+			t.Fatalf("Encode() of %d bytes error = %v", tt.length, err)
+		}
+		if code.version != tt.wantVersion || code.Size != tt.wantVersion*4+17 {
+			t.Errorf("Encode() of %d bytes = version %d, want %d", tt.length, code.version, tt.wantVersion)
+		}
+	}
+	if _, err := Encode(bytes.Repeat([]byte("a"), 667)); err == nil {
+		t.Errorf("Encode() of 667 bytes error = nil, want too long")
+	}
+}
+
+func TestPNG(t *testing.T) {
+	code, err := Encode([]byte("https://example.com"))
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	data, err := code.PNG(4)
+	if err != nil {
+		t.Fatalf("PNG() error = %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("png.Decode() error = %v", err)
+	}
+	size := (code.Size + 2*quietZone) * 4
+	if bounds := img.Bounds(); bounds.Dx() != size || bounds.Dy() != size {
+		t.Errorf("PNG() size = %v, want %dx%d", bounds, size, size)
+	}
+	// the top left module of the finder is dark, the quiet zone is light.
+	dark, _, _, _ := img.At(quietZone*4, quietZone*4).RGBA()
+	light, _, _, _ := img.At(0, 0).RGBA()
+	if dark != 0 || light == 0 {
+		t.Errorf("PNG() finder corner = %d, quiet zone = %d, want dark and light", dark, light)
+	}
+}
@@ -0,0 +1,53 @@
+package qrcode
+
+// gfExp and gfLog are the exponents and logarithms of the GF(256) field of
+// QR codes, with the 0x11d reducing polynomial.
+var gfExp, gfLog [256]int
+
+func init() {
+	value := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = value
+		gfLog[value] = i
+		value <<= 1
+		if value&0x100 != 0 {
+			value ^= 0x11d
+		}
+	}
+}
+
+func gfMul(a, b int) int {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[(gfLog[a]+gfLog[b])%255]
+}
+
+// reedSolomon returns the error correction codewords of data.
+func reedSolomon(data []byte, ecCodewords int) []byte {
+	// the generator polynomial is the product of (x - a^i), its
+	// coefficients are stored from the highest degree.
+	generator := []int{1}
+	for i := 0; i < ecCodewords; i++ {
+		next := make([]int, len(generator)+1)
+		for j, coef := range generator {
+			next[j] ^= coef
+			next[j+1] ^= gfMul(coef, gfExp[i])
+		}
+		generator = next
+	}
+	remainder := make([]int, ecCodewords)
+	for _, b := range data {
+		factor := int(b) ^ remainder[0]
+		copy(remainder, remainder[1:])
+		remainder[ecCodewords-1] = 0
+		for i := range remainder {
+			remainder[i] ^= gfMul(generator[i+1], factor)
+		}
+	}
+	result := make([]byte, ecCodewords)
+	for i, coef := range remainder {
+		result[i] = byte(coef)
+	}
+	return result
+}
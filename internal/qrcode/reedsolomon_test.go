@@ -0,0 +1,50 @@
+package qrcode
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want []byte
+	}{
+		{
+			// "01234567" at 1-M, the example of ISO/IEC 18004 annex I.
+			name: "numeric example",
+			data: []byte{0x10, 0x20, 0x0c, 0x56, 0x61, 0x80, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11},
+			want: []byte{0xa5, 0x24, 0xd4, 0xc1, 0xed, 0x36, 0xc7, 0x87, 0x2c, 0x55},
+		},
+		{
+			// "HELLO WORLD" at 1-M in alphanumeric mode.
+			name: "alphanumeric example",
+			data: []byte{0x20, 0x5b, 0x0b, 0x78, 0xd1, 0x72, 0xdc, 0x4d, 0x43, 0x40, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11},
+			want: []byte{0xc4, 0x23, 0x27, 0x77, 0xeb, 0xd7, 0xe7, 0xe2, 0x5d, 0x17},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := reedSolomon(tt.data, len(tt.want)); !bytes.Equal(got, tt.want) {
+				t.Errorf("reedSolomon() = % x, want % x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGF256(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if gfExp[gfLog[a]] != a {
+			t.Fatalf("gfExp[gfLog[%d]] = %d, want %d", a, gfExp[gfLog[a]], a)
+		}
+		// a^255 is 1, so a * a^254 is 1.
+		inverse := gfExp[(255-gfLog[a])%255]
+		if got := gfMul(a, inverse); got != 1 {
+			t.Errorf("gfMul(%d, %d) = %d, want 1", a, inverse, got)
+		}
+	}
+	if gfMul(0, 7) != 0 || gfMul(7, 0) != 0 {
+		t.Errorf("gfMul() of 0 is not 0")
+	}
+}
@@ -0,0 +1,335 @@
+// Package rrule parses and expands the RFC 5545 recurrence rules supported
+// by recurring tasks.
+//
+// The supported subset is FREQ (DAILY, WEEKLY, MONTHLY and YEARLY),
+// INTERVAL, COUNT, UNTIL, BYDAY (with ordinals for monthly rules),
+// BYMONTHDAY and WKST.
+package rrule
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recurrence frequencies.
+const (
+	Daily   = "DAILY"
+	Weekly  = "WEEKLY"
+	Monthly = "MONTHLY"
+	Yearly  = "YEARLY"
+)
+
+// maxPeriods bounds the number of periods expanded for a single rule.
+const maxPeriods = 50000
+
+var weekdayCodes = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+var weekdayNames = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// WeekdayNum is a BYDAY value, N is the occurrence of the weekday in the
+// month (negative from the end) or 0 for every occurrence.
+type WeekdayNum struct {
+	N   int
+	Day time.Weekday
+}
+
+// Rule is a parsed recurrence rule.
+type Rule struct {
+	Freq       string
+	Interval   int
+	Count      int
+	Until      time.Time
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	WeekStart  time.Weekday
+}
+
+// Parse parses a recurrence rule e.g FREQ=WEEKLY;BYDAY=MO,WE;COUNT=10, the
+// RRULE: prefix is optional.
+func Parse(value string) (*Rule, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "RRULE:")
+	rule := Rule{Interval: 1, WeekStart: time.Monday}
+	for _, part := range strings.Split(value, ";") {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid recurrence rule part %q", part)
+		}
+		name, val := strings.ToUpper(kv[0]), strings.ToUpper(kv[1])
+		var err error
+		switch name {
+		case "FREQ":
+			if val != Daily && val != Weekly && val != Monthly && val != Yearly {
+				return nil, fmt.Errorf("unsupported recurrence frequency %q", val)
+			}
+			rule.Freq = val
+		case "INTERVAL":
+			rule.Interval, err = strconv.Atoi(val)
+			if err != nil || rule.Interval <= 0 {
+				return nil, fmt.Errorf("invalid recurrence interval %q", val)
+			}
+		case "COUNT":
+			rule.Count, err = strconv.Atoi(val)
+			if err != nil || rule.Count <= 0 {
+				return nil, fmt.Errorf("invalid recurrence count %q", val)
+			}
+		case "UNTIL":
+			rule.Until, err = parseUntil(val)
+			if err != nil {
+				return nil, fmt.Errorf("invalid recurrence until %q", val)
+			}
+		case "BYDAY":
+			for _, day := range strings.Split(val, ",") {
+				weekdayNum, err := parseWeekdayNum(day)
+				if err != nil {
+					return nil, err
+				}
+				rule.ByDay = append(rule.ByDay, weekdayNum)
+			}
+		case "BYMONTHDAY":
+			for _, day := range strings.Split(val, ",") {
+				monthDay, err := strconv.Atoi(day)
+				if err != nil || monthDay == 0 || monthDay < -31 || monthDay > 31 {
+					return nil, fmt.Errorf("invalid recurrence month day %q", day)
+				}
+				rule.ByMonthDay = append(rule.ByMonthDay, monthDay)
+			}
+		case "WKST":
+			weekStart, ok := weekdayCodes[val]
+			if !ok {
+				return nil, fmt.Errorf("invalid recurrence week start %q", val)
+			}
+			rule.WeekStart = weekStart
+		default:
+			return nil, fmt.Errorf("unsupported recurrence rule part %q", name)
+		}
+	}
+	if rule.Freq == "" {
+		return nil, fmt.Errorf("recurrence rule must have a frequency")
+	}
+	if rule.Count > 0 && !rule.Until.IsZero() {
+		return nil, fmt.Errorf("recurrence rule can not have both count and until")
+	}
+	if len(rule.ByMonthDay) > 0 && rule.Freq != Monthly {
+		return nil, fmt.Errorf("month days are only supported in monthly recurrences")
+	}
+	if len(rule.ByDay) > 0 && rule.Freq == Yearly {
+		return nil, fmt.Errorf("weekdays are not supported in yearly recurrences")
+	}
+	for _, weekdayNum := range rule.ByDay {
+		if weekdayNum.N != 0 && rule.Freq != Monthly {
+			return nil, fmt.Errorf("numbered weekdays are only supported in monthly recurrences")
+		}
+	}
+	return &rule, nil
+}
+
+func parseUntil(value string) (time.Time, error) {
+	for _, layout := range []string{"20060102T150405Z", "20060102T150405", "20060102"} {
+		until, err := time.Parse(layout, value)
+		if err == nil {
+			if layout == "20060102" {
+				// a date until includes the whole day.
+				until = until.Add(24*time.Hour - time.Second)
+			}
+			return until, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid until %q", value)
+}
+
+func parseWeekdayNum(value string) (WeekdayNum, error) {
+	if len(value) < 2 {
+		return WeekdayNum{}, fmt.Errorf("invalid recurrence weekday %q", value)
+	}
+	day, ok := weekdayCodes[value[len(value)-2:]]
+	if !ok {
+		return WeekdayNum{}, fmt.Errorf("invalid recurrence weekday %q", value)
+	}
+	weekdayNum := WeekdayNum{Day: day}
+	if ordinal := value[:len(value)-2]; ordinal != "" {
+		n, err := strconv.Atoi(ordinal)
+		if err != nil || n == 0 || n < -5 || n > 5 {
+			return WeekdayNum{}, fmt.Errorf("invalid recurrence weekday %q", value)
+		}
+		weekdayNum.N = n
+	}
+	return weekdayNum, nil
+}
+
+// String formats the rule without the RRULE: prefix.
+func (r Rule) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, 0, len(r.ByDay))
+		for _, weekdayNum := range r.ByDay {
+			day := weekdayNames[weekdayNum.Day]
+			if weekdayNum.N != 0 {
+				day = strconv.Itoa(weekdayNum.N) + day
+			}
+			days = append(days, day)
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		days := make([]string, 0, len(r.ByMonthDay))
+		for _, day := range r.ByMonthDay {
+			days = append(days, strconv.Itoa(day))
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	if r.WeekStart != time.Monday {
+		parts = append(parts, "WKST="+weekdayNames[r.WeekStart])
+	}
+	return strings.Join(parts, ";")
+}
+
+// Between returns the start times of the occurrences of a series starting
+// at dtstart that start in [from, to).
+//
+// Occurrences keep the wall clock time of dtstart in loc, so they do not
+// drift when daylight saving time changes.
+func (r Rule) Between(dtstart, from, to time.Time, loc *time.Location) []time.Time {
+	local := dtstart.In(loc)
+	var occurrences []time.Time
+	count := 0
+	for period := 0; period < maxPeriods; period++ {
+		periodStart, dates := r.periodDates(local, period)
+		if !time.Date(periodStart.Year(), periodStart.Month(), periodStart.Day(), 0, 0, 0, 0, loc).Before(to) {
+			break
+		}
+		for _, date := range dates {
+			occurrence := time.Date(date.Year(), date.Month(), date.Day(), local.Hour(), local.Minute(), local.Second(), 0, loc)
+			if occurrence.Before(dtstart) {
+				continue
+			}
+			count++
+			if r.Count > 0 && count > r.Count {
+				return occurrences
+			}
+			if !r.Until.IsZero() && occurrence.After(r.Until) {
+				return occurrences
+			}
+			if !occurrence.Before(to) {
+				return occurrences
+			}
+			if !occurrence.Before(from) {
+				occurrences = append(occurrences, occurrence)
+			}
+		}
+	}
+	return occurrences
+}
+
+// periodDates returns the first day of the nth period of the rule and the
+// sorted dates of the period matching the rule.
+func (r Rule) periodDates(start time.Time, period int) (time.Time, []time.Time) {
+	step := period * r.Interval
+	year, month, day := start.Date()
+	var periodStart time.Time
+	var dates []time.Time
+	switch r.Freq {
+	case Daily:
+		periodStart = time.Date(year, month, day+step, 0, 0, 0, 0, time.UTC)
+		if r.matchesWeekday(periodStart) {
+			dates = append(dates, periodStart)
+		}
+	case Weekly:
+		offset := (int(start.Weekday()) - int(r.WeekStart) + 7) % 7
+		periodStart = time.Date(year, month, day-offset+7*step, 0, 0, 0, 0, time.UTC)
+		for i := 0; i < 7; i++ {
+			date := periodStart.AddDate(0, 0, i)
+			if len(r.ByDay) == 0 && date.Weekday() == start.Weekday() || len(r.ByDay) > 0 && r.matchesWeekday(date) {
+				dates = append(dates, date)
+			}
+		}
+	case Monthly:
+		periodStart = time.Date(year, month+time.Month(step), 1, 0, 0, 0, 0, time.UTC)
+		dates = r.monthDates(periodStart, day)
+	case Yearly:
+		periodStart = time.Date(year+step, month, 1, 0, 0, 0, 0, time.UTC)
+		date := time.Date(year+step, month, day, 0, 0, 0, 0, time.UTC)
+		// yearly occurrences on a day the month does not have are skipped.
+		if date.Day() == day {
+			dates = append(dates, date)
+		}
+	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
+	return periodStart, dates
+}
+
+// monthDates returns the dates of the month matching the rule, defaulting
+// to the day of the month of the series start.
+func (r Rule) monthDates(monthStart time.Time, startDay int) []time.Time {
+	daysInMonth := monthStart.AddDate(0, 1, -1).Day()
+	var dates []time.Time
+	switch {
+	case len(r.ByMonthDay) > 0:
+		for _, monthDay := range r.ByMonthDay {
+			if monthDay < 0 {
+				monthDay = daysInMonth + monthDay + 1
+			}
+			if monthDay >= 1 && monthDay <= daysInMonth {
+				dates = append(dates, monthStart.AddDate(0, 0, monthDay-1))
+			}
+		}
+	case len(r.ByDay) > 0:
+		for _, weekdayNum := range r.ByDay {
+			var matches []time.Time
+			for i := 0; i < daysInMonth; i++ {
+				date := monthStart.AddDate(0, 0, i)
+				if date.Weekday() == weekdayNum.Day {
+					matches = append(matches, date)
+				}
+			}
+			switch {
+			case weekdayNum.N == 0:
+				dates = append(dates, matches...)
+			case weekdayNum.N > 0 && weekdayNum.N <= len(matches):
+				dates = append(dates, matches[weekdayNum.N-1])
+			case weekdayNum.N < 0 && -weekdayNum.N <= len(matches):
+				dates = append(dates, matches[len(matches)+weekdayNum.N])
+			}
+		}
+	default:
+		// monthly occurrences on a day the month does not have are skipped.
+		if startDay <= daysInMonth {
+			dates = append(dates, monthStart.AddDate(0, 0, startDay-1))
+		}
+	}
+	return dates
+}
+
+func (r Rule) matchesWeekday(date time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, weekdayNum := range r.ByDay {
+		if weekdayNum.Day == date.Weekday() {
+			return true
+		}
+	}
+	return false
+}
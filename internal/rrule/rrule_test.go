@@ -0,0 +1,191 @@
+package rrule
+
+import (
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("LoadLocation(%q) error = %v", name, err)
+	}
+	return loc
+}
+
+// TestBetweenRFC5545 checks the examples of RFC 5545 section 3.8.5.3 using
+// the supported parts.
+func TestBetweenRFC5545(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	tests := []struct {
+		name    string
+		rule    string
+		dtstart string
+		want    []string
+	}{
+		{
+			name:    "daily for 10 occurrences",
+			rule:    "FREQ=DAILY;COUNT=10",
+			dtstart: "1997-09-02 09:00",
+			want: []string{
+				"1997-09-02 09:00", "1997-09-03 09:00", "1997-09-04 09:00", "1997-09-05 09:00", "1997-09-06 09:00",
+				"1997-09-07 09:00", "1997-09-08 09:00", "1997-09-09 09:00", "1997-09-10 09:00", "1997-09-11 09:00",
+			},
+		},
+		{
+			name:    "every 10 days, 5 occurrences",
+			rule:    "FREQ=DAILY;INTERVAL=10;COUNT=5",
+			dtstart: "1997-09-02 09:00",
+			want:    []string{"1997-09-02 09:00", "1997-09-12 09:00", "1997-09-22 09:00", "1997-10-02 09:00", "1997-10-12 09:00"},
+		},
+		{
+			name:    "weekly on tuesday and thursday for five weeks",
+			rule:    "FREQ=WEEKLY;UNTIL=19971007T000000Z;WKST=SU;BYDAY=TU,TH",
+			dtstart: "1997-09-02 09:00",
+			want: []string{
+				"1997-09-02 09:00", "1997-09-04 09:00", "1997-09-09 09:00", "1997-09-11 09:00", "1997-09-16 09:00",
+				"1997-09-18 09:00", "1997-09-23 09:00", "1997-09-25 09:00", "1997-09-30 09:00", "1997-10-02 09:00",
+			},
+		},
+		{
+			name:    "every other week on tuesday and sunday, week starting on monday",
+			rule:    "FREQ=WEEKLY;INTERVAL=2;COUNT=4;BYDAY=TU,SU;WKST=MO",
+			dtstart: "1997-08-05 09:00",
+			want:    []string{"1997-08-05 09:00", "1997-08-10 09:00", "1997-08-19 09:00", "1997-08-24 09:00"},
+		},
+		{
+			name:    "every other week on tuesday and sunday, week starting on sunday",
+			rule:    "FREQ=WEEKLY;INTERVAL=2;COUNT=4;BYDAY=TU,SU;WKST=SU",
+			dtstart: "1997-08-05 09:00",
+			want:    []string{"1997-08-05 09:00", "1997-08-17 09:00", "1997-08-19 09:00", "1997-08-31 09:00"},
+		},
+		{
+			name:    "monthly on the first friday for 10 occurrences",
+			rule:    "FREQ=MONTHLY;COUNT=10;BYDAY=1FR",
+			dtstart: "1997-09-05 09:00",
+			want: []string{
+				"1997-09-05 09:00", "1997-10-03 09:00", "1997-11-07 09:00", "1997-12-05 09:00", "1998-01-02 09:00",
+				"1998-02-06 09:00", "1998-03-06 09:00", "1998-04-03 09:00", "1998-05-01 09:00", "1998-06-05 09:00",
+			},
+		},
+		{
+			name:    "monthly on the second-to-last monday for 6 months",
+			rule:    "FREQ=MONTHLY;COUNT=6;BYDAY=-2MO",
+			dtstart: "1997-09-22 09:00",
+			want: []string{
+				"1997-09-22 09:00", "1997-10-20 09:00", "1997-11-17 09:00",
+				"1997-12-22 09:00", "1998-01-19 09:00", "1998-02-16 09:00",
+			},
+		},
+		{
+			name:    "monthly on the third-to-the-last day",
+			rule:    "FREQ=MONTHLY;COUNT=6;BYMONTHDAY=-3",
+			dtstart: "1997-09-28 09:00",
+			want: []string{
+				"1997-09-28 09:00", "1997-10-29 09:00", "1997-11-28 09:00",
+				"1997-12-29 09:00", "1998-01-29 09:00", "1998-02-26 09:00",
+			},
+		},
+		{
+			name:    "monthly on the 2nd and 15th for 10 occurrences",
+			rule:    "FREQ=MONTHLY;COUNT=10;BYMONTHDAY=2,15",
+			dtstart: "1997-09-02 09:00",
+			want: []string{
+				"1997-09-02 09:00", "1997-09-15 09:00", "1997-10-02 09:00", "1997-10-15 09:00", "1997-11-02 09:00",
+				"1997-11-15 09:00", "1997-12-02 09:00", "1997-12-15 09:00", "1998-01-02 09:00", "1998-01-15 09:00",
+			},
+		},
+		{
+			name:    "monthly on the 31st skips shorter months",
+			rule:    "FREQ=MONTHLY;COUNT=4",
+			dtstart: "2007-01-31 09:00",
+			want:    []string{"2007-01-31 09:00", "2007-03-31 09:00", "2007-05-31 09:00", "2007-07-31 09:00"},
+		},
+		{
+			name:    "yearly on february 29th skips common years",
+			rule:    "FREQ=YEARLY;COUNT=3",
+			dtstart: "2000-02-29 09:00",
+			want:    []string{"2000-02-29 09:00", "2004-02-29 09:00", "2008-02-29 09:00"},
+		},
+		{
+			name:    "daily keeps the wall clock time across daylight saving time",
+			rule:    "FREQ=DAILY;COUNT=3",
+			dtstart: "1997-10-25 09:00",
+			want:    []string{"1997-10-25 09:00", "1997-10-26 09:00", "1997-10-27 09:00"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.rule, err)
+			}
+			dtstart, _ := time.ParseInLocation("2006-01-02 15:04", tt.dtstart, newYork)
+			occurrences := rule.Between(dtstart, dtstart, dtstart.AddDate(10, 0, 0), newYork)
+			got := make([]string, 0, len(occurrences))
+			for _, occurrence := range occurrences {
+				got = append(got, occurrence.In(newYork).Format("2006-01-02 15:04"))
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Between() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBetweenRange(t *testing.T) {
+	rule, err := Parse("FREQ=WEEKLY;BYDAY=MO")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	dtstart := time.Date(2022, 1, 3, 10, 0, 0, 0, time.UTC)
+	from := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2022, 3, 14, 10, 0, 0, 0, time.UTC)
+	got := rule.Between(dtstart, from, to, time.UTC)
+	// the range end is exclusive.
+	want := []time.Time{time.Date(2022, 3, 7, 10, 0, 0, 0, time.UTC)}
+	if len(got) != len(want) || !got[0].Equal(want[0]) {
+		t.Errorf("Between() = %v, want %v", got, want)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "RRULE:FREQ=WEEKLY;BYDAY=MO,WE;COUNT=10", want: "FREQ=WEEKLY;COUNT=10;BYDAY=MO,WE"},
+		{value: "freq=monthly;byday=-1fr;interval=2", want: "FREQ=MONTHLY;INTERVAL=2;BYDAY=-1FR"},
+		{value: "FREQ=DAILY;UNTIL=20220301", want: "FREQ=DAILY;UNTIL=20220301T235959Z"},
+		{value: "FREQ=WEEKLY;WKST=SU", want: "FREQ=WEEKLY;WKST=SU"},
+		{value: "COUNT=3", wantErr: true},
+		{value: "FREQ=HOURLY", wantErr: true},
+		{value: "FREQ=DAILY;COUNT=2;UNTIL=20220301", wantErr: true},
+		{value: "FREQ=DAILY;INTERVAL=0", wantErr: true},
+		{value: "FREQ=WEEKLY;BYMONTHDAY=1", wantErr: true},
+		{value: "FREQ=WEEKLY;BYDAY=1MO", wantErr: true},
+		{value: "FREQ=YEARLY;BYDAY=MO", wantErr: true},
+		{value: "FREQ=MONTHLY;BYMONTHDAY=32", wantErr: true},
+		{value: "FREQ=DAILY;BYHOUR=9", wantErr: true},
+	}
+	for _, tt := range tests {
+		rule, err := Parse(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Parse(%q) = %v, want an error", tt.value, rule)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q) error = %v", tt.value, err)
+			continue
+		}
+		if got := rule.String(); got != tt.want {
+			t.Errorf("Parse(%q).String() = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package tenant
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type contextKey string
+
+const workspaceIDKey contextKey = "workspaceId"
+
+// WithWorkspace returns a copy of ctx scoped to the workspace.
+func WithWorkspace(ctx context.Context, workspaceID string) context.Context {
+	return context.WithValue(ctx, workspaceIDKey, workspaceID)
+}
+
+// WorkspaceID returns the workspace ctx is scoped to, or an empty string
+// for unscoped contexts (e.g. login and sign up).
+func WorkspaceID(ctx context.Context) string {
+	workspaceID, _ := ctx.Value(workspaceIDKey).(string)
+	return workspaceID
+}
+
+// Filter restricts a db query filter to the workspace of ctx by matching
+// field against the workspace id, the filter is returned unchanged for unscoped contexts.
+func Filter(ctx context.Context, field string, filter bson.M) bson.M {
+	if workspaceID := WorkspaceID(ctx); workspaceID != "" {
+		filter[field] = workspaceID
+	}
+	return filter
+}
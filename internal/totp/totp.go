@@ -0,0 +1,110 @@
+// Package totp generates and validates the RFC 6238 time-based one-time
+// passwords of authenticator apps, with the defaults every app supports:
+// HMAC-SHA1, 6 digits and 30 second periods.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Period is how long a code is valid.
+const Period = 30 * time.Second
+
+// Digits is the number of digits of a code.
+const Digits = 6
+
+// skew is the number of periods before and after the current one whose
+// codes are accepted, for clocks that are a bit off.
+const skew = 1
+
+// secretSize is the size of generated secrets, the size of a SHA1 hash as
+// recommended by RFC 4226.
+const secretSize = 20
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a random base32 encoded secret.
+func GenerateSecret() (string, error) {
+	secret := make([]byte, secretSize)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(secret), nil
+}
+
+// Code returns the code of the base32 encoded secret at t.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return code(key, counter(t)), nil
+}
+
+// Validate checks the code against the base32 encoded secret at t and
+// returns the counter of the period the code belongs to, callers store it
+// to reject codes of that period or older, which were already used.
+func Validate(secret, passcode string, t time.Time, lastCounter int64) (int64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+	passcode = strings.TrimSpace(passcode)
+	if len(passcode) != Digits {
+		return 0, false
+	}
+	current := counter(t)
+	for c := current - skew; c <= current+skew; c++ {
+		if c <= lastCounter {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(code(key, c)), []byte(passcode)) == 1 {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// ProvisioningURI returns the otpauth URI authenticator apps import,
+// usually from a QR code, the account is shown below the issuer.
+func ProvisioningURI(issuer, account, secret string) string {
+	label := escape(issuer) + ":" + escape(account)
+	return fmt.Sprintf("otpauth://totp/%s?secret=%s&issuer=%s&algorithm=SHA1&digits=%d&period=%d",
+		label, secret, escape(issuer), Digits, int(Period.Seconds()))
+}
+
+// escape encodes spaces as %20, some apps show the + of query encoding.
+func escape(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	return encoding.DecodeString(strings.TrimRight(secret, "="))
+}
+
+func counter(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// code is the HOTP value of the counter (RFC 4226).
+func code(key []byte, c int64) string {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(c))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, value%1000000)
+}
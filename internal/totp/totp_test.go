@@ -0,0 +1,110 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 secret of the RFC 4226 and RFC 6238 test vectors,
+// "12345678901234567890" encoded in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238(t *testing.T) {
+	// the SHA1 vectors of RFC 6238 appendix B, truncated to 6 digits.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("Code() error = %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("Code(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestCodeRFC4226(t *testing.T) {
+	key, err := decodeSecret(rfcSecret)
+	if err != nil {
+		t.Fatalf("decodeSecret() error = %v", err)
+	}
+	// the HOTP vectors of RFC 4226 appendix D.
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for c, wantCode := range want {
+		if got := code(key, int64(c)); got != wantCode {
+			t.Errorf("code(%d) = %s, want %s", c, got, wantCode)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := counter(now)
+	codeAt := func(offset time.Duration) string {
+		code, _ := Code(rfcSecret, now.Add(offset))
+		return code
+	}
+	tests := []struct {
+		name        string
+		secret      string
+		passcode    string
+		lastCounter int64
+		wantCounter int64
+		wantOK      bool
+	}{
+		{name: "current code", secret: rfcSecret, passcode: codeAt(0), wantCounter: current, wantOK: true},
+		{name: "code with spaces around", secret: rfcSecret, passcode: " " + codeAt(0) + "\n", wantCounter: current, wantOK: true},
+		{name: "lowercase secret with spaces", secret: "gezd gnbv gy3t qojq gezd gnbv gy3t qojq", passcode: codeAt(0), wantCounter: current, wantOK: true},
+		{name: "previous period", secret: rfcSecret, passcode: codeAt(-Period), wantCounter: current - 1, wantOK: true},
+		{name: "next period", secret: rfcSecret, passcode: codeAt(Period), wantCounter: current + 1, wantOK: true},
+		{name: "two periods ago", secret: rfcSecret, passcode: codeAt(-2 * Period)},
+		{name: "two periods ahead", secret: rfcSecret, passcode: codeAt(2 * Period)},
+		{name: "replayed code", secret: rfcSecret, passcode: codeAt(0), lastCounter: current},
+		{name: "code older than the last used", secret: rfcSecret, passcode: codeAt(-Period), lastCounter: current},
+		{name: "code newer than the last used", secret: rfcSecret, passcode: codeAt(Period), lastCounter: current, wantCounter: current + 1, wantOK: true},
+		{name: "wrong code", secret: rfcSecret, passcode: "000000"},
+		{name: "short code", secret: rfcSecret, passcode: codeAt(0)[:5]},
+		{name: "invalid secret", secret: "not base32!", passcode: codeAt(0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotCounter, gotOK := Validate(tt.secret, tt.passcode, now, tt.lastCounter)
+			if gotCounter != tt.wantCounter || gotOK != tt.wantOK {
+				t.Errorf("Validate() = %d, %v, want %d, %v", gotCounter, gotOK, tt.wantCounter, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret() error = %v", err)
+	}
+	key, err := decodeSecret(secret)
+	if err != nil || len(key) != secretSize {
+		t.Errorf("GenerateSecret() = %q, want %d base32 encoded bytes", secret, secretSize)
+	}
+	if other, _ := GenerateSecret(); other == secret {
+		t.Errorf("GenerateSecret() returned the same secret twice")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	got := ProvisioningURI("Todo List", "jane+work@example.com", rfcSecret)
+	want := "otpauth://totp/Todo%20List:jane%2Bwork%40example.com?secret=" + rfcSecret +
+		"&issuer=Todo%20List&algorithm=SHA1&digits=6&period=30"
+	if got != want {
+		t.Errorf("ProvisioningURI() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,302 @@
+// Package validation checks request inputs against the rules declared in
+// their validate struct tags, e.g
+//
+//	Email    string    `json:"email" validate:"required,email"`
+//	Title    string    `json:"title" validate:"required,max=200"`
+//	EndTime  time.Time `json:"endTime" validate:"after=StartTime"`
+//
+// Rules are separated by commas, rule arguments follow an equal sign:
+//
+//	required              the field is not empty, strings are trimmed
+//	required_with=F       the field is not empty when the field F is not empty
+//	required_unless=F     the field is not empty unless the bool field F is true
+//	email                 the string is an email address without a name
+//	password              the string follows the password policy
+//	min=N, max=N          the length of strings (in characters) and slices, or the value of numbers
+//	oneof=A B C           the string is one of the space separated values
+//	after=F               the time is after the time field F
+//
+// Empty fields are only checked by the required rules. The fields of nested
+// structs and of the structs in slices are checked too. Fields are reported
+// with their json names and their path, e.g operations[1].taskId.
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Error codes of invalid fields.
+const (
+	CodeRequired     = "required"
+	CodeInvalidEmail = "invalid_email"
+	CodeWeakPassword = "weak_password"
+	CodeTooShort     = "too_short"
+	CodeTooLong      = "too_long"
+	CodeTooSmall     = "too_small"
+	CodeTooLarge     = "too_large"
+	CodeNotAllowed   = "not_allowed"
+	CodeNotAfter     = "not_after"
+	CodeUnknownField = "unknown_field"
+	CodeInvalidType  = "invalid_type"
+	CodeInvalidJSON  = "invalid_json"
+)
+
+// Password policy, bcrypt ignores the bytes after the first 72.
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 72
+)
+
+// FieldError is an invalid field of an input.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Errors lists the invalid fields of an input.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, fieldError := range e {
+		messages = append(messages, fieldError.Message)
+	}
+	return strings.Join(messages, ", ")
+}
+
+// Validate checks the fields of the struct v, or of the struct v points to,
+// against their rules. The returned error is Errors listing every invalid
+// field, nil is returned when v is valid. It panics on unknown rules as
+// they are programming errors.
+func Validate(v interface{}) error {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validation: %T is not a struct", v))
+	}
+	errs := validateStruct(value, "")
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// validateStruct checks the fields of the struct value, prefix is the path
+// of the struct in the input.
+func validateStruct(value reflect.Value, prefix string) Errors {
+	var errs Errors
+	valueType := value.Type()
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		if field.PkgPath != "" {
+			// unexported fields are not part of inputs.
+			continue
+		}
+		tag := field.Tag.Get("validate")
+		valid := true
+		if tag != "" {
+			for _, rule := range strings.Split(tag, ",") {
+				name, arg := rule, ""
+				if i := strings.Index(rule, "="); i >= 0 {
+					name, arg = rule[:i], rule[i+1:]
+				}
+				fieldError := check(value, field, prefix, name, arg)
+				if fieldError != nil {
+					errs = append(errs, *fieldError)
+					// the other rules of the field are not checked, one
+					// error per field is enough.
+					valid = false
+					break
+				}
+			}
+		}
+		if valid {
+			errs = append(errs, validateNested(value.Field(i), prefix+jsonName(field))...)
+		}
+	}
+	return errs
+}
+
+// validateNested checks the fields of a nested struct, or of the structs in
+// a slice, path is the path of the field in the input.
+func validateNested(value reflect.Value, path string) Errors {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() {
+			return nil
+		}
+		return validateNested(value.Elem(), path)
+
+	case reflect.Struct:
+		if _, ok := value.Interface().(time.Time); ok {
+			return nil
+		}
+		return validateStruct(value, path+".")
+
+	case reflect.Slice, reflect.Array:
+		var errs Errors
+		for i := 0; i < value.Len(); i++ {
+			errs = append(errs, validateNested(value.Index(i), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return errs
+	}
+	return nil
+}
+
+func check(parent reflect.Value, field reflect.StructField, prefix, rule, arg string) *FieldError {
+	value := parent.FieldByIndex(field.Index)
+	name := prefix + jsonName(field)
+	switch rule {
+	case "required":
+		if isEmpty(value) {
+			return required(name)
+		}
+		return nil
+
+	case "required_with":
+		if isEmpty(value) && !isEmpty(otherField(parent, arg)) {
+			return &FieldError{Field: name, Code: CodeRequired, Message: fmt.Sprintf("%s is required with %s", name, prefix+otherName(parent, arg))}
+		}
+		return nil
+
+	case "required_unless":
+		if isEmpty(value) && !otherField(parent, arg).Bool() {
+			return &FieldError{Field: name, Code: CodeRequired, Message: fmt.Sprintf("%s is required unless %s is set", name, prefix+otherName(parent, arg))}
+		}
+		return nil
+	}
+	if isEmpty(value) {
+		return nil
+	}
+
+	switch rule {
+	case "email":
+		address, err := mail.ParseAddress(value.String())
+		if err != nil || address.Address != value.String() {
+			return &FieldError{Field: name, Code: CodeInvalidEmail, Message: fmt.Sprintf("%s must be a valid email address", name)}
+		}
+
+	case "password":
+		return checkPassword(name, value.String())
+
+	case "min", "max":
+		return checkLimit(name, value, rule, arg)
+
+	case "oneof":
+		for _, allowed := range strings.Fields(arg) {
+			if value.String() == allowed {
+				return nil
+			}
+		}
+		return &FieldError{Field: name, Code: CodeNotAllowed, Message: fmt.Sprintf("%s must be one of %s", name, strings.Join(strings.Fields(arg), ", "))}
+
+	case "after":
+		other := otherField(parent, arg)
+		if !other.Interface().(time.Time).IsZero() && !value.Interface().(time.Time).After(other.Interface().(time.Time)) {
+			return &FieldError{Field: name, Code: CodeNotAfter, Message: fmt.Sprintf("%s must be after %s", name, prefix+otherName(parent, arg))}
+		}
+
+	default:
+		panic(fmt.Sprintf("validation: unknown rule %q of field %s", rule, field.Name))
+	}
+	return nil
+}
+
+func checkPassword(name, password string) *FieldError {
+	if len(password) < MinPasswordLength || len(password) > MaxPasswordLength {
+		return &FieldError{
+			Field:   name,
+			Code:    CodeWeakPassword,
+			Message: fmt.Sprintf("%s must be between %d and %d characters", name, MinPasswordLength, MaxPasswordLength),
+		}
+	}
+	var letters, others bool
+	for _, r := range password {
+		if unicode.IsLetter(r) {
+			letters = true
+		} else if !unicode.IsSpace(r) {
+			others = true
+		}
+	}
+	if !letters || !others {
+		return &FieldError{Field: name, Code: CodeWeakPassword, Message: fmt.Sprintf("%s must contain letters and digits or symbols", name)}
+	}
+	return nil
+}
+
+func checkLimit(name string, value reflect.Value, rule, arg string) *FieldError {
+	limit, err := strconv.Atoi(arg)
+	if err != nil {
+		panic(fmt.Sprintf("validation: invalid %s argument %q of field %s", rule, arg, name))
+	}
+	var size int
+	var unit string
+	switch value.Kind() {
+	case reflect.String:
+		size, unit = utf8.RuneCountInString(value.String()), " characters"
+	case reflect.Slice, reflect.Map:
+		size, unit = value.Len(), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		size = int(value.Int())
+	default:
+		panic(fmt.Sprintf("validation: %s cannot be used on field %s", rule, name))
+	}
+	switch {
+	case rule == "min" && size < limit && unit != "":
+		return &FieldError{Field: name, Code: CodeTooShort, Message: fmt.Sprintf("%s must have at least %d%s", name, limit, unit)}
+	case rule == "min" && size < limit:
+		return &FieldError{Field: name, Code: CodeTooSmall, Message: fmt.Sprintf("%s must be at least %d", name, limit)}
+	case rule == "max" && size > limit && unit != "":
+		return &FieldError{Field: name, Code: CodeTooLong, Message: fmt.Sprintf("%s must have at most %d%s", name, limit, unit)}
+	case rule == "max" && size > limit:
+		return &FieldError{Field: name, Code: CodeTooLarge, Message: fmt.Sprintf("%s must be at most %d", name, limit)}
+	}
+	return nil
+}
+
+func required(name string) *FieldError {
+	return &FieldError{Field: name, Code: CodeRequired, Message: fmt.Sprintf("%s is required", name)}
+}
+
+// isEmpty reports whether a field is empty, blank strings are empty.
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	}
+	if t, ok := value.Interface().(time.Time); ok {
+		return t.IsZero()
+	}
+	return value.IsZero()
+}
+
+func otherField(parent reflect.Value, name string) reflect.Value {
+	value := parent.FieldByName(name)
+	if !value.IsValid() {
+		panic(fmt.Sprintf("validation: unknown field %s", name))
+	}
+	return value
+}
+
+func otherName(parent reflect.Value, name string) string {
+	field, _ := parent.Type().FieldByName(name)
+	return jsonName(field)
+}
+
+// jsonName returns the json name of a struct field.
+func jsonName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
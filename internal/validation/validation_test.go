@@ -0,0 +1,201 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type testInput struct {
+	Name      string    `json:"name" validate:"required,max=5"`
+	Nickname  string    `json:"nickname,omitempty" validate:"min=2"`
+	Email     string    `json:"email" validate:"email"`
+	Password  string    `json:"password" validate:"password"`
+	Color     string    `json:"color" validate:"oneof=red green"`
+	Count     int       `json:"count" validate:"min=1,max=10"`
+	Tags      []string  `json:"tags" validate:"max=2"`
+	Unit      string    `json:"unit" validate:"required_with=Count"`
+	AllDay    bool      `json:"allDay"`
+	StartTime time.Time `json:"startTime" validate:"required_unless=AllDay"`
+	EndTime   time.Time `json:"endTime" validate:"after=StartTime"`
+	NoJSON    string    `validate:"max=1"`
+}
+
+// validInput returns an input passing every rule, tests change one field.
+func validInput() testInput {
+	start := time.Date(2022, 3, 7, 9, 0, 0, 0, time.UTC)
+	return testInput{Name: "jane", StartTime: start, EndTime: start.Add(time.Hour)}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		change    func(input *testInput)
+		wantField string
+		wantCode  string
+	}{
+		{name: "valid", change: func(input *testInput) {}},
+		{name: "required missing", change: func(input *testInput) { input.Name = "" }, wantField: "name", wantCode: CodeRequired},
+		{name: "required blank", change: func(input *testInput) { input.Name = " \t\n" }, wantField: "name", wantCode: CodeRequired},
+		{name: "max counts characters", change: func(input *testInput) { input.Name = "ééééé" }},
+		{name: "max exceeded", change: func(input *testInput) { input.Name = "janedoe" }, wantField: "name", wantCode: CodeTooLong},
+		{name: "min of an empty string", change: func(input *testInput) { input.Nickname = "" }},
+		{name: "min counts characters", change: func(input *testInput) { input.Nickname = "é" }, wantField: "nickname", wantCode: CodeTooShort},
+		{name: "min", change: func(input *testInput) { input.Nickname = "jo" }},
+		{name: "email", change: func(input *testInput) { input.Email = "jane@example.com" }},
+		{name: "email without domain", change: func(input *testInput) { input.Email = "jane" }, wantField: "email", wantCode: CodeInvalidEmail},
+		{name: "email with a name", change: func(input *testInput) { input.Email = "Jane <jane@example.com>" }, wantField: "email", wantCode: CodeInvalidEmail},
+		{name: "password", change: func(input *testInput) { input.Password = "correct horse 1" }},
+		{name: "password too short", change: func(input *testInput) { input.Password = "abc123" }, wantField: "password", wantCode: CodeWeakPassword},
+		{name: "password too long", change: func(input *testInput) { input.Password = strings.Repeat("a1", 37) }, wantField: "password", wantCode: CodeWeakPassword},
+		{name: "password of letters", change: func(input *testInput) { input.Password = "abcdefgh" }, wantField: "password", wantCode: CodeWeakPassword},
+		{name: "password of digits and spaces", change: func(input *testInput) { input.Password = "1234 5678" }, wantField: "password", wantCode: CodeWeakPassword},
+		{name: "oneof", change: func(input *testInput) { input.Color = "green" }},
+		{name: "oneof not allowed", change: func(input *testInput) { input.Color = "blue" }, wantField: "color", wantCode: CodeNotAllowed},
+		{name: "number too small", change: func(input *testInput) { input.Count, input.Unit = -1, "h" }, wantField: "count", wantCode: CodeTooSmall},
+		{name: "number too large", change: func(input *testInput) { input.Count, input.Unit = 11, "h" }, wantField: "count", wantCode: CodeTooLarge},
+		{name: "too many items", change: func(input *testInput) { input.Tags = []string{"a", "b", "c"} }, wantField: "tags", wantCode: CodeTooLong},
+		{name: "required_with other set", change: func(input *testInput) { input.Count = 2 }, wantField: "unit", wantCode: CodeRequired},
+		{name: "required_with other set and blank", change: func(input *testInput) { input.Count, input.Unit = 2, " " }, wantField: "unit", wantCode: CodeRequired},
+		{name: "required_with both set", change: func(input *testInput) { input.Count, input.Unit = 2, "h" }},
+		{name: "required_unless other false", change: func(input *testInput) { input.StartTime = time.Time{} }, wantField: "startTime", wantCode: CodeRequired},
+		{name: "required_unless other true", change: func(input *testInput) { input.StartTime, input.AllDay = time.Time{}, true }},
+		{name: "after equal", change: func(input *testInput) { input.EndTime = input.StartTime }, wantField: "endTime", wantCode: CodeNotAfter},
+		{name: "after before", change: func(input *testInput) { input.EndTime = input.StartTime.Add(-time.Hour) }, wantField: "endTime", wantCode: CodeNotAfter},
+		{name: "after a zero field", change: func(input *testInput) { input.StartTime, input.AllDay = time.Time{}, true }},
+		{name: "field without json name", change: func(input *testInput) { input.NoJSON = "ab" }, wantField: "NoJSON", wantCode: CodeTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := validInput()
+			tt.change(&input)
+			err := Validate(&input)
+			if tt.wantField == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			errs, ok := err.(Errors)
+			if !ok || len(errs) != 1 || errs[0].Field != tt.wantField || errs[0].Code != tt.wantCode {
+				t.Errorf("Validate() = %#v, want a %s error of %s", err, tt.wantCode, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestValidateOneErrorPerField(t *testing.T) {
+	input := testInput{Name: "", EndTime: time.Now(), Count: 20}
+	err := Validate(input)
+	want := []string{"name:required", "count:too_large", "unit:required", "startTime:required"}
+	var got []string
+	for _, fieldError := range err.(Errors) {
+		got = append(got, fieldError.Field+":"+fieldError.Code)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Validate() = %v, want %v", got, want)
+	}
+	if msg := err.Error(); !strings.HasPrefix(msg, "name is required, count must be at most 10, unit is required with count") {
+		t.Errorf("Error() = %q, want the joined messages", msg)
+	}
+}
+
+type nestedItem struct {
+	ID   string `json:"id" validate:"required"`
+	Note string `json:"note" validate:"max=3"`
+}
+
+type nestedInput struct {
+	Items  []nestedItem  `json:"items" validate:"max=3"`
+	Refs   []*nestedItem `json:"refs"`
+	Parent *nestedItem   `json:"parent"`
+	Main   nestedItem    `json:"main"`
+}
+
+func TestValidateNested(t *testing.T) {
+	valid := nestedItem{ID: "1"}
+	tests := []struct {
+		name       string
+		input      nestedInput
+		wantFields []string
+	}{
+		{name: "valid", input: nestedInput{Items: []nestedItem{valid}, Refs: []*nestedItem{&valid, nil}, Parent: &valid, Main: valid}},
+		{
+			name:       "invalid slice elements",
+			input:      nestedInput{Items: []nestedItem{valid, {}, {ID: "3", Note: "long"}}, Main: valid},
+			wantFields: []string{"items[1].id", "items[2].note"},
+		},
+		{name: "invalid pointer elements", input: nestedInput{Refs: []*nestedItem{nil, {}}, Main: valid}, wantFields: []string{"refs[1].id"}},
+		{name: "invalid struct", input: nestedInput{}, wantFields: []string{"main.id"}},
+		{name: "invalid struct pointer", input: nestedInput{Parent: &nestedItem{}, Main: valid}, wantFields: []string{"parent.id"}},
+		{
+			// the elements of an invalid slice are not checked.
+			name:       "too many elements",
+			input:      nestedInput{Items: []nestedItem{{}, {}, {}, {}}, Main: valid},
+			wantFields: []string{"items"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.input)
+			var got []string
+			if err != nil {
+				for _, fieldError := range err.(Errors) {
+					got = append(got, fieldError.Field)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.wantFields, ",") {
+				t.Errorf("Validate() fields = %v, want %v", got, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestValidateNestedMessages(t *testing.T) {
+	type item struct {
+		Count int    `json:"count"`
+		Unit  string `json:"unit" validate:"required_with=Count"`
+	}
+	type input struct {
+		Items []item `json:"items"`
+	}
+	err := Validate(input{Items: []item{{Count: 1}}})
+	if err == nil || err.Error() != "items[0].unit is required with items[0].count" {
+		t.Errorf("Validate() = %v, want the other field with its path", err)
+	}
+}
+
+func TestValidatePanics(t *testing.T) {
+	type unknownRule struct {
+		Name string `json:"name" validate:"maxx=3"`
+	}
+	type invalidLimit struct {
+		Name string `json:"name" validate:"max=three"`
+	}
+	type limitOnBool struct {
+		Done bool `json:"done" validate:"max=1"`
+	}
+	type unknownField struct {
+		Name string `json:"name" validate:"required_with=Other"`
+	}
+	tests := []struct {
+		name  string
+		input interface{}
+	}{
+		{name: "unknown rule", input: unknownRule{Name: "jane"}},
+		{name: "invalid limit", input: invalidLimit{Name: "jane"}},
+		{name: "limit on a bool", input: limitOnBool{Done: true}},
+		{name: "unknown other field", input: unknownField{}},
+		{name: "not a struct", input: "jane"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Validate() did not panic")
+				}
+			}()
+			Validate(tt.input)
+		})
+	}
+}
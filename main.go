@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"net/http"
 	"os"
 	"strings"
@@ -12,9 +11,19 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
 	handlers "github.com/wisdommatt/todo-list-api/handlers"
-	"github.com/wisdommatt/todo-list-api/internal/jwt"
+	"github.com/wisdommatt/todo-list-api/internal/blobstore"
+	"github.com/wisdommatt/todo-list-api/internal/mailer"
+	"github.com/wisdommatt/todo-list-api/internal/oidc"
+	"github.com/wisdommatt/todo-list-api/services/attachments"
+	"github.com/wisdommatt/todo-list-api/services/comments"
+	"github.com/wisdommatt/todo-list-api/services/notifications"
+	"github.com/wisdommatt/todo-list-api/services/overlays"
+	"github.com/wisdommatt/todo-list-api/services/projects"
+	"github.com/wisdommatt/todo-list-api/services/shares"
 	"github.com/wisdommatt/todo-list-api/services/tasks"
+	"github.com/wisdommatt/todo-list-api/services/timeentries"
 	"github.com/wisdommatt/todo-list-api/services/users"
+	"github.com/wisdommatt/todo-list-api/services/workspaces"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -34,36 +43,200 @@ func main() {
 	godotenv.Load(".env", ".env-defaults")
 
 	mongoDB := mustConnectMongoDB(log)
-	usersService := users.NewUsersService(mongoDB, log)
-	tasksService := tasks.NewService(usersService, mongoDB, log)
+	mailSender, err := mailer.NewFromEnv(log)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to setup mailer")
+	}
+	usersService := users.NewUsersService(mailSender, mongoDB, log)
+	err = usersService.EnsureIndexes(context.Background())
+	if err != nil {
+		log.WithError(err).Fatal("Unable to create users indexes")
+	}
+	oidcProviders, err := oidc.ProvidersFromEnv()
+	if err != nil {
+		log.WithError(err).Fatal("Unable to setup identity providers")
+	}
+	for name, provider := range oidcProviders {
+		usersService.AddOIDCProvider(name, provider)
+	}
+	notificationsService := notifications.NewService(mongoDB, log)
+	sharesService := shares.NewService(notificationsService, mongoDB, log)
+	projectsService := projects.NewService(sharesService, mongoDB, log)
+	tasksService := tasks.NewService(usersService, projectsService, sharesService, notificationsService, mongoDB, log)
+	workspacesService := workspaces.NewService(usersService, notificationsService, mongoDB, log)
+	timeEntriesService := timeentries.NewService(mongoDB, log)
+	err = timeEntriesService.EnsureIndexes(context.Background())
+	if err != nil {
+		log.WithError(err).Fatal("Unable to create time entries indexes")
+	}
+	commentsService := comments.NewService(usersService, tasksService, notificationsService, mongoDB, log)
+	blobStore, err := blobstore.NewFromEnv()
+	if err != nil {
+		log.WithError(err).Fatal("Unable to setup blob store")
+	}
+	attachmentsService := attachments.NewService(blobStore, mongoDB, log)
+	overlaysService := overlays.NewService(overlays.NewHTTPClient(), mongoDB, log)
+	tasksService.AddBusyProvider(overlaysService)
+	go overlaysService.RunRefresher(context.Background(), overlays.RefreshInterval)
+	tasksService.OnTaskDeleted(commentsService.DeleteTaskComments)
+	tasksService.OnTaskDeleted(attachmentsService.DeleteTaskAttachments)
+	tasksService.OnTaskDeleted(func(ctx context.Context, taskID string) error {
+		return sharesService.DeleteResourceShares(ctx, shares.ResourceTask, taskID)
+	})
+
+	isLoggedInMiddleware := handlers.IsLoggedInMiddleware(usersService)
+	isVerifiedMiddleware := handlers.IsVerifiedMiddleware(usersService)
+	isAdminMiddleware := handlers.IsAdminMiddleware(usersService)
+	isLoggedInForMFAMiddleware := handlers.IsLoggedInForMFAMiddleware(usersService)
+	tasksScopesMiddleware := handlers.AccessTokenScopesMiddleware(users.ScopeTasksRead, users.ScopeTasksWrite)
 
+	// the WebDAV methods must be known before the CalDAV routes are added.
+	for _, method := range handlers.CalDAVMethods {
+		chi.RegisterMethod(method)
+	}
 	router := chi.NewRouter()
 	router.Route("/users/", func(r chi.Router) {
-		r.Post("/", handlers.HandleCreateUserEndpoint(usersService))
-		r.Post("/login", handlers.HandleUserLoginEndpoint(usersService))
+		r.Post("/", handlers.HandleCreateUserEndpoint(usersService, workspacesService))
+		r.Post("/login", handlers.HandleUserLoginEndpoint(usersService, workspacesService))
+		r.Post("/login/mfa", handlers.HandleCompleteMFALoginEndpoint(usersService, workspacesService))
+		r.Get("/login/oidc/{provider}", handlers.HandleStartOIDCLoginEndpoint(usersService))
+		r.Get("/login/oidc/{provider}/callback", handlers.HandleOIDCCallbackEndpoint(usersService, workspacesService))
+		r.Post("/verify", handlers.HandleVerifyEmailEndpoint(usersService))
+		r.Post("/password/forgot", handlers.HandleForgotPasswordEndpoint(usersService))
+		r.Post("/password/reset", handlers.HandleResetPasswordEndpoint(usersService))
 
 		r.Group(func(r chi.Router) {
 			r.Use(isLoggedInMiddleware)
 			r.Get("/{userId}", handlers.HandleGetUserEndpoint(usersService))
 			r.Get("/", handlers.HandleGetUsersEndpoint(usersService))
+			r.Patch("/{userId}", handlers.HandleUpdateUserEndpoint(usersService))
 			r.Delete("/{userId}", handlers.HandleDeleteUserEndpoint(usersService))
+			r.Post("/{userId}/password", handlers.HandleChangePasswordEndpoint(usersService))
+			r.With(isAdminMiddleware).Post("/{userId}/unlock", handlers.HandleUnlockUserEndpoint(usersService))
+			r.Post("/{userId}/verification-email", handlers.HandleResendVerificationEmailEndpoint(usersService))
+			r.Get("/{userId}/timesheet", handlers.HandleGetTimesheetEndpoint(timeEntriesService, usersService))
+			r.Get("/{userId}/preferences", handlers.HandleGetPreferencesEndpoint(usersService))
+			r.Put("/{userId}/preferences", handlers.HandleUpdatePreferencesEndpoint(usersService))
+			r.Get("/{userId}/agenda", handlers.HandleGetAgendaEndpoint(tasksService, usersService))
+			r.Get("/{userId}/calendar.ics", handlers.HandleGetCalendarEndpoint(tasksService, usersService))
+			r.With(isVerifiedMiddleware).Post("/{userId}/calendar-feed", handlers.HandleCreateCalendarFeedEndpoint(usersService))
+			r.Delete("/{userId}/calendar-feed", handlers.HandleRevokeCalendarFeedEndpoint(usersService))
+			r.Get("/{userId}/availability", handlers.HandleGetAvailabilityEndpoint(tasksService, usersService))
+			r.Post("/{userId}/schedule:plan", handlers.HandlePlanScheduleEndpoint(tasksService, usersService))
+			r.Post("/{userId}/schedule:commit", handlers.HandleCommitSchedulePlanEndpoint(tasksService))
+			r.Get("/{userId}/app-passwords", handlers.HandleGetAppPasswordsEndpoint(usersService))
+			r.With(isVerifiedMiddleware).Post("/{userId}/app-passwords", handlers.HandleCreateAppPasswordEndpoint(usersService))
+			r.Delete("/{userId}/app-passwords/{appPasswordId}", handlers.HandleDeleteAppPasswordEndpoint(usersService))
+			r.Get("/{userId}/tokens", handlers.HandleGetAccessTokensEndpoint(usersService))
+			r.With(isVerifiedMiddleware).Post("/{userId}/tokens", handlers.HandleCreateAccessTokenEndpoint(usersService))
+			r.Delete("/{userId}/tokens/{tokenId}", handlers.HandleDeleteAccessTokenEndpoint(usersService))
+			r.Get("/{userId}/sessions", handlers.HandleGetSessionsEndpoint(usersService))
+			r.Delete("/{userId}/sessions/{sessionId}", handlers.HandleDeleteSessionEndpoint(usersService))
+			r.Get("/{userId}/overlays", handlers.HandleGetOverlaysEndpoint(overlaysService, usersService))
+			r.With(isVerifiedMiddleware).Post("/{userId}/overlays", handlers.HandleCreateOverlayEndpoint(overlaysService, usersService))
+			r.Post("/{userId}/overlays/{overlayId}/refresh", handlers.HandleRefreshOverlayEndpoint(overlaysService, usersService))
+			r.Delete("/{userId}/overlays/{overlayId}", handlers.HandleDeleteOverlayEndpoint(overlaysService, usersService))
+			r.Post("/{userId}/import/ics", handlers.HandleImportCalendarEndpoint(tasksService, usersService, projectsService))
+			r.Get("/{userId}/notifications", handlers.HandleGetNotificationsEndpoint(notificationsService))
+			r.Put("/{userId}/notifications/{notificationId}/read", handlers.HandleReadNotificationEndpoint(notificationsService))
+			r.Get("/{userId}/projects", handlers.HandleGetProjectsEndpoint(projectsService))
+			r.Get("/{userId}/shares", handlers.HandleGetUserSharesEndpoint(sharesService))
+			r.Post("/{userId}/shares/{shareId}/accept", handlers.HandleRespondToShareEndpoint(sharesService, true))
+			r.Post("/{userId}/shares/{shareId}/decline", handlers.HandleRespondToShareEndpoint(sharesService, false))
+			r.Get("/{userId}/workspaces", handlers.HandleGetWorkspacesEndpoint(workspacesService))
+			r.With(isVerifiedMiddleware).Get("/{userId}/workspace-invitations", handlers.HandleGetWorkspaceInvitationsEndpoint(workspacesService, usersService))
+		})
+
+		// the tasks endpoints accept personal access tokens.
+		r.Group(func(r chi.Router) {
+			r.Use(tasksScopesMiddleware, isLoggedInMiddleware)
 			r.Get("/{userId}/tasks", handlers.HandleGetTasksEndpoint(tasksService))
+			r.Get("/{userId}/tasks/assigned", handlers.HandleGetAssignedTasksEndpoint(tasksService))
+		})
+
+		// users required to use two-factor authentication can enable it before anything else.
+		r.Group(func(r chi.Router) {
+			r.Use(isLoggedInForMFAMiddleware)
+			r.Post("/{userId}/mfa", handlers.HandleEnrollMFAEndpoint(usersService))
+			r.Post("/{userId}/mfa/verify", handlers.HandleVerifyMFAEndpoint(usersService))
+			r.Delete("/{userId}/mfa", handlers.HandleDisableMFAEndpoint(usersService))
+			r.Post("/{userId}/mfa/recovery-codes", handlers.HandleRegenerateRecoveryCodesEndpoint(usersService))
 		})
 	})
 
+	router.Route("/admin/", func(r chi.Router) {
+		r.Use(isLoggedInMiddleware, isAdminMiddleware)
+		r.Get("/mfa-policy", handlers.HandleGetMFAPolicyEndpoint(usersService))
+		r.Put("/mfa-policy", handlers.HandleUpdateMFAPolicyEndpoint(usersService))
+	})
+
+	router.Handle("/.well-known/caldav", http.RedirectHandler(handlers.CalDAVRoot, http.StatusMovedPermanently))
+	router.Mount(strings.TrimSuffix(handlers.CalDAVRoot, "/"), handlers.CalDAVAuthMiddleware(usersService)(handlers.HandleCalDAV(tasksService, usersService, projectsService)))
+	router.Get("/calendar/feeds/{token}.ics", handlers.HandleCalendarFeedEndpoint(tasksService, usersService))
+
 	router.Route("/tasks/", func(r chi.Router) {
-		r.Use(isLoggedInMiddleware)
-		r.Post("/", handlers.HandleCreateTaskEndpoint(tasksService, usersService))
+		r.Use(tasksScopesMiddleware, isLoggedInMiddleware)
+		r.Post("/", handlers.HandleCreateTaskEndpoint(tasksService, usersService, projectsService))
+		r.Post("/bulk", handlers.HandleBulkTasksEndpoint(tasksService))
 		r.Get("/{taskId}", handlers.HandleGetTaskEndpoint(tasksService))
 		r.Put("/{taskId}", handlers.HandleUpdateTaskEndpoint(tasksService))
 		r.Delete("/{taskId}", handlers.HandleDeleteTaskEndpoint(tasksService))
+
+		r.With(isVerifiedMiddleware).Post("/{taskId}/assignees", handlers.HandleAssignTaskEndpoint(tasksService, usersService))
+		r.Delete("/{taskId}/assignees/{userId}", handlers.HandleUnassignTaskEndpoint(tasksService))
+
+		r.Post("/{taskId}/timer/start", handlers.HandleStartTimerEndpoint(tasksService, timeEntriesService))
+		r.Post("/{taskId}/timer/stop", handlers.HandleStopTimerEndpoint(tasksService, timeEntriesService))
+		r.Get("/{taskId}/time-entries", handlers.HandleGetTimeEntriesEndpoint(tasksService, timeEntriesService))
+		r.Post("/{taskId}/time-entries", handlers.HandleCreateTimeEntryEndpoint(tasksService, timeEntriesService))
+		r.Put("/{taskId}/time-entries/{entryId}", handlers.HandleUpdateTimeEntryEndpoint(tasksService, timeEntriesService))
+		r.Delete("/{taskId}/time-entries/{entryId}", handlers.HandleDeleteTimeEntryEndpoint(tasksService, timeEntriesService))
+
+		r.Get("/{taskId}/comments", handlers.HandleGetCommentsEndpoint(tasksService, commentsService))
+		r.Post("/{taskId}/comments", handlers.HandleCreateCommentEndpoint(tasksService, commentsService))
+		r.Put("/{taskId}/comments/{commentId}", handlers.HandleUpdateCommentEndpoint(tasksService, commentsService))
+		r.Delete("/{taskId}/comments/{commentId}", handlers.HandleDeleteCommentEndpoint(tasksService, commentsService))
+
+		r.Get("/{taskId}/attachments", handlers.HandleGetAttachmentsEndpoint(tasksService, attachmentsService))
+		r.With(isVerifiedMiddleware).Post("/{taskId}/attachments", handlers.HandleUploadAttachmentEndpoint(tasksService, attachmentsService))
+		r.Get("/{taskId}/attachments/{attachmentId}", handlers.HandleDownloadAttachmentEndpoint(tasksService, attachmentsService))
+		r.Delete("/{taskId}/attachments/{attachmentId}", handlers.HandleDeleteAttachmentEndpoint(tasksService, attachmentsService))
+
+		r.Get("/{taskId}/shares", handlers.HandleGetSharesEndpoint(shares.ResourceTask, tasksService, projectsService, sharesService))
+		r.With(isVerifiedMiddleware).Post("/{taskId}/shares", handlers.HandleCreateShareEndpoint(shares.ResourceTask, tasksService, projectsService, sharesService, usersService))
+		r.Delete("/{taskId}/shares/{shareId}", handlers.HandleDeleteShareEndpoint(shares.ResourceTask, tasksService, projectsService, sharesService))
 	})
 
+	router.Route("/projects/", func(r chi.Router) {
+		r.Use(isLoggedInMiddleware)
+		r.Post("/", handlers.HandleCreateProjectEndpoint(projectsService))
+		r.Get("/{projectId}", handlers.HandleGetProjectEndpoint(projectsService))
+		r.Delete("/{projectId}", handlers.HandleDeleteProjectEndpoint(projectsService, tasksService))
+
+		r.Get("/{projectId}/shares", handlers.HandleGetSharesEndpoint(shares.ResourceProject, tasksService, projectsService, sharesService))
+		r.With(isVerifiedMiddleware).Post("/{projectId}/shares", handlers.HandleCreateShareEndpoint(shares.ResourceProject, tasksService, projectsService, sharesService, usersService))
+		r.Delete("/{projectId}/shares/{shareId}", handlers.HandleDeleteShareEndpoint(shares.ResourceProject, tasksService, projectsService, sharesService))
+	})
+
+	router.Route("/workspaces/", func(r chi.Router) {
+		r.Use(isLoggedInMiddleware)
+		r.With(isVerifiedMiddleware).Post("/", handlers.HandleCreateWorkspaceEndpoint(workspacesService, usersService))
+		r.Get("/{workspaceId}/members", handlers.HandleGetWorkspaceMembersEndpoint(workspacesService))
+		r.With(isVerifiedMiddleware).Post("/{workspaceId}/members", handlers.HandleInviteWorkspaceMemberEndpoint(workspacesService))
+		r.Delete("/{workspaceId}/members/{memberId}", handlers.HandleRemoveWorkspaceMemberEndpoint(workspacesService))
+		r.With(isVerifiedMiddleware).Post("/{workspaceId}/invitations/accept", handlers.HandleAcceptWorkspaceInvitationEndpoint(workspacesService, usersService))
+	})
+
+	// the read and write timeouts leave time for attachment uploads and
+	// downloads, calendar feeds and imports on slow connections, clients
+	// still have to send the headers quickly.
 	server := &http.Server{
-		Addr:         ":" + port,
-		Handler:      router,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 5 * time.Second,
+		Addr:              ":" + port,
+		Handler:           router,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       2 * time.Minute,
+		WriteTimeout:      2 * time.Minute,
+		IdleTimeout:       2 * time.Minute,
 	}
 	log.Infof("app running on port: %s", port)
 	log.Fatal(server.ListenAndServe())
@@ -78,19 +251,3 @@ func mustConnectMongoDB(log *logrus.Logger) *mongo.Database {
 	}
 	return client.Database(os.Getenv("MONGODB_DATABASE_NAME"))
 }
-
-func isLoggedInMiddleware(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-		authToken := r.Header.Get("Authorization")
-		authToken = strings.ReplaceAll(authToken, "Bearer ", "")
-		_, err := jwt.Decode([]byte(os.Getenv("JWT_SECRET")), authToken)
-		if err != nil {
-			json.NewEncoder(rw).Encode(map[string]string{
-				"status":  "unauthorized",
-				"message": "you are not authorized to proceed",
-			})
-			return
-		}
-		h.ServeHTTP(rw, r)
-	})
-}
@@ -0,0 +1,149 @@
+package attachments
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wisdommatt/todo-list-api/internal/apperror"
+	"github.com/wisdommatt/todo-list-api/internal/blobstore"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MaxSize is the maximum size of an uploaded attachment in bytes.
+const MaxSize = 10 << 20
+
+// AllowedContentTypes are the content types accepted for attachments,
+// the type is detected from the file content rather than trusted from the client.
+var AllowedContentTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+	"text/plain":      true,
+}
+
+// ErrAttachmentNotFound is returned when the attachment does not exist.
+var ErrAttachmentNotFound = apperror.New(apperror.NotFound, "attachment_not_found", "attachment does not exist")
+
+type Attachment struct {
+	ID          string    `json:"id" bson:"_id,omitempty"`
+	TaskID      string    `json:"taskId" bson:"taskId,omitempty"`
+	UserID      string    `json:"userId" bson:"userId,omitempty"`
+	FileName    string    `json:"fileName" bson:"fileName,omitempty"`
+	ContentType string    `json:"contentType" bson:"contentType,omitempty"`
+	Size        int64     `json:"size" bson:"size,omitempty"`
+	StorageKey  string    `json:"-" bson:"storageKey,omitempty"`
+	TimeAdded   time.Time `json:"timeAdded" bson:"timeAdded,omitempty"`
+}
+
+type Service struct {
+	blobStore    blobstore.BlobStore
+	dbCollection *mongo.Collection
+	log          *logrus.Logger
+}
+
+func NewService(blobStore blobstore.BlobStore, db *mongo.Database, log *logrus.Logger) *Service {
+	return &Service{
+		blobStore:    blobStore,
+		dbCollection: db.Collection("attachments"),
+		log:          log,
+	}
+}
+
+// CreateAttachment stores the attachment content in the blob store and saves its metadata.
+func (s *Service) CreateAttachment(ctx context.Context, attachment Attachment, content io.Reader) (*Attachment, error) {
+	log := s.log.WithContext(ctx).WithField("attachment", attachment)
+	attachment.ID = primitive.NewObjectID().Hex()
+	attachment.StorageKey = fmt.Sprintf("tasks/%s/%s", attachment.TaskID, attachment.ID)
+	attachment.TimeAdded = time.Now()
+	err := s.blobStore.Put(ctx, attachment.StorageKey, content, attachment.Size, attachment.ContentType)
+	if err != nil {
+		log.WithError(err).Error("failed to save attachment to blob store")
+		return nil, err
+	}
+	_, err = s.dbCollection.InsertOne(ctx, attachment)
+	if err != nil {
+		log.WithError(err).Error("failed to save attachment to db")
+		s.blobStore.Delete(ctx, attachment.StorageKey)
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+func (s *Service) GetAttachment(ctx context.Context, attachmentID string) (*Attachment, error) {
+	var attachment Attachment
+	log := s.log.WithContext(ctx).WithField("attachmentId", attachmentID)
+	err := s.dbCollection.FindOne(ctx, bson.M{"_id": attachmentID}).Decode(&attachment)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve attachment from db by id")
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+func (s *Service) GetAttachments(ctx context.Context, taskID string) ([]Attachment, error) {
+	log := s.log.WithContext(ctx).WithField("taskId", taskID)
+	cursor, err := s.dbCollection.Find(ctx, bson.M{"taskId": taskID})
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve attachments from db")
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var attachments []Attachment
+	err = cursor.All(ctx, &attachments)
+	if err != nil {
+		log.WithError(err).Error("failed to decode retrieved attachments")
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// OpenAttachment opens the attachment content, the caller must close it.
+func (s *Service) OpenAttachment(ctx context.Context, attachment *Attachment) (io.ReadCloser, error) {
+	content, err := s.blobStore.Get(ctx, attachment.StorageKey)
+	if err != nil {
+		s.log.WithContext(ctx).WithField("attachmentId", attachment.ID).WithError(err).
+			Error("failed to retrieve attachment from blob store")
+		return nil, err
+	}
+	return content, nil
+}
+
+func (s *Service) DeleteAttachment(ctx context.Context, attachmentID string) (*Attachment, error) {
+	var attachment Attachment
+	log := s.log.WithContext(ctx).WithField("attachmentId", attachmentID)
+	err := s.dbCollection.FindOneAndDelete(ctx, bson.M{"_id": attachmentID}).Decode(&attachment)
+	if err != nil {
+		log.WithError(err).Error("failed to delete attachment from db")
+		return nil, err
+	}
+	err = s.blobStore.Delete(ctx, attachment.StorageKey)
+	if err != nil {
+		log.WithError(err).Error("failed to delete attachment from blob store")
+	}
+	return &attachment, nil
+}
+
+// DeleteTaskAttachments removes every attachment of a task and their blobs.
+func (s *Service) DeleteTaskAttachments(ctx context.Context, taskID string) error {
+	attachments, err := s.GetAttachments(ctx, taskID)
+	if err != nil {
+		return err
+	}
+	for _, attachment := range attachments {
+		_, err = s.DeleteAttachment(ctx, attachment.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package comments
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wisdommatt/todo-list-api/internal/apperror"
+	"github.com/wisdommatt/todo-list-api/services/notifications"
+	"github.com/wisdommatt/todo-list-api/services/tasks"
+	"github.com/wisdommatt/todo-list-api/services/users"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mentionRegex matches @email and @handle mentions in comment bodies.
+// ErrCommentNotFound is returned when the comment does not exist.
+var ErrCommentNotFound = apperror.New(apperror.NotFound, "comment_not_found", "comment does not exist")
+
+var mentionRegex = regexp.MustCompile(`(?:^|[^\w@])@([A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}|[A-Za-z0-9_][A-Za-z0-9_.-]*)`)
+
+// Comment is a markdown comment on a task, replies reference their parent comment.
+type Comment struct {
+	ID          string        `json:"id" bson:"_id,omitempty"`
+	TaskID      string        `json:"taskId" bson:"taskId,omitempty"`
+	ParentID    string        `json:"parentId,omitempty" bson:"parentId,omitempty"`
+	UserID      string        `json:"userId" bson:"userId,omitempty"`
+	Body        string        `json:"body" bson:"body"`
+	Mentions    []string      `json:"mentions" bson:"mentions,omitempty"`
+	Edits       []CommentEdit `json:"edits,omitempty" bson:"edits,omitempty"`
+	Deleted     bool          `json:"deleted" bson:"deleted"`
+	TimeAdded   time.Time     `json:"timeAdded" bson:"timeAdded,omitempty"`
+	LastUpdated time.Time     `json:"lastUpdated" bson:"lastUpdated,omitempty"`
+}
+
+// CommentEdit is a previous version of an edited comment body.
+type CommentEdit struct {
+	Body     string    `json:"body" bson:"body"`
+	EditedAt time.Time `json:"editedAt" bson:"editedAt"`
+}
+
+type Service struct {
+	usersService         *users.Service
+	tasksService         *tasks.Service
+	notificationsService *notifications.Service
+	dbCollection         *mongo.Collection
+	log                  *logrus.Logger
+}
+
+func NewService(usersService *users.Service, tasksService *tasks.Service, notificationsService *notifications.Service, db *mongo.Database, log *logrus.Logger) *Service {
+	return &Service{
+		usersService:         usersService,
+		tasksService:         tasksService,
+		notificationsService: notificationsService,
+		dbCollection:         db.Collection("comments"),
+		log:                  log,
+	}
+}
+
+func (s *Service) CreateComment(ctx context.Context, comment Comment) (*Comment, error) {
+	log := s.log.WithContext(ctx).WithField("comment", comment)
+	comment.ID = primitive.NewObjectID().Hex()
+	comment.Mentions = s.resolveMentions(ctx, comment.Body)
+	comment.Edits = nil
+	comment.Deleted = false
+	comment.TimeAdded = time.Now()
+	comment.LastUpdated = time.Now()
+	_, err := s.dbCollection.InsertOne(ctx, comment)
+	if err != nil {
+		log.WithError(err).Error("failed to save comment to db")
+		return nil, err
+	}
+	s.notifyMentions(ctx, &comment, comment.Mentions)
+	return &comment, nil
+}
+
+func (s *Service) GetComment(ctx context.Context, commentID string) (*Comment, error) {
+	var comment Comment
+	log := s.log.WithContext(ctx).WithField("commentId", commentID)
+	err := s.dbCollection.FindOne(ctx, bson.M{"_id": commentID}).Decode(&comment)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrCommentNotFound
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve comment from db by id")
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// GetComments returns the task comments in creation order, replies are
+// grouped by clients using the comment parentId.
+func (s *Service) GetComments(ctx context.Context, taskID, lastID string, limit int) ([]Comment, error) {
+	log := s.log.WithContext(ctx).WithField("taskId", taskID).WithField("lastId", lastID).
+		WithField("limit", limit)
+	filter := bson.M{"_id": bson.M{"$gt": lastID}, "taskId": taskID}
+	findOpt := options.Find().SetLimit(int64(limit)).SetSort(bson.M{"_id": 1})
+	cursor, err := s.dbCollection.Find(ctx, filter, findOpt)
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve comments from db")
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var comments []Comment
+	err = cursor.All(ctx, &comments)
+	if err != nil {
+		log.WithError(err).Error("failed to decode retrieved comments")
+		return nil, err
+	}
+	return comments, nil
+}
+
+// UpdateComment replaces the comment body, keeping the previous body in the
+// comment edit history. Users mentioned for the first time are notified.
+func (s *Service) UpdateComment(ctx context.Context, commentID, body string) (*Comment, error) {
+	log := s.log.WithContext(ctx).WithField("commentId", commentID)
+	comment, err := s.GetComment(ctx, commentID)
+	if err != nil {
+		return nil, err
+	}
+	mentions := s.resolveMentions(ctx, body)
+	update := bson.M{
+		"$set": bson.M{
+			"body":        body,
+			"mentions":    mentions,
+			"lastUpdated": time.Now(),
+		},
+		"$push": bson.M{
+			"edits": CommentEdit{Body: comment.Body, EditedAt: time.Now()},
+		},
+	}
+	_, err = s.dbCollection.UpdateOne(ctx, bson.M{"_id": commentID}, update)
+	if err != nil {
+		log.WithError(err).Error("failed to update comment in db")
+		return nil, err
+	}
+	var newMentions []string
+	for _, userID := range mentions {
+		if !containsString(comment.Mentions, userID) {
+			newMentions = append(newMentions, userID)
+		}
+	}
+	s.notifyMentions(ctx, comment, newMentions)
+	return s.GetComment(ctx, commentID)
+}
+
+// DeleteComment removes the comment body while keeping the comment in
+// place so that replies remain attached to the thread.
+func (s *Service) DeleteComment(ctx context.Context, commentID string) (*Comment, error) {
+	log := s.log.WithContext(ctx).WithField("commentId", commentID)
+	update := bson.M{
+		"$set":   bson.M{"body": "", "deleted": true, "lastUpdated": time.Now()},
+		"$unset": bson.M{"mentions": "", "edits": ""},
+	}
+	_, err := s.dbCollection.UpdateOne(ctx, bson.M{"_id": commentID}, update)
+	if err != nil {
+		log.WithError(err).Error("failed to delete comment from db")
+		return nil, err
+	}
+	return s.GetComment(ctx, commentID)
+}
+
+// DeleteTaskComments removes every comment of a task.
+func (s *Service) DeleteTaskComments(ctx context.Context, taskID string) error {
+	_, err := s.dbCollection.DeleteMany(ctx, bson.M{"taskId": taskID})
+	if err != nil {
+		s.log.WithContext(ctx).WithField("taskId", taskID).WithError(err).Error("failed to delete task comments from db")
+		return err
+	}
+	return nil
+}
+
+// resolveMentions returns the ids of the users mentioned in the body,
+// mentions that do not match an email or handle are ignored.
+func (s *Service) resolveMentions(ctx context.Context, body string) []string {
+	var userIDs []string
+	for _, match := range mentionRegex.FindAllStringSubmatch(body, -1) {
+		mention := strings.TrimRight(match[1], ".")
+		var user *users.User
+		if strings.Contains(mention, "@") {
+			user, _ = s.usersService.GetUserByEmail(ctx, mention)
+		} else {
+			user, _ = s.usersService.GetUserByHandle(ctx, mention)
+		}
+		if user != nil && !containsString(userIDs, user.ID) {
+			userIDs = append(userIDs, user.ID)
+		}
+	}
+	return userIDs
+}
+
+// notifyMentions notifies the mentioned users who can view the task, the
+// others are not told it exists.
+func (s *Service) notifyMentions(ctx context.Context, comment *Comment, userIDs []string) {
+	if len(userIDs) == 0 {
+		return
+	}
+	task, err := s.tasksService.GetTask(ctx, comment.TaskID)
+	if err != nil {
+		return
+	}
+	for _, userID := range userIDs {
+		if userID == comment.UserID || !s.tasksService.CanViewTask(ctx, task, userID) {
+			continue
+		}
+		s.notificationsService.CreateNotification(ctx, notifications.Notification{
+			UserID:    userID,
+			Type:      notifications.TypeMention,
+			Message:   fmt.Sprintf("you were mentioned in a comment on task %s", comment.TaskID),
+			ActorID:   comment.UserID,
+			TaskID:    comment.TaskID,
+			CommentID: comment.ID,
+		})
+	}
+}
+
+func containsString(list []string, str string) bool {
+	for _, item := range list {
+		if item == str {
+			return true
+		}
+	}
+	return false
+}
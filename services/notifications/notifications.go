@@ -0,0 +1,100 @@
+package notifications
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wisdommatt/todo-list-api/internal/apperror"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Notification types.
+const (
+	TypeMention             = "mention"
+	TypeShareInvitation     = "share_invitation"
+	TypeWorkspaceInvitation = "workspace_invitation"
+	TypeAssignment          = "assignment"
+)
+
+// ErrNotificationNotFound is returned when the user has no notification with the id.
+var ErrNotificationNotFound = apperror.New(apperror.NotFound, "notification_not_found", "notification does not exist")
+
+type Notification struct {
+	ID        string    `json:"id" bson:"_id,omitempty"`
+	UserID    string    `json:"userId" bson:"userId,omitempty"`
+	Type      string    `json:"type" bson:"type,omitempty"`
+	Message   string    `json:"message" bson:"message,omitempty"`
+	ActorID   string    `json:"actorId,omitempty" bson:"actorId,omitempty"`
+	TaskID    string    `json:"taskId,omitempty" bson:"taskId,omitempty"`
+	CommentID string    `json:"commentId,omitempty" bson:"commentId,omitempty"`
+	ShareID   string    `json:"shareId,omitempty" bson:"shareId,omitempty"`
+	Read      bool      `json:"read" bson:"read"`
+	TimeAdded time.Time `json:"timeAdded" bson:"timeAdded,omitempty"`
+}
+
+type Service struct {
+	dbCollection *mongo.Collection
+	log          *logrus.Logger
+}
+
+func NewService(db *mongo.Database, log *logrus.Logger) *Service {
+	return &Service{
+		dbCollection: db.Collection("notifications"),
+		log:          log,
+	}
+}
+
+func (s *Service) CreateNotification(ctx context.Context, notification Notification) (*Notification, error) {
+	log := s.log.WithContext(ctx).WithField("notification", notification)
+	notification.ID = primitive.NewObjectID().Hex()
+	notification.Read = false
+	notification.TimeAdded = time.Now()
+	_, err := s.dbCollection.InsertOne(ctx, notification)
+	if err != nil {
+		log.WithError(err).Error("failed to save notification to db")
+		return nil, err
+	}
+	return &notification, nil
+}
+
+func (s *Service) GetNotifications(ctx context.Context, userID, lastID string, limit int) ([]Notification, error) {
+	log := s.log.WithContext(ctx).WithField("userId", userID).WithField("lastId", lastID).
+		WithField("limit", limit)
+	filter := bson.M{"_id": bson.M{"$gt": lastID}, "userId": userID}
+	findOpt := options.Find().SetLimit(int64(limit))
+	cursor, err := s.dbCollection.Find(ctx, filter, findOpt)
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve notifications from db")
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var notifications []Notification
+	err = cursor.All(ctx, &notifications)
+	if err != nil {
+		log.WithError(err).Error("failed to decode retrieved notifications")
+		return nil, err
+	}
+	return notifications, nil
+}
+
+// MarkAsRead marks a user notification as read.
+func (s *Service) MarkAsRead(ctx context.Context, userID, notificationID string) (*Notification, error) {
+	log := s.log.WithContext(ctx).WithField("userId", userID).WithField("notificationId", notificationID)
+	filter := bson.M{"_id": notificationID, "userId": userID}
+	update := bson.M{"$set": bson.M{"read": true}}
+	var notification Notification
+	err := s.dbCollection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).
+		Decode(&notification)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotificationNotFound
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to mark notification as read in db")
+		return nil, err
+	}
+	return &notification, nil
+}
@@ -0,0 +1,424 @@
+package overlays
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wisdommatt/todo-list-api/internal/apperror"
+	"github.com/wisdommatt/todo-list-api/internal/ical"
+	"github.com/wisdommatt/todo-list-api/internal/rrule"
+	"github.com/wisdommatt/todo-list-api/internal/tenant"
+	"github.com/wisdommatt/todo-list-api/services/tasks"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Overlay sources.
+const (
+	SourceURL    = "url"
+	SourceUpload = "upload"
+)
+
+// MaxSize is the maximum size of an overlay calendar.
+const MaxSize = 5 << 20
+
+// maxEvents bounds the busy events saved for an overlay.
+const maxEvents = 10000
+
+// Busy events are kept in a window around now, recurring events are
+// expanded in it.
+const (
+	eventsHistory = 7 * 24 * time.Hour
+	eventsHorizon = 365 * 24 * time.Hour
+)
+
+// busyTitle is the title of overlay events in overlap checks, the titles
+// of external events are not stored as they may be seen by other users.
+const busyTitle = "an event of an external calendar"
+
+// ErrInvalidCalendar is returned when the calendar of an overlay cannot be
+// fetched or read, it wraps the reason.
+var ErrInvalidCalendar = fmt.Errorf("invalid calendar")
+
+// ErrOverlayNotFound is returned when the overlay does not exist.
+var ErrOverlayNotFound = apperror.New(apperror.NotFound, "overlay_not_found", "overlay does not exist")
+
+// Overlay is a read-only external calendar whose events make the user busy,
+// fetched from a url or uploaded as a file.
+type Overlay struct {
+	ID          string `json:"id" bson:"_id,omitempty"`
+	UserID      string `json:"userId" bson:"userId,omitempty"`
+	WorkspaceID string `json:"workspaceId" bson:"workspaceId,omitempty"`
+	Name        string `json:"name" bson:"name,omitempty"`
+	Source      string `json:"source" bson:"source,omitempty"`
+	URL         string `json:"url,omitempty" bson:"url,omitempty"`
+	// TimeZone is used for the event times without time zone.
+	TimeZone   string `json:"timeZone" bson:"timeZone,omitempty"`
+	EventCount int    `json:"eventCount" bson:"eventCount"`
+	// LastRefreshed is the last time the events were updated, LastError is
+	// set when the last refresh failed.
+	LastRefreshed time.Time `json:"lastRefreshed" bson:"lastRefreshed,omitempty"`
+	LastError     string    `json:"lastError,omitempty" bson:"lastError,omitempty"`
+	// ETag and LastModified are the validators of the last fetched calendar.
+	ETag         string `json:"-" bson:"etag,omitempty"`
+	LastModified string `json:"-" bson:"lastModified,omitempty"`
+	// Calendar is the last fetched or uploaded calendar, its recurring
+	// events are expanded again on every refresh as the window moves.
+	Calendar string `json:"-" bson:"calendar,omitempty"`
+	// FailureCount is the number of refreshes that failed in a row, they
+	// delay the next refresh.
+	FailureCount int       `json:"-" bson:"failureCount"`
+	NextRefresh  time.Time `json:"-" bson:"nextRefresh,omitempty"`
+	TimeAdded    time.Time `json:"timeAdded" bson:"timeAdded,omitempty"`
+}
+
+// Event is a busy period of an overlay.
+type Event struct {
+	ID          string    `bson:"_id"`
+	OverlayID   string    `bson:"overlayId"`
+	UserID      string    `bson:"userId"`
+	WorkspaceID string    `bson:"workspaceId"`
+	StartTime   time.Time `bson:"startTime"`
+	EndTime     time.Time `bson:"endTime"`
+}
+
+type Service struct {
+	httpClient       *http.Client
+	dbCollection     *mongo.Collection
+	eventsCollection *mongo.Collection
+	log              *logrus.Logger
+}
+
+// NewService returns the overlays service, calendar urls are fetched with
+// the http client.
+func NewService(httpClient *http.Client, db *mongo.Database, log *logrus.Logger) *Service {
+	return &Service{
+		httpClient:       httpClient,
+		dbCollection:     db.Collection("overlays"),
+		eventsCollection: db.Collection("overlayEvents"),
+		log:              log,
+	}
+}
+
+// CreateOverlay saves the overlay and its events, from the calendar at its
+// url or from the uploaded calendar. Overlays whose calendar cannot be read
+// are not saved.
+func (s *Service) CreateOverlay(ctx context.Context, overlay Overlay, upload io.Reader) (*Overlay, error) {
+	log := s.log.WithContext(ctx).WithField("overlay", overlay)
+	overlay.ID = primitive.NewObjectID().Hex()
+	overlay.WorkspaceID = tenant.WorkspaceID(ctx)
+	overlay.TimeAdded = time.Now()
+	var events []Event
+	var err error
+	if overlay.Source == SourceURL {
+		events, err = s.loadEvents(ctx, &overlay)
+	} else {
+		var calendar []byte
+		calendar, err = ioutil.ReadAll(io.LimitReader(upload, MaxSize))
+		overlay.Calendar = string(calendar)
+		if err == nil {
+			events, err = readEvents(&overlay)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCalendar, err)
+	}
+	_, err = s.dbCollection.InsertOne(ctx, overlay)
+	if err != nil {
+		log.WithError(err).Error("failed to save overlay to db")
+		return nil, err
+	}
+	err = s.replaceEvents(ctx, &overlay, events)
+	if err != nil {
+		return nil, err
+	}
+	return &overlay, nil
+}
+
+func (s *Service) GetOverlay(ctx context.Context, overlayID string) (*Overlay, error) {
+	var overlay Overlay
+	log := s.log.WithContext(ctx).WithField("overlayId", overlayID)
+	err := s.dbCollection.FindOne(ctx, tenant.Filter(ctx, "workspaceId", bson.M{"_id": overlayID})).Decode(&overlay)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrOverlayNotFound
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve overlay from db by id")
+		return nil, err
+	}
+	return &overlay, nil
+}
+
+// GetOverlays returns the overlays of the user.
+func (s *Service) GetOverlays(ctx context.Context, userID string) ([]Overlay, error) {
+	log := s.log.WithContext(ctx).WithField("userId", userID)
+	opts := options.Find().SetProjection(bson.M{"calendar": 0})
+	cursor, err := s.dbCollection.Find(ctx, tenant.Filter(ctx, "workspaceId", bson.M{"userId": userID}), opts)
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve overlays from db")
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	overlays := []Overlay{}
+	err = cursor.All(ctx, &overlays)
+	if err != nil {
+		log.WithError(err).Error("failed to decode retrieved overlays")
+		return nil, err
+	}
+	return overlays, nil
+}
+
+// DeleteOverlay deletes the overlay and its events.
+func (s *Service) DeleteOverlay(ctx context.Context, overlayID string) (*Overlay, error) {
+	var overlay Overlay
+	log := s.log.WithContext(ctx).WithField("overlayId", overlayID)
+	err := s.dbCollection.FindOneAndDelete(ctx, tenant.Filter(ctx, "workspaceId", bson.M{"_id": overlayID})).Decode(&overlay)
+	if err != nil {
+		log.WithError(err).Error("failed to delete overlay from db")
+		return nil, err
+	}
+	_, err = s.eventsCollection.DeleteMany(ctx, bson.M{"overlayId": overlayID})
+	if err != nil {
+		log.WithError(err).Error("failed to delete overlay events from db")
+		return nil, err
+	}
+	return &overlay, nil
+}
+
+// GetBusyTasks returns the overlay events of the users between from and to
+// as tasks without id, it makes the service a tasks.BusyProvider.
+func (s *Service) GetBusyTasks(ctx context.Context, userIDs []string, from, to time.Time) ([]tasks.Task, error) {
+	log := s.log.WithContext(ctx).WithField("userIds", userIDs).WithField("from", from).WithField("to", to)
+	filter := tenant.Filter(ctx, "workspaceId", bson.M{
+		"userId":    bson.M{"$in": userIDs},
+		"startTime": bson.M{"$lt": to},
+		"endTime":   bson.M{"$gt": from},
+	})
+	cursor, err := s.eventsCollection.Find(ctx, filter)
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve overlay events from db")
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var events []Event
+	err = cursor.All(ctx, &events)
+	if err != nil {
+		log.WithError(err).Error("failed to decode retrieved overlay events")
+		return nil, err
+	}
+	busyTasks := make([]tasks.Task, 0, len(events))
+	for _, event := range events {
+		busyTasks = append(busyTasks, tasks.Task{
+			Title:     busyTitle,
+			UserID:    event.UserID,
+			StartTime: event.StartTime,
+			EndTime:   event.EndTime,
+		})
+	}
+	return busyTasks, nil
+}
+
+// replaceEvents replaces the saved events of the overlay and saves the
+// refresh status of the overlay.
+func (s *Service) replaceEvents(ctx context.Context, overlay *Overlay, events []Event) error {
+	log := s.log.WithContext(ctx).WithField("overlayId", overlay.ID)
+	_, err := s.eventsCollection.DeleteMany(ctx, bson.M{"overlayId": overlay.ID})
+	if err != nil {
+		log.WithError(err).Error("failed to delete overlay events from db")
+		return err
+	}
+	if len(events) > 0 {
+		documents := make([]interface{}, 0, len(events))
+		for _, event := range events {
+			documents = append(documents, event)
+		}
+		_, err = s.eventsCollection.InsertMany(ctx, documents)
+		if err != nil {
+			log.WithError(err).Error("failed to save overlay events to db")
+			return err
+		}
+	}
+	overlay.EventCount = len(events)
+	overlay.LastRefreshed = time.Now()
+	overlay.LastError = ""
+	overlay.FailureCount = 0
+	overlay.NextRefresh = overlay.LastRefreshed.Add(RefreshInterval)
+	return s.saveRefreshStatus(ctx, overlay)
+}
+
+func (s *Service) saveRefreshStatus(ctx context.Context, overlay *Overlay) error {
+	update := bson.M{
+		"$set": bson.M{
+			"eventCount":    overlay.EventCount,
+			"lastRefreshed": overlay.LastRefreshed,
+			"etag":          overlay.ETag,
+			"lastModified":  overlay.LastModified,
+			"lastError":     overlay.LastError,
+			"calendar":      overlay.Calendar,
+			"failureCount":  overlay.FailureCount,
+			"nextRefresh":   overlay.NextRefresh,
+		},
+	}
+	_, err := s.dbCollection.UpdateOne(ctx, bson.M{"_id": overlay.ID}, update)
+	if err != nil {
+		s.log.WithContext(ctx).WithField("overlayId", overlay.ID).WithError(err).Error("failed to save overlay refresh status to db")
+		return err
+	}
+	return nil
+}
+
+// loadEvents fetches the calendar of a url overlay and reads the busy events
+// of the overlay calendar.
+func (s *Service) loadEvents(ctx context.Context, overlay *Overlay) ([]Event, error) {
+	if overlay.Source == SourceURL {
+		err := s.fetchCalendar(ctx, overlay)
+		if err != nil {
+			return nil, err
+		}
+	} else if overlay.Calendar == "" {
+		// uploads added before calendars were kept.
+		return nil, fmt.Errorf("the calendar file was not kept, upload it again")
+	}
+	return readEvents(overlay)
+}
+
+// readEvents reads the busy events of the overlay calendar in the window
+// around now.
+func readEvents(overlay *Overlay) ([]Event, error) {
+	calendar, err := ical.Parse(strings.NewReader(overlay.Calendar))
+	if err != nil || calendar.Name != "VCALENDAR" {
+		return nil, fmt.Errorf("invalid calendar file")
+	}
+	loc, err := time.LoadLocation(overlay.TimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+	if tz := calendar.Text("X-WR-TIMEZONE"); tz != "" {
+		if calendarLoc, err := time.LoadLocation(tz); err == nil {
+			loc = calendarLoc
+		}
+	}
+	now := time.Now()
+	slots := busySlots(calendar, now.Add(-eventsHistory), now.Add(eventsHorizon), loc)
+	if len(slots) > maxEvents {
+		return nil, fmt.Errorf("the calendar has more than %d events", maxEvents)
+	}
+	events := make([]Event, 0, len(slots))
+	for _, slot := range slots {
+		events = append(events, Event{
+			ID:          primitive.NewObjectID().Hex(),
+			OverlayID:   overlay.ID,
+			UserID:      overlay.UserID,
+			WorkspaceID: overlay.WorkspaceID,
+			StartTime:   slot.StartTime,
+			EndTime:     slot.EndTime,
+		})
+	}
+	return events, nil
+}
+
+// busySlots returns the busy periods of the calendar events between from and
+// to. All-day, free and cancelled events do not make users busy, like
+// all-day tasks.
+func busySlots(calendar *ical.Component, from, to time.Time, loc *time.Location) []tasks.TimeSlot {
+	// overrides replace single occurrences of recurring events, keyed on
+	// their UID and the start time of the occurrence they replace.
+	overridden := map[string]bool{}
+	for _, component := range calendar.Components {
+		if property := component.Get("RECURRENCE-ID"); component.Name == "VEVENT" && property != nil {
+			start, _, err := ical.ParseTime(property, loc)
+			if err == nil {
+				overridden[component.Text("UID")+start.UTC().String()] = true
+			}
+		}
+	}
+	var slots []tasks.TimeSlot
+	for _, component := range calendar.Components {
+		if component.Name != "VEVENT" || !isBusy(component) {
+			continue
+		}
+		start, end, ok := eventTime(component, loc)
+		if !ok {
+			continue
+		}
+		duration := end.Sub(start)
+		starts := []time.Time{start}
+		if property := component.Get("RRULE"); property != nil && component.Get("RECURRENCE-ID") == nil {
+			if rule, err := rrule.Parse(property.Value); err == nil {
+				starts = rule.Between(start, from.Add(-duration), to, start.Location())
+			}
+		}
+		excluded := excludedDates(component, loc)
+		uid := component.Text("UID")
+		for _, occurrence := range starts {
+			key := occurrence.UTC().String()
+			if excluded[key] || component.Get("RECURRENCE-ID") == nil && overridden[uid+key] {
+				continue
+			}
+			slot := tasks.TimeSlot{StartTime: occurrence.UTC(), EndTime: occurrence.Add(duration).UTC()}
+			if slot.StartTime.Before(to) && slot.EndTime.After(from) {
+				slots = append(slots, slot)
+			}
+		}
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i].StartTime.Before(slots[j].StartTime) })
+	return slots
+}
+
+// isBusy reports whether the event makes the user busy.
+func isBusy(component *ical.Component) bool {
+	return !strings.EqualFold(component.Text("STATUS"), "CANCELLED") &&
+		!strings.EqualFold(component.Text("TRANSP"), "TRANSPARENT")
+}
+
+// eventTime returns the time range of a timed event.
+func eventTime(component *ical.Component, loc *time.Location) (time.Time, time.Time, bool) {
+	dtstart := component.Get("DTSTART")
+	if dtstart == nil {
+		return time.Time{}, time.Time{}, false
+	}
+	start, allDay, err := ical.ParseTime(dtstart, loc)
+	if err != nil || allDay {
+		return time.Time{}, time.Time{}, false
+	}
+	end := start
+	if dtend := component.Get("DTEND"); dtend != nil {
+		end, _, err = ical.ParseTime(dtend, loc)
+	} else if duration := component.Get("DURATION"); duration != nil {
+		var value time.Duration
+		value, err = ical.ParseDuration(duration.Value)
+		end = start.Add(value)
+	}
+	if err != nil || !end.After(start) {
+		return time.Time{}, time.Time{}, false
+	}
+	return start, end, true
+}
+
+// excludedDates returns the EXDATE occurrences of a recurring event.
+func excludedDates(component *ical.Component, loc *time.Location) map[string]bool {
+	excluded := map[string]bool{}
+	for _, property := range component.Properties {
+		if property.Name != "EXDATE" {
+			continue
+		}
+		for _, value := range strings.Split(property.Value, ",") {
+			exdate := property
+			exdate.Value = value
+			t, _, err := ical.ParseTime(&exdate, loc)
+			if err == nil {
+				excluded[t.UTC().String()] = true
+			}
+		}
+	}
+	return excluded
+}
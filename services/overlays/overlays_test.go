@@ -0,0 +1,65 @@
+package overlays
+
+import (
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/wisdommatt/todo-list-api/internal/ical"
+)
+
+func TestBusySlots(t *testing.T) {
+	calendar, err := ical.Parse(strings.NewReader("BEGIN:VCALENDAR\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:daily\r\n" +
+		"DTSTART;TZID=Europe/London:20220307T090000\r\n" +
+		"DURATION:PT30M\r\n" +
+		"RRULE:FREQ=DAILY;COUNT=5\r\n" +
+		"EXDATE;TZID=Europe/London:20220308T090000\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:daily\r\n" +
+		"RECURRENCE-ID;TZID=Europe/London:20220309T090000\r\n" +
+		"DTSTART;TZID=Europe/London:20220309T140000\r\n" +
+		"DTEND;TZID=Europe/London:20220309T150000\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:free\r\n" +
+		"DTSTART:20220307T120000Z\r\n" +
+		"DTEND:20220307T130000Z\r\n" +
+		"TRANSP:TRANSPARENT\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:cancelled\r\n" +
+		"DTSTART:20220307T120000Z\r\n" +
+		"DTEND:20220307T130000Z\r\n" +
+		"STATUS:CANCELLED\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:all-day\r\n" +
+		"DTSTART;VALUE=DATE:20220307\r\n" +
+		"DTEND;VALUE=DATE:20220308\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	from := time.Date(2022, 3, 7, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2022, 3, 11, 0, 0, 0, 0, time.UTC)
+	slots := busySlots(calendar, from, to, time.UTC)
+
+	// London is on GMT in early March.
+	want := []string{
+		"2022-03-07 09:00-09:30",
+		"2022-03-09 14:00-15:00",
+		"2022-03-10 09:00-09:30",
+	}
+	got := make([]string, 0, len(slots))
+	for _, slot := range slots {
+		got = append(got, slot.StartTime.Format("2006-01-02 15:04")+"-"+slot.EndTime.Format("15:04"))
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("busySlots() = %v, want %v", got, want)
+	}
+}
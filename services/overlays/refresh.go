@@ -0,0 +1,194 @@
+package overlays
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/wisdommatt/todo-list-api/internal/tenant"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// RefreshInterval is how often the url overlays are fetched again and the
+// events of every overlay are expanded again.
+const RefreshInterval = time.Hour
+
+// maxRefreshDelay bounds the delay before refreshing an overlay whose last
+// refreshes failed.
+const maxRefreshDelay = 24 * time.Hour
+
+// fetchTimeout bounds the time spent fetching an overlay calendar.
+const fetchTimeout = 30 * time.Second
+
+// privateNetworks are the networks overlay urls cannot point to, so users
+// cannot make the api fetch internal services.
+var privateNetworks = mustParseCIDRs(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7", "fe80::/10",
+)
+
+// NewHTTPClient returns the http client fetching overlay calendars, which
+// refuses to connect to private and loopback addresses.
+func NewHTTPClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return fmt.Errorf("invalid address %s", host)
+			}
+			for _, privateNetwork := range privateNetworks {
+				if privateNetwork.Contains(ip) {
+					return fmt.Errorf("connections to %s are not allowed", host)
+				}
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Transport: transport, Timeout: fetchTimeout}
+}
+
+// ValidateURL checks that an overlay url is an http or https url.
+func ValidateURL(rawURL string) error {
+	overlayURL, err := url.Parse(rawURL)
+	if err != nil || overlayURL.Host == "" || overlayURL.Scheme != "http" && overlayURL.Scheme != "https" {
+		return fmt.Errorf("url must be a valid http or https url")
+	}
+	return nil
+}
+
+// RefreshOverlay fetches the calendar of a url overlay again and replaces
+// the overlay events, expanded again from its calendar. Failures are saved in
+// the overlay last error and delay its next refresh.
+func (s *Service) RefreshOverlay(ctx context.Context, overlay *Overlay) error {
+	refreshed := *overlay
+	events, err := s.loadEvents(ctx, &refreshed)
+	if err != nil {
+		overlay.LastError = err.Error()
+		overlay.FailureCount++
+		overlay.NextRefresh = time.Now().Add(refreshDelay(overlay.FailureCount))
+		s.saveRefreshStatus(ctx, overlay)
+		return fmt.Errorf("%w: %s", ErrInvalidCalendar, err)
+	}
+	*overlay = refreshed
+	return s.replaceEvents(ctx, overlay, events)
+}
+
+// refreshDelay returns the delay before refreshing an overlay whose last
+// refreshes failed, doubling the refresh interval on every failure.
+func refreshDelay(failures int) time.Duration {
+	delay := RefreshInterval
+	for i := 1; i < failures && delay < maxRefreshDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRefreshDelay {
+		delay = maxRefreshDelay
+	}
+	return delay
+}
+
+// RefreshDueOverlays refreshes the overlays of every workspace whose next
+// refresh is due.
+func (s *Service) RefreshDueOverlays(ctx context.Context) {
+	log := s.log.WithContext(ctx)
+	filter := bson.M{
+		"$or": []bson.M{
+			{"nextRefresh": bson.M{"$lte": time.Now()}},
+			{"nextRefresh": bson.M{"$exists": false}},
+		},
+	}
+	cursor, err := s.dbCollection.Find(ctx, filter)
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve due overlays from db")
+		return
+	}
+	defer cursor.Close(ctx)
+	var overlays []Overlay
+	err = cursor.All(ctx, &overlays)
+	if err != nil {
+		log.WithError(err).Error("failed to decode retrieved due overlays")
+		return
+	}
+	for i := range overlays {
+		overlay := &overlays[i]
+		err = s.RefreshOverlay(tenant.WithWorkspace(ctx, overlay.WorkspaceID), overlay)
+		if err != nil {
+			log.WithError(err).WithField("overlayId", overlay.ID).Error("failed to refresh overlay")
+		}
+	}
+}
+
+// RunRefresher refreshes the due overlays until ctx is done, looking for them
+// four times per interval.
+func (s *Service) RunRefresher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval / 4)
+	defer ticker.Stop()
+	for {
+		s.RefreshDueOverlays(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetchCalendar fetches the calendar of a url overlay, the saved calendar is
+// kept when it did not change since it was last fetched.
+func (s *Service) fetchCalendar(ctx context.Context, overlay *Overlay) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, overlay.URL, nil)
+	if err != nil {
+		return fmt.Errorf("invalid calendar url")
+	}
+	req.Header.Set("Accept", "text/calendar")
+	if overlay.Calendar != "" && overlay.ETag != "" {
+		req.Header.Set("If-None-Match", overlay.ETag)
+	}
+	if overlay.Calendar != "" && overlay.LastModified != "" {
+		req.Header.Set("If-Modified-Since", overlay.LastModified)
+	}
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		s.log.WithContext(ctx).WithError(err).WithField("overlayId", overlay.ID).Error("failed to fetch overlay calendar")
+		return fmt.Errorf("the calendar could not be fetched")
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotModified && overlay.Calendar != "" {
+		return nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("the calendar could not be fetched, got status %d", res.StatusCode)
+	}
+	calendar, err := ioutil.ReadAll(io.LimitReader(res.Body, MaxSize))
+	if err != nil {
+		return fmt.Errorf("the calendar could not be fetched")
+	}
+	overlay.Calendar = string(calendar)
+	overlay.ETag = res.Header.Get("ETag")
+	overlay.LastModified = res.Header.Get("Last-Modified")
+	return nil
+}
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
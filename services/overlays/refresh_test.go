@@ -0,0 +1,158 @@
+package overlays
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// weeklyCalendar has an event every monday since 2020, whose occurrences
+// around now are only found by expanding it again.
+const weeklyCalendar = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:weekly@example.com\r\n" +
+	"DTSTART:20200106T100000Z\r\n" +
+	"DTEND:20200106T110000Z\r\n" +
+	"RRULE:FREQ=WEEKLY\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func newTestService(client *http.Client) *Service {
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+	return &Service{httpClient: client, log: log}
+}
+
+func TestNewHTTPClientBlocksPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		t.Errorf("request to %s reached the loopback server", r.URL)
+	}))
+	defer server.Close()
+
+	res, err := NewHTTPClient().Get(server.URL)
+	if err == nil {
+		res.Body.Close()
+		t.Fatalf("Get(%s) error = nil, want the connection to be refused", server.URL)
+	}
+	if !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("Get(%s) error = %v, want a blocked address error", server.URL, err)
+	}
+}
+
+func TestLoadEventsNotModified(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			rw.WriteHeader(http.StatusNotModified)
+			return
+		}
+		rw.Header().Set("ETag", `"v1"`)
+		rw.Write([]byte(weeklyCalendar))
+	}))
+	defer server.Close()
+
+	s := newTestService(server.Client())
+	overlay := &Overlay{ID: "overlay", UserID: "user", Source: SourceURL, URL: server.URL}
+	events, err := s.loadEvents(context.Background(), overlay)
+	if err != nil {
+		t.Fatalf("loadEvents() error = %v", err)
+	}
+	if overlay.ETag != `"v1"` || overlay.Calendar != weeklyCalendar {
+		t.Fatalf("overlay = %q %q, want the fetched calendar and its etag", overlay.ETag, overlay.Calendar)
+	}
+
+	events, err = s.loadEvents(context.Background(), overlay)
+	if err != nil {
+		t.Fatalf("loadEvents() after a 304 error = %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("requests = %d, want 2", requests)
+	}
+	// the saved calendar is expanded again in the window around now.
+	from, to := time.Now().Add(-eventsHistory), time.Now().Add(eventsHorizon)
+	if len(events) < 50 {
+		t.Fatalf("events = %d, want the weekly occurrences of a year", len(events))
+	}
+	for _, event := range events {
+		if !event.StartTime.Before(to) || !event.EndTime.After(from) {
+			t.Errorf("event %v - %v is outside of the window", event.StartTime, event.EndTime)
+		}
+		if event.StartTime.Weekday() != time.Monday || event.EndTime.Sub(event.StartTime) != time.Hour {
+			t.Errorf("event %v - %v is not an occurrence of the weekly event", event.StartTime, event.EndTime)
+		}
+		if event.OverlayID != "overlay" || event.UserID != "user" {
+			t.Errorf("event = %+v, want the overlay and user ids", event)
+		}
+	}
+}
+
+func TestLoadEventsWithoutSavedCalendar(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// overlays saved without their calendar cannot use a 304.
+		if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+			rw.WriteHeader(http.StatusNotModified)
+			return
+		}
+		rw.Write([]byte(weeklyCalendar))
+	}))
+	defer server.Close()
+
+	s := newTestService(server.Client())
+	overlay := &Overlay{Source: SourceURL, URL: server.URL, ETag: `"v1"`, LastModified: "Mon, 07 Mar 2022 10:00:00 GMT"}
+	events, err := s.loadEvents(context.Background(), overlay)
+	if err != nil {
+		t.Fatalf("loadEvents() error = %v", err)
+	}
+	if len(events) == 0 || overlay.Calendar != weeklyCalendar {
+		t.Errorf("loadEvents() = %d events, want the events of the fetched calendar", len(events))
+	}
+
+	upload := &Overlay{Source: SourceUpload}
+	if _, err := s.loadEvents(context.Background(), upload); err == nil {
+		t.Errorf("loadEvents() of an upload without calendar error = nil, want an error")
+	}
+}
+
+func TestLoadEventsErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing.ics":
+			rw.WriteHeader(http.StatusNotFound)
+		case "/page.html":
+			rw.Write([]byte("<html></html>"))
+		}
+	}))
+	defer server.Close()
+
+	s := newTestService(server.Client())
+	for _, path := range []string{"/missing.ics", "/page.html"} {
+		overlay := &Overlay{Source: SourceURL, URL: server.URL + path}
+		if _, err := s.loadEvents(context.Background(), overlay); err == nil {
+			t.Errorf("loadEvents(%s) error = nil, want an error", path)
+		}
+	}
+}
+
+func TestRefreshDelay(t *testing.T) {
+	tests := map[int]time.Duration{
+		0:  RefreshInterval,
+		1:  RefreshInterval,
+		2:  2 * RefreshInterval,
+		3:  4 * RefreshInterval,
+		6:  maxRefreshDelay,
+		50: maxRefreshDelay,
+	}
+	for failures, want := range tests {
+		if got := refreshDelay(failures); got != want {
+			t.Errorf("refreshDelay(%d) = %v, want %v", failures, got, want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package projects
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wisdommatt/todo-list-api/internal/apperror"
+	"github.com/wisdommatt/todo-list-api/internal/tenant"
+	"github.com/wisdommatt/todo-list-api/services/shares"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrProjectNotFound is returned when the project does not exist.
+var ErrProjectNotFound = apperror.New(apperror.NotFound, "project_not_found", "project does not exist")
+
+// Project is a list grouping tasks, it is owned by the user that created it.
+type Project struct {
+	ID          string    `json:"id" bson:"_id,omitempty"`
+	Name        string    `json:"name" bson:"name,omitempty"`
+	UserID      string    `json:"userId" bson:"userId,omitempty"`
+	WorkspaceID string    `json:"workspaceId" bson:"workspaceId,omitempty"`
+	TimeAdded   time.Time `json:"timeAdded" bson:"timeAdded,omitempty"`
+}
+
+type Service struct {
+	sharesService *shares.Service
+	dbCollection  *mongo.Collection
+	log           *logrus.Logger
+}
+
+func NewService(sharesService *shares.Service, db *mongo.Database, log *logrus.Logger) *Service {
+	return &Service{
+		sharesService: sharesService,
+		dbCollection:  db.Collection("projects"),
+		log:           log,
+	}
+}
+
+func (s *Service) CreateProject(ctx context.Context, project Project) (*Project, error) {
+	log := s.log.WithContext(ctx).WithField("project", project)
+	project.ID = primitive.NewObjectID().Hex()
+	project.WorkspaceID = tenant.WorkspaceID(ctx)
+	project.TimeAdded = time.Now()
+	_, err := s.dbCollection.InsertOne(ctx, project)
+	if err != nil {
+		log.WithError(err).Error("failed to save project to db")
+		return nil, err
+	}
+	return &project, nil
+}
+
+func (s *Service) GetProject(ctx context.Context, projectID string) (*Project, error) {
+	var project Project
+	log := s.log.WithContext(ctx).WithField("projectId", projectID)
+	err := s.dbCollection.FindOne(ctx, tenant.Filter(ctx, "workspaceId", bson.M{"_id": projectID})).Decode(&project)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrProjectNotFound
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve project from db by id")
+		return nil, err
+	}
+	return &project, nil
+}
+
+// GetProjects returns the projects owned by the user and the ones shared with the user.
+func (s *Service) GetProjects(ctx context.Context, userID string) ([]Project, error) {
+	log := s.log.WithContext(ctx).WithField("userId", userID)
+	sharedProjectIDs, err := s.sharesService.GetSharedResourceIDs(ctx, userID, shares.ResourceProject)
+	if err != nil {
+		return nil, err
+	}
+	filter := tenant.Filter(ctx, "workspaceId", bson.M{"$or": []bson.M{
+		{"userId": userID},
+		{"_id": bson.M{"$in": sharedProjectIDs}},
+	}})
+	cursor, err := s.dbCollection.Find(ctx, filter)
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve projects from db")
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var projects []Project
+	err = cursor.All(ctx, &projects)
+	if err != nil {
+		log.WithError(err).Error("failed to decode retrieved projects")
+		return nil, err
+	}
+	return projects, nil
+}
+
+// GetProjectIDs returns the ids of the projects the user can access.
+func (s *Service) GetProjectIDs(ctx context.Context, userID string) ([]string, error) {
+	projects, err := s.GetProjects(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	projectIDs := make([]string, 0, len(projects))
+	for _, project := range projects {
+		projectIDs = append(projectIDs, project.ID)
+	}
+	return projectIDs, nil
+}
+
+func (s *Service) DeleteProject(ctx context.Context, projectID string) (*Project, error) {
+	var project Project
+	log := s.log.WithContext(ctx).WithField("projectId", projectID)
+	err := s.dbCollection.FindOneAndDelete(ctx, tenant.Filter(ctx, "workspaceId", bson.M{"_id": projectID})).Decode(&project)
+	if err != nil {
+		log.WithError(err).Error("failed to delete project from db")
+		return nil, err
+	}
+	s.sharesService.DeleteResourceShares(ctx, shares.ResourceProject, projectID)
+	return &project, nil
+}
+
+// ProjectRole returns the role of the user on the project, or an empty
+// string when the user cannot access the project.
+func (s *Service) ProjectRole(ctx context.Context, project *Project, userID string) string {
+	if project.UserID == userID {
+		return shares.RoleOwner
+	}
+	return s.sharesService.GetUserRole(ctx, userID, shares.ResourceProject, project.ID)
+}
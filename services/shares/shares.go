@@ -0,0 +1,245 @@
+package shares
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wisdommatt/todo-list-api/internal/apperror"
+	"github.com/wisdommatt/todo-list-api/services/notifications"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Permission roles, from the least to the most privileged.
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleOwner  = "owner"
+)
+
+// Types of resources that can be shared.
+const (
+	ResourceTask    = "task"
+	ResourceProject = "project"
+)
+
+// Share invitation statuses.
+const (
+	StatusPending  = "pending"
+	StatusAccepted = "accepted"
+	StatusDeclined = "declined"
+)
+
+// ErrShareNotFound is returned when the share does not exist.
+var ErrShareNotFound = apperror.New(apperror.NotFound, "share_not_found", "share does not exist")
+
+var roleRanks = map[string]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleOwner:  3,
+}
+
+// Share grants a user a role on a task or project once the invitation is accepted.
+type Share struct {
+	ID           string    `json:"id" bson:"_id,omitempty"`
+	ResourceType string    `json:"resourceType" bson:"resourceType,omitempty"`
+	ResourceID   string    `json:"resourceId" bson:"resourceId,omitempty"`
+	UserID       string    `json:"userId" bson:"userId,omitempty"`
+	Role         string    `json:"role" bson:"role,omitempty"`
+	Status       string    `json:"status" bson:"status,omitempty"`
+	InvitedBy    string    `json:"invitedBy" bson:"invitedBy,omitempty"`
+	TimeAdded    time.Time `json:"timeAdded" bson:"timeAdded,omitempty"`
+	LastUpdated  time.Time `json:"lastUpdated" bson:"lastUpdated,omitempty"`
+}
+
+// IsValidRole reports whether role is a known permission role.
+func IsValidRole(role string) bool {
+	return roleRanks[role] > 0
+}
+
+// RoleAtLeast reports whether role grants at least the permissions of minRole.
+func RoleAtLeast(role, minRole string) bool {
+	return roleRanks[role] > 0 && roleRanks[role] >= roleRanks[minRole]
+}
+
+// HighestRole returns the most privileged of the roles.
+func HighestRole(roles ...string) string {
+	highest := ""
+	for _, role := range roles {
+		if roleRanks[role] > roleRanks[highest] {
+			highest = role
+		}
+	}
+	return highest
+}
+
+type Service struct {
+	notificationsService *notifications.Service
+	dbCollection         *mongo.Collection
+	log                  *logrus.Logger
+}
+
+func NewService(notificationsService *notifications.Service, db *mongo.Database, log *logrus.Logger) *Service {
+	return &Service{
+		notificationsService: notificationsService,
+		dbCollection:         db.Collection("shares"),
+		log:                  log,
+	}
+}
+
+// CreateShare invites a user to a resource, inviting a user that already
+// has a share replaces its role and resets the invitation.
+func (s *Service) CreateShare(ctx context.Context, share Share) (*Share, error) {
+	log := s.log.WithContext(ctx).WithField("share", share)
+	filter := bson.M{
+		"resourceType": share.ResourceType,
+		"resourceId":   share.ResourceID,
+		"userId":       share.UserID,
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"role":        share.Role,
+			"status":      StatusPending,
+			"invitedBy":   share.InvitedBy,
+			"lastUpdated": time.Now(),
+		},
+		"$setOnInsert": bson.M{
+			"_id":       primitive.NewObjectID().Hex(),
+			"timeAdded": time.Now(),
+		},
+	}
+	updateOpt := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var savedShare Share
+	err := s.dbCollection.FindOneAndUpdate(ctx, filter, update, updateOpt).Decode(&savedShare)
+	if err != nil {
+		log.WithError(err).Error("failed to save share to db")
+		return nil, err
+	}
+	s.notificationsService.CreateNotification(ctx, notifications.Notification{
+		UserID:  savedShare.UserID,
+		Type:    notifications.TypeShareInvitation,
+		Message: fmt.Sprintf("you were invited to a %s as %s", savedShare.ResourceType, savedShare.Role),
+		ActorID: savedShare.InvitedBy,
+		ShareID: savedShare.ID,
+	})
+	return &savedShare, nil
+}
+
+func (s *Service) GetShare(ctx context.Context, shareID string) (*Share, error) {
+	var share Share
+	log := s.log.WithContext(ctx).WithField("shareId", shareID)
+	err := s.dbCollection.FindOne(ctx, bson.M{"_id": shareID}).Decode(&share)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrShareNotFound
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve share from db by id")
+		return nil, err
+	}
+	return &share, nil
+}
+
+// GetResourceShares returns every share of a resource.
+func (s *Service) GetResourceShares(ctx context.Context, resourceType, resourceID string) ([]Share, error) {
+	return s.findShares(ctx, bson.M{"resourceType": resourceType, "resourceId": resourceID})
+}
+
+// GetUserShares returns the user shares, status filters by invitation status when not empty.
+func (s *Service) GetUserShares(ctx context.Context, userID, status string) ([]Share, error) {
+	filter := bson.M{"userId": userID}
+	if status != "" {
+		filter["status"] = status
+	}
+	return s.findShares(ctx, filter)
+}
+
+// RespondToShare accepts or declines a share invitation.
+func (s *Service) RespondToShare(ctx context.Context, shareID string, accept bool) (*Share, error) {
+	log := s.log.WithContext(ctx).WithField("shareId", shareID).WithField("accept", accept)
+	status := StatusDeclined
+	if accept {
+		status = StatusAccepted
+	}
+	update := bson.M{"$set": bson.M{"status": status, "lastUpdated": time.Now()}}
+	var share Share
+	err := s.dbCollection.FindOneAndUpdate(ctx, bson.M{"_id": shareID}, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).
+		Decode(&share)
+	if err != nil {
+		log.WithError(err).Error("failed to update share status in db")
+		return nil, err
+	}
+	return &share, nil
+}
+
+func (s *Service) DeleteShare(ctx context.Context, shareID string) (*Share, error) {
+	var share Share
+	log := s.log.WithContext(ctx).WithField("shareId", shareID)
+	err := s.dbCollection.FindOneAndDelete(ctx, bson.M{"_id": shareID}).Decode(&share)
+	if err != nil {
+		log.WithError(err).Error("failed to delete share from db")
+		return nil, err
+	}
+	return &share, nil
+}
+
+// DeleteResourceShares removes every share of a resource.
+func (s *Service) DeleteResourceShares(ctx context.Context, resourceType, resourceID string) error {
+	_, err := s.dbCollection.DeleteMany(ctx, bson.M{"resourceType": resourceType, "resourceId": resourceID})
+	if err != nil {
+		s.log.WithContext(ctx).WithField("resourceType", resourceType).WithField("resourceId", resourceID).
+			WithError(err).Error("failed to delete resource shares from db")
+		return err
+	}
+	return nil
+}
+
+// GetUserRole returns the role granted to the user by an accepted share of
+// the resource, or an empty string when the resource is not shared with the user.
+func (s *Service) GetUserRole(ctx context.Context, userID, resourceType, resourceID string) string {
+	filter := bson.M{
+		"resourceType": resourceType,
+		"resourceId":   resourceID,
+		"userId":       userID,
+		"status":       StatusAccepted,
+	}
+	var share Share
+	err := s.dbCollection.FindOne(ctx, filter).Decode(&share)
+	if err != nil {
+		return ""
+	}
+	return share.Role
+}
+
+// GetSharedResourceIDs returns the ids of the resources of the given type shared with the user.
+func (s *Service) GetSharedResourceIDs(ctx context.Context, userID, resourceType string) ([]string, error) {
+	shares, err := s.findShares(ctx, bson.M{"userId": userID, "resourceType": resourceType, "status": StatusAccepted})
+	if err != nil {
+		return nil, err
+	}
+	resourceIDs := make([]string, 0, len(shares))
+	for _, share := range shares {
+		resourceIDs = append(resourceIDs, share.ResourceID)
+	}
+	return resourceIDs, nil
+}
+
+func (s *Service) findShares(ctx context.Context, filter bson.M) ([]Share, error) {
+	log := s.log.WithContext(ctx).WithField("filter", filter)
+	cursor, err := s.dbCollection.Find(ctx, filter)
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve shares from db")
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var shares []Share
+	err = cursor.All(ctx, &shares)
+	if err != nil {
+		log.WithError(err).Error("failed to decode retrieved shares")
+		return nil, err
+	}
+	return shares, nil
+}
@@ -0,0 +1,257 @@
+package tasks
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/wisdommatt/todo-list-api/internal/rrule"
+	"github.com/wisdommatt/todo-list-api/internal/tenant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AgendaItem is a task, or an occurrence of a recurring task, on a day of the agenda.
+type AgendaItem struct {
+	TaskID    string    `json:"taskId"`
+	Title     string    `json:"title"`
+	Status    string    `json:"status"`
+	ProjectID string    `json:"projectId,omitempty"`
+	AllDay    bool      `json:"allDay"`
+	Recurring bool      `json:"recurring"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	// DayStartTime and DayEndTime are the part of the item within the day.
+	DayStartTime time.Time `json:"dayStartTime"`
+	DayEndTime   time.Time `json:"dayEndTime"`
+	// ContinuesFromPreviousDay and ContinuesNextDay are set on the days of
+	// items spanning several days.
+	ContinuesFromPreviousDay bool `json:"continuesFromPreviousDay"`
+	ContinuesNextDay         bool `json:"continuesNextDay"`
+}
+
+// AgendaDay is the items of a day of the agenda with summary counts.
+type AgendaDay struct {
+	Date           string       `json:"date"`
+	Items          []AgendaItem `json:"items"`
+	Count          int          `json:"count"`
+	AllDayCount    int          `json:"allDayCount"`
+	CompletedCount int          `json:"completedCount"`
+	// BusyMinutes is the time covered by the timed items of the day.
+	BusyMinutes int `json:"busyMinutes"`
+}
+
+// GetAgenda returns the tasks in the calendar of the user (the tasks they own
+// or are assigned to) between from and to, bucketed by day in loc. Every day
+// of the range is returned, recurring tasks are expanded into occurrences.
+func (s *Service) GetAgenda(ctx context.Context, userID string, from, to time.Time, loc *time.Location) ([]AgendaDay, error) {
+	log := s.log.WithContext(ctx).WithField("userId", userID).WithField("from", from).WithField("to", to)
+	// all-day tasks are stored at UTC midnights, so the range is widened by
+	// a day on each side to cover every time zone.
+	filter := tenant.Filter(ctx, "workspaceId", bson.M{
+		"$and": []bson.M{
+			{"$or": []bson.M{
+				{"userId": userID},
+				{"assignees": userID},
+			}},
+			{"$or": []bson.M{
+				{
+					"startTime": bson.M{"$lt": to.Add(24 * time.Hour)},
+					"endTime":   bson.M{"$gt": from.Add(-24 * time.Hour)},
+				},
+				{
+					"recurrence": bson.M{"$exists": true},
+					"startTime":  bson.M{"$lt": to.Add(24 * time.Hour)},
+				},
+			}},
+		},
+	})
+	cursor, err := s.dbCollection.Find(ctx, filter)
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve agenda tasks from db")
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var tasks []Task
+	err = cursor.All(ctx, &tasks)
+	if err != nil {
+		log.WithError(err).Error("failed to decode retrieved agenda tasks")
+		return nil, err
+	}
+
+	days, dayIndexes := agendaDays(from, to, loc)
+	for _, task := range tasks {
+		for _, occurrence := range taskOccurrences(task, from, to, loc) {
+			if task.AllDay {
+				addAllDayItem(days, dayIndexes, task, occurrence)
+			} else {
+				addTimedItem(days, dayIndexes, task, occurrence, loc)
+			}
+		}
+	}
+	for i := range days {
+		day := &days[i]
+		sort.SliceStable(day.Items, func(a, b int) bool {
+			if day.Items[a].AllDay != day.Items[b].AllDay {
+				return day.Items[a].AllDay
+			}
+			return day.Items[a].DayStartTime.Before(day.Items[b].DayStartTime)
+		})
+		var busy []TimeSlot
+		for _, item := range day.Items {
+			day.Count++
+			if item.AllDay {
+				day.AllDayCount++
+			} else {
+				busy = append(busy, TimeSlot{StartTime: item.DayStartTime, EndTime: item.DayEndTime})
+			}
+			if item.Status == StatusCompleted {
+				day.CompletedCount++
+			}
+		}
+		for _, slot := range mergeSlots(busy) {
+			day.BusyMinutes += int(slot.EndTime.Sub(slot.StartTime).Minutes())
+		}
+	}
+	return days, nil
+}
+
+// agendaDays returns the empty days of loc between from and to, with the
+// index of each date.
+func agendaDays(from, to time.Time, loc *time.Location) ([]AgendaDay, map[string]int) {
+	days := []AgendaDay{}
+	dayIndexes := map[string]int{}
+	localFrom := from.In(loc)
+	for day := time.Date(localFrom.Year(), localFrom.Month(), localFrom.Day(), 0, 0, 0, 0, loc); day.Before(to); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		dayIndexes[date] = len(days)
+		days = append(days, AgendaDay{Date: date, Items: []AgendaItem{}})
+	}
+	return days, dayIndexes
+}
+
+// taskOccurrences returns the time ranges of the task, or of the task
+// occurrences for recurring tasks, that may fall between from and to.
+func taskOccurrences(task Task, from, to time.Time, loc *time.Location) []TimeSlot {
+	duration := task.EndTime.Sub(task.StartTime)
+	if task.Recurrence == "" {
+		return []TimeSlot{{StartTime: task.StartTime, EndTime: task.EndTime}}
+	}
+	rule, err := rrule.Parse(task.Recurrence)
+	if err != nil {
+		return []TimeSlot{{StartTime: task.StartTime, EndTime: task.EndTime}}
+	}
+	// all-day occurrences repeat on dates, independently of the time zone.
+	ruleLoc := loc
+	if task.AllDay {
+		ruleLoc = time.UTC
+	}
+	var occurrences []TimeSlot
+	for _, start := range rule.Between(task.StartTime, from.Add(-duration-24*time.Hour), to.Add(24*time.Hour), ruleLoc) {
+		occurrences = append(occurrences, TimeSlot{StartTime: start, EndTime: start.Add(duration)})
+	}
+	return occurrences
+}
+
+// OccursBetween reports whether an occurrence of the task overlaps the
+// time range, zero times leave the range open. Unscheduled tasks occur at
+// their deadline.
+func (t Task) OccursBetween(from, to time.Time, loc *time.Location) bool {
+	if t.StartTime.IsZero() {
+		return t.Deadline.IsZero() || !t.Deadline.Before(from) && (to.IsZero() || t.Deadline.Before(to))
+	}
+	if to.IsZero() {
+		return t.Recurrence != "" || t.EndTime.After(from)
+	}
+	for _, occurrence := range taskOccurrences(t, from, to, loc) {
+		if occurrence.StartTime.Before(to) && occurrence.EndTime.After(from) {
+			return true
+		}
+	}
+	return false
+}
+
+// addAllDayItem adds the all-day occurrence to the days of its dates.
+func addAllDayItem(days []AgendaDay, dayIndexes map[string]int, task Task, occurrence TimeSlot) {
+	start, end := AllDayRange(occurrence.StartTime.UTC(), occurrence.EndTime.UTC())
+	for date := start; date.Before(end); date = date.AddDate(0, 0, 1) {
+		index, ok := dayIndexes[date.Format("2006-01-02")]
+		if !ok {
+			continue
+		}
+		item := newAgendaItem(task, occurrence)
+		item.DayStartTime, item.DayEndTime = date, date.AddDate(0, 0, 1)
+		item.ContinuesFromPreviousDay = date.After(start)
+		item.ContinuesNextDay = date.AddDate(0, 0, 1).Before(end)
+		days[index].Items = append(days[index].Items, item)
+	}
+}
+
+// addTimedItem adds the occurrence to every day of loc it overlaps, split at midnight.
+func addTimedItem(days []AgendaDay, dayIndexes map[string]int, task Task, occurrence TimeSlot, loc *time.Location) {
+	start := occurrence.StartTime
+	for {
+		localStart := start.In(loc)
+		nextDay := time.Date(localStart.Year(), localStart.Month(), localStart.Day()+1, 0, 0, 0, 0, loc)
+		end := occurrence.EndTime
+		if nextDay.Before(end) {
+			end = nextDay
+		}
+		if index, ok := dayIndexes[localStart.Format("2006-01-02")]; ok {
+			item := newAgendaItem(task, occurrence)
+			item.DayStartTime, item.DayEndTime = start, end
+			item.ContinuesFromPreviousDay = start.After(occurrence.StartTime)
+			item.ContinuesNextDay = end.Before(occurrence.EndTime)
+			days[index].Items = append(days[index].Items, item)
+		}
+		if !end.Before(occurrence.EndTime) {
+			return
+		}
+		start = end
+	}
+}
+
+func newAgendaItem(task Task, occurrence TimeSlot) AgendaItem {
+	return AgendaItem{
+		TaskID:    task.ID,
+		Title:     task.Title,
+		Status:    task.Status,
+		ProjectID: task.ProjectID,
+		AllDay:    task.AllDay,
+		Recurring: task.Recurrence != "",
+		StartTime: occurrence.StartTime,
+		EndTime:   occurrence.EndTime,
+	}
+}
+
+// GetCalendarTasks returns the tasks in the calendar of the user that end
+// after since, the recurring tasks and the unscheduled tasks.
+func (s *Service) GetCalendarTasks(ctx context.Context, userID string, since time.Time) ([]Task, error) {
+	log := s.log.WithContext(ctx).WithField("userId", userID).WithField("since", since)
+	filter := tenant.Filter(ctx, "workspaceId", bson.M{
+		"$and": []bson.M{
+			{"$or": []bson.M{
+				{"userId": userID},
+				{"assignees": userID},
+			}},
+			{"$or": []bson.M{
+				{"endTime": bson.M{"$gte": since}},
+				{"recurrence": bson.M{"$exists": true}},
+				{"startTime": bson.M{"$exists": false}},
+			}},
+		},
+	})
+	cursor, err := s.dbCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"startTime": 1}))
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve calendar tasks from db")
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var tasks []Task
+	err = cursor.All(ctx, &tasks)
+	if err != nil {
+		log.WithError(err).Error("failed to decode retrieved calendar tasks")
+		return nil, err
+	}
+	return tasks, nil
+}
@@ -0,0 +1,212 @@
+package tasks
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// formatAgenda formats the items of the days as "date start-end" in UTC,
+// items continuing from the previous day start with < and items
+// continuing on the next day end with >.
+func formatAgenda(days []AgendaDay) string {
+	var formatted []string
+	for _, day := range days {
+		for _, item := range day.Items {
+			value := day.Date + " " + formatSlots([]TimeSlot{{item.DayStartTime, item.DayEndTime}})
+			if item.ContinuesFromPreviousDay {
+				value = "<" + value
+			}
+			if item.ContinuesNextDay {
+				value += ">"
+			}
+			formatted = append(formatted, value)
+		}
+	}
+	return strings.Join(formatted, ",")
+}
+
+func utcTime(month time.Month, day, hour, minute int) time.Time {
+	return time.Date(2022, month, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestAddTimedItem(t *testing.T) {
+	london := mustLoadLocation(t, "Europe/London")
+	newYork := mustLoadLocation(t, "America/New_York")
+	tests := []struct {
+		name       string
+		from, to   time.Time
+		loc        *time.Location
+		occurrence TimeSlot
+		want       string
+	}{
+		{
+			name:       "within a day",
+			from:       utcTime(3, 7, 0, 0),
+			to:         utcTime(3, 8, 0, 0),
+			loc:        time.UTC,
+			occurrence: TimeSlot{utcTime(3, 7, 10, 0), utcTime(3, 7, 11, 0)},
+			want:       "2022-03-07 03-07 10:00-11:00",
+		},
+		{
+			name:       "ending at midnight",
+			from:       utcTime(3, 7, 0, 0),
+			to:         utcTime(3, 9, 0, 0),
+			loc:        time.UTC,
+			occurrence: TimeSlot{utcTime(3, 7, 22, 0), utcTime(3, 8, 0, 0)},
+			want:       "2022-03-07 03-07 22:00-03-08 00:00",
+		},
+		{
+			name:       "across midnight",
+			from:       utcTime(3, 7, 0, 0),
+			to:         utcTime(3, 9, 0, 0),
+			loc:        time.UTC,
+			occurrence: TimeSlot{utcTime(3, 7, 22, 0), utcTime(3, 8, 2, 0)},
+			want:       "2022-03-07 03-07 22:00-03-08 00:00>,<2022-03-08 03-08 00:00-02:00",
+		},
+		{
+			name:       "across several days",
+			from:       utcTime(3, 7, 0, 0),
+			to:         utcTime(3, 10, 0, 0),
+			loc:        time.UTC,
+			occurrence: TimeSlot{utcTime(3, 7, 22, 0), utcTime(3, 9, 2, 0)},
+			want:       "2022-03-07 03-07 22:00-03-08 00:00>,<2022-03-08 03-08 00:00-03-09 00:00>,<2022-03-09 03-09 00:00-02:00",
+		},
+		{
+			name:       "days outside the range",
+			from:       utcTime(3, 8, 0, 0),
+			to:         utcTime(3, 9, 0, 0),
+			loc:        time.UTC,
+			occurrence: TimeSlot{utcTime(3, 7, 22, 0), utcTime(3, 9, 2, 0)},
+			want:       "<2022-03-08 03-08 00:00-03-09 00:00>",
+		},
+		{
+			name:       "day of the location",
+			from:       time.Date(2022, 3, 6, 0, 0, 0, 0, newYork),
+			to:         time.Date(2022, 3, 8, 0, 0, 0, 0, newYork),
+			loc:        newYork,
+			occurrence: TimeSlot{utcTime(3, 7, 2, 0), utcTime(3, 7, 3, 0)},
+			// 21:00 to 22:00 EST on sunday.
+			want: "2022-03-06 03-07 02:00-03:00",
+		},
+		{
+			name:       "split at midnight of the location",
+			from:       time.Date(2022, 3, 6, 0, 0, 0, 0, newYork),
+			to:         time.Date(2022, 3, 8, 0, 0, 0, 0, newYork),
+			loc:        newYork,
+			occurrence: TimeSlot{utcTime(3, 7, 4, 0), utcTime(3, 7, 6, 0)},
+			want:       "2022-03-06 03-07 04:00-05:00>,<2022-03-07 03-07 05:00-06:00",
+		},
+		{
+			name:       "spring forward",
+			from:       time.Date(2022, 3, 26, 0, 0, 0, 0, london),
+			to:         time.Date(2022, 3, 29, 0, 0, 0, 0, london),
+			loc:        london,
+			occurrence: TimeSlot{utcTime(3, 26, 22, 0), utcTime(3, 27, 23, 30)},
+			// 2022-03-27 is 23 hours long, it ends at 23:00 UTC.
+			want: "2022-03-26 03-26 22:00-03-27 00:00>,<2022-03-27 03-27 00:00-23:00>,<2022-03-28 03-27 23:00-23:30",
+		},
+		{
+			name:       "fall back",
+			from:       time.Date(2022, 10, 29, 0, 0, 0, 0, london),
+			to:         time.Date(2022, 11, 1, 0, 0, 0, 0, london),
+			loc:        london,
+			occurrence: TimeSlot{utcTime(10, 29, 22, 0), utcTime(10, 31, 1, 0)},
+			// 2022-10-30 is 25 hours long, it starts at 23:00 UTC the day before.
+			want: "2022-10-29 10-29 22:00-23:00>,<2022-10-30 10-29 23:00-10-31 00:00>,<2022-10-31 10-31 00:00-01:00",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			days, dayIndexes := agendaDays(tt.from, tt.to, tt.loc)
+			addTimedItem(days, dayIndexes, Task{ID: "task"}, tt.occurrence, tt.loc)
+			if got := formatAgenda(days); got != tt.want {
+				t.Errorf("addTimedItem() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddAllDayItem(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	tests := []struct {
+		name       string
+		from, to   time.Time
+		loc        *time.Location
+		occurrence TimeSlot
+		want       string
+	}{
+		{
+			name:       "one day",
+			from:       utcTime(3, 7, 0, 0),
+			to:         utcTime(3, 9, 0, 0),
+			loc:        time.UTC,
+			occurrence: TimeSlot{utcTime(3, 7, 0, 0), utcTime(3, 8, 0, 0)},
+			want:       "2022-03-07 03-07 00:00-03-08 00:00",
+		},
+		{
+			name:       "several days",
+			from:       utcTime(3, 7, 0, 0),
+			to:         utcTime(3, 10, 0, 0),
+			loc:        time.UTC,
+			occurrence: TimeSlot{utcTime(3, 7, 0, 0), utcTime(3, 10, 0, 0)},
+			want:       "2022-03-07 03-07 00:00-03-08 00:00>,<2022-03-08 03-08 00:00-03-09 00:00>,<2022-03-09 03-09 00:00-03-10 00:00",
+		},
+		{
+			name:       "end during a day",
+			from:       utcTime(3, 7, 0, 0),
+			to:         utcTime(3, 10, 0, 0),
+			loc:        time.UTC,
+			occurrence: TimeSlot{utcTime(3, 7, 0, 0), utcTime(3, 8, 12, 0)},
+			want:       "2022-03-07 03-07 00:00-03-08 00:00>,<2022-03-08 03-08 00:00-03-09 00:00",
+		},
+		{
+			name:       "days outside the range",
+			from:       utcTime(3, 8, 0, 0),
+			to:         utcTime(3, 9, 0, 0),
+			loc:        time.UTC,
+			occurrence: TimeSlot{utcTime(3, 7, 0, 0), utcTime(3, 10, 0, 0)},
+			want:       "<2022-03-08 03-08 00:00-03-09 00:00>",
+		},
+		{
+			name:       "same dates in every location",
+			from:       time.Date(2022, 3, 6, 0, 0, 0, 0, newYork),
+			to:         time.Date(2022, 3, 9, 0, 0, 0, 0, newYork),
+			loc:        newYork,
+			occurrence: TimeSlot{utcTime(3, 7, 0, 0), utcTime(3, 8, 0, 0)},
+			want:       "2022-03-07 03-07 00:00-03-08 00:00",
+		},
+		{
+			name:       "across the spring forward",
+			from:       time.Date(2022, 3, 12, 0, 0, 0, 0, newYork),
+			to:         time.Date(2022, 3, 15, 0, 0, 0, 0, newYork),
+			loc:        newYork,
+			occurrence: TimeSlot{utcTime(3, 13, 0, 0), utcTime(3, 15, 0, 0)},
+			want:       "2022-03-13 03-13 00:00-03-14 00:00>,<2022-03-14 03-14 00:00-03-15 00:00",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			days, dayIndexes := agendaDays(tt.from, tt.to, tt.loc)
+			addAllDayItem(days, dayIndexes, Task{ID: "task", AllDay: true}, tt.occurrence)
+			if got := formatAgenda(days); got != tt.want {
+				t.Errorf("addAllDayItem() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAgendaDays(t *testing.T) {
+	london := mustLoadLocation(t, "Europe/London")
+	days, dayIndexes := agendaDays(time.Date(2022, 3, 26, 12, 0, 0, 0, london), time.Date(2022, 3, 28, 0, 0, 0, 0, london), london)
+	var dates []string
+	for i, day := range days {
+		dates = append(dates, day.Date)
+		if dayIndexes[day.Date] != i {
+			t.Errorf("agendaDays() index of %s = %d, want %d", day.Date, dayIndexes[day.Date], i)
+		}
+	}
+	if got := strings.Join(dates, ","); got != "2022-03-26,2022-03-27" {
+		t.Errorf("agendaDays() = %s, want 2022-03-26,2022-03-27", got)
+	}
+}
@@ -0,0 +1,319 @@
+package tasks
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/wisdommatt/todo-list-api/internal/tenant"
+	"github.com/wisdommatt/todo-list-api/services/users"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// maxSuggestionWindow bounds how far from the requested time slots are suggested.
+const maxSuggestionWindow = 7 * 24 * time.Hour
+
+// TimeSlot is a period of time in a calendar.
+type TimeSlot struct {
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+}
+
+// WorkingHours are the working hours of a day in minutes from midnight.
+type WorkingHours struct {
+	StartMinute int `json:"startMinute"`
+	EndMinute   int `json:"endMinute"`
+}
+
+// AvailabilityOptions configures how free slots are computed.
+type AvailabilityOptions struct {
+	// WorkingHours are the working hours per weekday, days without working hours are skipped.
+	WorkingHours map[time.Weekday]WorkingHours
+	// MinGap is the minimum free time kept between a free slot and busy periods.
+	MinGap time.Duration
+	// Location is the time zone working hours are expressed in.
+	Location *time.Location
+}
+
+// DefaultAvailabilityOptions returns options with 09:00 to 17:00 working
+// hours from monday to friday in UTC and no gap between tasks.
+func DefaultAvailabilityOptions() AvailabilityOptions {
+	workingHours := map[time.Weekday]WorkingHours{}
+	for day := time.Monday; day <= time.Friday; day++ {
+		workingHours[day] = WorkingHours{StartMinute: 9 * 60, EndMinute: 17 * 60}
+	}
+	return AvailabilityOptions{
+		WorkingHours: workingHours,
+		Location:     time.UTC,
+	}
+}
+
+// UserAvailabilityOptions returns the availability options matching the
+// working hours and time zone of the user preferences.
+func UserAvailabilityOptions(preferences users.Preferences) AvailabilityOptions {
+	opts := AvailabilityOptions{
+		WorkingHours: map[time.Weekday]WorkingHours{},
+		Location:     preferences.Location(),
+	}
+	for name, hours := range preferences.WorkingHours {
+		day, ok := users.ParseWeekday(name)
+		if !ok {
+			continue
+		}
+		start, startErr := users.ParseClock(hours.Start)
+		end, endErr := users.ParseClock(hours.End)
+		if startErr == nil && endErr == nil {
+			opts.WorkingHours[day] = WorkingHours{StartMinute: start, EndMinute: end}
+		}
+	}
+	return opts
+}
+
+// WithinWorkingHours reports whether the time range is inside the working
+// hours of a single day.
+func WithinWorkingHours(startTime, endTime time.Time, opts AvailabilityOptions) bool {
+	windows := workingWindows(startTime, endTime, opts)
+	return len(windows) == 1 && windows[0].StartTime.Equal(startTime) && windows[0].EndTime.Equal(endTime)
+}
+
+// GetBusyPeriods returns the merged periods between from and to during
+// which any of the users has a scheduled task.
+func (s *Service) GetBusyPeriods(ctx context.Context, userIDs []string, from, to time.Time) ([]TimeSlot, error) {
+	tasks, err := s.getBusyTasks(ctx, userIDs, from, to, nil)
+	if err != nil {
+		return nil, err
+	}
+	busy := make([]TimeSlot, 0, len(tasks))
+	for _, task := range tasks {
+		busy = append(busy, TimeSlot{StartTime: task.StartTime, EndTime: task.EndTime})
+	}
+	return mergeSlots(busy), nil
+}
+
+// getBusyTasks returns the tasks between from and to in the calendar of any
+// of the users, ignoring all-day tasks and the tasks with excludeTaskIDs,
+// followed by the busy events of the busy providers. Recurring tasks are
+// returned once per occurrence between from and to, with the times of the
+// occurrence.
+func (s *Service) getBusyTasks(ctx context.Context, userIDs []string, from, to time.Time, excludeTaskIDs []string) ([]Task, error) {
+	log := s.log.WithContext(ctx).WithField("userIds", userIDs).WithField("from", from).WithField("to", to)
+	filter := tenant.Filter(ctx, "workspaceId", bson.M{
+		"$and": []bson.M{
+			{"$or": []bson.M{
+				{"userId": bson.M{"$in": userIDs}},
+				{"assignees": bson.M{"$in": userIDs}},
+			}},
+			{"$or": []bson.M{
+				{
+					"startTime": bson.M{"$lt": to},
+					"endTime":   bson.M{"$gt": from},
+				},
+				{
+					"recurrence": bson.M{"$exists": true},
+					"startTime":  bson.M{"$lt": to},
+				},
+			}},
+		},
+		"allDay": bson.M{"$ne": true},
+	})
+	if len(excludeTaskIDs) > 0 {
+		filter["_id"] = bson.M{"$nin": excludeTaskIDs}
+	}
+	cursor, err := s.dbCollection.Find(ctx, filter)
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve busy tasks from db")
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var tasks []Task
+	err = cursor.All(ctx, &tasks)
+	if err != nil {
+		log.WithError(err).Error("failed to decode retrieved busy tasks")
+		return nil, err
+	}
+	tasks = s.expandOccurrences(ctx, tasks, from, to)
+	busyTasks, err := s.getProvidedBusyTasks(ctx, userIDs, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return append(tasks, busyTasks...), nil
+}
+
+// expandOccurrences replaces the recurring tasks with their occurrences
+// overlapping from and to. Occurrences repeat at the wall clock time of the
+// task owner time zone, like in their agenda.
+func (s *Service) expandOccurrences(ctx context.Context, tasks []Task, from, to time.Time) []Task {
+	expanded := make([]Task, 0, len(tasks))
+	locations := map[string]*time.Location{}
+	for _, task := range tasks {
+		if task.Recurrence == "" {
+			expanded = append(expanded, task)
+			continue
+		}
+		loc, ok := locations[task.UserID]
+		if !ok {
+			loc = time.UTC
+			owner, err := s.usersService.GetUser(ctx, task.UserID)
+			if err == nil {
+				loc = owner.GetPreferences().Location()
+			}
+			locations[task.UserID] = loc
+		}
+		for _, occurrence := range taskOccurrences(task, from, to, loc) {
+			if occurrence.StartTime.Before(to) && occurrence.EndTime.After(from) {
+				task.StartTime, task.EndTime = occurrence.StartTime, occurrence.EndTime
+				expanded = append(expanded, task)
+			}
+		}
+	}
+	return expanded
+}
+
+// FindFreeSlots returns the free periods of at least duration between from
+// and to, within working hours, during which none of the users is busy.
+func (s *Service) FindFreeSlots(ctx context.Context, userIDs []string, from, to time.Time, duration time.Duration, opts AvailabilityOptions) ([]TimeSlot, error) {
+	busy, err := s.GetBusyPeriods(ctx, userIDs, from.Add(-opts.MinGap), to.Add(opts.MinGap))
+	if err != nil {
+		return nil, err
+	}
+	return freeSlots(from, to, busy, duration, opts), nil
+}
+
+// SuggestSlots returns up to limit free slots of exactly duration, the
+// closest to near first. Slots in the past are never suggested.
+func (s *Service) SuggestSlots(ctx context.Context, userIDs []string, near time.Time, duration time.Duration, opts AvailabilityOptions, limit int) ([]TimeSlot, error) {
+	from := near.Add(-maxSuggestionWindow)
+	if now := time.Now(); from.Before(now) {
+		from = now
+	}
+	to := near.Add(maxSuggestionWindow)
+	if !to.After(from) {
+		return []TimeSlot{}, nil
+	}
+	free, err := s.FindFreeSlots(ctx, userIDs, from, to, duration, opts)
+	if err != nil {
+		return nil, err
+	}
+	suggestions := make([]TimeSlot, 0, len(free))
+	for _, slot := range free {
+		// picking the start time in the free slot closest to the requested time.
+		start := near
+		if start.Before(slot.StartTime) {
+			start = slot.StartTime
+		}
+		if latestStart := slot.EndTime.Add(-duration); start.After(latestStart) {
+			start = latestStart
+		}
+		suggestions = append(suggestions, TimeSlot{StartTime: start, EndTime: start.Add(duration)})
+	}
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return absDuration(suggestions[i].StartTime.Sub(near)) < absDuration(suggestions[j].StartTime.Sub(near))
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions, nil
+}
+
+// freeSlots returns the periods of at least duration between from and to,
+// within working hours, that are at least the options gap away from busy periods.
+func freeSlots(from, to time.Time, busy []TimeSlot, duration time.Duration, opts AvailabilityOptions) []TimeSlot {
+	padded := make([]TimeSlot, 0, len(busy))
+	for _, slot := range busy {
+		padded = append(padded, TimeSlot{StartTime: slot.StartTime.Add(-opts.MinGap), EndTime: slot.EndTime.Add(opts.MinGap)})
+	}
+	padded = mergeSlots(padded)
+
+	var free []TimeSlot
+	for _, window := range workingWindows(from, to, opts) {
+		for _, slot := range subtractSlots(window, padded) {
+			if slot.EndTime.Sub(slot.StartTime) >= duration {
+				free = append(free, slot)
+			}
+		}
+	}
+	return free
+}
+
+// workingWindows returns the working hours periods between from and to.
+//
+// Working hours are built from wall clock times in the options location,
+// so days with a daylight saving time change are handled correctly.
+func workingWindows(from, to time.Time, opts AvailabilityOptions) []TimeSlot {
+	loc := opts.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	var windows []TimeSlot
+	localFrom := from.In(loc)
+	day := time.Date(localFrom.Year(), localFrom.Month(), localFrom.Day(), 0, 0, 0, 0, loc)
+	for day.Before(to) {
+		hours, ok := opts.WorkingHours[day.Weekday()]
+		if ok && hours.EndMinute > hours.StartMinute {
+			window := TimeSlot{
+				StartTime: time.Date(day.Year(), day.Month(), day.Day(), 0, hours.StartMinute, 0, 0, loc),
+				EndTime:   time.Date(day.Year(), day.Month(), day.Day(), 0, hours.EndMinute, 0, 0, loc),
+			}
+			if window.StartTime.Before(from) {
+				window.StartTime = from
+			}
+			if window.EndTime.After(to) {
+				window.EndTime = to
+			}
+			if window.EndTime.After(window.StartTime) {
+				windows = append(windows, window)
+			}
+		}
+		day = time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, loc)
+	}
+	return windows
+}
+
+// mergeSlots sorts the slots and merges the ones that overlap or touch.
+func mergeSlots(slots []TimeSlot) []TimeSlot {
+	if len(slots) == 0 {
+		return slots
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i].StartTime.Before(slots[j].StartTime) })
+	merged := []TimeSlot{slots[0]}
+	for _, slot := range slots[1:] {
+		last := &merged[len(merged)-1]
+		if !slot.StartTime.After(last.EndTime) {
+			if slot.EndTime.After(last.EndTime) {
+				last.EndTime = slot.EndTime
+			}
+			continue
+		}
+		merged = append(merged, slot)
+	}
+	return merged
+}
+
+// subtractSlots returns the parts of window not covered by the sorted and merged busy slots.
+func subtractSlots(window TimeSlot, busy []TimeSlot) []TimeSlot {
+	var free []TimeSlot
+	start := window.StartTime
+	for _, slot := range busy {
+		if !slot.EndTime.After(start) {
+			continue
+		}
+		if !slot.StartTime.Before(window.EndTime) {
+			break
+		}
+		if slot.StartTime.After(start) {
+			free = append(free, TimeSlot{StartTime: start, EndTime: slot.StartTime})
+		}
+		start = slot.EndTime
+	}
+	if window.EndTime.After(start) {
+		free = append(free, TimeSlot{StartTime: start, EndTime: window.EndTime})
+	}
+	return free
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,408 @@
+package tasks
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// monday is 2022-03-07, a monday.
+func monday(hour, minute int) time.Time {
+	return time.Date(2022, 3, 7, hour, minute, 0, 0, time.UTC)
+}
+
+// formatSlots formats the slots in UTC, e.g "03-07 09:00-10:00".
+func formatSlots(slots []TimeSlot) string {
+	formatted := make([]string, 0, len(slots))
+	for _, slot := range slots {
+		start, end := slot.StartTime.UTC(), slot.EndTime.UTC()
+		if start.YearDay() == end.YearDay() {
+			formatted = append(formatted, start.Format("01-02 15:04")+"-"+end.Format("15:04"))
+		} else {
+			formatted = append(formatted, start.Format("01-02 15:04")+"-"+end.Format("01-02 15:04"))
+		}
+	}
+	return strings.Join(formatted, ",")
+}
+
+func everyDayOptions(loc *time.Location, startMinute, endMinute int) AvailabilityOptions {
+	opts := AvailabilityOptions{WorkingHours: map[time.Weekday]WorkingHours{}, Location: loc}
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		opts.WorkingHours[day] = WorkingHours{StartMinute: startMinute, EndMinute: endMinute}
+	}
+	return opts
+}
+
+func TestWorkingWindows(t *testing.T) {
+	london := mustLoadLocation(t, "Europe/London")
+	newYork := mustLoadLocation(t, "America/New_York")
+	tests := []struct {
+		name string
+		from time.Time
+		to   time.Time
+		opts AvailabilityOptions
+		want string
+	}{
+		{
+			name: "weekends are skipped",
+			from: time.Date(2022, 3, 4, 0, 0, 0, 0, time.UTC),
+			to:   time.Date(2022, 3, 8, 0, 0, 0, 0, time.UTC),
+			opts: DefaultAvailabilityOptions(),
+			want: "03-04 09:00-17:00,03-07 09:00-17:00",
+		},
+		{
+			name: "clipped to from and to",
+			from: monday(10, 30),
+			to:   monday(15, 0),
+			opts: DefaultAvailabilityOptions(),
+			want: "03-07 10:30-15:00",
+		},
+		{
+			name: "from and to outside working hours",
+			from: monday(17, 0),
+			to:   time.Date(2022, 3, 8, 9, 0, 0, 0, time.UTC),
+			opts: DefaultAvailabilityOptions(),
+			want: "",
+		},
+		{
+			name: "days of the location",
+			// 2022-03-06 20:00 UTC is monday in Auckland.
+			from: time.Date(2022, 3, 6, 0, 0, 0, 0, time.UTC),
+			to:   time.Date(2022, 3, 7, 0, 0, 0, 0, time.UTC),
+			opts: AvailabilityOptions{
+				WorkingHours: DefaultAvailabilityOptions().WorkingHours,
+				Location:     mustLoadLocation(t, "Pacific/Auckland"),
+			},
+			// 09:00 to 17:00 in NZDT (UTC+13).
+			want: "03-06 20:00-03-07 00:00",
+		},
+		{
+			name: "nil location is utc",
+			from: monday(0, 0),
+			to:   monday(23, 0),
+			opts: AvailabilityOptions{WorkingHours: DefaultAvailabilityOptions().WorkingHours},
+			want: "03-07 09:00-17:00",
+		},
+		{
+			name: "empty working hours",
+			from: monday(0, 0),
+			to:   monday(23, 0),
+			opts: AvailabilityOptions{WorkingHours: map[time.Weekday]WorkingHours{time.Monday: {StartMinute: 600, EndMinute: 600}}},
+			want: "",
+		},
+		{
+			name: "spring forward in london",
+			// clocks go from 01:00 GMT to 02:00 BST on 2022-03-27.
+			from: time.Date(2022, 3, 26, 0, 0, 0, 0, time.UTC),
+			to:   time.Date(2022, 3, 28, 0, 0, 0, 0, time.UTC),
+			opts: everyDayOptions(london, 9*60, 17*60),
+			want: "03-26 09:00-17:00,03-27 08:00-16:00",
+		},
+		{
+			name: "working hours across the spring forward",
+			from: time.Date(2022, 3, 27, 0, 0, 0, 0, time.UTC),
+			to:   time.Date(2022, 3, 27, 12, 0, 0, 0, time.UTC),
+			opts: everyDayOptions(london, 0, 5*60),
+			// 00:00 GMT to 05:00 BST is four hours long.
+			want: "03-27 00:00-04:00",
+		},
+		{
+			name: "fall back in new york",
+			// clocks go from 02:00 EDT to 01:00 EST on 2022-11-06.
+			from: time.Date(2022, 11, 5, 0, 0, 0, 0, time.UTC),
+			to:   time.Date(2022, 11, 7, 0, 0, 0, 0, time.UTC),
+			opts: everyDayOptions(newYork, 9*60, 17*60),
+			want: "11-05 13:00-21:00,11-06 14:00-22:00",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatSlots(workingWindows(tt.from, tt.to, tt.opts)); got != tt.want {
+				t.Errorf("workingWindows() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("LoadLocation() error = %v", err)
+	}
+	return loc
+}
+
+func TestMergeSlots(t *testing.T) {
+	tests := []struct {
+		name  string
+		slots []TimeSlot
+		want  string
+	}{
+		{name: "empty", want: ""},
+		{
+			name:  "unsorted",
+			slots: []TimeSlot{{monday(13, 0), monday(14, 0)}, {monday(9, 0), monday(10, 0)}},
+			want:  "03-07 09:00-10:00,03-07 13:00-14:00",
+		},
+		{
+			name:  "touching",
+			slots: []TimeSlot{{monday(9, 0), monday(10, 0)}, {monday(10, 0), monday(11, 0)}},
+			want:  "03-07 09:00-11:00",
+		},
+		{
+			name:  "overlapping",
+			slots: []TimeSlot{{monday(10, 0), monday(12, 0)}, {monday(9, 0), monday(10, 30)}},
+			want:  "03-07 09:00-12:00",
+		},
+		{
+			name:  "contained",
+			slots: []TimeSlot{{monday(9, 0), monday(12, 0)}, {monday(10, 0), monday(11, 0)}, {monday(12, 0), monday(12, 30)}},
+			want:  "03-07 09:00-12:30",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatSlots(mergeSlots(tt.slots)); got != tt.want {
+				t.Errorf("mergeSlots() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubtractSlots(t *testing.T) {
+	window := TimeSlot{StartTime: monday(9, 0), EndTime: monday(17, 0)}
+	tests := []struct {
+		name string
+		busy []TimeSlot
+		want string
+	}{
+		{name: "not busy", want: "03-07 09:00-17:00"},
+		{
+			name: "busy outside the window",
+			busy: []TimeSlot{{monday(7, 0), monday(9, 0)}, {monday(17, 0), monday(18, 0)}},
+			want: "03-07 09:00-17:00",
+		},
+		{
+			name: "busy across the window edges",
+			busy: []TimeSlot{{monday(8, 0), monday(10, 0)}, {monday(16, 0), monday(18, 0)}},
+			want: "03-07 10:00-16:00",
+		},
+		{
+			name: "busy inside the window",
+			busy: []TimeSlot{{monday(10, 0), monday(11, 0)}, {monday(13, 0), monday(14, 0)}},
+			want: "03-07 09:00-10:00,03-07 11:00-13:00,03-07 14:00-17:00",
+		},
+		{name: "busy the whole window", busy: []TimeSlot{{monday(8, 0), monday(18, 0)}}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatSlots(subtractSlots(window, tt.busy)); got != tt.want {
+				t.Errorf("subtractSlots() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFreeSlots(t *testing.T) {
+	withGap := DefaultAvailabilityOptions()
+	withGap.MinGap = 15 * time.Minute
+	tests := []struct {
+		name     string
+		busy     []TimeSlot
+		duration time.Duration
+		opts     AvailabilityOptions
+		want     string
+	}{
+		{name: "not busy", duration: time.Hour, opts: DefaultAvailabilityOptions(), want: "03-07 09:00-17:00"},
+		{
+			name:     "min gap around busy slots",
+			busy:     []TimeSlot{{monday(11, 0), monday(12, 0)}},
+			duration: time.Hour,
+			opts:     withGap,
+			want:     "03-07 09:00-10:45,03-07 12:15-17:00",
+		},
+		{
+			name:     "min gap between close busy slots",
+			busy:     []TimeSlot{{monday(10, 0), monday(11, 0)}, {monday(11, 20), monday(12, 0)}},
+			duration: time.Minute,
+			opts:     withGap,
+			// the 20 minutes between the slots are less than both gaps.
+			want: "03-07 09:00-09:45,03-07 12:15-17:00",
+		},
+		{
+			name:     "min gap of busy slots outside the range",
+			busy:     []TimeSlot{{monday(8, 0), monday(9, 0)}},
+			duration: time.Hour,
+			opts:     withGap,
+			want:     "03-07 09:15-17:00",
+		},
+		{
+			name:     "touching busy slots",
+			busy:     []TimeSlot{{monday(10, 0), monday(11, 0)}, {monday(11, 0), monday(12, 0)}},
+			duration: time.Hour,
+			opts:     DefaultAvailabilityOptions(),
+			want:     "03-07 09:00-10:00,03-07 12:00-17:00",
+		},
+		{
+			name:     "overlapping busy slots",
+			busy:     []TimeSlot{{monday(11, 0), monday(12, 0)}, {monday(10, 0), monday(11, 30)}},
+			duration: time.Hour,
+			opts:     DefaultAvailabilityOptions(),
+			want:     "03-07 09:00-10:00,03-07 12:00-17:00",
+		},
+		{
+			name:     "free time shorter than the duration",
+			busy:     []TimeSlot{{monday(9, 30), monday(16, 30)}},
+			duration: time.Hour,
+			opts:     DefaultAvailabilityOptions(),
+			want:     "",
+		},
+		{
+			name:     "free time of exactly the duration",
+			busy:     []TimeSlot{{monday(10, 0), monday(16, 0)}},
+			duration: time.Hour,
+			opts:     DefaultAvailabilityOptions(),
+			want:     "03-07 09:00-10:00,03-07 16:00-17:00",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := formatSlots(freeSlots(monday(0, 0), monday(23, 0), tt.busy, tt.duration, tt.opts))
+			if got != tt.want {
+				t.Errorf("freeSlots() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithinWorkingHours(t *testing.T) {
+	opts := DefaultAvailabilityOptions()
+	tests := []struct {
+		name       string
+		start, end time.Time
+		want       bool
+	}{
+		{name: "inside", start: monday(10, 0), end: monday(11, 0), want: true},
+		{name: "whole day", start: monday(9, 0), end: monday(17, 0), want: true},
+		{name: "starts early", start: monday(8, 30), end: monday(9, 30)},
+		{name: "ends late", start: monday(16, 30), end: monday(17, 30)},
+		{name: "across days", start: monday(16, 0), end: time.Date(2022, 3, 8, 10, 0, 0, 0, time.UTC)},
+		{name: "weekend", start: time.Date(2022, 3, 6, 10, 0, 0, 0, time.UTC), end: time.Date(2022, 3, 6, 11, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := WithinWorkingHours(tt.start, tt.end, opts); got != tt.want {
+				t.Errorf("WithinWorkingHours() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func busyTaskDocument(id string, start, end time.Time) bson.D {
+	return bson.D{
+		{Key: "_id", Value: id},
+		{Key: "userId", Value: "user"},
+		{Key: "startTime", Value: start},
+		{Key: "endTime", Value: end},
+	}
+}
+
+func TestSuggestSlots(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	ns := "db.tasks"
+	// a monday at noon far enough ahead that no slot is in the past.
+	near := time.Now().UTC().AddDate(0, 0, 14)
+	near = time.Date(near.Year(), near.Month(), near.Day()+int(time.Monday-near.Weekday()+7)%7, 12, 0, 0, 0, time.UTC)
+	at := func(days, hour, minute int) time.Time {
+		return time.Date(near.Year(), near.Month(), near.Day()+days, hour, minute, 0, 0, time.UTC)
+	}
+	format := func(slots []TimeSlot) string {
+		formatted := make([]string, 0, len(slots))
+		for _, slot := range slots {
+			days := int(slot.StartTime.Sub(at(0, 0, 0)).Hours()) / 24
+			if slot.StartTime.Before(at(0, 0, 0)) {
+				days--
+			}
+			formatted = append(formatted, slot.StartTime.Format("15:04")+"-"+slot.EndTime.Format("15:04")+"+"+string(rune('0'+days+7)))
+		}
+		return strings.Join(formatted, ",")
+	}
+
+	tests := []struct {
+		name  string
+		busy  []bson.D
+		limit int
+		// want are the suggestions with their day relative to near, +7
+		// being the day of near.
+		want string
+	}{
+		{name: "near is free", limit: 2, want: "12:00-13:00+7,09:00-10:00+8"},
+		{
+			name:  "clamped to the closest free time",
+			busy:  []bson.D{busyTaskDocument("busy", at(0, 11, 0), at(0, 12, 30))},
+			limit: 4,
+			// the start time closest to near in each free slot: after the
+			// busy task, before it and the next working days, which are
+			// closer than the friday before.
+			want: "12:30-13:30+7,10:00-11:00+7,09:00-10:00+8,09:00-10:00+9",
+		},
+		{
+			name:  "limit",
+			busy:  []bson.D{busyTaskDocument("busy", at(0, 11, 0), at(0, 12, 30))},
+			limit: 1,
+			want:  "12:30-13:30+7",
+		},
+		{
+			name:  "busy all day",
+			busy:  []bson.D{busyTaskDocument("busy", at(0, 0, 0), at(1, 0, 0))},
+			limit: 3,
+			want:  "09:00-10:00+8,09:00-10:00+9,16:00-17:00+4",
+		},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, tt.busy...))
+			slots, err := newMockService(mt).SuggestSlots(context.Background(), []string{"user"}, near, time.Hour, DefaultAvailabilityOptions(), tt.limit)
+			if err != nil {
+				mt.Fatalf("SuggestSlots() error = %v", err)
+			}
+			if got := format(slots); got != tt.want {
+				mt.Errorf("SuggestSlots() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	mt.Run("past slots", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch))
+		now := time.Now()
+		slots, err := newMockService(mt).SuggestSlots(context.Background(), []string{"user"}, now.Add(-24*time.Hour), time.Hour, everyDayOptions(time.UTC, 0, 24*60-1), 5)
+		if err != nil {
+			mt.Fatalf("SuggestSlots() error = %v", err)
+		}
+		if len(slots) == 0 {
+			mt.Fatalf("SuggestSlots() = none, want slots from now")
+		}
+		for _, slot := range slots {
+			if slot.StartTime.Before(now) {
+				mt.Errorf("SuggestSlots() = %v, want no slot in the past", slot)
+			}
+		}
+	})
+
+	mt.Run("near too far in the past", func(mt *mtest.T) {
+		slots, err := newMockService(mt).SuggestSlots(context.Background(), []string{"user"}, time.Now().Add(-8*24*time.Hour), time.Hour, DefaultAvailabilityOptions(), 5)
+		if err != nil || len(slots) != 0 {
+			mt.Errorf("SuggestSlots() = %v, %v, want none", slots, err)
+		}
+		if len(mt.GetAllStartedEvents()) != 0 {
+			mt.Errorf("SuggestSlots() queried the db, want no query")
+		}
+	})
+}
@@ -0,0 +1,198 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/wisdommatt/todo-list-api/internal/apperror"
+	"github.com/wisdommatt/todo-list-api/internal/tenant"
+	"github.com/wisdommatt/todo-list-api/services/shares"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Supported bulk operations.
+const (
+	BulkOpComplete   = "complete"
+	BulkOpReschedule = "reschedule"
+	BulkOpRetag      = "retag"
+	BulkOpDelete     = "delete"
+)
+
+// Bulk operation result statuses.
+const (
+	BulkStatusSuccess = "success"
+	BulkStatusError   = "error"
+	BulkStatusAborted = "aborted"
+)
+
+// StatusCompleted is the status assigned to completed tasks.
+const StatusCompleted = "COMPLETED"
+
+// ErrBulkAborted is returned when an all-or-nothing bulk request was rolled back.
+var ErrBulkAborted = apperror.New(apperror.Conflict, "bulk_aborted", "bulk operation aborted, no changes were applied")
+
+// BulkOperation is a single operation executed as part of a bulk request.
+type BulkOperation struct {
+	Op        string    `json:"op"`
+	TaskID    string    `json:"taskId"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	Tags      []string  `json:"tags"`
+}
+
+// BulkResult is the outcome of a single bulk operation.
+type BulkResult struct {
+	Op     string `json:"op"`
+	TaskID string `json:"taskId"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Task   *Task  `json:"task,omitempty"`
+}
+
+// BulkTasks runs the operations in order on behalf of the user and reports
+// the outcome of each one, operations on tasks the user cannot modify fail.
+//
+// When atomic is true the operations run inside a transaction and the first
+// failure rolls back every change, in which case ErrBulkAborted is returned.
+func (s *Service) BulkTasks(ctx context.Context, userID string, operations []BulkOperation, atomic bool) ([]BulkResult, error) {
+	log := s.log.WithContext(ctx).WithField("userId", userID).WithField("operations", len(operations)).
+		WithField("atomic", atomic)
+	if !atomic {
+		results := make([]BulkResult, len(operations))
+		for i, op := range operations {
+			result, err := s.runBulkOperation(ctx, userID, op)
+			if err != nil {
+				result.Error = "an error occured, please try again later"
+			}
+			results[i] = result
+		}
+		s.runBulkDeleteHooks(ctx, results)
+		return results, nil
+	}
+
+	var results []BulkResult
+	session, err := s.dbCollection.Database().Client().StartSession()
+	if err != nil {
+		log.WithError(err).Error("failed to start db session for bulk operation")
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		// the callback can be retried by the driver, so results are rebuilt every time.
+		results = make([]BulkResult, len(operations))
+		for i, op := range operations {
+			results[i], err = s.runBulkOperation(sessCtx, userID, op)
+			if err != nil {
+				// db errors are returned as is so the driver retries the
+				// transient ones instead of reporting an abort.
+				return nil, err
+			}
+			if results[i].Status != BulkStatusSuccess {
+				for j := range results {
+					if j != i {
+						results[j] = BulkResult{Op: operations[j].Op, TaskID: operations[j].TaskID, Status: BulkStatusAborted}
+					}
+				}
+				return nil, ErrBulkAborted
+			}
+		}
+		return nil, nil
+	})
+	if err == ErrBulkAborted {
+		return results, err
+	}
+	if err != nil {
+		log.WithError(err).Error("bulk operation transaction failed")
+		return nil, err
+	}
+	// delete hooks clean up data outside the transaction, so they only run once it is committed.
+	s.runBulkDeleteHooks(ctx, results)
+	return results, nil
+}
+
+func (s *Service) runBulkDeleteHooks(ctx context.Context, results []BulkResult) {
+	for _, result := range results {
+		if result.Op == BulkOpDelete && result.Status == BulkStatusSuccess {
+			s.runDeleteHooks(ctx, result.TaskID)
+		}
+	}
+}
+
+// runBulkOperation runs a single bulk operation, the operations which cannot
+// be applied fail in the returned result while db errors are returned.
+func (s *Service) runBulkOperation(ctx context.Context, userID string, op BulkOperation) (BulkResult, error) {
+	result := BulkResult{Op: op.Op, TaskID: op.TaskID, Status: BulkStatusError}
+	task, err := s.GetTask(ctx, op.TaskID)
+	if err != nil && err != ErrTaskNotFound {
+		return result, err
+	}
+	if err == ErrTaskNotFound || !s.CanViewTask(ctx, task, userID) {
+		result.Error = "task does not exist"
+		return result, nil
+	}
+	requiredRole := shares.RoleEditor
+	if op.Op == BulkOpDelete {
+		requiredRole = shares.RoleOwner
+	}
+	if !shares.RoleAtLeast(s.TaskRole(ctx, task, userID), requiredRole) {
+		result.Error = "you are not allowed to modify this task"
+		return result, nil
+	}
+	switch op.Op {
+	case BulkOpComplete:
+		task, err = s.UpdateTask(ctx, op.TaskID, Task{Status: StatusCompleted})
+
+	case BulkOpReschedule:
+		if op.StartTime.IsZero() || !op.EndTime.After(op.StartTime) {
+			result.Error = "startTime and endTime are required and endTime must be after startTime"
+			return result, nil
+		}
+		var overlappingTask *Task
+		overlappingTask, err = s.GetOverlappingTask(ctx, task.Participants(), op.StartTime, op.EndTime, task.ID)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return result, err
+		}
+		if overlappingTask != nil {
+			result.Error = fmt.Sprintf("this task if overlapping with %s, pick another time", overlappingTask.Title)
+			return result, nil
+		}
+		task, err = s.UpdateTask(ctx, op.TaskID, Task{StartTime: op.StartTime, EndTime: op.EndTime})
+
+	case BulkOpRetag:
+		task, err = s.setTaskTags(ctx, op.TaskID, op.Tags)
+
+	case BulkOpDelete:
+		task, err = s.deleteTask(ctx, op.TaskID)
+
+	default:
+		result.Error = fmt.Sprintf("unsupported operation %q", op.Op)
+		return result, nil
+	}
+	if err == ErrTaskNotFound {
+		// the task was deleted by a previous operation of the request.
+		result.Error = "task does not exist"
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+	result.Status = BulkStatusSuccess
+	result.Task = task
+	return result, nil
+}
+
+// setTaskTags replaces the task tags, an empty list removes every tag.
+func (s *Service) setTaskTags(ctx context.Context, taskID string, tags []string) (*Task, error) {
+	log := s.log.WithContext(ctx).WithField("taskId", taskID).WithField("tags", tags)
+	if tags == nil {
+		tags = []string{}
+	}
+	_, err := s.dbCollection.UpdateOne(ctx, tenant.Filter(ctx, "workspaceId", bson.M{"_id": taskID}), bson.M{"$set": bson.M{"tags": tags}})
+	if err != nil {
+		log.WithError(err).Error("failed to update task tags in db")
+		return nil, err
+	}
+	return s.GetTask(ctx, taskID)
+}
@@ -0,0 +1,95 @@
+package tasks
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// newMockService returns a tasks service whose tasks collection is the
+// mocked collection of mt.
+func newMockService(mt *mtest.T) *Service {
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+	return &Service{dbCollection: mt.Coll, log: log}
+}
+
+func taskDocument(id, userID string) bson.D {
+	return bson.D{
+		{Key: "_id", Value: id},
+		{Key: "userId", Value: userID},
+		{Key: "title", Value: "Write report"},
+		{Key: "startTime", Value: time.Date(2022, 3, 7, 10, 0, 0, 0, time.UTC)},
+		{Key: "endTime", Value: time.Date(2022, 3, 7, 11, 0, 0, 0, time.UTC)},
+	}
+}
+
+func TestBulkTasksRescheduleDeletedTask(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	ns := "db.tasks"
+	reschedule := BulkOperation{
+		Op:        BulkOpReschedule,
+		TaskID:    "task",
+		StartTime: time.Date(2022, 3, 8, 10, 0, 0, 0, time.UTC),
+		EndTime:   time.Date(2022, 3, 8, 11, 0, 0, 0, time.UTC),
+	}
+
+	mt.Run("deleted by a previous operation", func(mt *mtest.T) {
+		s := newMockService(mt)
+		mt.AddMockResponses(
+			// the delete operation.
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, taskDocument("task", "user")),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: taskDocument("task", "user")}),
+			// the reschedule operation.
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch),
+		)
+		results, err := s.BulkTasks(context.Background(), "user", []BulkOperation{{Op: BulkOpDelete, TaskID: "task"}, reschedule}, false)
+		if err != nil {
+			mt.Fatalf("BulkTasks() error = %v", err)
+		}
+		if results[0].Status != BulkStatusSuccess {
+			mt.Errorf("delete result = %+v, want a success", results[0])
+		}
+		if results[1].Status != BulkStatusError || results[1].Error != "task does not exist" {
+			mt.Errorf("reschedule result = %+v, want a task does not exist error", results[1])
+		}
+	})
+
+	mt.Run("deleted after it was read", func(mt *mtest.T) {
+		s := newMockService(mt)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, taskDocument("task", "user")),
+			// no overlapping task.
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch),
+			// the update matches nothing and the task is not found again.
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "nModified", Value: 0}),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch),
+		)
+		result, err := s.runBulkOperation(context.Background(), "user", reschedule)
+		if err != nil {
+			mt.Fatalf("runBulkOperation() error = %v", err)
+		}
+		if result.Status != BulkStatusError || result.Error != "task does not exist" || result.Task != nil {
+			mt.Errorf("runBulkOperation() = %+v, want a task does not exist error", result)
+		}
+	})
+
+	mt.Run("db error while updating", func(mt *mtest.T) {
+		s := newMockService(mt)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, taskDocument("task", "user")),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch),
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 11600, Message: "interrupted at shutdown"}),
+		)
+		result, err := s.runBulkOperation(context.Background(), "user", reschedule)
+		if err == nil {
+			mt.Errorf("runBulkOperation() = %+v, want the db error", result)
+		}
+	})
+}
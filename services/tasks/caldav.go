@@ -0,0 +1,199 @@
+package tasks
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wisdommatt/todo-list-api/internal/ical"
+	"github.com/wisdommatt/todo-list-api/internal/tenant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CalendarObjectName returns the name of the task resource in CalDAV
+// collections, tasks created by CalDAV clients keep the name they were created with.
+func (t Task) CalendarObjectName() string {
+	if t.ObjectName != "" {
+		return t.ObjectName
+	}
+	return t.ID + ".ics"
+}
+
+// EncodeCalendarObject returns the task as an iCalendar object and its
+// entity tag, which changes every time the object changes.
+func EncodeCalendarObject(task Task, loc *time.Location) ([]byte, string, error) {
+	var object bytes.Buffer
+	w := ical.NewWriter(&object)
+	w.Begin("VCALENDAR")
+	w.Property("VERSION", "2.0")
+	w.Property("PRODID", icalProductID)
+	if loc != time.UTC {
+		if from, to, ok := timedTasksRange([]Task{task}); ok {
+			w.Timezone(loc, from, to)
+		}
+	}
+	// the creation time keeps the object identical until the task changes.
+	writeTaskComponent(w, task, loc, task.TimeAdded)
+	w.End("VCALENDAR")
+	err := w.Flush()
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(object.Bytes())
+	return object.Bytes(), hex.EncodeToString(sum[:16]), nil
+}
+
+// TaskFromICalendar returns the task described by a calendar object, which
+// must contain a single event or to-do. Changes to single occurrences of
+// recurring tasks are ignored.
+func TaskFromICalendar(calendar *ical.Component, loc *time.Location) (Task, bool, error) {
+	var component *ical.Component
+	for _, child := range calendar.Components {
+		if child.Name != "VEVENT" && child.Name != "VTODO" || child.Get("RECURRENCE-ID") != nil {
+			continue
+		}
+		if component != nil {
+			return Task{}, false, fmt.Errorf("the calendar object must contain a single event or to-do")
+		}
+		component = child
+	}
+	if component == nil {
+		return Task{}, false, fmt.Errorf("the calendar object must contain an event or a to-do")
+	}
+	if component.Text("UID") == "" {
+		return Task{}, false, fmt.Errorf("missing UID")
+	}
+	task, err := taskFromComponent(component, loc)
+	if err != nil {
+		return Task{}, false, err
+	}
+	// to-dos written without duration keep their estimate.
+	if component.Name == "VTODO" && component.Get("DURATION") == nil {
+		task.EstimatedMinutes = 0
+	}
+	return task, component.Name == "VTODO", nil
+}
+
+// UpdateFromICalendar updates the existing task with the task read from a
+// calendar object.
+func (s *Service) UpdateFromICalendar(ctx context.Context, existing *Task, task Task, todo bool) (*Task, error) {
+	if todo && task.EstimatedMinutes == 0 {
+		task.EstimatedMinutes = existing.EstimatedMinutes
+	}
+	_, err := s.updateCalendarFields(ctx, existing, task, todo)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetTask(ctx, existing.ID)
+}
+
+// CreateFromICalendar creates the task read from a calendar object stored
+// with the name in a CalDAV collection.
+func (s *Service) CreateFromICalendar(ctx context.Context, task Task, name string) (*Task, error) {
+	if task.Flexible && task.EstimatedMinutes == 0 {
+		task.EstimatedMinutes = defaultTodoMinutes
+	}
+	task.ObjectName = name
+	return s.CreateTask(ctx, task)
+}
+
+// GetCollectionTasks returns the tasks of a CalDAV collection: the tasks of
+// the project, or when projectID is empty the tasks of the user calendar
+// that are not in a project the user can access.
+func (s *Service) GetCollectionTasks(ctx context.Context, userID, projectID string) ([]Task, error) {
+	log := s.log.WithContext(ctx).WithField("userId", userID).WithField("projectId", projectID)
+	filter := bson.M{"projectId": projectID}
+	if projectID == "" {
+		projectIDs, err := s.projectsService.GetProjectIDs(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		filter = bson.M{
+			"$or": []bson.M{
+				{"userId": userID},
+				{"assignees": userID},
+			},
+			"projectId": bson.M{"$nin": projectIDs},
+		}
+	}
+	cursor, err := s.dbCollection.Find(ctx, tenant.Filter(ctx, "workspaceId", filter), options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve collection tasks from db")
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var tasks []Task
+	err = cursor.All(ctx, &tasks)
+	if err != nil {
+		log.WithError(err).Error("failed to decode retrieved collection tasks")
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// InCollection reports whether the task is part of the CalDAV collection
+// of the project, or of the user collection when projectID is empty.
+func (s *Service) InCollection(ctx context.Context, task *Task, userID, projectID string) bool {
+	if projectID != "" {
+		return task.ProjectID == projectID
+	}
+	isParticipant := false
+	for _, participant := range task.Participants() {
+		if participant == userID {
+			isParticipant = true
+		}
+	}
+	if !isParticipant {
+		return false
+	}
+	if task.ProjectID == "" {
+		return true
+	}
+	project, err := s.projectsService.GetProject(ctx, task.ProjectID)
+	return err != nil || s.projectsService.ProjectRole(ctx, project, userID) == ""
+}
+
+// GetTaskByObjectName returns the task stored with the CalDAV object name.
+func (s *Service) GetTaskByObjectName(ctx context.Context, name string) (*Task, error) {
+	var task Task
+	log := s.log.WithContext(ctx).WithField("name", name)
+	filter := tenant.Filter(ctx, "workspaceId", bson.M{"$or": []bson.M{
+		{"objectName": name},
+		{"_id": strings.TrimSuffix(name, ".ics"), "objectName": bson.M{"$exists": false}},
+	}})
+	err := s.dbCollection.FindOne(ctx, filter).Decode(&task)
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve task from db by object name")
+		return nil, err
+	}
+	return &task, nil
+}
+
+// GetTaskByICalUID returns the task of the user with the iCalendar UID,
+// which is either the UID of an imported task or derived from the task id.
+func (s *Service) GetTaskByICalUID(ctx context.Context, userID, uid string) (*Task, error) {
+	task, err := s.getTaskByUID(ctx, userID, uid)
+	if err == nil {
+		return task, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	if !strings.HasSuffix(uid, icalUIDSuffix) {
+		return nil, ErrTaskNotFound
+	}
+	task, err = s.GetTask(ctx, strings.TrimSuffix(uid, icalUIDSuffix))
+	if err != nil {
+		return nil, err
+	}
+	if task.UserID != userID || task.UID != "" {
+		return nil, ErrTaskNotFound
+	}
+	return task, nil
+}
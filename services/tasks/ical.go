@@ -0,0 +1,128 @@
+package tasks
+
+import (
+	"io"
+	"strings"
+	"time"
+
+	"github.com/wisdommatt/todo-list-api/internal/ical"
+)
+
+// icalProductID identifies the api in the calendars it generates.
+const icalProductID = "-//todo-list-api//Tasks//EN"
+
+// icalUIDSuffix makes the ids of the tasks globally unique identifiers.
+const icalUIDSuffix = "@todo-list-api"
+
+// ICalUID returns the iCalendar unique identifier of the task, imported
+// tasks keep their original identifier.
+func (t Task) ICalUID() string {
+	if t.UID != "" {
+		return t.UID
+	}
+	return t.ID + icalUIDSuffix
+}
+
+// EncodeICalendar writes the tasks as an iCalendar calendar named name.
+// Scheduled tasks are written as events in loc and unscheduled tasks as to-dos.
+func EncodeICalendar(out io.Writer, tasks []Task, name string, loc *time.Location) error {
+	w := ical.NewWriter(out)
+	w.Begin("VCALENDAR")
+	w.Property("VERSION", "2.0")
+	w.Property("PRODID", icalProductID)
+	w.Property("CALSCALE", "GREGORIAN")
+	if name != "" {
+		w.Text("X-WR-CALNAME", name)
+	}
+	if loc != time.UTC {
+		w.Text("X-WR-TIMEZONE", loc.String())
+		if from, to, ok := timedTasksRange(tasks); ok {
+			w.Timezone(loc, from, to)
+		}
+	}
+	now := time.Now()
+	for _, task := range tasks {
+		writeTaskComponent(w, task, loc, now)
+	}
+	w.End("VCALENDAR")
+	return w.Flush()
+}
+
+// writeTaskComponent writes the task as a VEVENT, or as a VTODO when it is not scheduled.
+func writeTaskComponent(w *ical.Writer, task Task, loc *time.Location, now time.Time) {
+	if task.StartTime.IsZero() {
+		w.Begin("VTODO")
+		w.Property("UID", task.ICalUID())
+		w.DateTime("DTSTAMP", now, nil)
+		w.Text("SUMMARY", task.Title)
+		if !task.Deadline.IsZero() {
+			w.DateTime("DUE", task.Deadline, loc)
+		}
+		if task.Status == StatusCompleted {
+			w.Property("STATUS", "COMPLETED")
+		} else {
+			w.Property("STATUS", "NEEDS-ACTION")
+		}
+		writeCategories(w, task.Tags)
+		w.End("VTODO")
+		return
+	}
+	w.Begin("VEVENT")
+	w.Property("UID", task.ICalUID())
+	w.DateTime("DTSTAMP", now, nil)
+	w.Text("SUMMARY", task.Title)
+	if task.AllDay {
+		w.Date("DTSTART", task.StartTime.UTC())
+		w.Date("DTEND", task.EndTime.UTC())
+	} else {
+		w.DateTime("DTSTART", task.StartTime, loc)
+		w.DateTime("DTEND", task.EndTime, loc)
+	}
+	if task.Recurrence != "" {
+		w.Property("RRULE", task.Recurrence)
+	}
+	writeCategories(w, task.Tags)
+	w.End("VEVENT")
+}
+
+func writeCategories(w *ical.Writer, tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+	escaped := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		escaped = append(escaped, ical.EscapeText(tag))
+	}
+	w.Property("CATEGORIES", strings.Join(escaped, ","))
+}
+
+// timedTasksRange returns the range the time zone definition must cover
+// for the timed tasks, from the earliest one to at least two years from now
+// for recurring tasks.
+func timedTasksRange(tasks []Task) (time.Time, time.Time, bool) {
+	var from, to time.Time
+	found := false
+	for _, task := range tasks {
+		if task.StartTime.IsZero() && task.Deadline.IsZero() || task.AllDay {
+			continue
+		}
+		start, end := task.StartTime, task.EndTime
+		if task.StartTime.IsZero() {
+			start, end = task.Deadline, task.Deadline
+		}
+		if !found || start.Before(from) {
+			from = start
+		}
+		if !found || end.After(to) {
+			to = end
+		}
+		found = true
+	}
+	if !found {
+		return from, to, false
+	}
+	if minTo := time.Now().AddDate(2, 0, 0); to.Before(minTo) {
+		to = minTo
+	}
+	return from.AddDate(0, 0, -1), to, true
+}
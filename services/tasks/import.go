@@ -0,0 +1,408 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wisdommatt/todo-list-api/internal/apperror"
+	"github.com/wisdommatt/todo-list-api/internal/ical"
+	"github.com/wisdommatt/todo-list-api/internal/rrule"
+	"github.com/wisdommatt/todo-list-api/internal/tenant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Import overlap policies, deciding what happens to imported events
+// overlapping with the user tasks.
+const (
+	ImportOverlapSkip  = "skip"
+	ImportOverlapFail  = "fail"
+	ImportOverlapAllow = "allow"
+)
+
+// Import item statuses.
+const (
+	ImportStatusCreated   = "created"
+	ImportStatusUpdated   = "updated"
+	ImportStatusUnchanged = "unchanged"
+	ImportStatusSkipped   = "skipped"
+	ImportStatusAborted   = "aborted"
+	ImportStatusError     = "error"
+)
+
+// defaultTodoMinutes is the estimated duration of imported to-dos without a duration.
+const defaultTodoMinutes = 30
+
+// ErrImportAborted is returned when an import with the fail overlap policy
+// has overlapping events, in which case nothing is imported.
+var ErrImportAborted = apperror.New(apperror.Conflict, "import_aborted", "import aborted because of overlapping events, no changes were applied")
+
+// ImportResult is the outcome of the import of a calendar component.
+type ImportResult struct {
+	UID       string `json:"uid"`
+	Title     string `json:"title"`
+	Component string `json:"component"`
+	Status    string `json:"status"`
+	TaskID    string `json:"taskId,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// IsValidImportOverlapPolicy reports whether policy is a known overlap policy.
+func IsValidImportOverlapPolicy(policy string) bool {
+	return policy == ImportOverlapSkip || policy == ImportOverlapFail || policy == ImportOverlapAllow
+}
+
+// importItem is a calendar component to import and the task it maps to.
+type importItem struct {
+	result   ImportResult
+	task     Task
+	existing *Task
+}
+
+// ImportICalendar imports the events and to-dos of an iCalendar calendar as
+// tasks owned by the user, events become scheduled tasks and to-dos
+// flexible tasks. Floating times are interpreted in loc.
+//
+// Imports are idempotent: components are matched with the tasks of the
+// user on their UID, so importing a file again updates the tasks.
+func (s *Service) ImportICalendar(ctx context.Context, userID, projectID string, calendar *ical.Component, overlapPolicy string, loc *time.Location) ([]ImportResult, error) {
+	var err error
+	var items []*importItem
+	seenUIDs := map[string]bool{}
+	for _, component := range calendar.Components {
+		if component.Name != "VEVENT" && component.Name != "VTODO" {
+			continue
+		}
+		item := &importItem{result: ImportResult{
+			UID:       component.Text("UID"),
+			Title:     component.Text("SUMMARY"),
+			Component: component.Name,
+		}}
+		items = append(items, item)
+		switch {
+		case item.result.UID == "":
+			item.result.Status, item.result.Error = ImportStatusError, "missing UID"
+			continue
+		case component.Get("RECURRENCE-ID") != nil:
+			item.result.Status, item.result.Error = ImportStatusSkipped, "changes to single occurrences are not supported"
+			continue
+		case strings.EqualFold(component.Text("STATUS"), "CANCELLED"):
+			item.result.Status, item.result.Error = ImportStatusSkipped, "cancelled"
+			continue
+		case seenUIDs[item.result.UID]:
+			item.result.Status, item.result.Error = ImportStatusSkipped, "duplicate UID"
+			continue
+		}
+		seenUIDs[item.result.UID] = true
+		item.task, err = taskFromComponent(component, loc)
+		if err != nil {
+			item.result.Status, item.result.Error = ImportStatusError, err.Error()
+			continue
+		}
+		item.existing, err = s.getTaskByUID(ctx, userID, item.result.UID)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+	}
+
+	aborted, err := s.checkImportOverlaps(ctx, userID, items, overlapPolicy)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if aborted && item.result.Status == "" {
+			item.result.Status = ImportStatusAborted
+		}
+		if item.result.Status != "" {
+			continue
+		}
+		err = s.applyImportItem(ctx, userID, projectID, item)
+		if err != nil {
+			item.result.Status, item.result.Error = ImportStatusError, "failed to save the task"
+		}
+	}
+
+	results := make([]ImportResult, 0, len(items))
+	for _, item := range items {
+		results = append(results, item.result)
+	}
+	if aborted {
+		return results, ErrImportAborted
+	}
+	return results, nil
+}
+
+// checkImportOverlaps checks the timed events against the user tasks and
+// the events imported before them, applying the overlap policy. It reports
+// whether the import must be aborted.
+func (s *Service) checkImportOverlaps(ctx context.Context, userID string, items []*importItem, overlapPolicy string) (bool, error) {
+	if overlapPolicy == ImportOverlapAllow {
+		return false, nil
+	}
+	aborted := false
+	var imported []*importItem
+	for _, item := range items {
+		task := item.task
+		if item.result.Status != "" || task.StartTime.IsZero() || task.AllDay {
+			continue
+		}
+		excludeTaskID := ""
+		if item.existing != nil {
+			excludeTaskID = item.existing.ID
+		}
+		overlappingTitle := ""
+		// recurring events are checked on their first occurrence.
+		overlappingTask, err := s.GetOverlappingTask(ctx, []string{userID}, task.StartTime, task.EndTime, excludeTaskID)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return false, err
+		}
+		if overlappingTask != nil && !importReplaces(items, overlappingTask) {
+			overlappingTitle = overlappingTask.Title
+		}
+		for _, other := range imported {
+			if task.StartTime.Before(other.task.EndTime) && other.task.StartTime.Before(task.EndTime) {
+				overlappingTitle = other.task.Title
+			}
+		}
+		if overlappingTitle == "" {
+			imported = append(imported, item)
+			continue
+		}
+		item.result.Error = fmt.Sprintf("overlaps with %s", overlappingTitle)
+		if overlapPolicy == ImportOverlapFail {
+			item.result.Status = ImportStatusError
+			aborted = true
+		} else {
+			item.result.Status = ImportStatusSkipped
+		}
+	}
+	return aborted, nil
+}
+
+// importReplaces reports whether the task is updated by one of the import
+// items, in which case its current time does not matter.
+func importReplaces(items []*importItem, task *Task) bool {
+	for _, item := range items {
+		if item.existing != nil && item.existing.ID == task.ID && item.result.Status == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// applyImportItem creates the task of the item, or updates the task
+// previously imported with the same UID.
+func (s *Service) applyImportItem(ctx context.Context, userID, projectID string, item *importItem) error {
+	if item.existing == nil {
+		task := item.task
+		task.UserID = userID
+		task.CreatedBy = userID
+		task.ProjectID = projectID
+		created, err := s.CreateTask(ctx, task)
+		if err != nil {
+			return err
+		}
+		item.result.Status, item.result.TaskID = ImportStatusCreated, created.ID
+		return nil
+	}
+	item.result.TaskID = item.existing.ID
+	updated, err := s.updateCalendarFields(ctx, item.existing, item.task, item.result.Component == "VTODO")
+	if err != nil {
+		return err
+	}
+	item.result.Status = ImportStatusUnchanged
+	if updated {
+		item.result.Status = ImportStatusUpdated
+	}
+	return nil
+}
+
+// updateCalendarFields updates the fields of the existing task set from a
+// calendar component, it reports whether the task changed.
+func (s *Service) updateCalendarFields(ctx context.Context, existing *Task, task Task, todo bool) (bool, error) {
+	fields := importedFields(task, todo)
+	if importedFieldsEqual(fields, importedFields(*existing, todo)) {
+		return false, nil
+	}
+	set, unset := bson.M{}, bson.M{}
+	for field, value := range fields {
+		if isZeroImportedValue(value) {
+			unset[field] = ""
+		} else {
+			set[field] = value
+		}
+	}
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	_, err := s.dbCollection.UpdateOne(ctx, tenant.Filter(ctx, "workspaceId", bson.M{"_id": existing.ID}), update)
+	if err != nil {
+		s.log.WithContext(ctx).WithError(err).WithField("taskId", existing.ID).Error("failed to update task calendar fields in db")
+		return false, err
+	}
+	return true, nil
+}
+
+// importedFields returns the task fields set from calendar components,
+// events set the task time and to-dos the fields of flexible tasks.
+func importedFields(task Task, todo bool) bson.M {
+	fields := bson.M{
+		"title": task.Title,
+		"tags":  task.Tags,
+	}
+	if todo {
+		fields["status"] = task.Status
+		fields["flexible"] = task.Flexible
+		fields["estimatedMinutes"] = task.EstimatedMinutes
+		fields["deadline"] = task.Deadline
+	} else {
+		fields["startTime"] = task.StartTime
+		fields["endTime"] = task.EndTime
+		fields["allDay"] = task.AllDay
+		fields["recurrence"] = task.Recurrence
+	}
+	return fields
+}
+
+func importedFieldsEqual(a, b bson.M) bool {
+	for field, value := range a {
+		switch v := value.(type) {
+		case time.Time:
+			if !v.Equal(b[field].(time.Time)) {
+				return false
+			}
+		case []string:
+			if strings.Join(v, ",") != strings.Join(b[field].([]string), ",") {
+				return false
+			}
+		default:
+			if value != b[field] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isZeroImportedValue(value interface{}) bool {
+	switch v := value.(type) {
+	case time.Time:
+		return v.IsZero()
+	case string:
+		return v == ""
+	case bool:
+		return !v
+	case int:
+		return v == 0
+	case []string:
+		return len(v) == 0
+	}
+	return false
+}
+
+// taskFromComponent maps a VEVENT to a scheduled task and a VTODO to a flexible task.
+func taskFromComponent(component *ical.Component, loc *time.Location) (Task, error) {
+	task := Task{
+		UID:   component.Text("UID"),
+		Title: component.Text("SUMMARY"),
+	}
+	for _, property := range component.Properties {
+		if property.Name == "CATEGORIES" {
+			for _, tag := range ical.SplitText(property.Value) {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					task.Tags = append(task.Tags, tag)
+				}
+			}
+		}
+	}
+	if task.Title == "" {
+		task.Title = "(no title)"
+	}
+	if component.Name == "VTODO" {
+		return todoTask(component, task, loc)
+	}
+
+	dtstart := component.Get("DTSTART")
+	if dtstart == nil {
+		return task, fmt.Errorf("missing DTSTART")
+	}
+	start, allDay, err := ical.ParseTime(dtstart, loc)
+	if err != nil {
+		return task, fmt.Errorf("invalid DTSTART")
+	}
+	end := start
+	if allDay {
+		end = start.AddDate(0, 0, 1)
+	}
+	if dtend := component.Get("DTEND"); dtend != nil {
+		end, _, err = ical.ParseTime(dtend, loc)
+		if err != nil {
+			return task, fmt.Errorf("invalid DTEND")
+		}
+	} else if duration := component.Get("DURATION"); duration != nil {
+		value, err := ical.ParseDuration(duration.Value)
+		if err != nil {
+			return task, fmt.Errorf("invalid DURATION")
+		}
+		end = start.Add(value)
+	}
+	if end.Before(start) {
+		return task, fmt.Errorf("the event ends before it starts")
+	}
+	task.StartTime, task.EndTime, task.AllDay = start, end, allDay
+	if allDay {
+		task.StartTime, task.EndTime = AllDayRange(start, end)
+	}
+	if property := component.Get("RRULE"); property != nil {
+		rule, err := rrule.Parse(property.Value)
+		if err != nil {
+			return task, err
+		}
+		task.Recurrence = rule.String()
+	}
+	return task, nil
+}
+
+func todoTask(component *ical.Component, task Task, loc *time.Location) (Task, error) {
+	task.Flexible = true
+	task.EstimatedMinutes = defaultTodoMinutes
+	if property := component.Get("DURATION"); property != nil {
+		duration, err := ical.ParseDuration(property.Value)
+		if err != nil || duration <= 0 {
+			return task, fmt.Errorf("invalid DURATION")
+		}
+		task.EstimatedMinutes = int(duration.Minutes())
+	}
+	if property := component.Get("DUE"); property != nil {
+		due, allDay, err := ical.ParseTime(property, loc)
+		if err != nil {
+			return task, fmt.Errorf("invalid DUE")
+		}
+		if allDay {
+			// a to-do due on a date can be done until the end of that day.
+			due = time.Date(due.Year(), due.Month(), due.Day()+1, 0, 0, 0, 0, loc)
+		}
+		task.Deadline = due
+	}
+	if strings.EqualFold(component.Text("STATUS"), "COMPLETED") {
+		task.Status = StatusCompleted
+	}
+	return task, nil
+}
+
+// getTaskByUID returns the task of the user imported with the iCalendar UID.
+func (s *Service) getTaskByUID(ctx context.Context, userID, uid string) (*Task, error) {
+	var task Task
+	filter := tenant.Filter(ctx, "workspaceId", bson.M{"userId": userID, "uid": uid})
+	err := s.dbCollection.FindOne(ctx, filter).Decode(&task)
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
@@ -0,0 +1,364 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/wisdommatt/todo-list-api/internal/apperror"
+	"github.com/wisdommatt/todo-list-api/internal/tenant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Schedule plan statuses.
+const (
+	PlanStatusPreview   = "preview"
+	PlanStatusCommitted = "committed"
+)
+
+// DefaultScheduleHorizon is how far ahead flexible tasks without a deadline are scheduled.
+const DefaultScheduleHorizon = 14 * 24 * time.Hour
+
+// MaxScheduleHorizon bounds how far ahead flexible tasks are scheduled.
+const MaxScheduleHorizon = 90 * 24 * time.Hour
+
+// planValidity is how long a schedule plan can be committed after it was made.
+const planValidity = 15 * time.Minute
+
+// ErrPlanExpired is returned when committing a plan that is committed or too old.
+var ErrPlanExpired = apperror.New(apperror.Conflict, "plan_expired", "the schedule plan expired, make a new plan")
+
+// ErrPlanOutdated is returned when the calendar changed since the plan was made.
+var ErrPlanOutdated = apperror.New(apperror.Conflict, "plan_outdated", "the calendar changed since the plan was made, make a new plan")
+
+// ErrSchedulePlanNotFound is returned when the schedule plan does not exist.
+var ErrSchedulePlanNotFound = apperror.New(apperror.NotFound, "schedule_plan_not_found", "schedule plan does not exist")
+
+// PlannedTask is the time a flexible task is placed at by a schedule plan.
+type PlannedTask struct {
+	TaskID    string    `json:"taskId" bson:"taskId"`
+	Title     string    `json:"title" bson:"title"`
+	StartTime time.Time `json:"startTime" bson:"startTime"`
+	EndTime   time.Time `json:"endTime" bson:"endTime"`
+}
+
+// UnscheduledTask is a flexible task a schedule plan could not place.
+type UnscheduledTask struct {
+	TaskID string `json:"taskId" bson:"taskId"`
+	Title  string `json:"title" bson:"title"`
+	Reason string `json:"reason" bson:"reason"`
+}
+
+// SchedulePlan is a preview of where the flexible tasks of a user would be
+// placed, tasks are only moved once the plan is committed.
+type SchedulePlan struct {
+	ID          string            `json:"id" bson:"_id,omitempty"`
+	UserID      string            `json:"userId" bson:"userId,omitempty"`
+	WorkspaceID string            `json:"workspaceId" bson:"workspaceId,omitempty"`
+	Status      string            `json:"status" bson:"status,omitempty"`
+	Tasks       []PlannedTask     `json:"tasks" bson:"tasks"`
+	Unscheduled []UnscheduledTask `json:"unscheduled" bson:"unscheduled"`
+	ExpiresAt   time.Time         `json:"expiresAt" bson:"expiresAt,omitempty"`
+	TimeAdded   time.Time         `json:"timeAdded" bson:"timeAdded,omitempty"`
+}
+
+// PlanSchedule places the flexible tasks owned by the user that have not
+// started yet into the free time of their participants, between now and the
+// horizon or the latest deadline, and saves the result as a plan preview.
+//
+// Tasks are placed greedily at the earliest free slot: tasks whose
+// dependencies are placed come first, by priority then by deadline. Fixed
+// tasks are never moved, tasks that cannot end before their deadline are
+// left unscheduled.
+func (s *Service) PlanSchedule(ctx context.Context, userID string, horizon time.Duration, opts AvailabilityOptions) (*SchedulePlan, error) {
+	log := s.log.WithContext(ctx).WithField("userId", userID)
+	now := time.Now().Truncate(time.Minute).Add(time.Minute)
+	flexibleTasks, err := s.getSchedulableTasks(ctx, userID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	end := now.Add(horizon)
+	taskIDs := make([]string, 0, len(flexibleTasks))
+	participants := map[string]bool{}
+	for _, task := range flexibleTasks {
+		taskIDs = append(taskIDs, task.ID)
+		for _, participant := range task.Participants() {
+			participants[participant] = true
+		}
+		if task.Deadline.After(end) {
+			end = task.Deadline
+		}
+	}
+	if end.After(now.Add(MaxScheduleHorizon)) {
+		end = now.Add(MaxScheduleHorizon)
+	}
+	userIDs := make([]string, 0, len(participants))
+	for participant := range participants {
+		userIDs = append(userIDs, participant)
+	}
+	busyTasks, err := s.getBusyTasks(ctx, userIDs, now.Add(-opts.MinGap), end.Add(opts.MinGap), taskIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := SchedulePlan{
+		ID:          primitive.NewObjectID().Hex(),
+		UserID:      userID,
+		WorkspaceID: tenant.WorkspaceID(ctx),
+		Status:      PlanStatusPreview,
+		Tasks:       []PlannedTask{},
+		Unscheduled: []UnscheduledTask{},
+		ExpiresAt:   time.Now().Add(planValidity),
+		TimeAdded:   time.Now(),
+	}
+	err = s.placeTasks(ctx, &plan, flexibleTasks, busyTasks, now, end, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.plansCollection.InsertOne(ctx, plan)
+	if err != nil {
+		log.WithError(err).Error("failed to save schedule plan to db")
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// placeTasks adds the flexible tasks to the plan in dependency order.
+func (s *Service) placeTasks(ctx context.Context, plan *SchedulePlan, flexibleTasks, busyTasks []Task, from, to time.Time, opts AvailabilityOptions) error {
+	pending := map[string]Task{}
+	for _, task := range flexibleTasks {
+		pending[task.ID] = task
+	}
+	// placed holds the end time of the dependencies that can be waited for.
+	placed := map[string]time.Time{}
+	calendarTasks := append([]Task{}, busyTasks...)
+
+	for len(pending) > 0 {
+		ready, blocked, err := s.readyTasks(ctx, pending, placed, from)
+		if err != nil {
+			return err
+		}
+		if len(ready) == 0 {
+			// every pending task is waiting on an unschedulable or circular dependency.
+			for _, task := range blocked {
+				plan.Unscheduled = append(plan.Unscheduled, UnscheduledTask{
+					TaskID: task.ID,
+					Title:  task.Title,
+					Reason: "depends on a task that cannot be scheduled",
+				})
+				delete(pending, task.ID)
+			}
+			break
+		}
+		sort.SliceStable(ready, func(i, j int) bool { return schedulesBefore(ready[i], ready[j]) })
+		task := ready[0]
+		delete(pending, task.ID)
+
+		earliest := from
+		for _, dependencyID := range task.DependsOn {
+			if placed[dependencyID].After(earliest) {
+				earliest = placed[dependencyID]
+			}
+		}
+		duration := time.Duration(task.EstimatedMinutes) * time.Minute
+		slot, ok := firstFreeSlot(task, calendarTasks, earliest, to, duration, opts)
+		if !ok {
+			reason := fmt.Sprintf("no free time before %s", to.Format(time.RFC3339))
+			plan.Unscheduled = append(plan.Unscheduled, UnscheduledTask{TaskID: task.ID, Title: task.Title, Reason: reason})
+			continue
+		}
+		if !task.Deadline.IsZero() && slot.EndTime.After(task.Deadline) {
+			plan.Unscheduled = append(plan.Unscheduled, UnscheduledTask{
+				TaskID: task.ID,
+				Title:  task.Title,
+				Reason: "not enough free time before the deadline",
+			})
+			continue
+		}
+		placed[task.ID] = slot.EndTime
+		task.StartTime, task.EndTime = slot.StartTime, slot.EndTime
+		calendarTasks = append(calendarTasks, task)
+		plan.Tasks = append(plan.Tasks, PlannedTask{
+			TaskID:    task.ID,
+			Title:     task.Title,
+			StartTime: slot.StartTime,
+			EndTime:   slot.EndTime,
+		})
+	}
+	return nil
+}
+
+// readyTasks splits the pending tasks between the ones whose dependencies
+// are placed or already done and the ones still waiting on a dependency.
+func (s *Service) readyTasks(ctx context.Context, pending map[string]Task, placed map[string]time.Time, from time.Time) ([]Task, []Task, error) {
+	var ready, blocked []Task
+	for _, task := range pending {
+		isReady := true
+		for _, dependencyID := range task.DependsOn {
+			if _, ok := placed[dependencyID]; ok {
+				continue
+			}
+			if _, ok := pending[dependencyID]; ok {
+				isReady = false
+				break
+			}
+			// the dependency is not part of the plan, it is either a completed
+			// or fixed task, or a flexible task the scheduler could not place
+			// whose time, if any, is stale.
+			dependency, err := s.GetTask(ctx, dependencyID)
+			switch {
+			case err == ErrTaskNotFound:
+				isReady = false
+			case err != nil:
+				return nil, nil, err
+			case dependency.Status == StatusCompleted:
+				placed[dependencyID] = from
+			case !dependency.Flexible && !dependency.EndTime.IsZero():
+				placed[dependencyID] = dependency.EndTime
+			default:
+				isReady = false
+			}
+			if !isReady {
+				break
+			}
+		}
+		if isReady {
+			ready = append(ready, task)
+		} else {
+			blocked = append(blocked, task)
+		}
+	}
+	return ready, blocked, nil
+}
+
+// schedulesBefore reports whether task a is placed before task b, by
+// priority then deadline, tasks without a deadline coming last.
+func schedulesBefore(a, b Task) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if a.Deadline.IsZero() != b.Deadline.IsZero() {
+		return !a.Deadline.IsZero()
+	}
+	if !a.Deadline.Equal(b.Deadline) {
+		return a.Deadline.Before(b.Deadline)
+	}
+	return a.ID < b.ID
+}
+
+// firstFreeSlot returns the earliest slot of duration between from and to
+// that is free in the calendar of every participant of the task.
+func firstFreeSlot(task Task, calendarTasks []Task, from, to time.Time, duration time.Duration, opts AvailabilityOptions) (TimeSlot, bool) {
+	participants := map[string]bool{}
+	for _, participant := range task.Participants() {
+		participants[participant] = true
+	}
+	var busy []TimeSlot
+	for _, calendarTask := range calendarTasks {
+		for _, participant := range calendarTask.Participants() {
+			if participants[participant] {
+				busy = append(busy, TimeSlot{StartTime: calendarTask.StartTime, EndTime: calendarTask.EndTime})
+				break
+			}
+		}
+	}
+	free := freeSlots(from, to, busy, duration, opts)
+	if len(free) == 0 {
+		return TimeSlot{}, false
+	}
+	return TimeSlot{StartTime: free[0].StartTime, EndTime: free[0].StartTime.Add(duration)}, true
+}
+
+// getSchedulableTasks returns the flexible tasks owned by the user that are
+// not completed and are not scheduled before now.
+func (s *Service) getSchedulableTasks(ctx context.Context, userID string, now time.Time) ([]Task, error) {
+	log := s.log.WithContext(ctx).WithField("userId", userID)
+	filter := tenant.Filter(ctx, "workspaceId", bson.M{
+		"userId":           userID,
+		"flexible":         true,
+		"estimatedMinutes": bson.M{"$gt": 0},
+		"status":           bson.M{"$ne": StatusCompleted},
+		"$or": []bson.M{
+			{"startTime": bson.M{"$exists": false}},
+			{"startTime": bson.M{"$gte": now}},
+		},
+	})
+	cursor, err := s.dbCollection.Find(ctx, filter)
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve flexible tasks from db")
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var tasks []Task
+	err = cursor.All(ctx, &tasks)
+	if err != nil {
+		log.WithError(err).Error("failed to decode retrieved flexible tasks")
+		return nil, err
+	}
+	return tasks, nil
+}
+
+func (s *Service) GetSchedulePlan(ctx context.Context, planID string) (*SchedulePlan, error) {
+	var plan SchedulePlan
+	log := s.log.WithContext(ctx).WithField("planId", planID)
+	err := s.plansCollection.FindOne(ctx, tenant.Filter(ctx, "workspaceId", bson.M{"_id": planID})).Decode(&plan)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrSchedulePlanNotFound
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve schedule plan from db by id")
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// CommitSchedulePlan moves the planned tasks to the times of the plan
+// preview, the plan is rejected when a planned time is no longer free.
+func (s *Service) CommitSchedulePlan(ctx context.Context, plan *SchedulePlan) (*SchedulePlan, error) {
+	log := s.log.WithContext(ctx).WithField("planId", plan.ID)
+	if plan.Status != PlanStatusPreview || time.Now().After(plan.ExpiresAt) {
+		return nil, ErrPlanExpired
+	}
+	taskIDs := make([]string, 0, len(plan.Tasks))
+	for _, planned := range plan.Tasks {
+		taskIDs = append(taskIDs, planned.TaskID)
+	}
+	for _, planned := range plan.Tasks {
+		task, err := s.GetTask(ctx, planned.TaskID)
+		if err != nil || !task.Flexible {
+			return nil, ErrPlanOutdated
+		}
+		busyTasks, err := s.getBusyTasks(ctx, task.Participants(), planned.StartTime, planned.EndTime, taskIDs)
+		if err != nil {
+			return nil, err
+		}
+		if len(busyTasks) > 0 {
+			return nil, ErrPlanOutdated
+		}
+	}
+	// the status is switched first so a plan can only be committed once.
+	filter := bson.M{"_id": plan.ID, "status": PlanStatusPreview}
+	result, err := s.plansCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"status": PlanStatusCommitted}})
+	if err != nil {
+		log.WithError(err).Error("failed to commit schedule plan in db")
+		return nil, err
+	}
+	if result.ModifiedCount == 0 {
+		return nil, ErrPlanExpired
+	}
+	for _, planned := range plan.Tasks {
+		update := bson.M{"$set": bson.M{"startTime": planned.StartTime, "endTime": planned.EndTime}}
+		_, err = s.dbCollection.UpdateOne(ctx, tenant.Filter(ctx, "workspaceId", bson.M{"_id": planned.TaskID}), update)
+		if err != nil {
+			log.WithError(err).WithField("taskId", planned.TaskID).Error("failed to move planned task in db")
+			return nil, err
+		}
+	}
+	plan.Status = PlanStatusCommitted
+	return plan, nil
+}
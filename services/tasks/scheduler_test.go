@@ -0,0 +1,302 @@
+package tasks
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func flexibleTask(id string, minutes, priority int, dependsOn ...string) Task {
+	return Task{ID: id, UserID: "user", Title: id, Flexible: true, EstimatedMinutes: minutes, Priority: priority, DependsOn: dependsOn}
+}
+
+// dependencyDocument is a task outside of the plan.
+func dependencyDocument(flexible bool, status string, endTime time.Time) bson.D {
+	return bson.D{
+		{Key: "_id", Value: "dependency"},
+		{Key: "userId", Value: "user"},
+		{Key: "flexible", Value: flexible},
+		{Key: "status", Value: status},
+		{Key: "startTime", Value: endTime.Add(-time.Hour)},
+		{Key: "endTime", Value: endTime},
+	}
+}
+
+var errDBFailure = mtest.CommandError{Code: 11600, Message: "interrupted at shutdown"}
+
+func TestPlaceTasks(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	ns := "db.tasks"
+	from, to := monday(9, 0), time.Date(2022, 3, 8, 17, 0, 0, 0, time.UTC)
+	withDeadline := func(task Task, deadline time.Time) Task {
+		task.Deadline = deadline
+		return task
+	}
+	withAssignee := func(task Task, assignee string) Task {
+		task.Assignees = []string{assignee}
+		return task
+	}
+
+	tests := []struct {
+		name      string
+		tasks     []Task
+		busy      []Task
+		responses []bson.D
+		// want are the planned tasks, followed by the unscheduled ones.
+		want string
+	}{
+		{
+			name:  "by priority",
+			tasks: []Task{flexibleTask("low", 60, 1), flexibleTask("high", 60, 2), flexibleTask("none", 30, 0)},
+			want:  "high 03-07 09:00-10:00,low 03-07 10:00-11:00,none 03-07 11:00-11:30",
+		},
+		{
+			name: "by deadline",
+			tasks: []Task{
+				flexibleTask("none", 60, 0),
+				withDeadline(flexibleTask("later", 60, 0), to),
+				withDeadline(flexibleTask("sooner", 60, 0), monday(17, 0)),
+			},
+			want: "sooner 03-07 09:00-10:00,later 03-07 10:00-11:00,none 03-07 11:00-12:00",
+		},
+		{
+			name:  "after the dependencies",
+			tasks: []Task{flexibleTask("second", 60, 2, "first"), flexibleTask("first", 60, 1)},
+			want:  "first 03-07 09:00-10:00,second 03-07 10:00-11:00",
+		},
+		{
+			name:  "around busy tasks",
+			tasks: []Task{flexibleTask("task", 90, 0)},
+			busy:  []Task{{ID: "busy", UserID: "user", StartTime: monday(10, 0), EndTime: monday(11, 0)}},
+			want:  "task 03-07 11:00-12:30",
+		},
+		{
+			name:  "around busy tasks of the assignees",
+			tasks: []Task{withAssignee(flexibleTask("task", 60, 0), "assignee")},
+			busy: []Task{
+				{ID: "assigned", UserID: "other", Assignees: []string{"assignee"}, StartTime: monday(9, 0), EndTime: monday(10, 0)},
+				{ID: "unrelated", UserID: "other", StartTime: monday(10, 0), EndTime: monday(11, 0)},
+			},
+			want: "task 03-07 10:00-11:00",
+		},
+		{
+			name:  "no free time",
+			tasks: []Task{flexibleTask("task", 9*60, 0)},
+			want:  "task: no free time before 2022-03-08T17:00:00Z",
+		},
+		{
+			name:  "not enough time before the deadline",
+			tasks: []Task{withDeadline(flexibleTask("task", 60, 0), monday(9, 30))},
+			want:  "task: not enough free time before the deadline",
+		},
+		{
+			name:  "circular dependencies",
+			tasks: []Task{flexibleTask("a", 60, 0, "b"), flexibleTask("b", 60, 0, "a"), flexibleTask("c", 60, 0)},
+			want:  "c 03-07 09:00-10:00,a: depends on a task that cannot be scheduled,b: depends on a task that cannot be scheduled",
+		},
+		{
+			name: "dependency left unscheduled",
+			tasks: []Task{
+				flexibleTask("second", 60, 0, "dependency"),
+				withDeadline(flexibleTask("dependency", 60, 0), monday(9, 30)),
+			},
+			// the dependency keeps its previous time in the db.
+			responses: []bson.D{dependencyDocument(true, "", monday(8, 0))},
+			want:      "dependency: not enough free time before the deadline,second: depends on a task that cannot be scheduled",
+		},
+		{
+			name:      "fixed dependency outside of the plan",
+			tasks:     []Task{flexibleTask("task", 60, 0, "dependency")},
+			responses: []bson.D{dependencyDocument(false, "", monday(14, 0))},
+			want:      "task 03-07 14:00-15:00",
+		},
+		{
+			name:      "completed dependency outside of the plan",
+			tasks:     []Task{flexibleTask("task", 60, 0, "dependency")},
+			responses: []bson.D{dependencyDocument(true, StatusCompleted, monday(14, 0))},
+			want:      "task 03-07 09:00-10:00",
+		},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			for _, response := range tt.responses {
+				mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, response))
+			}
+			plan := SchedulePlan{}
+			err := newMockService(mt).placeTasks(context.Background(), &plan, tt.tasks, tt.busy, from, to, DefaultAvailabilityOptions())
+			if err != nil {
+				mt.Fatalf("placeTasks() error = %v", err)
+			}
+			var got []string
+			for _, planned := range plan.Tasks {
+				got = append(got, planned.TaskID+" "+formatSlots([]TimeSlot{{planned.StartTime, planned.EndTime}}))
+			}
+			// blocked tasks are unscheduled in map order.
+			unscheduled := make([]string, 0, len(plan.Unscheduled))
+			for _, task := range plan.Unscheduled {
+				unscheduled = append(unscheduled, task.TaskID+": "+task.Reason)
+			}
+			sort.Strings(unscheduled)
+			if got := strings.Join(append(got, unscheduled...), ","); got != tt.want {
+				mt.Errorf("placeTasks() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	mt.Run("db error", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(errDBFailure))
+		plan := SchedulePlan{}
+		tasks := []Task{flexibleTask("task", 60, 0, "dependency")}
+		err := newMockService(mt).placeTasks(context.Background(), &plan, tasks, nil, from, to, DefaultAvailabilityOptions())
+		if err == nil {
+			mt.Errorf("placeTasks() error = nil, want the db error")
+		}
+	})
+}
+
+func TestReadyTasks(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	ns := "db.tasks"
+	from := monday(9, 0)
+
+	tests := []struct {
+		name     string
+		pending  []Task
+		placed   map[string]time.Time
+		response bson.D
+		// noDocument mocks a dependency which does not exist.
+		noDocument  bool
+		wantReady   string
+		wantBlocked string
+		// wantPlaced is the time the dependency is placed at, if any.
+		wantPlaced time.Time
+	}{
+		{name: "no dependencies", pending: []Task{flexibleTask("task", 60, 0)}, wantReady: "task"},
+		{
+			name:       "dependency placed",
+			pending:    []Task{flexibleTask("task", 60, 0, "dependency")},
+			placed:     map[string]time.Time{"dependency": monday(10, 0)},
+			wantReady:  "task",
+			wantPlaced: monday(10, 0),
+		},
+		{
+			name:        "dependency pending",
+			pending:     []Task{flexibleTask("task", 60, 0, "dependency"), flexibleTask("dependency", 60, 0)},
+			wantReady:   "dependency",
+			wantBlocked: "task",
+		},
+		{
+			name:       "completed dependency",
+			pending:    []Task{flexibleTask("task", 60, 0, "dependency")},
+			response:   dependencyDocument(true, StatusCompleted, monday(14, 0)),
+			wantReady:  "task",
+			wantPlaced: from,
+		},
+		{
+			name:       "fixed dependency",
+			pending:    []Task{flexibleTask("task", 60, 0, "dependency")},
+			response:   dependencyDocument(false, "", monday(14, 0)),
+			wantReady:  "task",
+			wantPlaced: monday(14, 0),
+		},
+		{
+			name:        "fixed dependency without time",
+			pending:     []Task{flexibleTask("task", 60, 0, "dependency")},
+			response:    bson.D{{Key: "_id", Value: "dependency"}, {Key: "userId", Value: "user"}},
+			wantBlocked: "task",
+		},
+		{
+			name:        "flexible dependency outside of the plan",
+			pending:     []Task{flexibleTask("task", 60, 0, "dependency")},
+			response:    dependencyDocument(true, "", monday(14, 0)),
+			wantBlocked: "task",
+		},
+		{
+			name:        "deleted dependency",
+			pending:     []Task{flexibleTask("task", 60, 0, "dependency")},
+			noDocument:  true,
+			wantBlocked: "task",
+		},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			if tt.response != nil {
+				mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, tt.response))
+			}
+			if tt.noDocument {
+				mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch))
+			}
+			pending := map[string]Task{}
+			for _, task := range tt.pending {
+				pending[task.ID] = task
+			}
+			placed := map[string]time.Time{}
+			for id, end := range tt.placed {
+				placed[id] = end
+			}
+			ready, blocked, err := newMockService(mt).readyTasks(context.Background(), pending, placed, from)
+			if err != nil {
+				mt.Fatalf("readyTasks() error = %v", err)
+			}
+			if got := taskIDs(ready); got != tt.wantReady {
+				mt.Errorf("readyTasks() ready = %s, want %s", got, tt.wantReady)
+			}
+			if got := taskIDs(blocked); got != tt.wantBlocked {
+				mt.Errorf("readyTasks() blocked = %s, want %s", got, tt.wantBlocked)
+			}
+			if got := placed["dependency"]; !got.Equal(tt.wantPlaced) {
+				mt.Errorf("readyTasks() placed dependency at %v, want %v", got, tt.wantPlaced)
+			}
+		})
+	}
+
+	mt.Run("db error", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(errDBFailure))
+		pending := map[string]Task{"task": flexibleTask("task", 60, 0, "dependency")}
+		_, _, err := newMockService(mt).readyTasks(context.Background(), pending, map[string]time.Time{}, from)
+		if err == nil || err == ErrTaskNotFound {
+			mt.Errorf("readyTasks() error = %v, want the db error", err)
+		}
+	})
+}
+
+func taskIDs(tasks []Task) string {
+	ids := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		ids = append(ids, task.ID)
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+func TestSchedulesBefore(t *testing.T) {
+	sooner, later := monday(12, 0), monday(17, 0)
+	tests := []struct {
+		name string
+		a, b Task
+		want bool
+	}{
+		{name: "higher priority", a: Task{ID: "b", Priority: 2}, b: Task{ID: "a", Priority: 1, Deadline: sooner}, want: true},
+		{name: "lower priority", a: Task{ID: "a", Priority: 1, Deadline: sooner}, b: Task{ID: "b", Priority: 2}},
+		{name: "deadline before none", a: Task{ID: "b", Deadline: later}, b: Task{ID: "a"}, want: true},
+		{name: "none after deadline", a: Task{ID: "a"}, b: Task{ID: "b", Deadline: later}},
+		{name: "sooner deadline", a: Task{ID: "b", Deadline: sooner}, b: Task{ID: "a", Deadline: later}, want: true},
+		{name: "later deadline", a: Task{ID: "a", Deadline: later}, b: Task{ID: "b", Deadline: sooner}},
+		{name: "same deadline by id", a: Task{ID: "a", Deadline: later}, b: Task{ID: "b", Deadline: later}, want: true},
+		{name: "same task", a: Task{ID: "a"}, b: Task{ID: "a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := schedulesBefore(tt.a, tt.b); got != tt.want {
+				t.Errorf("schedulesBefore() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package tasks
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wisdommatt/todo-list-api/internal/apperror"
+	"github.com/wisdommatt/todo-list-api/internal/tenant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// syncTokenPrefix makes sync tokens URIs as required by RFC 6578.
+const syncTokenPrefix = "urn:todo-list-api:sync:"
+
+// syncStateLifetime is how long unused sync tokens stay valid, clients
+// with older tokens download their collection again.
+const syncStateLifetime = 30 * 24 * time.Hour
+
+// ErrInvalidSyncToken is returned for unknown or expired sync tokens.
+var ErrInvalidSyncToken = apperror.New(apperror.Invalid, "invalid_sync_token", "invalid sync token")
+
+// SyncState is a snapshot of the entity tags of the objects of a CalDAV
+// collection, sync tokens refer to them so the changes since a token are
+// found by comparing the snapshot with the collection. Objects of the
+// snapshot missing from the collection were deleted.
+type SyncState struct {
+	ID          string       `bson:"_id"`
+	UserID      string       `bson:"userId"`
+	Collection  string       `bson:"collection"`
+	WorkspaceID string       `bson:"workspaceId"`
+	Objects     []SyncObject `bson:"objects"`
+	LastUsed    time.Time    `bson:"lastUsed"`
+}
+
+// SyncObject is the entity tag of an object when a snapshot was taken.
+type SyncObject struct {
+	Name string `bson:"name"`
+	ETag string `bson:"etag"`
+}
+
+// SaveSyncState saves a snapshot of the entity tags of the collection
+// objects and returns its sync token. Identical snapshots have the same
+// token, so it also serves as the collection tag.
+func (s *Service) SaveSyncState(ctx context.Context, userID, collection string, etags map[string]string) (string, error) {
+	log := s.log.WithContext(ctx).WithField("userId", userID).WithField("collection", collection)
+	objects := make([]SyncObject, 0, len(etags))
+	for name, etag := range etags {
+		objects = append(objects, SyncObject{Name: name, ETag: etag})
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n%s\n", tenant.WorkspaceID(ctx), userID, collection)
+	for _, object := range objects {
+		fmt.Fprintf(hash, "%s %s\n", object.Name, object.ETag)
+	}
+	stateID := hex.EncodeToString(hash.Sum(nil)[:16])
+
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{"lastUsed": now},
+		"$setOnInsert": bson.M{
+			"userId":      userID,
+			"collection":  collection,
+			"workspaceId": tenant.WorkspaceID(ctx),
+			"objects":     objects,
+		},
+	}
+	result, err := s.syncCollection.UpdateOne(ctx, bson.M{"_id": stateID}, update, options.Update().SetUpsert(true))
+	if err != nil {
+		log.WithError(err).Error("failed to save sync state to db")
+		return "", err
+	}
+	if result.UpsertedCount > 0 {
+		_, err = s.syncCollection.DeleteMany(ctx, bson.M{"lastUsed": bson.M{"$lt": now.Add(-syncStateLifetime)}})
+		if err != nil {
+			log.WithError(err).Error("failed to delete expired sync states from db")
+		}
+	}
+	return syncTokenPrefix + stateID, nil
+}
+
+// GetSyncState returns the entity tags of the collection objects saved
+// with the sync token.
+func (s *Service) GetSyncState(ctx context.Context, userID, collection, token string) (map[string]string, error) {
+	log := s.log.WithContext(ctx).WithField("userId", userID).WithField("collection", collection)
+	if !strings.HasPrefix(token, syncTokenPrefix) {
+		return nil, ErrInvalidSyncToken
+	}
+	var state SyncState
+	filter := tenant.Filter(ctx, "workspaceId", bson.M{
+		"_id":        strings.TrimPrefix(token, syncTokenPrefix),
+		"userId":     userID,
+		"collection": collection,
+		"lastUsed":   bson.M{"$gte": time.Now().Add(-syncStateLifetime)},
+	})
+	err := s.syncCollection.FindOne(ctx, filter).Decode(&state)
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve sync state from db")
+		return nil, ErrInvalidSyncToken
+	}
+	etags := make(map[string]string, len(state.Objects))
+	for _, object := range state.Objects {
+		etags[object.Name] = object.ETag
+	}
+	return etags, nil
+}
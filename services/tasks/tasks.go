@@ -2,9 +2,15 @@ package tasks
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/wisdommatt/todo-list-api/internal/apperror"
+	"github.com/wisdommatt/todo-list-api/internal/tenant"
+	"github.com/wisdommatt/todo-list-api/services/notifications"
+	"github.com/wisdommatt/todo-list-api/services/projects"
+	"github.com/wisdommatt/todo-list-api/services/shares"
 	"github.com/wisdommatt/todo-list-api/services/users"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -12,68 +18,185 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+var (
+	// ErrTaskNotFound is returned when the task does not exist or the user cannot view it.
+	ErrTaskNotFound = apperror.New(apperror.NotFound, "task_not_found", "task does not exist")
+	// ErrTaskForbidden is returned when the user can view the task but not modify it.
+	ErrTaskForbidden = apperror.New(apperror.Forbidden, "task_forbidden", "you are not allowed to update this task")
+	// ErrTaskOverlap is returned when a task overlaps another task of its participants.
+	ErrTaskOverlap = apperror.New(apperror.Conflict, "task_overlap", "the task overlaps another task")
+)
+
 type Task struct {
 	ID        string    `json:"id" bson:"_id,omitempty"`
 	Title     string    `json:"title" bson:"title,omitempty"`
 	StartTime time.Time `json:"startTime" bson:"startTime,omitempty"`
 	EndTime   time.Time `json:"endTime" bson:"endTime,omitempty"`
-	UserID    string    `json:"userId" bson:"userId,omitempty"`
-	Status    string    `json:"status" bson:"status,omitempty"`
+	// UserID is the owner of the task.
+	UserID string `json:"userId" bson:"userId,omitempty"`
+	// CreatedBy is the user that created the task, which can differ from the owner.
+	CreatedBy string `json:"createdBy" bson:"createdBy,omitempty"`
+	// Assignees are the users working on the task, the task is part of their calendar.
+	Assignees []string `json:"assignees" bson:"assignees,omitempty"`
+	ProjectID string   `json:"projectId,omitempty" bson:"projectId,omitempty"`
+	// WorkspaceID is the workspace the task belongs to, it is set from the request workspace.
+	WorkspaceID string   `json:"workspaceId" bson:"workspaceId,omitempty"`
+	Status      string   `json:"status" bson:"status,omitempty"`
+	Tags        []string `json:"tags" bson:"tags,omitempty"`
+	// AllDay tasks span whole dates, their start and end times are the UTC
+	// midnights of the first day and of the day after the last day.
+	AllDay bool `json:"allDay" bson:"allDay,omitempty"`
+	// Recurrence is the RFC 5545 recurrence rule repeating the task e.g FREQ=WEEKLY;BYDAY=MO.
+	Recurrence string `json:"recurrence,omitempty" bson:"recurrence,omitempty"`
+	// UID is the iCalendar unique identifier of imported tasks.
+	UID string `json:"uid,omitempty" bson:"uid,omitempty"`
+	// ObjectName is the resource name of tasks created by CalDAV clients.
+	ObjectName string `json:"-" bson:"objectName,omitempty"`
+	// Flexible tasks have no fixed time, they are placed in free time by the scheduler.
+	Flexible         bool      `json:"flexible" bson:"flexible,omitempty"`
+	EstimatedMinutes int       `json:"estimatedMinutes,omitempty" bson:"estimatedMinutes,omitempty"`
+	Deadline         time.Time `json:"deadline" bson:"deadline,omitempty"`
+	// Priority orders flexible tasks when scheduling, higher priorities are placed first.
+	Priority int `json:"priority,omitempty" bson:"priority,omitempty"`
+	// DependsOn are the ids of the tasks that must end before the task starts.
+	DependsOn []string  `json:"dependsOn,omitempty" bson:"dependsOn,omitempty"`
 	TimeAdded time.Time `json:"-" bson:"timeAdded,omitempty"`
 }
 
+// Participants returns the ids of the users whose calendar contains the task.
+func (t Task) Participants() []string {
+	participants := []string{t.UserID}
+	for _, assignee := range t.Assignees {
+		if assignee != t.UserID {
+			participants = append(participants, assignee)
+		}
+	}
+	return participants
+}
+
+// AllDayRange returns the UTC midnights bounding the dates of an all-day
+// task, the end is exclusive and at least one day after the start.
+func AllDayRange(startTime, endTime time.Time) (time.Time, time.Time) {
+	start := time.Date(startTime.Year(), startTime.Month(), startTime.Day(), 0, 0, 0, 0, time.UTC)
+	end := time.Date(endTime.Year(), endTime.Month(), endTime.Day(), 0, 0, 0, 0, time.UTC)
+	if endTime.Hour() != 0 || endTime.Minute() != 0 || endTime.Second() != 0 {
+		// an end time during a day includes that day.
+		end = end.AddDate(0, 0, 1)
+	}
+	if !end.After(start) {
+		end = start.AddDate(0, 0, 1)
+	}
+	return start, end
+}
+
+// DeleteHook is called after a task is deleted to clean up data attached to it.
+type DeleteHook func(ctx context.Context, taskID string) error
+
+// BusyProvider is a source of busy time of users outside of their tasks
+// e.g the events of external calendars. Busy events are returned as tasks
+// without id, between from and to.
+type BusyProvider interface {
+	GetBusyTasks(ctx context.Context, userIDs []string, from, to time.Time) ([]Task, error)
+}
+
 type Service struct {
-	usersService *users.Service
-	dbCollection *mongo.Collection
-	log          *logrus.Logger
+	usersService         *users.Service
+	projectsService      *projects.Service
+	sharesService        *shares.Service
+	notificationsService *notifications.Service
+	dbCollection         *mongo.Collection
+	plansCollection      *mongo.Collection
+	syncCollection       *mongo.Collection
+	log                  *logrus.Logger
+	deleteHooks          []DeleteHook
+	busyProviders        []BusyProvider
 }
 
-func NewService(usersService *users.Service, db *mongo.Database, log *logrus.Logger) *Service {
+func NewService(usersService *users.Service, projectsService *projects.Service, sharesService *shares.Service, notificationsService *notifications.Service, db *mongo.Database, log *logrus.Logger) *Service {
 	return &Service{
-		usersService: usersService,
-		dbCollection: db.Collection("tasks"),
-		log:          log,
+		usersService:         usersService,
+		projectsService:      projectsService,
+		sharesService:        sharesService,
+		notificationsService: notificationsService,
+		dbCollection:         db.Collection("tasks"),
+		plansCollection:      db.Collection("schedulePlans"),
+		syncCollection:       db.Collection("syncStates"),
+		log:                  log,
 	}
 }
 
 func (s *Service) CreateTask(ctx context.Context, task Task) (*Task, error) {
 	log := s.log.WithContext(ctx).WithField("task", task)
 	task.ID = primitive.NewObjectID().Hex()
+	task.WorkspaceID = tenant.WorkspaceID(ctx)
 	task.TimeAdded = time.Now()
+	if task.AllDay {
+		task.StartTime, task.EndTime = AllDayRange(task.StartTime, task.EndTime)
+	}
 	_, err := s.dbCollection.InsertOne(ctx, task)
 	if err != nil {
 		log.WithError(err).Error("failed to save task to db")
 		return nil, err
 	}
+	for _, assignee := range task.Assignees {
+		s.notifyAssignee(ctx, &task, assignee, task.CreatedBy)
+	}
 	return &task, nil
 }
 
 func (s *Service) GetTaskWithinTimeRange(ctx context.Context, userID string, startTime, endTime time.Time) (*Task, error) {
-	filter := bson.M{"$or": []bson.M{
-		{
-			"startTime": bson.M{"$lte": startTime},
-			"endTime":   bson.M{"$gte": startTime},
-			"userId":    userID,
-		},
-		{
-			"startTime": bson.M{"$lte": endTime},
-			"endTime":   bson.M{"$gte": endTime},
-			"userId":    userID,
-		},
-	}}
-	var task Task
-	err := s.dbCollection.FindOne(ctx, filter).Decode(&task)
+	return s.GetOverlappingTask(ctx, []string{userID}, startTime, endTime, "")
+}
+
+// GetOverlappingTask returns the first task overlapping the time range in the
+// calendar of any of the users (the tasks they own or are assigned to),
+// ignoring the task with excludeTaskID (used when rescheduling an existing task).
+// Time ranges are half-open, a task can start when another one ends.
+//
+// The occurrences of recurring tasks and the busy events of the busy
+// providers are considered too. mongo.ErrNoDocuments is returned when no
+// task overlaps the time range.
+func (s *Service) GetOverlappingTask(ctx context.Context, userIDs []string, startTime, endTime time.Time, excludeTaskID string) (*Task, error) {
+	var excludeTaskIDs []string
+	if excludeTaskID != "" {
+		excludeTaskIDs = []string{excludeTaskID}
+	}
+	busyTasks, err := s.getBusyTasks(ctx, userIDs, startTime, endTime, excludeTaskIDs)
 	if err != nil {
-		s.log.WithError(err).Error("cannot retrieve task from db")
 		return nil, err
 	}
-	return &task, nil
+	if len(busyTasks) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return &busyTasks[0], nil
+}
+
+// AddBusyProvider registers a source of busy time considered by the overlap
+// checks and the availability queries.
+func (s *Service) AddBusyProvider(provider BusyProvider) {
+	s.busyProviders = append(s.busyProviders, provider)
+}
+
+// getProvidedBusyTasks returns the busy events of the busy providers.
+func (s *Service) getProvidedBusyTasks(ctx context.Context, userIDs []string, from, to time.Time) ([]Task, error) {
+	var busyTasks []Task
+	for _, provider := range s.busyProviders {
+		providedTasks, err := provider.GetBusyTasks(ctx, userIDs, from, to)
+		if err != nil {
+			return nil, err
+		}
+		busyTasks = append(busyTasks, providedTasks...)
+	}
+	return busyTasks, nil
 }
 
 func (s *Service) GetTask(ctx context.Context, taskID string) (*Task, error) {
 	var task Task
 	log := s.log.WithContext(ctx).WithField("taskId", taskID)
-	err := s.dbCollection.FindOne(ctx, bson.M{"_id": taskID}).Decode(&task)
+	err := s.dbCollection.FindOne(ctx, tenant.Filter(ctx, "workspaceId", bson.M{"_id": taskID})).Decode(&task)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrTaskNotFound
+	}
 	if err != nil {
 		log.WithError(err).Error("failed to retrieve task from db by id")
 		return nil, err
@@ -81,6 +204,62 @@ func (s *Service) GetTask(ctx context.Context, taskID string) (*Task, error) {
 	return &task, nil
 }
 
+// GetVisibleTask returns the task when the user is allowed to see it, the
+// tasks the user cannot see are reported as not found.
+func (s *Service) GetVisibleTask(ctx context.Context, taskID, userID string) (*Task, error) {
+	task, err := s.GetTask(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.CanViewTask(ctx, task, userID) {
+		return nil, ErrTaskNotFound
+	}
+	return task, nil
+}
+
+// TaskRole evaluates the role of the user on the task, considering task
+// ownership, shares of the task and the role of the user on the task project.
+//
+// An empty string is returned when the user cannot access the task.
+func (s *Service) TaskRole(ctx context.Context, task *Task, userID string) string {
+	if userID == "" {
+		return ""
+	}
+	if task.UserID == userID {
+		return shares.RoleOwner
+	}
+	role := s.sharesService.GetUserRole(ctx, userID, shares.ResourceTask, task.ID)
+	for _, assignee := range task.Assignees {
+		if assignee == userID {
+			role = shares.HighestRole(role, shares.RoleEditor)
+		}
+	}
+	if task.ProjectID != "" {
+		project, err := s.projectsService.GetProject(ctx, task.ProjectID)
+		if err == nil {
+			role = shares.HighestRole(role, s.projectsService.ProjectRole(ctx, project, userID))
+		}
+	}
+	return role
+}
+
+// CanViewTask reports whether the user is allowed to see the task.
+func (s *Service) CanViewTask(ctx context.Context, task *Task, userID string) bool {
+	return shares.RoleAtLeast(s.TaskRole(ctx, task, userID), shares.RoleViewer)
+}
+
+// CanEditTask reports whether the user is allowed to modify the task.
+func (s *Service) CanEditTask(ctx context.Context, task *Task, userID string) bool {
+	return shares.RoleAtLeast(s.TaskRole(ctx, task, userID), shares.RoleEditor)
+}
+
+// CanManageTask reports whether the user is allowed to delete and share the task.
+func (s *Service) CanManageTask(ctx context.Context, task *Task, userID string) bool {
+	return shares.RoleAtLeast(s.TaskRole(ctx, task, userID), shares.RoleOwner)
+}
+
+// GetTasks returns the user tasks, including the tasks shared with the
+// user directly or through a project.
 func (s *Service) GetTasks(ctx context.Context, userID, lastID string, limit int) ([]Task, error) {
 	log := s.log.WithContext(ctx).WithField("userId", userID).WithField("lastId", lastID).
 		WithField("limit", limit)
@@ -88,7 +267,22 @@ func (s *Service) GetTasks(ctx context.Context, userID, lastID string, limit int
 	if err != nil {
 		return nil, err
 	}
-	filter := bson.M{"_id": bson.M{"$gt": lastID}, "userId": userID}
+	sharedTaskIDs, err := s.sharesService.GetSharedResourceIDs(ctx, userID, shares.ResourceTask)
+	if err != nil {
+		return nil, err
+	}
+	projectIDs, err := s.projectsService.GetProjectIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	filter := tenant.Filter(ctx, "workspaceId", bson.M{
+		"_id": bson.M{"$gt": lastID},
+		"$or": []bson.M{
+			{"userId": userID},
+			{"_id": bson.M{"$in": sharedTaskIDs}},
+			{"projectId": bson.M{"$in": projectIDs}},
+		},
+	})
 	findOpt := options.Find().SetLimit(int64(limit))
 	cursor, err := s.dbCollection.Find(ctx, filter, findOpt)
 	if err != nil {
@@ -105,10 +299,91 @@ func (s *Service) GetTasks(ctx context.Context, userID, lastID string, limit int
 	return tasks, nil
 }
 
+// GetAssignedTasks returns the tasks assigned to the user.
+func (s *Service) GetAssignedTasks(ctx context.Context, userID, lastID string, limit int) ([]Task, error) {
+	log := s.log.WithContext(ctx).WithField("userId", userID).WithField("lastId", lastID).
+		WithField("limit", limit)
+	filter := tenant.Filter(ctx, "workspaceId", bson.M{"_id": bson.M{"$gt": lastID}, "assignees": userID})
+	findOpt := options.Find().SetLimit(int64(limit))
+	cursor, err := s.dbCollection.Find(ctx, filter, findOpt)
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve assigned tasks from db")
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var tasks []Task
+	err = cursor.All(ctx, &tasks)
+	if err != nil {
+		log.WithError(err).Error("failed to decode retrieved assigned tasks")
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// AssignTask adds the user to the task assignees and notifies the user.
+func (s *Service) AssignTask(ctx context.Context, taskID, userID, assignedBy string) (*Task, error) {
+	log := s.log.WithContext(ctx).WithField("taskId", taskID).WithField("userId", userID)
+	filter := tenant.Filter(ctx, "workspaceId", bson.M{"_id": taskID})
+	_, err := s.dbCollection.UpdateOne(ctx, filter, bson.M{"$addToSet": bson.M{"assignees": userID}})
+	if err != nil {
+		log.WithError(err).Error("failed to assign task in db")
+		return nil, err
+	}
+	task, err := s.GetTask(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	s.notifyAssignee(ctx, task, userID, assignedBy)
+	return task, nil
+}
+
+// UnassignTask removes the user from the task assignees.
+func (s *Service) UnassignTask(ctx context.Context, taskID, userID string) (*Task, error) {
+	log := s.log.WithContext(ctx).WithField("taskId", taskID).WithField("userId", userID)
+	filter := tenant.Filter(ctx, "workspaceId", bson.M{"_id": taskID})
+	_, err := s.dbCollection.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"assignees": userID}})
+	if err != nil {
+		log.WithError(err).Error("failed to unassign task in db")
+		return nil, err
+	}
+	return s.GetTask(ctx, taskID)
+}
+
+func (s *Service) notifyAssignee(ctx context.Context, task *Task, userID, assignedBy string) {
+	if userID == assignedBy {
+		return
+	}
+	s.notificationsService.CreateNotification(ctx, notifications.Notification{
+		UserID:  userID,
+		Type:    notifications.TypeAssignment,
+		Message: fmt.Sprintf("you were assigned to %s", task.Title),
+		ActorID: assignedBy,
+		TaskID:  task.ID,
+	})
+}
+
+// OnTaskDeleted registers a hook that runs every time a task is deleted.
+func (s *Service) OnTaskDeleted(hook DeleteHook) {
+	s.deleteHooks = append(s.deleteHooks, hook)
+}
+
 func (s *Service) DeleteTask(ctx context.Context, taskID string) (*Task, error) {
+	task, err := s.deleteTask(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	s.runDeleteHooks(ctx, taskID)
+	return task, nil
+}
+
+// deleteTask removes the task from the db without running the delete hooks.
+func (s *Service) deleteTask(ctx context.Context, taskID string) (*Task, error) {
 	var task Task
 	log := s.log.WithContext(ctx).WithField("taskId", taskID)
-	err := s.dbCollection.FindOneAndDelete(ctx, bson.M{"_id": taskID}).Decode(&task)
+	err := s.dbCollection.FindOneAndDelete(ctx, tenant.Filter(ctx, "workspaceId", bson.M{"_id": taskID})).Decode(&task)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrTaskNotFound
+	}
 	if err != nil {
 		log.WithError(err).Error("failed to delete task from db")
 		return nil, err
@@ -116,9 +391,30 @@ func (s *Service) DeleteTask(ctx context.Context, taskID string) (*Task, error)
 	return &task, nil
 }
 
+func (s *Service) runDeleteHooks(ctx context.Context, taskID string) {
+	for _, hook := range s.deleteHooks {
+		err := hook(ctx, taskID)
+		if err != nil {
+			s.log.WithContext(ctx).WithField("taskId", taskID).WithError(err).Error("task delete hook failed")
+		}
+	}
+}
+
+// RemoveProjectTasks detaches every task from the project.
+func (s *Service) RemoveProjectTasks(ctx context.Context, projectID string) error {
+	_, err := s.dbCollection.UpdateMany(ctx, tenant.Filter(ctx, "workspaceId", bson.M{"projectId": projectID}), bson.M{"$unset": bson.M{"projectId": ""}})
+	if err != nil {
+		s.log.WithContext(ctx).WithField("projectId", projectID).WithError(err).Error("failed to detach project tasks in db")
+		return err
+	}
+	return nil
+}
+
 func (s *Service) UpdateTask(ctx context.Context, taskID string, update Task) (*Task, error) {
 	log := s.log.WithContext(ctx).WithField("taskId", taskID).WithField("update", update)
-	filter := bson.M{"_id": taskID}
+	filter := tenant.Filter(ctx, "workspaceId", bson.M{"_id": taskID})
+	// tasks cannot be moved to another workspace.
+	update.WorkspaceID = ""
 	updateBSON := bson.M{"$set": update}
 	_, err := s.dbCollection.UpdateOne(ctx, filter, updateBSON)
 	if err != nil {
@@ -0,0 +1,305 @@
+package timeentries
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wisdommatt/todo-list-api/internal/apperror"
+	"github.com/wisdommatt/todo-list-api/internal/tenant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrTimerRunning is returned when starting a timer while another one is running.
+	ErrTimerRunning = apperror.New(apperror.Conflict, "timer_running", "a timer is already running, stop it first")
+	// ErrNoRunningTimer is returned when stopping a timer that is not running.
+	ErrNoRunningTimer = apperror.New(apperror.Invalid, "no_running_timer", "there is no running timer for this task")
+	// ErrTimeEntryNotFound is returned when the time entry does not exist.
+	ErrTimeEntryNotFound = apperror.New(apperror.NotFound, "time_entry_not_found", "time entry does not exist")
+)
+
+// TimeEntry is time actually spent by a user on a task, running timers have no end time.
+type TimeEntry struct {
+	ID          string    `json:"id" bson:"_id,omitempty"`
+	TaskID      string    `json:"taskId" bson:"taskId,omitempty"`
+	UserID      string    `json:"userId" bson:"userId,omitempty"`
+	ProjectID   string    `json:"projectId,omitempty" bson:"projectId,omitempty"`
+	WorkspaceID string    `json:"workspaceId" bson:"workspaceId,omitempty"`
+	StartTime   time.Time `json:"startTime" bson:"startTime,omitempty"`
+	EndTime     time.Time `json:"endTime" bson:"endTime,omitempty"`
+	Running     bool      `json:"running" bson:"running"`
+	Manual      bool      `json:"manual" bson:"manual"`
+	Seconds     int64     `json:"seconds" bson:"seconds"`
+	Note        string    `json:"note,omitempty" bson:"note,omitempty"`
+	TimeAdded   time.Time `json:"timeAdded" bson:"timeAdded,omitempty"`
+	LastUpdated time.Time `json:"lastUpdated" bson:"lastUpdated,omitempty"`
+}
+
+// ElapsedSeconds returns the entry duration, running timers are measured up to now.
+func (e TimeEntry) ElapsedSeconds(now time.Time) int64 {
+	if e.Running {
+		return int64(now.Sub(e.StartTime).Seconds())
+	}
+	return e.Seconds
+}
+
+// TimesheetDay is the time tracked during a day, grouped by project.
+type TimesheetDay struct {
+	Date     string             `json:"date"`
+	Seconds  int64              `json:"seconds"`
+	Projects []TimesheetProject `json:"projects"`
+}
+
+// TimesheetProject is the time tracked for a project during a day, tasks
+// without a project are grouped under an empty project id.
+type TimesheetProject struct {
+	ProjectID string `json:"projectId"`
+	Seconds   int64  `json:"seconds"`
+}
+
+type Service struct {
+	dbCollection *mongo.Collection
+	log          *logrus.Logger
+}
+
+func NewService(db *mongo.Database, log *logrus.Logger) *Service {
+	return &Service{
+		dbCollection: db.Collection("timeEntries"),
+		log:          log,
+	}
+}
+
+// EnsureIndexes creates the indexes the service relies on, a unique index
+// keeps users from having two running timers when starting them concurrently.
+func (s *Service) EnsureIndexes(ctx context.Context) error {
+	_, err := s.dbCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "userId", Value: 1}},
+		Options: options.Index().
+			SetName("userId_running_unique").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"running": true}),
+	})
+	if err != nil {
+		s.log.WithContext(ctx).WithError(err).Error("failed to create time entries indexes")
+		return err
+	}
+	return nil
+}
+
+// StartTimer starts a timer on the task for the user, a user can only have
+// one running timer across every task and workspace.
+func (s *Service) StartTimer(ctx context.Context, taskID, projectID, userID string) (*TimeEntry, error) {
+	log := s.log.WithContext(ctx).WithField("taskId", taskID).WithField("userId", userID)
+	entry := TimeEntry{
+		ID:          primitive.NewObjectID().Hex(),
+		TaskID:      taskID,
+		UserID:      userID,
+		ProjectID:   projectID,
+		WorkspaceID: tenant.WorkspaceID(ctx),
+		StartTime:   time.Now(),
+		Running:     true,
+		TimeAdded:   time.Now(),
+		LastUpdated: time.Now(),
+	}
+	// the upsert only inserts the new entry when the user has no running
+	// timer, otherwise the running timer is matched and left untouched.
+	filter := bson.M{"userId": userID, "running": true}
+	update := bson.M{"$setOnInsert": entry}
+	var runningEntry TimeEntry
+	err := s.dbCollection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetUpsert(true)).
+		Decode(&runningEntry)
+	// concurrent upserts can both miss the running timer, the unique index
+	// rejects the second insert.
+	if err == nil || mongo.IsDuplicateKeyError(err) {
+		return nil, ErrTimerRunning
+	}
+	if err != mongo.ErrNoDocuments {
+		log.WithError(err).Error("failed to save timer to db")
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// StopTimer stops the user running timer on the task.
+func (s *Service) StopTimer(ctx context.Context, taskID, userID string) (*TimeEntry, error) {
+	log := s.log.WithContext(ctx).WithField("taskId", taskID).WithField("userId", userID)
+	filter := tenant.Filter(ctx, "workspaceId", bson.M{"taskId": taskID, "userId": userID, "running": true})
+	var entry TimeEntry
+	err := s.dbCollection.FindOne(ctx, filter).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNoRunningTimer
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve running timer from db")
+		return nil, err
+	}
+	now := time.Now()
+	update := bson.M{"$set": bson.M{
+		"endTime":     now,
+		"running":     false,
+		"seconds":     int64(now.Sub(entry.StartTime).Seconds()),
+		"lastUpdated": now,
+	}}
+	result, err := s.dbCollection.UpdateOne(ctx, bson.M{"_id": entry.ID, "running": true}, update)
+	if err != nil {
+		log.WithError(err).Error("failed to stop timer in db")
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		// a concurrent request stopped the timer first.
+		return nil, ErrNoRunningTimer
+	}
+	return s.GetTimeEntry(ctx, entry.ID)
+}
+
+// CreateTimeEntry saves a manual time entry.
+func (s *Service) CreateTimeEntry(ctx context.Context, entry TimeEntry) (*TimeEntry, error) {
+	log := s.log.WithContext(ctx).WithField("entry", entry)
+	entry.ID = primitive.NewObjectID().Hex()
+	entry.WorkspaceID = tenant.WorkspaceID(ctx)
+	entry.Running = false
+	entry.Manual = true
+	entry.Seconds = int64(entry.EndTime.Sub(entry.StartTime).Seconds())
+	entry.TimeAdded = time.Now()
+	entry.LastUpdated = time.Now()
+	_, err := s.dbCollection.InsertOne(ctx, entry)
+	if err != nil {
+		log.WithError(err).Error("failed to save time entry to db")
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (s *Service) GetTimeEntry(ctx context.Context, entryID string) (*TimeEntry, error) {
+	var entry TimeEntry
+	log := s.log.WithContext(ctx).WithField("entryId", entryID)
+	err := s.dbCollection.FindOne(ctx, tenant.Filter(ctx, "workspaceId", bson.M{"_id": entryID})).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrTimeEntryNotFound
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve time entry from db by id")
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// GetTaskTimeEntries returns the time entries of a task, oldest first.
+func (s *Service) GetTaskTimeEntries(ctx context.Context, taskID string) ([]TimeEntry, error) {
+	filter := tenant.Filter(ctx, "workspaceId", bson.M{"taskId": taskID})
+	return s.findTimeEntries(ctx, filter)
+}
+
+// UpdateTimeEntry changes the time range and note of a stopped time entry.
+func (s *Service) UpdateTimeEntry(ctx context.Context, entryID string, startTime, endTime time.Time, note string) (*TimeEntry, error) {
+	log := s.log.WithContext(ctx).WithField("entryId", entryID)
+	filter := tenant.Filter(ctx, "workspaceId", bson.M{"_id": entryID, "running": false})
+	update := bson.M{"$set": bson.M{
+		"startTime":   startTime,
+		"endTime":     endTime,
+		"seconds":     int64(endTime.Sub(startTime).Seconds()),
+		"note":        note,
+		"lastUpdated": time.Now(),
+	}}
+	_, err := s.dbCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.WithError(err).Error("failed to update time entry in db")
+		return nil, err
+	}
+	return s.GetTimeEntry(ctx, entryID)
+}
+
+func (s *Service) DeleteTimeEntry(ctx context.Context, entryID string) (*TimeEntry, error) {
+	var entry TimeEntry
+	log := s.log.WithContext(ctx).WithField("entryId", entryID)
+	err := s.dbCollection.FindOneAndDelete(ctx, tenant.Filter(ctx, "workspaceId", bson.M{"_id": entryID})).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrTimeEntryNotFound
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to delete time entry from db")
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// GetTimesheet returns the time tracked by the user between from and to,
+// grouped by day in loc and by project. Entries spanning several days are
+// split at midnight, running timers are counted up to now.
+func (s *Service) GetTimesheet(ctx context.Context, userID string, from, to time.Time, loc *time.Location) ([]TimesheetDay, error) {
+	now := time.Now()
+	filter := tenant.Filter(ctx, "workspaceId", bson.M{
+		"userId":    userID,
+		"startTime": bson.M{"$lt": to},
+		"$or": []bson.M{
+			{"running": true},
+			{"endTime": bson.M{"$gt": from}},
+		},
+	})
+	entries, err := s.findTimeEntries(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	dayProjects := map[string]map[string]int64{}
+	for _, entry := range entries {
+		start, end := entry.StartTime, entry.EndTime
+		if entry.Running {
+			end = now
+		}
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		for start.Before(end) {
+			localStart := start.In(loc)
+			nextDay := time.Date(localStart.Year(), localStart.Month(), localStart.Day()+1, 0, 0, 0, 0, loc)
+			chunkEnd := end
+			if nextDay.Before(end) {
+				chunkEnd = nextDay
+			}
+			date := localStart.Format("2006-01-02")
+			if dayProjects[date] == nil {
+				dayProjects[date] = map[string]int64{}
+			}
+			dayProjects[date][entry.ProjectID] += int64(chunkEnd.Sub(start).Seconds())
+			start = chunkEnd
+		}
+	}
+	days := make([]TimesheetDay, 0, len(dayProjects))
+	for date, projects := range dayProjects {
+		day := TimesheetDay{Date: date}
+		for projectID, seconds := range projects {
+			day.Seconds += seconds
+			day.Projects = append(day.Projects, TimesheetProject{ProjectID: projectID, Seconds: seconds})
+		}
+		sort.Slice(day.Projects, func(i, j int) bool { return day.Projects[i].ProjectID < day.Projects[j].ProjectID })
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Date < days[j].Date })
+	return days, nil
+}
+
+func (s *Service) findTimeEntries(ctx context.Context, filter bson.M) ([]TimeEntry, error) {
+	log := s.log.WithContext(ctx).WithField("filter", filter)
+	findOpt := options.Find().SetSort(bson.M{"startTime": 1})
+	cursor, err := s.dbCollection.Find(ctx, filter, findOpt)
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve time entries from db")
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var entries []TimeEntry
+	err = cursor.All(ctx, &entries)
+	if err != nil {
+		log.WithError(err).Error("failed to decode retrieved time entries")
+		return nil, err
+	}
+	return entries, nil
+}
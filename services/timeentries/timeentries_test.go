@@ -0,0 +1,103 @@
+package timeentries
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func newMockService(mt *mtest.T) *Service {
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+	return &Service{dbCollection: mt.Coll, log: log}
+}
+
+func TestStopTimer(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	ns := "db.timeEntries"
+	running := bson.D{
+		{Key: "_id", Value: "entry"},
+		{Key: "taskId", Value: "task"},
+		{Key: "userId", Value: "user"},
+		{Key: "startTime", Value: time.Now().Add(-time.Hour)},
+		{Key: "running", Value: true},
+	}
+
+	mt.Run("no running timer", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch))
+		if _, err := newMockService(mt).StopTimer(context.Background(), "task", "user"); err != ErrNoRunningTimer {
+			mt.Errorf("StopTimer() error = %v, want %v", err, ErrNoRunningTimer)
+		}
+	})
+
+	mt.Run("stopped by a concurrent request", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, running),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "nModified", Value: 0}),
+		)
+		if entry, err := newMockService(mt).StopTimer(context.Background(), "task", "user"); err != ErrNoRunningTimer {
+			mt.Errorf("StopTimer() = %+v, %v, want %v", entry, err, ErrNoRunningTimer)
+		}
+	})
+
+	mt.Run("stopped", func(mt *mtest.T) {
+		stopped := bson.D{{Key: "_id", Value: "entry"}, {Key: "running", Value: false}, {Key: "seconds", Value: 3600}}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, running),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, stopped),
+		)
+		entry, err := newMockService(mt).StopTimer(context.Background(), "task", "user")
+		if err != nil {
+			mt.Fatalf("StopTimer() error = %v", err)
+		}
+		if entry.Running || entry.Seconds != 3600 {
+			mt.Errorf("StopTimer() = %+v, want the stopped entry", entry)
+		}
+	})
+}
+
+func TestStartTimerAlreadyRunning(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("running timer matched", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{{Key: "_id", Value: "entry"}}}))
+		if _, err := newMockService(mt).StartTimer(context.Background(), "task", "", "user"); err != ErrTimerRunning {
+			mt.Errorf("StartTimer() error = %v, want %v", err, ErrTimerRunning)
+		}
+	})
+
+	mt.Run("concurrent insert", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "duplicate key"}))
+		if _, err := newMockService(mt).StartTimer(context.Background(), "task", "", "user"); err != ErrTimerRunning {
+			mt.Errorf("StartTimer() error = %v, want %v", err, ErrTimerRunning)
+		}
+	})
+}
+
+func TestGetTimeEntry(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("not found", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.timeEntries", mtest.FirstBatch))
+		if _, err := newMockService(mt).GetTimeEntry(context.Background(), "entry"); err != ErrTimeEntryNotFound {
+			mt.Errorf("GetTimeEntry() error = %v, want %v", err, ErrTimeEntryNotFound)
+		}
+	})
+
+	mt.Run("db error", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 11600, Message: "interrupted at shutdown"}))
+		_, err := newMockService(mt).GetTimeEntry(context.Background(), "entry")
+		if err == nil || err == ErrTimeEntryNotFound {
+			mt.Errorf("GetTimeEntry() error = %v, want the db error", err)
+		}
+	})
+}
@@ -0,0 +1,162 @@
+package users
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/wisdommatt/todo-list-api/internal/apperror"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AccessTokenPrefix starts every personal access token, it tells them apart
+// from auth tokens and makes leaked tokens easy to search for.
+const AccessTokenPrefix = "tdl_pat_"
+
+// accessTokenLastUsedInterval limits how often the last use of an access
+// token is saved, scripts can send many requests.
+const accessTokenLastUsedInterval = time.Minute
+
+// The scopes of personal access tokens, every other endpoint rejects them.
+const (
+	ScopeTasksRead  = "tasks:read"
+	ScopeTasksWrite = "tasks:write"
+)
+
+// scopes are the valid scopes of personal access tokens.
+var scopes = []string{ScopeTasksRead, ScopeTasksWrite}
+
+var (
+	// ErrAccessTokenNotFound is returned when revoking an access token the user does not have.
+	ErrAccessTokenNotFound = apperror.New(apperror.NotFound, "access_token_not_found", "access token does not exist")
+	// ErrUnknownScope is returned when creating an access token with a scope which does not exist.
+	ErrUnknownScope = apperror.New(apperror.Invalid, "unknown_scope", "the scopes must be tasks:read or tasks:write")
+)
+
+// AccessToken is a personal access token for scripts and integrations, it
+// gives access to a single workspace and to the endpoints of its scopes.
+// Only its hash is stored.
+type AccessToken struct {
+	ID          string   `json:"id" bson:"id"`
+	Name        string   `json:"name" bson:"name"`
+	TokenHash   string   `json:"-" bson:"tokenHash"`
+	Scopes      []string `json:"scopes" bson:"scopes"`
+	WorkspaceID string   `json:"workspaceId" bson:"workspaceId"`
+	// ExpiresAt is zero for tokens which never expire.
+	ExpiresAt time.Time `json:"expiresAt" bson:"expiresAt,omitempty"`
+	TimeAdded time.Time `json:"timeAdded" bson:"timeAdded"`
+	LastUsed  time.Time `json:"lastUsed" bson:"lastUsed,omitempty"`
+}
+
+// HasScope reports whether the access token gives access to the scope.
+func (t AccessToken) HasScope(scope string) bool {
+	for _, tokenScope := range t.Scopes {
+		if tokenScope == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsExpired reports whether the access token cannot be used anymore.
+func (t AccessToken) IsExpired() bool {
+	return !t.ExpiresAt.IsZero() && !time.Now().Before(t.ExpiresAt)
+}
+
+// CreateAccessToken generates a new access token for the workspace, it
+// never expires when expiresAt is zero. The token is only returned once.
+func (s *Service) CreateAccessToken(ctx context.Context, userID, workspaceID, name string, tokenScopes []string, expiresAt time.Time) (*AccessToken, string, error) {
+	log := s.log.WithContext(ctx).WithField("userId", userID)
+	for _, scope := range tokenScopes {
+		if !isScope(scope) {
+			return nil, "", ErrUnknownScope
+		}
+	}
+	token, err := generateToken()
+	if err != nil {
+		log.WithError(err).Error("failed to generate access token")
+		return nil, "", err
+	}
+	token = AccessTokenPrefix + token
+	accessToken := AccessToken{
+		ID:          primitive.NewObjectID().Hex(),
+		Name:        name,
+		TokenHash:   hashToken(token),
+		Scopes:      tokenScopes,
+		WorkspaceID: workspaceID,
+		ExpiresAt:   expiresAt,
+		TimeAdded:   time.Now(),
+	}
+	_, err = s.dbCollection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$push": bson.M{"accessTokens": accessToken}, "$set": bson.M{"lastUpdated": time.Now()}})
+	if err != nil {
+		log.WithError(err).Error("failed to save access token to db")
+		return nil, "", err
+	}
+	return &accessToken, token, nil
+}
+
+// DeleteAccessToken revokes the access token of the user.
+func (s *Service) DeleteAccessToken(ctx context.Context, userID, accessTokenID string) error {
+	log := s.log.WithContext(ctx).WithField("userId", userID).WithField("accessTokenId", accessTokenID)
+	filter := bson.M{"_id": userID, "accessTokens.id": accessTokenID}
+	update := bson.M{"$pull": bson.M{"accessTokens": bson.M{"id": accessTokenID}}, "$set": bson.M{"lastUpdated": time.Now()}}
+	result, err := s.dbCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.WithError(err).Error("failed to delete access token from db")
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrAccessTokenNotFound
+	}
+	return nil
+}
+
+// AuthenticateAccessToken returns the user with the access token, expired
+// tokens are rejected with ErrInvalidCredentials.
+func (s *Service) AuthenticateAccessToken(ctx context.Context, token string) (*User, *AccessToken, error) {
+	var user User
+	log := s.log.WithContext(ctx)
+	if !strings.HasPrefix(token, AccessTokenPrefix) {
+		return nil, nil, ErrInvalidCredentials
+	}
+	tokenHash := hashToken(token)
+	err := s.dbCollection.FindOne(ctx, bson.M{"accessTokens.tokenHash": tokenHash}).Decode(&user)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.WithError(err).Error("failed to retrieve user from db by access token")
+			return nil, nil, err
+		}
+		return nil, nil, ErrInvalidCredentials
+	}
+	for i := range user.AccessTokens {
+		accessToken := &user.AccessTokens[i]
+		if accessToken.TokenHash != tokenHash {
+			continue
+		}
+		if accessToken.IsExpired() {
+			return nil, nil, ErrInvalidCredentials
+		}
+		if time.Since(accessToken.LastUsed) > accessTokenLastUsedInterval {
+			accessToken.LastUsed = time.Now()
+			filter := bson.M{"_id": user.ID, "accessTokens.id": accessToken.ID}
+			update := bson.M{"$set": bson.M{"accessTokens.$.lastUsed": accessToken.LastUsed}}
+			_, err = s.dbCollection.UpdateOne(ctx, filter, update)
+			if err != nil {
+				log.WithError(err).WithField("userId", user.ID).Error("failed to save access token last use to db")
+			}
+		}
+		return &user, accessToken, nil
+	}
+	return nil, nil, ErrInvalidCredentials
+}
+
+func isScope(scope string) bool {
+	for _, valid := range scopes {
+		if valid == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"github.com/wisdommatt/todo-list-api/internal/apperror"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// appPasswordLastUsedInterval limits how often the last use of an app
+// password is saved, clients authenticate every request.
+const appPasswordLastUsedInterval = time.Minute
+
+// ErrAppPasswordNotFound is returned when revoking an app password the user does not have.
+var ErrAppPasswordNotFound = apperror.New(apperror.NotFound, "app_password_not_found", "app password does not exist")
+
+// AppPassword is a generated password for clients that cannot use auth
+// tokens e.g CalDAV clients. It gives access to a single workspace and only
+// its hash is stored.
+type AppPassword struct {
+	ID           string    `json:"id" bson:"id"`
+	Name         string    `json:"name" bson:"name"`
+	PasswordHash string    `json:"-" bson:"passwordHash"`
+	WorkspaceID  string    `json:"workspaceId" bson:"workspaceId"`
+	TimeAdded    time.Time `json:"timeAdded" bson:"timeAdded"`
+	LastUsed     time.Time `json:"lastUsed" bson:"lastUsed,omitempty"`
+}
+
+// CreateAppPassword generates a new app password for the workspace, the
+// password is only returned once.
+func (s *Service) CreateAppPassword(ctx context.Context, userID, workspaceID, name string) (*AppPassword, string, error) {
+	log := s.log.WithContext(ctx).WithField("userId", userID)
+	password, err := generateAppPassword()
+	if err != nil {
+		log.WithError(err).Error("failed to generate app password")
+		return nil, "", err
+	}
+	appPassword := AppPassword{
+		ID:           primitive.NewObjectID().Hex(),
+		Name:         name,
+		PasswordHash: hashToken(normalizeAppPassword(password)),
+		WorkspaceID:  workspaceID,
+		TimeAdded:    time.Now(),
+	}
+	_, err = s.dbCollection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$push": bson.M{"appPasswords": appPassword}, "$set": bson.M{"lastUpdated": time.Now()}})
+	if err != nil {
+		log.WithError(err).Error("failed to save app password to db")
+		return nil, "", err
+	}
+	return &appPassword, password, nil
+}
+
+// DeleteAppPassword revokes the app password of the user.
+func (s *Service) DeleteAppPassword(ctx context.Context, userID, appPasswordID string) error {
+	log := s.log.WithContext(ctx).WithField("userId", userID).WithField("appPasswordId", appPasswordID)
+	filter := bson.M{"_id": userID, "appPasswords.id": appPasswordID}
+	update := bson.M{"$pull": bson.M{"appPasswords": bson.M{"id": appPasswordID}}, "$set": bson.M{"lastUpdated": time.Now()}}
+	result, err := s.dbCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.WithError(err).Error("failed to delete app password from db")
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrAppPasswordNotFound
+	}
+	return nil
+}
+
+// AuthenticateAppPassword returns the user with the email and the matching app password.
+func (s *Service) AuthenticateAppPassword(ctx context.Context, email, password string) (*User, *AppPassword, error) {
+	var user User
+	log := s.log.WithContext(ctx).WithField("email", email)
+	passwordHash := hashToken(normalizeAppPassword(password))
+	filter := bson.M{"email": email, "appPasswords.passwordHash": passwordHash}
+	err := s.dbCollection.FindOne(ctx, filter).Decode(&user)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.WithError(err).Error("failed to retrieve user from db by app password")
+			return nil, nil, err
+		}
+		return nil, nil, ErrInvalidCredentials
+	}
+	for i := range user.AppPasswords {
+		appPassword := &user.AppPasswords[i]
+		if appPassword.PasswordHash != passwordHash {
+			continue
+		}
+		if time.Since(appPassword.LastUsed) > appPasswordLastUsedInterval {
+			appPassword.LastUsed = time.Now()
+			filter := bson.M{"_id": user.ID, "appPasswords.id": appPassword.ID}
+			update := bson.M{"$set": bson.M{"appPasswords.$.lastUsed": appPassword.LastUsed}}
+			_, err = s.dbCollection.UpdateOne(ctx, filter, update)
+			if err != nil {
+				log.WithError(err).Error("failed to save app password last use to db")
+			}
+		}
+		return &user, appPassword, nil
+	}
+	return nil, nil, ErrInvalidCredentials
+}
+
+// generateAppPassword returns a random password in groups of four
+// characters, easy to type on a phone.
+func generateAppPassword() (string, error) {
+	bytes := make([]byte, 15)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+	encoded := strings.ToLower(base32.StdEncoding.EncodeToString(bytes))
+	var groups []string
+	for i := 0; i < len(encoded); i += 4 {
+		groups = append(groups, encoded[i:i+4])
+	}
+	return strings.Join(groups, "-"), nil
+}
+
+// normalizeAppPassword ignores the case and the separators of app passwords.
+func normalizeAppPassword(password string) string {
+	password = strings.ToLower(password)
+	return strings.NewReplacer("-", "", " ", "").Replace(password)
+}
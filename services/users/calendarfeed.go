@@ -0,0 +1,89 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/wisdommatt/todo-list-api/internal/apperror"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrCalendarFeedNotFound is returned when no user has the calendar feed token.
+var ErrCalendarFeedNotFound = apperror.New(apperror.NotFound, "calendar_feed_not_found", "calendar feed does not exist")
+
+// CalendarFeed is the secret token giving read access to the user calendar
+// feed of a workspace without authentication, only the token hash is stored.
+type CalendarFeed struct {
+	TokenHash   string    `bson:"tokenHash"`
+	WorkspaceID string    `bson:"workspaceId"`
+	TimeAdded   time.Time `bson:"timeAdded"`
+}
+
+// GenerateCalendarFeedToken creates a new calendar feed token for the
+// workspace calendar of the user, replacing the previous one.
+func (s *Service) GenerateCalendarFeedToken(ctx context.Context, userID, workspaceID string) (string, error) {
+	log := s.log.WithContext(ctx).WithField("userId", userID)
+	token, err := generateToken()
+	if err != nil {
+		log.WithError(err).Error("failed to generate calendar feed token")
+		return "", err
+	}
+	feed := CalendarFeed{
+		TokenHash:   hashToken(token),
+		WorkspaceID: workspaceID,
+		TimeAdded:   time.Now(),
+	}
+	_, err = s.dbCollection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"calendarFeed": feed, "lastUpdated": time.Now()}})
+	if err != nil {
+		log.WithError(err).Error("failed to save calendar feed token to db")
+		return "", err
+	}
+	return token, nil
+}
+
+// RevokeCalendarFeedToken disables the user calendar feed.
+func (s *Service) RevokeCalendarFeedToken(ctx context.Context, userID string) error {
+	log := s.log.WithContext(ctx).WithField("userId", userID)
+	_, err := s.dbCollection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$unset": bson.M{"calendarFeed": ""}, "$set": bson.M{"lastUpdated": time.Now()}})
+	if err != nil {
+		log.WithError(err).Error("failed to revoke calendar feed token in db")
+		return err
+	}
+	return nil
+}
+
+// GetUserByCalendarFeedToken returns the user owning the calendar feed token.
+func (s *Service) GetUserByCalendarFeedToken(ctx context.Context, token string) (*User, error) {
+	var user User
+	log := s.log.WithContext(ctx)
+	err := s.dbCollection.FindOne(ctx, bson.M{"calendarFeed.tokenHash": hashToken(token)}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrCalendarFeedNotFound
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve user from db by calendar feed token")
+		return nil, err
+	}
+	return &user, nil
+}
+
+// generateToken returns a random hex token.
+func generateToken() (string, error) {
+	bytes := make([]byte, 32)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// hashToken returns the hash of a token as stored in the db, random tokens
+// have enough entropy for a fast hash.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,58 @@
+package users
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestGetUserByCalendarFeedToken(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	ns := "db.users"
+
+	mt.Run("found", func(mt *mtest.T) {
+		s := newMockService(mt, nil)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, bson.D{{Key: "_id", Value: "user"}}))
+		user, err := s.GetUserByCalendarFeedToken(context.Background(), "token")
+		if err != nil || user.ID != "user" {
+			mt.Fatalf("GetUserByCalendarFeedToken() = %v, %v, want the user", user, err)
+		}
+		filter := mt.GetStartedEvent().Command.Lookup("filter").Document()
+		if got := filter.Lookup("calendarFeed.tokenHash").StringValue(); got != hashToken("token") {
+			mt.Errorf("GetUserByCalendarFeedToken() filter = %s, want the token hash", got)
+		}
+	})
+
+	mt.Run("not found", func(mt *mtest.T) {
+		s := newMockService(mt, nil)
+		log, hook := logrustest.NewNullLogger()
+		s.log = log
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch))
+		_, err := s.GetUserByCalendarFeedToken(context.Background(), "token")
+		if err != ErrCalendarFeedNotFound {
+			mt.Errorf("GetUserByCalendarFeedToken() error = %v, want %v", err, ErrCalendarFeedNotFound)
+		}
+		if len(hook.AllEntries()) != 0 {
+			mt.Errorf("GetUserByCalendarFeedToken() logged %q, want no log", hook.LastEntry().Message)
+		}
+	})
+
+	mt.Run("db error", func(mt *mtest.T) {
+		s := newMockService(mt, nil)
+		log, hook := logrustest.NewNullLogger()
+		s.log = log
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 11600, Message: "interrupted at shutdown"}))
+		_, err := s.GetUserByCalendarFeedToken(context.Background(), "token")
+		if err == nil || err == ErrCalendarFeedNotFound {
+			mt.Errorf("GetUserByCalendarFeedToken() error = %v, want the db error", err)
+		}
+		if entry := hook.LastEntry(); entry == nil || entry.Level != logrus.ErrorLevel {
+			mt.Errorf("GetUserByCalendarFeedToken() did not log the db error")
+		}
+	})
+}
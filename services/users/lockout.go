@@ -0,0 +1,257 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wisdommatt/todo-list-api/internal/apperror"
+	"github.com/wisdommatt/todo-list-api/internal/mailer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// loginAttemptsWindow is how long failed login attempts are remembered,
+// the count restarts when there was no failure during the window.
+const loginAttemptsWindow = time.Hour
+
+// maxLoginBackoff caps the delay between failed login attempts.
+const maxLoginBackoff = 15 * time.Minute
+
+// lockoutPolicy is how failed login attempts of an account or an ip are
+// slowed down: after FreeAttempts failures every attempt waits twice as long
+// as the previous one, starting at a second, and after LockoutAttempts
+// failures no attempt is allowed for LockoutDuration.
+type lockoutPolicy struct {
+	FreeAttempts    int
+	LockoutAttempts int
+	LockoutDuration time.Duration
+}
+
+var (
+	accountLockoutPolicy = lockoutPolicy{FreeAttempts: 3, LockoutAttempts: 10, LockoutDuration: 30 * time.Minute}
+	// ips are allowed more attempts as they can be shared by many users.
+	ipLockoutPolicy = lockoutPolicy{FreeAttempts: 20, LockoutAttempts: 100, LockoutDuration: time.Hour}
+)
+
+var (
+	// ErrLoginThrottled is returned when logging in too soon after failed attempts.
+	ErrLoginThrottled = apperror.New(apperror.RateLimited, "login_throttled", "too many failed login attempts, please try again later")
+	// ErrAccountLocked is returned when logging in to an account locked
+	// after too many failed attempts.
+	ErrAccountLocked = apperror.New(apperror.RateLimited, "account_locked", "the account is locked after too many failed login attempts, please try again later or reset your password")
+)
+
+// loginAttempts are the recent failed login attempts of an account or an
+// ip, they are stored so every api replica enforces the same limits.
+type loginAttempts struct {
+	// Key is the email or ip prefixed with its kind e.g "ip:127.0.0.1".
+	Key          string    `bson:"_id"`
+	Failures     int       `bson:"failures"`
+	LastFailure  time.Time `bson:"lastFailure"`
+	BlockedUntil time.Time `bson:"blockedUntil,omitempty"`
+	Locked       bool      `bson:"locked,omitempty"`
+}
+
+// EnsureIndexes creates the indexes the service relies on, a TTL index
+// deletes the login attempts once they neither count nor block anymore.
+func (s *Service) EnsureIndexes(ctx context.Context) error {
+	// attempts block for at most the longest lockout after their last failure.
+	expireAfter := loginAttemptsWindow
+	if ipLockoutPolicy.LockoutDuration > expireAfter {
+		expireAfter = ipLockoutPolicy.LockoutDuration
+	}
+	if accountLockoutPolicy.LockoutDuration > expireAfter {
+		expireAfter = accountLockoutPolicy.LockoutDuration
+	}
+	_, err := s.loginAttemptsCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "lastFailure", Value: 1}},
+		Options: options.Index().
+			SetName("lastFailure_ttl").
+			SetExpireAfterSeconds(int32(expireAfter.Seconds())),
+	})
+	if err != nil {
+		s.log.WithContext(ctx).WithError(err).Error("failed to create login attempts indexes")
+		return err
+	}
+	return nil
+}
+
+func accountAttemptsKey(email string) string {
+	return "account:" + strings.ToLower(email)
+}
+
+func ipAttemptsKey(ip string) string {
+	return "ip:" + ip
+}
+
+// backoff returns how long the next attempt waits after failures.
+func (p lockoutPolicy) backoff(failures int) time.Duration {
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+	exponent := failures - p.FreeAttempts - 1
+	if exponent >= 20 {
+		return maxLoginBackoff
+	}
+	backoff := time.Second << uint(exponent)
+	if backoff > maxLoginBackoff {
+		return maxLoginBackoff
+	}
+	return backoff
+}
+
+// checkLoginAllowed returns ErrAccountLocked or ErrLoginThrottled, with the
+// time to wait, when the account or ip must wait before logging in again.
+func (s *Service) checkLoginAllowed(ctx context.Context, email, ip string) error {
+	log := s.log.WithContext(ctx).WithField("email", email).WithField("ip", ip)
+	accountKey := accountAttemptsKey(email)
+	keys := []string{accountKey}
+	if ip != "" {
+		keys = append(keys, ipAttemptsKey(ip))
+	}
+	now := time.Now()
+	filter := bson.M{"_id": bson.M{"$in": keys}, "blockedUntil": bson.M{"$gt": now}}
+	cursor, err := s.loginAttemptsCollection.Find(ctx, filter)
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve login attempts from db")
+		return err
+	}
+	defer cursor.Close(ctx)
+	var blocked []loginAttempts
+	err = cursor.All(ctx, &blocked)
+	if err != nil {
+		log.WithError(err).Error("failed to decode retrieved login attempts")
+		return err
+	}
+	var retryAfter time.Duration
+	locked := false
+	for _, attempts := range blocked {
+		if wait := attempts.BlockedUntil.Sub(now); wait > retryAfter {
+			retryAfter = wait
+		}
+		// ips are throttled but never reported as locked accounts.
+		locked = locked || (attempts.Locked && attempts.Key == accountKey)
+	}
+	switch {
+	case locked:
+		return ErrAccountLocked.WithRetryAfter(retryAfter)
+	case retryAfter > 0:
+		return ErrLoginThrottled.WithRetryAfter(retryAfter)
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed login attempt of the account and ip,
+// the account owner is emailed when the account gets locked.
+func (s *Service) recordLoginFailure(ctx context.Context, email, ip string) {
+	locked := s.recordAttemptFailure(ctx, accountAttemptsKey(email), accountLockoutPolicy)
+	if ip != "" {
+		s.recordAttemptFailure(ctx, ipAttemptsKey(ip), ipLockoutPolicy)
+	}
+	if locked {
+		s.sendLockoutEmail(ctx, email)
+	}
+}
+
+// recordAttemptFailure counts a failed attempt of key and blocks the next
+// attempts following the policy, it reports whether key just got locked.
+func (s *Service) recordAttemptFailure(ctx context.Context, key string, policy lockoutPolicy) bool {
+	log := s.log.WithContext(ctx).WithField("key", key)
+	now := time.Now()
+	// the failures of a past window are forgotten, unless they still block.
+	staleFilter := bson.M{
+		"_id":          key,
+		"lastFailure":  bson.M{"$lte": now.Add(-loginAttemptsWindow)},
+		"blockedUntil": bson.M{"$not": bson.M{"$gt": now}},
+	}
+	_, err := s.loginAttemptsCollection.UpdateOne(ctx, staleFilter, bson.M{
+		"$set":   bson.M{"failures": 0},
+		"$unset": bson.M{"locked": ""},
+	})
+	if err != nil {
+		log.WithError(err).Error("failed to reset login attempts in db")
+		return false
+	}
+	// the failures are incremented atomically so concurrent attempts on
+	// other replicas are all counted.
+	var attempts loginAttempts
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	update := bson.M{"$inc": bson.M{"failures": 1}, "$set": bson.M{"lastFailure": now}}
+	err = s.loginAttemptsCollection.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&attempts)
+	if mongo.IsDuplicateKeyError(err) {
+		// a concurrent attempt inserted the document first.
+		err = s.loginAttemptsCollection.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&attempts)
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to save login attempt to db")
+		return false
+	}
+	var block bson.M
+	switch {
+	case attempts.Failures >= policy.LockoutAttempts:
+		block = bson.M{"blockedUntil": now.Add(policy.LockoutDuration), "locked": true}
+	case policy.backoff(attempts.Failures) > 0:
+		block = bson.M{"blockedUntil": now.Add(policy.backoff(attempts.Failures))}
+	default:
+		return false
+	}
+	// blocks are only extended, a slower attempt cannot shorten the block
+	// set by a concurrent one.
+	filter := bson.M{"_id": key, "blockedUntil": bson.M{"$not": bson.M{"$gt": block["blockedUntil"]}}}
+	_, err = s.loginAttemptsCollection.UpdateOne(ctx, filter, bson.M{"$set": block})
+	if err != nil {
+		log.WithError(err).Error("failed to save login block to db")
+		return false
+	}
+	return attempts.Failures == policy.LockoutAttempts
+}
+
+// clearLoginFailures forgets the failed login attempts of the account.
+func (s *Service) clearLoginFailures(ctx context.Context, email string) error {
+	_, err := s.loginAttemptsCollection.DeleteOne(ctx, bson.M{"_id": accountAttemptsKey(email)})
+	if err != nil {
+		s.log.WithContext(ctx).WithField("email", email).WithError(err).Error("failed to delete login attempts from db")
+		return err
+	}
+	return nil
+}
+
+// UnlockUser allows the user to log in again after failed login attempts.
+func (s *Service) UnlockUser(ctx context.Context, userID string) (*User, error) {
+	user, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.clearLoginFailures(ctx, user.Email)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// sendLockoutEmail tells the user with the email that their account was
+// locked, nothing is sent for unknown emails.
+func (s *Service) sendLockoutEmail(ctx context.Context, email string) {
+	log := s.log.WithContext(ctx).WithField("email", email)
+	user, err := s.GetUserByEmail(ctx, email)
+	if err != nil {
+		return
+	}
+	err = s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your account was locked",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nYour account was locked for %d minutes after %d failed login attempts.\n\n"+
+				"If it was not you, someone may be trying to guess your password. You can reset your "+
+				"password to unlock your account now:\n\n%s\n",
+			user.FirstName, int(accountLockoutPolicy.LockoutDuration.Minutes()), accountLockoutPolicy.LockoutAttempts,
+			appURL()+"/forgot-password",
+		),
+	})
+	if err != nil {
+		log.WithError(err).Error("failed to send lockout email")
+	}
+}
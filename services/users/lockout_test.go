@@ -0,0 +1,288 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wisdommatt/todo-list-api/internal/apperror"
+	"github.com/wisdommatt/todo-list-api/internal/mailer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// fakeMailer records the sent messages.
+type fakeMailer struct {
+	messages []mailer.Message
+}
+
+func (m *fakeMailer) Send(ctx context.Context, message mailer.Message) error {
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+func newMockService(mt *mtest.T, mailSender mailer.Mailer) *Service {
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+	return &Service{
+		log:                     log,
+		dbCollection:            mt.Coll,
+		loginAttemptsCollection: mt.Coll,
+		mailer:                  mailSender,
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 0, want: 0},
+		{failures: 3, want: 0},
+		{failures: 4, want: time.Second},
+		{failures: 5, want: 2 * time.Second},
+		{failures: 6, want: 4 * time.Second},
+		{failures: 13, want: 512 * time.Second},
+		{failures: 14, want: maxLoginBackoff},
+		{failures: 24, want: maxLoginBackoff},
+		// large exponents do not overflow the shift.
+		{failures: 100, want: maxLoginBackoff},
+	}
+	for _, tt := range tests {
+		if got := accountLockoutPolicy.backoff(tt.failures); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+	if got := ipLockoutPolicy.backoff(20); got != 0 {
+		t.Errorf("ip backoff(20) = %v, want 0", got)
+	}
+	if got := ipLockoutPolicy.backoff(21); got != time.Second {
+		t.Errorf("ip backoff(21) = %v, want %v", got, time.Second)
+	}
+}
+
+func attemptsDocument(key string, blockedFor time.Duration, locked bool) bson.D {
+	return bson.D{
+		{Key: "_id", Value: key},
+		{Key: "failures", Value: 5},
+		{Key: "lastFailure", Value: time.Now()},
+		{Key: "blockedUntil", Value: time.Now().Add(blockedFor)},
+		{Key: "locked", Value: locked},
+	}
+}
+
+func TestCheckLoginAllowed(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	ns := "db.loginAttempts"
+	accountKey := accountAttemptsKey("Jane@example.com")
+	ipKey := ipAttemptsKey("127.0.0.1")
+
+	tests := []struct {
+		name           string
+		blocked        []bson.D
+		wantErr        error
+		wantRetryAfter time.Duration
+	}{
+		{name: "not blocked"},
+		{name: "account throttled", blocked: []bson.D{attemptsDocument(accountKey, time.Minute, false)}, wantErr: ErrLoginThrottled, wantRetryAfter: time.Minute},
+		{name: "account locked", blocked: []bson.D{attemptsDocument(accountKey, 30*time.Minute, true)}, wantErr: ErrAccountLocked, wantRetryAfter: 30 * time.Minute},
+		{name: "ip locked", blocked: []bson.D{attemptsDocument(ipKey, time.Hour, true)}, wantErr: ErrLoginThrottled, wantRetryAfter: time.Hour},
+		{
+			name:           "longest wait of the account and ip",
+			blocked:        []bson.D{attemptsDocument(accountKey, 30*time.Minute, true), attemptsDocument(ipKey, time.Hour, true)},
+			wantErr:        ErrAccountLocked,
+			wantRetryAfter: time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, tt.blocked...))
+			err := newMockService(mt, nil).checkLoginAllowed(context.Background(), "Jane@example.com", "127.0.0.1")
+			if tt.wantErr == nil {
+				if err != nil {
+					mt.Errorf("checkLoginAllowed() error = %v, want nil", err)
+				}
+				return
+			}
+			appErr, ok := apperror.As(err)
+			if !ok || !errors.Is(err, tt.wantErr) {
+				mt.Fatalf("checkLoginAllowed() error = %v, want %v", err, tt.wantErr)
+			}
+			if wait := tt.wantRetryAfter - appErr.RetryAfter; wait < 0 || wait > time.Minute {
+				mt.Errorf("checkLoginAllowed() retry after = %v, want %v", appErr.RetryAfter, tt.wantRetryAfter)
+			}
+		})
+	}
+
+	mt.Run("keys", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch))
+		newMockService(mt, nil).checkLoginAllowed(context.Background(), "Jane@example.com", "127.0.0.1")
+		filter := mt.GetStartedEvent().Command.Lookup("filter").String()
+		if !strings.Contains(filter, `"account:jane@example.com"`) || !strings.Contains(filter, `"ip:127.0.0.1"`) {
+			mt.Errorf("checkLoginAllowed() filter = %s, want the lowercase email and the ip keys", filter)
+		}
+	})
+
+	mt.Run("db error", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 11600, Message: "interrupted at shutdown"}))
+		err := newMockService(mt, nil).checkLoginAllowed(context.Background(), "jane@example.com", "")
+		if _, ok := apperror.As(err); err == nil || ok {
+			mt.Errorf("checkLoginAllowed() error = %v, want the db error", err)
+		}
+	})
+}
+
+// attemptsResponses are the responses to the stale window reset and to the
+// failure increment returning failures.
+func attemptsResponses(key string, failures int) []bson.D {
+	return []bson.D{
+		mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "nModified", Value: 0}),
+		mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{
+			{Key: "_id", Value: key},
+			{Key: "failures", Value: failures},
+			{Key: "lastFailure", Value: time.Now()},
+		}}),
+	}
+}
+
+func TestRecordAttemptFailure(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	key := accountAttemptsKey("jane@example.com")
+	blockUpdate := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1})
+
+	tests := []struct {
+		name        string
+		failures    int
+		wantBlocked time.Duration
+		wantLocked  bool
+		wantNewLock bool
+	}{
+		{name: "free attempt", failures: accountLockoutPolicy.FreeAttempts},
+		{name: "first throttled attempt", failures: accountLockoutPolicy.FreeAttempts + 1, wantBlocked: time.Second},
+		{name: "backoff doubles", failures: accountLockoutPolicy.FreeAttempts + 3, wantBlocked: 4 * time.Second},
+		{name: "locked", failures: accountLockoutPolicy.LockoutAttempts, wantBlocked: accountLockoutPolicy.LockoutDuration, wantLocked: true, wantNewLock: true},
+		// the lock is extended but the owner is only emailed once.
+		{name: "already locked", failures: accountLockoutPolicy.LockoutAttempts + 1, wantBlocked: accountLockoutPolicy.LockoutDuration, wantLocked: true},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			mt.AddMockResponses(append(attemptsResponses(key, tt.failures), blockUpdate)...)
+			before := time.Now()
+			newLock := newMockService(mt, nil).recordAttemptFailure(context.Background(), key, accountLockoutPolicy)
+			if newLock != tt.wantNewLock {
+				mt.Errorf("recordAttemptFailure() = %v, want %v", newLock, tt.wantNewLock)
+			}
+			events := mt.GetAllStartedEvents()
+			if tt.wantBlocked == 0 {
+				if len(events) != 2 {
+					mt.Errorf("recordAttemptFailure() ran %d commands, want no block", len(events))
+				}
+				return
+			}
+			if len(events) != 3 {
+				mt.Fatalf("recordAttemptFailure() ran %d commands, want a block", len(events))
+			}
+			set := events[2].Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("u", "$set").Document()
+			blockedUntil := set.Lookup("blockedUntil").Time()
+			// bson times are stored in milliseconds.
+			if wait := blockedUntil.Sub(before); wait < tt.wantBlocked-time.Millisecond || wait > tt.wantBlocked+time.Second {
+				mt.Errorf("recordAttemptFailure() blocked for %v, want %v", wait, tt.wantBlocked)
+			}
+			if _, err := set.LookupErr("locked"); (err == nil) != tt.wantLocked {
+				mt.Errorf("recordAttemptFailure() block = %s, want locked %v", set, tt.wantLocked)
+			}
+		})
+	}
+
+	mt.Run("stale window reset", func(mt *mtest.T) {
+		mt.AddMockResponses(attemptsResponses(key, 1)...)
+		before := time.Now()
+		newMockService(mt, nil).recordAttemptFailure(context.Background(), key, accountLockoutPolicy)
+		reset := mt.GetStartedEvent()
+		if reset.CommandName != "update" {
+			mt.Fatalf("first command = %s, want the stale window reset", reset.CommandName)
+		}
+		update := reset.Command.Lookup("updates").Array().Index(0).Value().Document()
+		staleBefore := update.Lookup("q", "lastFailure", "$lte").Time()
+		if window := before.Sub(staleBefore); window < loginAttemptsWindow-time.Second || window > loginAttemptsWindow+time.Millisecond {
+			mt.Errorf("reset of failures older than %v, want %v", window, loginAttemptsWindow)
+		}
+		// failures still blocking the account are not reset.
+		if _, err := update.LookupErr("q", "blockedUntil", "$not", "$gt"); err != nil {
+			mt.Errorf("reset filter = %s, want blocked attempts excluded", update.Lookup("q"))
+		}
+		if failures := update.Lookup("u", "$set", "failures").Int32(); failures != 0 {
+			mt.Errorf("reset failures = %d, want 0", failures)
+		}
+		if _, err := update.LookupErr("u", "$unset", "locked"); err != nil {
+			mt.Errorf("reset = %s, want the lock removed", update.Lookup("u"))
+		}
+	})
+
+	mt.Run("concurrent insert", func(mt *mtest.T) {
+		responses := attemptsResponses(key, accountLockoutPolicy.LockoutAttempts)
+		mt.AddMockResponses(
+			responses[0],
+			mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "duplicate key error"}),
+			responses[1],
+			blockUpdate,
+		)
+		if !newMockService(mt, nil).recordAttemptFailure(context.Background(), key, accountLockoutPolicy) {
+			mt.Errorf("recordAttemptFailure() = false, want the failure counted after retrying")
+		}
+	})
+
+	mt.Run("db error", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 11600, Message: "interrupted at shutdown"}))
+		if newMockService(mt, nil).recordAttemptFailure(context.Background(), key, accountLockoutPolicy) {
+			mt.Errorf("recordAttemptFailure() = true, want false")
+		}
+	})
+}
+
+func TestRecordLoginFailureLockoutEmail(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	key := accountAttemptsKey("jane@example.com")
+	blockUpdate := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1})
+	user := bson.D{{Key: "_id", Value: "user"}, {Key: "email", Value: "jane@example.com"}, {Key: "firstName", Value: "Jane"}}
+
+	mt.Run("locked", func(mt *mtest.T) {
+		mt.AddMockResponses(append(attemptsResponses(key, accountLockoutPolicy.LockoutAttempts),
+			blockUpdate,
+			mtest.CreateCursorResponse(0, "db.users", mtest.FirstBatch, user),
+		)...)
+		sender := &fakeMailer{}
+		newMockService(mt, sender).recordLoginFailure(context.Background(), "jane@example.com", "")
+		if len(sender.messages) != 1 || sender.messages[0].To != "jane@example.com" {
+			mt.Errorf("recordLoginFailure() sent %+v, want a lockout email to the user", sender.messages)
+		}
+	})
+
+	mt.Run("already locked", func(mt *mtest.T) {
+		mt.AddMockResponses(append(attemptsResponses(key, accountLockoutPolicy.LockoutAttempts+1), blockUpdate)...)
+		sender := &fakeMailer{}
+		newMockService(mt, sender).recordLoginFailure(context.Background(), "jane@example.com", "")
+		if len(sender.messages) != 0 {
+			mt.Errorf("recordLoginFailure() sent %d emails, want none", len(sender.messages))
+		}
+	})
+
+	mt.Run("unknown email", func(mt *mtest.T) {
+		mt.AddMockResponses(append(attemptsResponses(key, accountLockoutPolicy.LockoutAttempts),
+			blockUpdate,
+			mtest.CreateCursorResponse(0, "db.users", mtest.FirstBatch),
+		)...)
+		sender := &fakeMailer{}
+		newMockService(mt, sender).recordLoginFailure(context.Background(), "jane@example.com", "")
+		if len(sender.messages) != 0 {
+			mt.Errorf("recordLoginFailure() sent %d emails, want none", len(sender.messages))
+		}
+	})
+}
@@ -0,0 +1,298 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/wisdommatt/todo-list-api/internal/apperror"
+	"github.com/wisdommatt/todo-list-api/internal/qrcode"
+	"github.com/wisdommatt/todo-list-api/internal/totp"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// mfaIssuer is the name authenticator apps show for the accounts.
+const mfaIssuer = "Todo List"
+
+// mfaChallengeLifetime is how long the second step of a login can be completed.
+const mfaChallengeLifetime = 5 * time.Minute
+
+// qrCodeScale is the size in pixels of the provisioning QR code modules.
+const qrCodeScale = 6
+
+// recoveryCodesCount is the number of recovery codes generated at once.
+const recoveryCodesCount = 10
+
+var (
+	// ErrMFAAlreadyEnabled is returned when enrolling a user with two-factor
+	// authentication enabled.
+	ErrMFAAlreadyEnabled = apperror.New(apperror.Conflict, "mfa_already_enabled", "two-factor authentication is already enabled")
+	// ErrMFANotEnrolled is returned when verifying or disabling two-factor
+	// authentication the user has not set up.
+	ErrMFANotEnrolled = apperror.New(apperror.Conflict, "mfa_not_enrolled", "two-factor authentication is not set up")
+	// ErrInvalidMFACode is returned for wrong, used or expired codes.
+	ErrInvalidMFACode = apperror.New(apperror.Invalid, "invalid_mfa_code", "invalid two-factor authentication code")
+	// ErrInvalidMFAChallenge is returned for invalid or expired challenge tokens.
+	ErrInvalidMFAChallenge = apperror.New(apperror.Unauthenticated, "invalid_mfa_challenge", "invalid or expired two-factor authentication challenge, please log in again")
+	// ErrMFARequiredByPolicy is returned when disabling two-factor
+	// authentication the policy requires for the user role.
+	ErrMFARequiredByPolicy = apperror.New(apperror.Forbidden, "mfa_required", "two-factor authentication is required for your role")
+)
+
+// MFA is the two-factor authentication of the user with an authenticator
+// app, it is enabled once a first code is verified.
+type MFA struct {
+	Secret    string    `json:"-" bson:"secret"`
+	Enabled   bool      `json:"enabled" bson:"enabled"`
+	EnabledAt time.Time `json:"enabledAt" bson:"enabledAt,omitempty"`
+	// LastCounter is the period of the last accepted code, so a code cannot
+	// be used twice.
+	LastCounter int64 `json:"-" bson:"lastCounter"`
+	// RecoveryCodeHashes are the hashes of the unused recovery codes.
+	RecoveryCodeHashes []string `json:"-" bson:"recoveryCodeHashes,omitempty"`
+}
+
+// MFAEnrollment is what authenticator apps need to generate codes, the
+// secret can be typed in apps that cannot scan the QR code.
+type MFAEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioningUri"`
+	// QRCode is the provisioning uri as a PNG data uri, to show in an img tag.
+	QRCode string `json:"qrCode"`
+}
+
+// IsMFAEnabled reports whether the user logs in with a second factor.
+func (u User) IsMFAEnabled() bool {
+	return u.MFA != nil && u.MFA.Enabled
+}
+
+// EnrollMFA generates a new secret for the user authenticator app, two-factor
+// authentication is enabled once VerifyMFA is called with a first code.
+func (s *Service) EnrollMFA(ctx context.Context, user *User) (*MFAEnrollment, error) {
+	log := s.log.WithContext(ctx).WithField("userId", user.ID)
+	if user.IsMFAEnabled() {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		log.WithError(err).Error("failed to generate mfa secret")
+		return nil, err
+	}
+	filter := bson.M{"_id": user.ID, "mfa.enabled": bson.M{"$ne": true}}
+	update := bson.M{"$set": bson.M{"mfa": MFA{Secret: secret}, "lastUpdated": time.Now()}}
+	result, err := s.dbCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.WithError(err).Error("failed to save mfa secret to db")
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	uri := totp.ProvisioningURI(mfaIssuer, user.Email, secret)
+	code, err := qrcode.Encode([]byte(uri))
+	if err != nil {
+		log.WithError(err).Error("failed to encode provisioning uri qr code")
+		return nil, err
+	}
+	png, err := code.PNG(qrCodeScale)
+	if err != nil {
+		log.WithError(err).Error("failed to render provisioning uri qr code")
+		return nil, err
+	}
+	return &MFAEnrollment{
+		Secret:          secret,
+		ProvisioningURI: uri,
+		QRCode:          "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	}, nil
+}
+
+// VerifyMFA enables two-factor authentication with the first code of the
+// enrolled authenticator app and returns the recovery codes of the user.
+func (s *Service) VerifyMFA(ctx context.Context, user *User, code string) ([]string, error) {
+	log := s.log.WithContext(ctx).WithField("userId", user.ID)
+	if user.MFA == nil {
+		return nil, ErrMFANotEnrolled
+	}
+	if user.IsMFAEnabled() {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	err := s.checkLoginAllowed(ctx, user.Email, "")
+	if err != nil {
+		return nil, err
+	}
+	counter, ok := totp.Validate(user.MFA.Secret, code, time.Now(), user.MFA.LastCounter)
+	if !ok {
+		s.recordLoginFailure(ctx, user.Email, "")
+		return nil, ErrInvalidMFACode
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		log.WithError(err).Error("failed to generate recovery codes")
+		return nil, err
+	}
+	now := time.Now()
+	filter := bson.M{"_id": user.ID, "mfa.secret": user.MFA.Secret, "mfa.enabled": false}
+	update := bson.M{"$set": bson.M{
+		"mfa.enabled":            true,
+		"mfa.enabledAt":          now,
+		"mfa.lastCounter":        counter,
+		"mfa.recoveryCodeHashes": hashes,
+		"lastUpdated":            now,
+	}}
+	result, err := s.dbCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.WithError(err).Error("failed to enable mfa in db")
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		// the user enrolled again or enabled it concurrently.
+		return nil, ErrInvalidMFACode
+	}
+	return codes, nil
+}
+
+// DisableMFA turns two-factor authentication off after checking a code or
+// a recovery code, unless the policy requires it for the user role.
+func (s *Service) DisableMFA(ctx context.Context, user *User, code string) error {
+	log := s.log.WithContext(ctx).WithField("userId", user.ID)
+	if !user.IsMFAEnabled() {
+		return ErrMFANotEnrolled
+	}
+	required, err := s.IsMFARequired(ctx, user)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrMFARequiredByPolicy
+	}
+	err = s.checkMFACode(ctx, user, code, "")
+	if err != nil {
+		return err
+	}
+	update := bson.M{"$unset": bson.M{"mfa": ""}, "$set": bson.M{"lastUpdated": time.Now()}}
+	_, err = s.dbCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, update)
+	if err != nil {
+		log.WithError(err).Error("failed to disable mfa in db")
+		return err
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user after
+// checking a code, the previous ones cannot be used anymore.
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context, user *User, code string) ([]string, error) {
+	log := s.log.WithContext(ctx).WithField("userId", user.ID)
+	if !user.IsMFAEnabled() {
+		return nil, ErrMFANotEnrolled
+	}
+	err := s.checkMFACode(ctx, user, code, "")
+	if err != nil {
+		return nil, err
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		log.WithError(err).Error("failed to generate recovery codes")
+		return nil, err
+	}
+	update := bson.M{"$set": bson.M{"mfa.recoveryCodeHashes": hashes, "lastUpdated": time.Now()}}
+	_, err = s.dbCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, update)
+	if err != nil {
+		log.WithError(err).Error("failed to save recovery codes to db")
+		return nil, err
+	}
+	return codes, nil
+}
+
+// mfaChallengeToken returns the token completing the login of the user
+// with a second factor.
+func mfaChallengeToken(userID string) string {
+	return signedToken(purposeMFAChallenge, []string{userID}, time.Now().Add(mfaChallengeLifetime))
+}
+
+// CompleteMFAChallenge completes the login of a user with two-factor
+// authentication, from the challenge token returned by LoginUser and a code
+// or a recovery code, and returns an auth token of a new session on the client.
+func (s *Service) CompleteMFAChallenge(ctx context.Context, challengeToken, code string, client Client) (*User, string, error) {
+	fields, ok := parseSignedToken(purposeMFAChallenge, challengeToken, 1)
+	if !ok {
+		return nil, "", ErrInvalidMFAChallenge
+	}
+	user, err := s.GetUser(ctx, fields[0])
+	if err == ErrUserNotFound {
+		return nil, "", ErrInvalidMFAChallenge
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if !user.IsMFAEnabled() {
+		return nil, "", ErrInvalidMFAChallenge
+	}
+	err = s.checkMFACode(ctx, user, code, client.IP)
+	if err != nil {
+		return nil, "", err
+	}
+	s.clearLoginFailures(ctx, user.Email)
+	authToken, err := s.createSession(ctx, user, client)
+	if err != nil {
+		return nil, "", err
+	}
+	return user, authToken, nil
+}
+
+// checkMFACode checks a code of the user authenticator app, or one of their
+// recovery codes which cannot be used again. Wrong codes count as failed
+// logins, so guessing them is throttled like passwords.
+func (s *Service) checkMFACode(ctx context.Context, user *User, code, ip string) error {
+	log := s.log.WithContext(ctx).WithField("userId", user.ID)
+	err := s.checkLoginAllowed(ctx, user.Email, ip)
+	if err != nil {
+		return err
+	}
+	var filter, update bson.M
+	if counter, ok := totp.Validate(user.MFA.Secret, code, time.Now(), user.MFA.LastCounter); ok {
+		// a code is only accepted once, even by concurrent requests.
+		filter = bson.M{"_id": user.ID, "mfa.lastCounter": bson.M{"$lt": counter}}
+		update = bson.M{"$set": bson.M{"mfa.lastCounter": counter}}
+	} else {
+		hash := hashToken(normalizeRecoveryCode(code))
+		filter = bson.M{"_id": user.ID, "mfa.recoveryCodeHashes": hash}
+		update = bson.M{"$pull": bson.M{"mfa.recoveryCodeHashes": hash}}
+	}
+	result, err := s.dbCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.WithError(err).Error("failed to save mfa code use to db")
+		return err
+	}
+	if result.MatchedCount == 0 {
+		s.recordLoginFailure(ctx, user.Email, ip)
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// generateRecoveryCodes returns new recovery codes, formatted like
+// "abcde-fghij", and their hashes.
+func generateRecoveryCodes() ([]string, []string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, recoveryCodesCount)
+	hashes := make([]string, recoveryCodesCount)
+	for i := range codes {
+		random := make([]byte, 7)
+		_, err := rand.Read(random)
+		if err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(random)[:10])
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashToken(code)
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode removes the formatting of a recovery code.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -0,0 +1,102 @@
+package users
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/wisdommatt/todo-list-api/internal/apperror"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mfaPolicyID is the id of the MFA policy in the settings collection.
+const mfaPolicyID = "mfaPolicy"
+
+// mfaPolicyCacheDuration is how long the MFA policy is cached, it is checked
+// on every authenticated request.
+const mfaPolicyCacheDuration = 30 * time.Second
+
+// ErrUnknownRole is returned when the MFA policy requires a role which does not exist.
+var ErrUnknownRole = apperror.New(apperror.Invalid, "unknown_role", "the required roles must be admin or user")
+
+// MFAPolicy is the roles whose users must enable two-factor authentication
+// before using the api.
+type MFAPolicy struct {
+	RequiredRoles []string  `json:"requiredRoles" bson:"requiredRoles"`
+	LastUpdated   time.Time `json:"lastUpdated" bson:"lastUpdated,omitempty"`
+}
+
+// mfaPolicyCache is the MFA policy last read from the db.
+type mfaPolicyCache struct {
+	mu       sync.Mutex
+	policy   MFAPolicy
+	expireAt time.Time
+}
+
+// Requires reports whether users with the role must enable two-factor
+// authentication.
+func (p MFAPolicy) Requires(role string) bool {
+	for _, requiredRole := range p.RequiredRoles {
+		if requiredRole == role {
+			return true
+		}
+	}
+	return false
+}
+
+// GetMFAPolicy returns the MFA policy, no role requires two-factor
+// authentication until an admin sets it.
+func (s *Service) GetMFAPolicy(ctx context.Context) (*MFAPolicy, error) {
+	s.mfaPolicy.mu.Lock()
+	defer s.mfaPolicy.mu.Unlock()
+	if time.Now().Before(s.mfaPolicy.expireAt) {
+		policy := s.mfaPolicy.policy
+		return &policy, nil
+	}
+	policy := MFAPolicy{RequiredRoles: []string{}}
+	err := s.settingsCollection.FindOne(ctx, bson.M{"_id": mfaPolicyID}).Decode(&policy)
+	if err != nil && err != mongo.ErrNoDocuments {
+		s.log.WithContext(ctx).WithError(err).Error("failed to retrieve mfa policy from db")
+		return nil, err
+	}
+	s.mfaPolicy.policy = policy
+	s.mfaPolicy.expireAt = time.Now().Add(mfaPolicyCacheDuration)
+	return &policy, nil
+}
+
+// UpdateMFAPolicy replaces the MFA policy, it applies to the other api
+// replicas once their cached policy expires.
+func (s *Service) UpdateMFAPolicy(ctx context.Context, policy MFAPolicy) (*MFAPolicy, error) {
+	if policy.RequiredRoles == nil {
+		policy.RequiredRoles = []string{}
+	}
+	for _, role := range policy.RequiredRoles {
+		if role != RoleAdmin && role != RoleUser {
+			return nil, ErrUnknownRole
+		}
+	}
+	policy.LastUpdated = time.Now()
+	opts := options.Replace().SetUpsert(true)
+	_, err := s.settingsCollection.ReplaceOne(ctx, bson.M{"_id": mfaPolicyID}, policy, opts)
+	if err != nil {
+		s.log.WithContext(ctx).WithError(err).Error("failed to save mfa policy to db")
+		return nil, err
+	}
+	s.mfaPolicy.mu.Lock()
+	s.mfaPolicy.policy = policy
+	s.mfaPolicy.expireAt = time.Now().Add(mfaPolicyCacheDuration)
+	s.mfaPolicy.mu.Unlock()
+	return &policy, nil
+}
+
+// IsMFARequired reports whether the policy requires the user to enable
+// two-factor authentication.
+func (s *Service) IsMFARequired(ctx context.Context, user *User) (bool, error) {
+	policy, err := s.GetMFAPolicy(ctx)
+	if err != nil {
+		return false, err
+	}
+	return policy.Requires(user.GetRole()), nil
+}
@@ -0,0 +1,210 @@
+package users
+
+import (
+	"context"
+	"time"
+
+	"github.com/wisdommatt/todo-list-api/internal/apperror"
+	"github.com/wisdommatt/todo-list-api/internal/oidc"
+	"github.com/wisdommatt/todo-list-api/internal/tenant"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// oidcLoginLifetime is how long the user has to log in with the identity
+// provider once redirected to it.
+const oidcLoginLifetime = 10 * time.Minute
+
+var (
+	// ErrOIDCProviderNotFound is returned for identity providers which are not configured.
+	ErrOIDCProviderNotFound = apperror.New(apperror.NotFound, "oidc_provider_not_found", "identity provider does not exist")
+	// ErrInvalidOIDCState is returned when the callback state is unknown,
+	// expired or already used.
+	ErrInvalidOIDCState = apperror.New(apperror.Invalid, "invalid_oidc_state", "invalid or expired login, please try again")
+	// ErrOIDCLoginFailed is returned when the identity provider refused the
+	// login or its ID token is invalid.
+	ErrOIDCLoginFailed = apperror.New(apperror.Unauthenticated, "oidc_login_failed", "login with the identity provider failed")
+	// ErrOIDCEmailNotVerified is returned when the identity provider did not
+	// verify the email of an identity which is not linked to a user yet.
+	ErrOIDCEmailNotVerified = apperror.New(apperror.Forbidden, "oidc_email_not_verified", "the identity provider did not verify your email")
+)
+
+// Identity is an account of the user with an identity provider, the user
+// can log in with it instead of a password.
+type Identity struct {
+	Provider string    `json:"provider" bson:"provider"`
+	Subject  string    `json:"-" bson:"subject"`
+	Email    string    `json:"email" bson:"email"`
+	LinkedAt time.Time `json:"linkedAt" bson:"linkedAt"`
+}
+
+// oidcLogin is a login started with an identity provider, it is deleted
+// when the provider redirects back to the callback.
+type oidcLogin struct {
+	State        string    `bson:"_id"`
+	Provider     string    `bson:"provider"`
+	Nonce        string    `bson:"nonce"`
+	CodeVerifier string    `bson:"codeVerifier"`
+	ExpiresAt    time.Time `bson:"expiresAt"`
+}
+
+// AddOIDCProvider registers an identity provider users can log in with.
+func (s *Service) AddOIDCProvider(name string, provider *oidc.Provider) {
+	if s.oidcProviders == nil {
+		s.oidcProviders = map[string]*oidc.Provider{}
+	}
+	s.oidcProviders[name] = provider
+}
+
+// StartOIDCLogin returns the url of the identity provider login page, the
+// provider redirects to the callback which calls CompleteOIDCLogin.
+func (s *Service) StartOIDCLogin(ctx context.Context, providerName string) (string, error) {
+	log := s.log.WithContext(ctx).WithField("provider", providerName)
+	provider, ok := s.oidcProviders[providerName]
+	if !ok {
+		return "", ErrOIDCProviderNotFound
+	}
+	login := oidcLogin{Provider: providerName, ExpiresAt: time.Now().Add(oidcLoginLifetime)}
+	var err error
+	for _, value := range []*string{&login.State, &login.Nonce, &login.CodeVerifier} {
+		*value, err = oidc.RandomString()
+		if err != nil {
+			log.WithError(err).Error("failed to generate oidc login secrets")
+			return "", err
+		}
+	}
+	authURL, err := provider.AuthURL(ctx, login.State, login.Nonce, login.CodeVerifier)
+	if err != nil {
+		log.WithError(err).Error("failed to build identity provider auth url")
+		return "", err
+	}
+	_, err = s.oidcLoginsCollection.InsertOne(ctx, login)
+	if err != nil {
+		log.WithError(err).Error("failed to save oidc login to db")
+		return "", err
+	}
+	// logins the user never completed are deleted along the way.
+	_, err = s.oidcLoginsCollection.DeleteMany(ctx, bson.M{"expiresAt": bson.M{"$lte": time.Now()}})
+	if err != nil {
+		log.WithError(err).Error("failed to delete expired oidc logins from db")
+	}
+	return authURL, nil
+}
+
+// CompleteOIDCLogin exchanges the code the identity provider redirected
+// with for the identity of the user and returns the user and an auth token
+// of a new session on the client, or a challenge token like LoginUser for users with two-factor
+// authentication.
+//
+// The identity is linked to the user with the email verified by the
+// provider, a user is created when there is none. Linking an account whose
+// email was never verified removes its password, it may have been created
+// by someone else to take over the account.
+func (s *Service) CompleteOIDCLogin(ctx context.Context, providerName, state, code string, client Client) (*User, string, error) {
+	log := s.log.WithContext(ctx).WithField("provider", providerName)
+	provider, ok := s.oidcProviders[providerName]
+	if !ok {
+		return nil, "", ErrOIDCProviderNotFound
+	}
+	// the login is deleted so the state cannot be used twice.
+	var login oidcLogin
+	filter := bson.M{"_id": state, "provider": providerName, "expiresAt": bson.M{"$gt": time.Now()}}
+	err := s.oidcLoginsCollection.FindOneAndDelete(ctx, filter).Decode(&login)
+	if err == mongo.ErrNoDocuments {
+		return nil, "", ErrInvalidOIDCState
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve oidc login from db")
+		return nil, "", err
+	}
+	claims, err := provider.Exchange(ctx, code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		log.WithError(err).Warn("identity provider login failed")
+		return nil, "", ErrOIDCLoginFailed
+	}
+	user, err := s.linkIdentity(tenant.WithWorkspace(ctx, ""), providerName, claims)
+	if err != nil {
+		return nil, "", err
+	}
+	if user.IsMFAEnabled() {
+		return user, mfaChallengeToken(user.ID), nil
+	}
+	authToken, err := s.createSession(ctx, user, client)
+	if err != nil {
+		return nil, "", err
+	}
+	return user, authToken, nil
+}
+
+// linkIdentity returns the user the identity is linked to, linking it to
+// the user with the same email or to a new user first.
+func (s *Service) linkIdentity(ctx context.Context, providerName string, claims *oidc.Claims) (*User, error) {
+	log := s.log.WithContext(ctx).WithField("provider", providerName)
+	var user User
+	filter := bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": providerName, "subject": claims.Subject}}}
+	err := s.dbCollection.FindOne(ctx, filter).Decode(&user)
+	if err == nil {
+		return &user, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		log.WithError(err).Error("failed to retrieve user by identity from db")
+		return nil, err
+	}
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, ErrOIDCEmailNotVerified
+	}
+	now := time.Now()
+	identity := Identity{Provider: providerName, Subject: claims.Subject, Email: claims.Email, LinkedAt: now}
+	existingUser, err := s.GetUserByEmail(ctx, claims.Email)
+	if err == ErrUserNotFound {
+		return s.createOIDCUser(ctx, identity, claims)
+	}
+	if err != nil {
+		return nil, err
+	}
+	update := bson.M{
+		"$push": bson.M{"identities": identity},
+		"$set":  bson.M{"lastUpdated": now},
+	}
+	if !existingUser.IsEmailVerified() {
+		update["$set"] = bson.M{"emailVerifiedAt": now, "tokensValidAfter": now, "lastUpdated": now}
+		update["$unset"] = bson.M{"emailVerification": "", "password": "", "appPasswords": "", "accessTokens": "", "calendarFeed": ""}
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = s.dbCollection.FindOneAndUpdate(ctx, bson.M{"_id": existingUser.ID}, update, opts).Decode(&user)
+	if err != nil {
+		log.WithError(err).WithField("userId", existingUser.ID).Error("failed to link identity to user in db")
+		return nil, err
+	}
+	if !existingUser.IsEmailVerified() {
+		s.deleteSessions(ctx, user.ID, "")
+	}
+	return &user, nil
+}
+
+// createOIDCUser creates a user with a verified email and no password for
+// the identity.
+func (s *Service) createOIDCUser(ctx context.Context, identity Identity, claims *oidc.Claims) (*User, error) {
+	now := time.Now()
+	user := User{
+		ID:              primitive.NewObjectID().Hex(),
+		FirstName:       claims.GivenName,
+		LastName:        claims.FamilyName,
+		Email:           claims.Email,
+		Identities:      []Identity{identity},
+		EmailVerifiedAt: now,
+		TimeAdded:       now,
+		LastUpdated:     now,
+	}
+	if user.FirstName == "" {
+		user.FirstName = claims.Email
+	}
+	_, err := s.dbCollection.InsertOne(ctx, user)
+	if err != nil {
+		s.log.WithContext(ctx).WithField("user", user).WithError(err).Error("cannot save user to db")
+		return nil, err
+	}
+	return &user, nil
+}
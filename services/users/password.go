@@ -0,0 +1,189 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/wisdommatt/todo-list-api/internal/apperror"
+	"github.com/wisdommatt/todo-list-api/internal/mailer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordResetLifetime is how long a password reset token can be used.
+const passwordResetLifetime = 30 * time.Minute
+
+// passwordResetInterval limits how often a password reset email is sent to a user.
+const passwordResetInterval = time.Minute
+
+var (
+	// ErrInvalidResetToken is returned for invalid, expired or used password
+	// reset tokens.
+	ErrInvalidResetToken = apperror.New(apperror.Invalid, "invalid_reset_token", "invalid or expired password reset token")
+	// ErrIncorrectPassword is returned when the current password given to
+	// change it or the email is incorrect.
+	ErrIncorrectPassword = apperror.New(apperror.Invalid, "incorrect_password", "current password is incorrect")
+	// ErrCurrentPasswordRequired is returned when changing the email without
+	// the current password.
+	ErrCurrentPasswordRequired = apperror.New(apperror.Invalid, "current_password_required", "the current password is required to change the email")
+	// ErrPasswordUnchanged is returned when the new password is the current one.
+	ErrPasswordUnchanged = apperror.New(apperror.Invalid, "password_unchanged", "new password must be different from the current password")
+)
+
+// PasswordReset is a pending password reset of the user, only the token
+// hash is stored.
+type PasswordReset struct {
+	TokenHash   string    `bson:"tokenHash"`
+	ExpiresAt   time.Time `bson:"expiresAt"`
+	RequestedAt time.Time `bson:"requestedAt"`
+}
+
+// IsAuthTokenValid reports whether an auth token issued at issuedAt was not
+// revoked, tokens issued before the last password change are revoked. Tokens
+// have a precision of a second.
+func (u User) IsAuthTokenValid(issuedAt time.Time) bool {
+	return u.TokensValidAfter.IsZero() || !issuedAt.Before(u.TokensValidAfter.Truncate(time.Second))
+}
+
+// RequestPasswordReset emails a password reset token to the user with the
+// email. Nothing is sent for unknown emails, or when a reset was requested
+// recently, without an error so callers cannot tell whether the account exists.
+func (s *Service) RequestPasswordReset(ctx context.Context, email string) error {
+	log := s.log.WithContext(ctx).WithField("email", email)
+	user, err := s.GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil
+	}
+	if user.PasswordReset != nil && time.Since(user.PasswordReset.RequestedAt) < passwordResetInterval {
+		return nil
+	}
+	token, err := generateToken()
+	if err != nil {
+		log.WithError(err).Error("failed to generate password reset token")
+		return err
+	}
+	now := time.Now()
+	reset := PasswordReset{
+		TokenHash:   hashToken(token),
+		ExpiresAt:   now.Add(passwordResetLifetime),
+		RequestedAt: now,
+	}
+	_, err = s.dbCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"passwordReset": reset}})
+	if err != nil {
+		log.WithError(err).Error("failed to save password reset to db")
+		return err
+	}
+	err = s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nA password reset was requested for your account, open the link below to choose a new password:\n\n%s\n\n"+
+				"Or send this token to POST /users/password/reset:\n\n%s\n\n"+
+				"The link expires in 30 minutes. If you did not request it, you can ignore this email.\n",
+			user.FirstName, appURL()+"/reset-password?token="+token, token,
+		),
+	})
+	if err != nil {
+		log.WithError(err).Error("failed to send password reset email")
+		return err
+	}
+	return nil
+}
+
+// ResetPassword replaces the password of the user with the reset token. The
+// token cannot be used again, and the auth tokens, app passwords, access
+// tokens and calendar feed of the user are revoked.
+func (s *Service) ResetPassword(ctx context.Context, token, password string) (*User, error) {
+	log := s.log.WithContext(ctx)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		log.WithError(err).Error("cannot generate password from hash")
+		return nil, err
+	}
+	now := time.Now()
+	filter := bson.M{
+		"passwordReset.tokenHash": hashToken(token),
+		"passwordReset.expiresAt": bson.M{"$gt": now},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"password":         string(hashedPassword),
+			"tokensValidAfter": now,
+			"lastUpdated":      now,
+		},
+		"$unset": bson.M{"passwordReset": "", "appPasswords": "", "accessTokens": "", "calendarFeed": ""},
+	}
+	var user User
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = s.dbCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInvalidResetToken
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to reset user password in db")
+		return nil, err
+	}
+	// resetting the password unlocks the account.
+	s.clearLoginFailures(ctx, user.Email)
+	s.deleteSessions(ctx, user.ID, "")
+	return &user, nil
+}
+
+// ChangePassword replaces the password of the user after checking the
+// current one. The auth tokens issued before and the sessions but sessionID
+// are revoked, so the caller must issue a new token for the session.
+func (s *Service) ChangePassword(ctx context.Context, userID, sessionID, currentPassword, newPassword string) (*User, error) {
+	log := s.log.WithContext(ctx).WithField("userId", userID)
+	user, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.checkCurrentPassword(ctx, user, currentPassword)
+	if err != nil {
+		return nil, err
+	}
+	if currentPassword == newPassword {
+		return nil, ErrPasswordUnchanged
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		log.WithError(err).Error("cannot generate password from hash")
+		return nil, err
+	}
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"password":         string(hashedPassword),
+			"tokensValidAfter": now,
+			"lastUpdated":      now,
+		},
+		"$unset": bson.M{"passwordReset": ""},
+	}
+	_, err = s.dbCollection.UpdateOne(ctx, bson.M{"_id": userID}, update)
+	if err != nil {
+		log.WithError(err).Error("failed to change user password in db")
+		return nil, err
+	}
+	s.deleteSessions(ctx, userID, sessionID)
+	return s.GetUser(ctx, userID)
+}
+
+// checkCurrentPassword returns ErrIncorrectPassword when password is not the
+// user password. Guesses count as failed logins, so a stolen session cannot
+// be used to find the password faster than the login.
+func (s *Service) checkCurrentPassword(ctx context.Context, user *User, password string) error {
+	err := s.checkLoginAllowed(ctx, user.Email, "")
+	if err != nil {
+		return err
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
+	if err != nil {
+		s.recordLoginFailure(ctx, user.Email, "")
+		return ErrIncorrectPassword
+	}
+	s.clearLoginFailures(ctx, user.Email)
+	return nil
+}
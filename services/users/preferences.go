@@ -0,0 +1,146 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+var localeRegex = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// Preferences are the user calendar settings, day based queries are
+// interpreted in the user time zone.
+type Preferences struct {
+	// TimeZone is an IANA time zone name e.g Europe/London.
+	TimeZone string `json:"timeZone" bson:"timeZone,omitempty"`
+	// WorkingHours are the working hours by lowercase weekday name, missing days are days off.
+	WorkingHours map[string]DayHours `json:"workingHours" bson:"workingHours"`
+	WeekStart    string              `json:"weekStart" bson:"weekStart,omitempty"`
+	Locale       string              `json:"locale" bson:"locale,omitempty"`
+	// RejectOutsideWorkingHours rejects the tasks scheduled outside of the user working hours.
+	RejectOutsideWorkingHours bool `json:"rejectOutsideWorkingHours" bson:"rejectOutsideWorkingHours,omitempty"`
+}
+
+// DayHours are the working hours of a day as HH:MM times.
+type DayHours struct {
+	Start string `json:"start" bson:"start"`
+	End   string `json:"end" bson:"end"`
+}
+
+// DefaultPreferences returns the preferences of users that did not set
+// them: UTC, 09:00 to 17:00 from monday to friday and weeks starting on monday.
+func DefaultPreferences() Preferences {
+	workingHours := map[string]DayHours{}
+	for _, day := range []string{"monday", "tuesday", "wednesday", "thursday", "friday"} {
+		workingHours[day] = DayHours{Start: "09:00", End: "17:00"}
+	}
+	return Preferences{
+		TimeZone:     "UTC",
+		WorkingHours: workingHours,
+		WeekStart:    "monday",
+		Locale:       "en-US",
+	}
+}
+
+// GetPreferences returns the user preferences, using the default for the unset ones.
+func (u User) GetPreferences() Preferences {
+	preferences := u.Preferences
+	defaults := DefaultPreferences()
+	if preferences.TimeZone == "" {
+		preferences.TimeZone = defaults.TimeZone
+	}
+	if preferences.WorkingHours == nil {
+		preferences.WorkingHours = defaults.WorkingHours
+	}
+	if preferences.WeekStart == "" {
+		preferences.WeekStart = defaults.WeekStart
+	}
+	if preferences.Locale == "" {
+		preferences.Locale = defaults.Locale
+	}
+	return preferences
+}
+
+// Location returns the preferences time zone, UTC when it is unset or unknown.
+func (p Preferences) Location() *time.Location {
+	loc, err := time.LoadLocation(p.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// FirstWeekday returns the day weeks start on.
+func (p Preferences) FirstWeekday() time.Weekday {
+	return weekdays[p.WeekStart]
+}
+
+// Validate checks that the preferences are well formed.
+func (p Preferences) Validate() error {
+	if _, err := time.LoadLocation(p.TimeZone); err != nil || p.TimeZone == "" || p.TimeZone == "Local" {
+		return fmt.Errorf("timeZone must be a valid IANA time zone e.g Europe/London")
+	}
+	for day, hours := range p.WorkingHours {
+		if _, ok := weekdays[day]; !ok {
+			return fmt.Errorf("%s is not a valid weekday", day)
+		}
+		start, err := ParseClock(hours.Start)
+		if err != nil {
+			return fmt.Errorf("%s working hours start must be a valid time e.g 09:00", day)
+		}
+		end, err := ParseClock(hours.End)
+		if err != nil {
+			return fmt.Errorf("%s working hours end must be a valid time e.g 17:00", day)
+		}
+		if end <= start {
+			return fmt.Errorf("%s working hours must end after they start", day)
+		}
+	}
+	if _, ok := weekdays[p.WeekStart]; !ok {
+		return fmt.Errorf("weekStart must be a weekday e.g monday")
+	}
+	if !localeRegex.MatchString(p.Locale) {
+		return fmt.Errorf("locale must be a valid language tag e.g en-US")
+	}
+	return nil
+}
+
+// ParseWeekday returns the weekday of a lowercase weekday name.
+func ParseWeekday(name string) (time.Weekday, bool) {
+	day, ok := weekdays[name]
+	return day, ok
+}
+
+// ParseClock parses a HH:MM time of day into minutes from midnight.
+func ParseClock(value string) (int, error) {
+	clock, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return clock.Hour()*60 + clock.Minute(), nil
+}
+
+// UpdatePreferences replaces the user preferences.
+func (s *Service) UpdatePreferences(ctx context.Context, userID string, preferences Preferences) (*User, error) {
+	log := s.log.WithContext(ctx).WithField("userId", userID)
+	update := bson.M{"$set": bson.M{"preferences": preferences, "lastUpdated": time.Now()}}
+	_, err := s.dbCollection.UpdateOne(ctx, bson.M{"_id": userID}, update)
+	if err != nil {
+		log.WithError(err).Error("failed to update user preferences in db")
+		return nil, err
+	}
+	return s.GetUser(ctx, userID)
+}
@@ -0,0 +1,175 @@
+package users
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/wisdommatt/todo-list-api/internal/apperror"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxUserAgentLength limits the size of the user agents saved with sessions.
+const maxUserAgentLength = 512
+
+// sessionLastSeenInterval limits how often the last request of a session is
+// saved, clients authenticate every request.
+const sessionLastSeenInterval = time.Minute
+
+// ErrSessionNotFound is returned for sessions which were revoked or do not
+// belong to the user.
+var ErrSessionNotFound = apperror.New(apperror.NotFound, "session_not_found", "session does not exist")
+
+// Client is the client a user logs in from.
+type Client struct {
+	IP        string
+	UserAgent string
+}
+
+// Session is a login of the user on a device, the auth tokens issued for it
+// are revoked when it is deleted.
+type Session struct {
+	ID        string    `json:"id" bson:"_id"`
+	UserID    string    `json:"-" bson:"userId"`
+	Device    string    `json:"device" bson:"device"`
+	IP        string    `json:"ip" bson:"ip"`
+	UserAgent string    `json:"userAgent" bson:"userAgent"`
+	TimeAdded time.Time `json:"timeAdded" bson:"timeAdded"`
+	LastSeen  time.Time `json:"lastSeen" bson:"lastSeen"`
+	// Current is set for the session of the request listing the sessions.
+	Current bool `json:"current" bson:"-"`
+}
+
+// createSession starts a session of the user on the client and returns an
+// auth token for it.
+func (s *Service) createSession(ctx context.Context, user *User, client Client) (string, error) {
+	log := s.log.WithContext(ctx).WithField("userId", user.ID)
+	if len(client.UserAgent) > maxUserAgentLength {
+		client.UserAgent = client.UserAgent[:maxUserAgentLength]
+	}
+	now := time.Now()
+	session := Session{
+		ID:        primitive.NewObjectID().Hex(),
+		UserID:    user.ID,
+		Device:    deviceName(client.UserAgent),
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		TimeAdded: now,
+		LastSeen:  now,
+	}
+	_, err := s.sessionsCollection.InsertOne(ctx, session)
+	if err != nil {
+		log.WithError(err).Error("failed to save session to db")
+		return "", err
+	}
+	return s.GenerateAuthToken(ctx, user.ID, user.defaultWorkspaceID(), session.ID)
+}
+
+// GetSessions returns the sessions of the user, the most recently used first.
+func (s *Service) GetSessions(ctx context.Context, userID string) ([]Session, error) {
+	log := s.log.WithContext(ctx).WithField("userId", userID)
+	opts := options.Find().SetSort(bson.M{"lastSeen": -1})
+	cursor, err := s.sessionsCollection.Find(ctx, bson.M{"userId": userID}, opts)
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve sessions from db")
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	sessions := []Session{}
+	err = cursor.All(ctx, &sessions)
+	if err != nil {
+		log.WithError(err).Error("failed to decode retrieved sessions")
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// CheckSession returns ErrSessionNotFound when the session of an auth token
+// was revoked, and saves the last request of the session.
+func (s *Service) CheckSession(ctx context.Context, userID, sessionID string) error {
+	log := s.log.WithContext(ctx).WithField("userId", userID).WithField("sessionId", sessionID)
+	var session Session
+	err := s.sessionsCollection.FindOne(ctx, bson.M{"_id": sessionID, "userId": userID}).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve session from db")
+		return err
+	}
+	if time.Since(session.LastSeen) > sessionLastSeenInterval {
+		_, err = s.sessionsCollection.UpdateOne(ctx, bson.M{"_id": sessionID}, bson.M{"$set": bson.M{"lastSeen": time.Now()}})
+		if err != nil {
+			log.WithError(err).Error("failed to save session last request to db")
+		}
+	}
+	return nil
+}
+
+// DeleteSession revokes the session of the user, its auth tokens are
+// rejected from then on.
+func (s *Service) DeleteSession(ctx context.Context, userID, sessionID string) error {
+	log := s.log.WithContext(ctx).WithField("userId", userID).WithField("sessionId", sessionID)
+	result, err := s.sessionsCollection.DeleteOne(ctx, bson.M{"_id": sessionID, "userId": userID})
+	if err != nil {
+		log.WithError(err).Error("failed to delete session from db")
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// deleteSessions revokes the sessions of the user but keepSessionID, which
+// can be empty.
+func (s *Service) deleteSessions(ctx context.Context, userID, keepSessionID string) error {
+	filter := bson.M{"userId": userID}
+	if keepSessionID != "" {
+		filter["_id"] = bson.M{"$ne": keepSessionID}
+	}
+	_, err := s.sessionsCollection.DeleteMany(ctx, filter)
+	if err != nil {
+		s.log.WithContext(ctx).WithField("userId", userID).WithError(err).Error("failed to delete sessions from db")
+		return err
+	}
+	return nil
+}
+
+// deviceName returns a readable name of the device with the user agent,
+// e.g "Firefox on Windows".
+func deviceName(userAgent string) string {
+	var browser, system string
+	for _, b := range []struct{ token, name string }{
+		// browsers also send the tokens of the browsers they are based on.
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"}, {"Safari/", "Safari"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, platform := range []struct{ token, name string }{
+		{"iPhone", "iPhone"}, {"iPad", "iPad"}, {"Android", "Android"}, {"Windows", "Windows"},
+		{"Mac OS X", "macOS"}, {"CrOS", "ChromeOS"}, {"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, platform.token) {
+			system = platform.name
+			break
+		}
+	}
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "" || system != "":
+		return browser + system
+	}
+	// other clients are usually named by their first product e.g curl/7.79.1.
+	if product := strings.TrimSpace(strings.SplitN(userAgent, "/", 2)[0]); product != "" && len(product) <= 50 {
+		return product
+	}
+	return "Unknown device"
+}
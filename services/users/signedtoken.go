@@ -0,0 +1,57 @@
+package users
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signed tokens are fields signed with the JWT secret, their purpose is
+// part of the signature so a token cannot be used for another purpose.
+const (
+	purposeEmailVerification = "email-verification"
+	purposeMFAChallenge      = "mfa-challenge"
+)
+
+// signedToken returns a token with the fields, valid until expires.
+func signedToken(purpose string, fields []string, expires time.Time) string {
+	fields = append(fields, strconv.FormatInt(expires.Unix(), 10))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(strings.Join(fields, "\n")))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signPayload(purpose, payload))
+}
+
+// parseSignedToken returns the fields of a token, it returns false when the
+// token is not a valid token of the purpose with n fields or when it expired.
+func parseSignedToken(purpose, token string, n int) ([]string, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, signPayload(purpose, parts[0])) {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, false
+	}
+	fields := strings.Split(string(payload), "\n")
+	if len(fields) != n+1 {
+		return nil, false
+	}
+	expires, err := strconv.ParseInt(fields[n], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return nil, false
+	}
+	return fields[:n], true
+}
+
+func signPayload(purpose, payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET")))
+	mac.Write([]byte(purpose + "\n" + payload))
+	return mac.Sum(nil)
+}
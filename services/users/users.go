@@ -2,12 +2,15 @@ package users
 
 import (
 	"context"
-	"fmt"
 	"os"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/wisdommatt/todo-list-api/internal/apperror"
 	"github.com/wisdommatt/todo-list-api/internal/jwt"
+	"github.com/wisdommatt/todo-list-api/internal/mailer"
+	"github.com/wisdommatt/todo-list-api/internal/oidc"
+	"github.com/wisdommatt/todo-list-api/internal/tenant"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -16,29 +19,83 @@ import (
 )
 
 type User struct {
-	ID          string    `json:"id" bson:"_id,omitempty"`
-	FirstName   string    `json:"firstName" bson:"firstName,omitempty"`
-	LastName    string    `json:"lastName" bson:"lastName,omitempty"`
-	Email       string    `json:"email" bson:"email,omitempty"`
-	Password    string    `json:"-" bson:"password,omitempty"`
-	TimeAdded   time.Time `json:"timeAdded" bson:"timeAdded,omitempty"`
-	LastUpdated time.Time `json:"-" bson:"lastUpdated,omitempty"`
+	ID        string `json:"id" bson:"_id,omitempty"`
+	FirstName string `json:"firstName" bson:"firstName,omitempty"`
+	LastName  string `json:"lastName" bson:"lastName,omitempty"`
+	Email     string `json:"email" bson:"email,omitempty"`
+	Handle    string `json:"handle,omitempty" bson:"handle,omitempty"`
+	Password  string `json:"-" bson:"password,omitempty"`
+	// Role is RoleAdmin for the admins of the api, who are set in the db.
+	Role string `json:"role,omitempty" bson:"role,omitempty"`
+	// WorkspaceIDs are the workspaces the user is a member of.
+	WorkspaceIDs []string      `json:"workspaceIds" bson:"workspaceIds,omitempty"`
+	Preferences  Preferences   `json:"preferences" bson:"preferences,omitempty"`
+	CalendarFeed *CalendarFeed `json:"-" bson:"calendarFeed,omitempty"`
+	AppPasswords []AppPassword `json:"-" bson:"appPasswords,omitempty"`
+	AccessTokens []AccessToken `json:"-" bson:"accessTokens,omitempty"`
+	// EmailVerification is set until the user verifies their email.
+	EmailVerification *EmailVerification `json:"emailVerification,omitempty" bson:"emailVerification,omitempty"`
+	EmailVerifiedAt   time.Time          `json:"emailVerifiedAt" bson:"emailVerifiedAt,omitempty"`
+	PasswordReset     *PasswordReset     `json:"-" bson:"passwordReset,omitempty"`
+	MFA               *MFA               `json:"mfa,omitempty" bson:"mfa,omitempty"`
+	// Identities are the identity provider accounts linked to the user.
+	Identities []Identity `json:"identities,omitempty" bson:"identities,omitempty"`
+	// TokensValidAfter revokes the auth tokens issued before it.
+	TokensValidAfter time.Time `json:"-" bson:"tokensValidAfter,omitempty"`
+	TimeAdded        time.Time `json:"timeAdded" bson:"timeAdded,omitempty"`
+	LastUpdated      time.Time `json:"-" bson:"lastUpdated,omitempty"`
 }
 
+const (
+	// RoleAdmin is the role of the users administrating the api e.g unlocking accounts.
+	RoleAdmin = "admin"
+	// RoleUser is the role of the other users, it is not stored.
+	RoleUser = "user"
+)
+
+var (
+	// ErrUserNotFound is returned when the user does not exist or is not in the selected workspace.
+	ErrUserNotFound = apperror.New(apperror.NotFound, "user_not_found", "user does not exist")
+	// ErrInvalidCredentials is returned when the email or password is wrong.
+	ErrInvalidCredentials = apperror.New(apperror.Unauthenticated, "invalid_credentials", "invalid credentials")
+	// ErrEmailTaken is returned when the email belongs to another user.
+	ErrEmailTaken = apperror.New(apperror.Conflict, "email_taken", "a user with this email already exists")
+	// ErrHandleTaken is returned when the handle belongs to another user.
+	ErrHandleTaken = apperror.New(apperror.Conflict, "handle_taken", "a user with this handle already exists")
+)
+
 type Service struct {
-	log          *logrus.Logger
-	dbCollection *mongo.Collection
+	log                     *logrus.Logger
+	dbCollection            *mongo.Collection
+	loginAttemptsCollection *mongo.Collection
+	settingsCollection      *mongo.Collection
+	oidcLoginsCollection    *mongo.Collection
+	sessionsCollection      *mongo.Collection
+	mfaPolicy               mfaPolicyCache
+	oidcProviders           map[string]*oidc.Provider
+	mailer                  mailer.Mailer
 }
 
-func NewUsersService(db *mongo.Database, log *logrus.Logger) *Service {
+// NewUsersService returns the users service, account emails are sent with
+// the mailer.
+func NewUsersService(mailSender mailer.Mailer, db *mongo.Database, log *logrus.Logger) *Service {
 	return &Service{
-		log:          log,
-		dbCollection: db.Collection("users"),
+		log:                     log,
+		dbCollection:            db.Collection("users"),
+		loginAttemptsCollection: db.Collection("loginAttempts"),
+		settingsCollection:      db.Collection("settings"),
+		oidcLoginsCollection:    db.Collection("oidcLogins"),
+		sessionsCollection:      db.Collection("sessions"),
+		mailer:                  mailSender,
 	}
 }
 
 func (s *Service) CreateUser(ctx context.Context, user User) (*User, error) {
 	log := s.log.WithContext(ctx).WithField("user", user)
+	err := s.checkEmailAndHandle(ctx, user.Email, user.Handle)
+	if err != nil {
+		return nil, err
+	}
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 	if err != nil {
 		log.WithError(err).Error("cannot generate password from hash")
@@ -48,6 +105,7 @@ func (s *Service) CreateUser(ctx context.Context, user User) (*User, error) {
 	user.ID = primitive.NewObjectID().Hex()
 	user.TimeAdded = time.Now()
 	user.LastUpdated = time.Now()
+	user.EmailVerification = &EmailVerification{Email: user.Email}
 	_, err = s.dbCollection.InsertOne(ctx, user)
 	if err != nil {
 		log.WithError(err).Error("cannot save user to db")
@@ -56,10 +114,38 @@ func (s *Service) CreateUser(ctx context.Context, user User) (*User, error) {
 	return &user, nil
 }
 
+// checkEmailAndHandle returns ErrEmailTaken or ErrHandleTaken when the email
+// or handle belongs to a user of any workspace, empty values are not checked.
+func (s *Service) checkEmailAndHandle(ctx context.Context, email, handle string) error {
+	ctx = tenant.WithWorkspace(ctx, "")
+	if email != "" {
+		_, err := s.GetUserByEmail(ctx, email)
+		if err == nil {
+			return ErrEmailTaken
+		}
+		if err != ErrUserNotFound {
+			return err
+		}
+	}
+	if handle != "" {
+		_, err := s.GetUserByHandle(ctx, handle)
+		if err == nil {
+			return ErrHandleTaken
+		}
+		if err != ErrUserNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *Service) GetUser(ctx context.Context, userID string) (*User, error) {
 	var user User
 	log := s.log.WithContext(ctx).WithField("userId", userID)
-	err := s.dbCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	err := s.dbCollection.FindOne(ctx, tenant.Filter(ctx, "workspaceIds", bson.M{"_id": userID})).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUserNotFound
+	}
 	if err != nil {
 		log.WithError(err).Error("cannot retrieve user from db by id")
 		return nil, err
@@ -70,7 +156,10 @@ func (s *Service) GetUser(ctx context.Context, userID string) (*User, error) {
 func (s *Service) GetUserByEmail(ctx context.Context, email string) (*User, error) {
 	var user User
 	log := s.log.WithContext(ctx).WithField("email", email)
-	err := s.dbCollection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	err := s.dbCollection.FindOne(ctx, tenant.Filter(ctx, "workspaceIds", bson.M{"email": email})).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUserNotFound
+	}
 	if err != nil {
 		log.WithError(err).Error("cannot retrieve user from db by email")
 		return nil, err
@@ -78,9 +167,23 @@ func (s *Service) GetUserByEmail(ctx context.Context, email string) (*User, erro
 	return &user, nil
 }
 
+func (s *Service) GetUserByHandle(ctx context.Context, handle string) (*User, error) {
+	var user User
+	log := s.log.WithContext(ctx).WithField("handle", handle)
+	err := s.dbCollection.FindOne(ctx, tenant.Filter(ctx, "workspaceIds", bson.M{"handle": handle})).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		log.WithError(err).Error("cannot retrieve user from db by handle")
+		return nil, err
+	}
+	return &user, nil
+}
+
 func (s *Service) GetUsers(ctx context.Context, lastID string, limit int) ([]User, error) {
 	log := s.log.WithContext(ctx).WithField("lastId", lastID).WithField("limit", limit)
-	filter := bson.M{"_id": bson.M{"$gt": lastID}}
+	filter := tenant.Filter(ctx, "workspaceIds", bson.M{"_id": bson.M{"$gt": lastID}})
 	findOpt := options.Find().SetLimit(int64(limit))
 	cursor, err := s.dbCollection.Find(ctx, filter, findOpt)
 	if err != nil {
@@ -97,34 +200,177 @@ func (s *Service) GetUsers(ctx context.Context, lastID string, limit int) ([]Use
 	return users, nil
 }
 
+// UpdateUser updates the first name, last name and email of the user, the
+// other fields of update are ignored. Changing the email requires the
+// current password and the new email must be verified again, ErrEmailTaken
+// is returned when it belongs to another user.
+func (s *Service) UpdateUser(ctx context.Context, userID, currentPassword string, update User) (*User, error) {
+	log := s.log.WithContext(ctx).WithField("userId", userID).WithField("update", update)
+	user, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	// only the set fields are updated, the user struct cannot be used as
+	// its preferences are never omitted.
+	fields := bson.M{"lastUpdated": time.Now()}
+	if update.FirstName != "" {
+		fields["firstName"] = update.FirstName
+	}
+	if update.LastName != "" {
+		fields["lastName"] = update.LastName
+	}
+	updateBSON := bson.M{"$set": fields}
+	if update.Email != "" && update.Email != user.Email {
+		// the email receives password resets, so a stolen session must not
+		// be enough to take over the account by changing it.
+		if currentPassword == "" {
+			return nil, ErrCurrentPasswordRequired
+		}
+		err = s.checkCurrentPassword(ctx, user, currentPassword)
+		if err != nil {
+			return nil, err
+		}
+		err = s.checkEmailAndHandle(ctx, update.Email, "")
+		if err != nil {
+			return nil, err
+		}
+		fields["email"] = update.Email
+		fields["emailVerification"] = EmailVerification{Email: update.Email}
+		updateBSON["$unset"] = bson.M{"emailVerifiedAt": ""}
+	}
+	_, err = s.dbCollection.UpdateOne(ctx, bson.M{"_id": userID}, updateBSON)
+	if err != nil {
+		log.WithError(err).Error("failed to update user in db")
+		return nil, err
+	}
+	return s.GetUser(ctx, userID)
+}
+
 func (s *Service) DeleteUser(ctx context.Context, userID string) (*User, error) {
 	log := s.log.WithContext(ctx).WithField("userId", userID)
-	filter := bson.M{"_id": userID}
+	filter := tenant.Filter(ctx, "workspaceIds", bson.M{"_id": userID})
 	var deletedUser User
 	err := s.dbCollection.FindOneAndDelete(ctx, filter).Decode(&deletedUser)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUserNotFound
+	}
 	if err != nil {
 		log.WithError(err).Error("failed to delete user from db")
 		return nil, err
 	}
+	s.deleteSessions(ctx, userID, "")
 	return &deletedUser, nil
 }
 
-func (s *Service) LoginUser(ctx context.Context, email, password string) (*User, string, error) {
-	var errInvalidCredentials = fmt.Errorf("invalid credentials")
-	log := s.log.WithField("email", email)
+// LoginUser returns the user with the email and password and an auth token
+// of a new session on the client. When the user enabled two-factor authentication, a challenge token to
+// complete the login with CompleteMFAChallenge is returned instead.
+// Failed attempts of the email and of the client ip are slowed down
+// exponentially and lock the account after too many of them, in which case
+// ErrLoginThrottled or ErrAccountLocked is returned.
+func (s *Service) LoginUser(ctx context.Context, email, password string, client Client) (*User, string, error) {
+	ip := client.IP
+	err := s.checkLoginAllowed(ctx, email, ip)
+	if err != nil {
+		return nil, "", err
+	}
 	userWithEmail, err := s.GetUserByEmail(ctx, email)
+	if err == ErrUserNotFound {
+		// unknown emails are counted too, so they cannot be told apart.
+		s.recordLoginFailure(ctx, email, ip)
+		return nil, "", ErrInvalidCredentials
+	}
 	if err != nil {
-		return nil, "", errInvalidCredentials
+		return nil, "", err
 	}
 	err = bcrypt.CompareHashAndPassword([]byte(userWithEmail.Password), []byte(password))
 	if err != nil {
-		log.WithError(err).Error("failed to compare password with hash")
-		return nil, "", errInvalidCredentials
+		s.recordLoginFailure(ctx, email, ip)
+		return nil, "", ErrInvalidCredentials
+	}
+	if userWithEmail.IsMFAEnabled() {
+		// the failures are cleared once the second factor is checked too.
+		return userWithEmail, mfaChallengeToken(userWithEmail.ID), nil
 	}
-	authToken, err := jwt.Encode([]byte(os.Getenv("JWT_SECRET")), jwt.Payload{UserID: userWithEmail.ID})
+	// the ip failures are kept, an attacker could log in to their own
+	// account to reset them.
+	s.clearLoginFailures(ctx, email)
+	authToken, err := s.createSession(ctx, userWithEmail, client)
 	if err != nil {
-		log.WithError(err).Error("failed to encode jwt token")
 		return nil, "", err
 	}
 	return userWithEmail, authToken, nil
 }
+
+// GenerateAuthToken returns an auth token of the session for the user with
+// workspaceID as the selected workspace.
+func (s *Service) GenerateAuthToken(ctx context.Context, userID, workspaceID, sessionID string) (string, error) {
+	authToken, err := jwt.Encode([]byte(os.Getenv("JWT_SECRET")), jwt.Payload{
+		UserID:      userID,
+		WorkspaceID: workspaceID,
+		SessionID:   sessionID,
+		IssuedAt:    time.Now(),
+	})
+	if err != nil {
+		s.log.WithContext(ctx).WithField("userId", userID).WithError(err).Error("failed to encode jwt token")
+		return "", err
+	}
+	return authToken, nil
+}
+
+// AddUserToWorkspace makes the user a member of the workspace.
+func (s *Service) AddUserToWorkspace(ctx context.Context, userID, workspaceID string) error {
+	log := s.log.WithContext(ctx).WithField("userId", userID).WithField("workspaceId", workspaceID)
+	update := bson.M{"$addToSet": bson.M{"workspaceIds": workspaceID}, "$set": bson.M{"lastUpdated": time.Now()}}
+	_, err := s.dbCollection.UpdateOne(ctx, bson.M{"_id": userID}, update)
+	if err != nil {
+		log.WithError(err).Error("failed to add user to workspace in db")
+		return err
+	}
+	return nil
+}
+
+// RemoveUserFromWorkspace removes the user from the workspace members.
+func (s *Service) RemoveUserFromWorkspace(ctx context.Context, userID, workspaceID string) error {
+	log := s.log.WithContext(ctx).WithField("userId", userID).WithField("workspaceId", workspaceID)
+	update := bson.M{"$pull": bson.M{"workspaceIds": workspaceID}, "$set": bson.M{"lastUpdated": time.Now()}}
+	_, err := s.dbCollection.UpdateOne(ctx, bson.M{"_id": userID}, update)
+	if err != nil {
+		log.WithError(err).Error("failed to remove user from workspace in db")
+		return err
+	}
+	return nil
+}
+
+// IsAdmin reports whether the user administrates the api.
+func (u User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// GetRole returns the role of the user, RoleUser when none is set.
+func (u User) GetRole() string {
+	if u.Role == "" {
+		return RoleUser
+	}
+	return u.Role
+}
+
+// defaultWorkspaceID returns the workspace selected by the auth tokens of
+// the user, other workspaces are selected per request with the
+// X-Workspace-ID header.
+func (u User) defaultWorkspaceID() string {
+	if len(u.WorkspaceIDs) > 0 {
+		return u.WorkspaceIDs[0]
+	}
+	return ""
+}
+
+// IsWorkspaceMember reports whether the user belongs to the workspace.
+func (u User) IsWorkspaceMember(workspaceID string) bool {
+	for _, id := range u.WorkspaceIDs {
+		if id == workspaceID {
+			return true
+		}
+	}
+	return false
+}
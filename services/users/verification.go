@@ -0,0 +1,158 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/wisdommatt/todo-list-api/internal/apperror"
+	"github.com/wisdommatt/todo-list-api/internal/mailer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// verificationTokenLifetime is how long a verification email can be used.
+const verificationTokenLifetime = 24 * time.Hour
+
+// Verification emails are throttled to one every verificationResendInterval
+// and maxVerificationEmails every verificationWindow.
+const (
+	verificationResendInterval = time.Minute
+	verificationWindow         = 24 * time.Hour
+	maxVerificationEmails      = 5
+)
+
+var (
+	// ErrInvalidVerificationToken is returned for invalid, expired or used
+	// verification tokens.
+	ErrInvalidVerificationToken = apperror.New(apperror.Invalid, "invalid_verification_token", "invalid or expired verification token")
+	// ErrEmailAlreadyVerified is returned when sending a verification email
+	// to a verified user.
+	ErrEmailAlreadyVerified = apperror.New(apperror.Conflict, "email_already_verified", "email is already verified")
+	// ErrVerificationThrottled is returned when too many verification emails
+	// were sent to the user recently.
+	ErrVerificationThrottled = apperror.New(apperror.RateLimited, "verification_throttled", "a verification email was sent recently, please try again later")
+)
+
+// EmailVerification is the pending verification of the user email, users
+// without one are verified. Users created before email verification existed
+// have neither a verification nor EmailVerifiedAt and are verified.
+type EmailVerification struct {
+	Email  string    `json:"email" bson:"email"`
+	SentAt time.Time `json:"sentAt" bson:"sentAt,omitempty"`
+	// SentCount is the number of emails sent since WindowStart.
+	SentCount   int       `json:"-" bson:"sentCount"`
+	WindowStart time.Time `json:"-" bson:"windowStart,omitempty"`
+}
+
+// IsEmailVerified reports whether the user verified their email.
+func (u User) IsEmailVerified() bool {
+	return u.EmailVerification == nil
+}
+
+// SendVerificationEmail sends an email with a verification token to the
+// user, ErrVerificationThrottled is returned when too many were sent.
+func (s *Service) SendVerificationEmail(ctx context.Context, user *User) error {
+	log := s.log.WithContext(ctx).WithField("userId", user.ID)
+	if user.IsEmailVerified() {
+		return ErrEmailAlreadyVerified
+	}
+	now := time.Now()
+	verification := *user.EmailVerification
+	if now.Sub(verification.SentAt) < verificationResendInterval {
+		return ErrVerificationThrottled
+	}
+	if now.Sub(verification.WindowStart) >= verificationWindow {
+		verification.WindowStart = now
+		verification.SentCount = 0
+	}
+	if verification.SentCount >= maxVerificationEmails {
+		return ErrVerificationThrottled
+	}
+	verification.SentCount++
+	verification.SentAt = now
+
+	// the email is reserved before it is sent, so concurrent requests cannot
+	// both send one.
+	filter := bson.M{"_id": user.ID, "emailVerification.email": verification.Email}
+	if user.EmailVerification.SentAt.IsZero() {
+		filter["emailVerification.sentAt"] = bson.M{"$exists": false}
+	} else {
+		filter["emailVerification.sentAt"] = user.EmailVerification.SentAt
+	}
+	result, err := s.dbCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"emailVerification": verification}})
+	if err != nil {
+		log.WithError(err).Error("failed to save email verification to db")
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrVerificationThrottled
+	}
+	user.EmailVerification = &verification
+
+	token := verificationToken(user.ID, verification.Email, now.Add(verificationTokenLifetime))
+	err = s.mailer.Send(ctx, mailer.Message{
+		To:      verification.Email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nPlease verify your email by opening the link below:\n\n%s\n\n"+
+				"Or send this token to POST /users/verify:\n\n%s\n\nThe link expires in 24 hours.\n",
+			user.FirstName, appURL()+"/verify-email?token="+token, token,
+		),
+	})
+	if err != nil {
+		log.WithError(err).Error("failed to send verification email")
+		return err
+	}
+	return nil
+}
+
+// VerifyEmail marks the email of the verification token as verified and
+// returns its user. Tokens cannot be used again, or after the user email
+// changed.
+func (s *Service) VerifyEmail(ctx context.Context, token string) (*User, error) {
+	log := s.log.WithContext(ctx)
+	userID, email, err := parseVerificationToken(token)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"_id": userID, "email": email, "emailVerification.email": email}
+	now := time.Now()
+	update := bson.M{
+		"$set":   bson.M{"emailVerifiedAt": now, "lastUpdated": now},
+		"$unset": bson.M{"emailVerification": ""},
+	}
+	var user User
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = s.dbCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInvalidVerificationToken
+	}
+	if err != nil {
+		log.WithError(err).WithField("userId", userID).Error("failed to verify user email in db")
+		return nil, err
+	}
+	return &user, nil
+}
+
+// verificationToken returns a token for the verification of the user email
+// until expires.
+func verificationToken(userID, email string, expires time.Time) string {
+	return signedToken(purposeEmailVerification, []string{userID, email}, expires)
+}
+
+func parseVerificationToken(token string) (userID, email string, err error) {
+	fields, ok := parseSignedToken(purposeEmailVerification, token, 2)
+	if !ok {
+		return "", "", ErrInvalidVerificationToken
+	}
+	return fields[0], fields[1], nil
+}
+
+// appURL returns the base url of the app used in email links.
+func appURL() string {
+	return strings.TrimSuffix(os.Getenv("APP_URL"), "/")
+}
@@ -0,0 +1,313 @@
+package workspaces
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/wisdommatt/todo-list-api/internal/apperror"
+	"github.com/wisdommatt/todo-list-api/internal/tenant"
+	"github.com/wisdommatt/todo-list-api/services/notifications"
+	"github.com/wisdommatt/todo-list-api/services/users"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Workspace roles, from the least to the most privileged.
+const (
+	RoleMember = "member"
+	RoleAdmin  = "admin"
+	RoleOwner  = "owner"
+)
+
+// Member statuses.
+const (
+	StatusInvited = "invited"
+	StatusActive  = "active"
+)
+
+var (
+	// ErrWorkspaceNotFound is returned when the workspace does not exist.
+	ErrWorkspaceNotFound = apperror.New(apperror.NotFound, "workspace_not_found", "workspace does not exist")
+	// ErrMemberNotFound is returned when the user is not an active member of
+	// the workspace or the membership does not exist.
+	ErrMemberNotFound = apperror.New(apperror.NotFound, "member_not_found", "member does not exist")
+	// ErrInvitationNotFound is returned when accepting an invitation the user did not receive.
+	ErrInvitationNotFound = apperror.New(apperror.NotFound, "invitation_not_found", "invitation does not exist")
+)
+
+var roleRanks = map[string]int{
+	RoleMember: 1,
+	RoleAdmin:  2,
+	RoleOwner:  3,
+}
+
+// Workspace is a team sharing one deployment, users and tasks are scoped to a workspace.
+type Workspace struct {
+	ID        string    `json:"id" bson:"_id,omitempty"`
+	Name      string    `json:"name" bson:"name,omitempty"`
+	OwnerID   string    `json:"ownerId" bson:"ownerId,omitempty"`
+	TimeAdded time.Time `json:"timeAdded" bson:"timeAdded,omitempty"`
+}
+
+// Member is a workspace membership, invited members are identified by
+// email until they accept the invitation.
+type Member struct {
+	ID          string    `json:"id" bson:"_id,omitempty"`
+	WorkspaceID string    `json:"workspaceId" bson:"workspaceId,omitempty"`
+	UserID      string    `json:"userId,omitempty" bson:"userId,omitempty"`
+	Email       string    `json:"email" bson:"email,omitempty"`
+	Role        string    `json:"role" bson:"role,omitempty"`
+	Status      string    `json:"status" bson:"status,omitempty"`
+	InvitedBy   string    `json:"invitedBy,omitempty" bson:"invitedBy,omitempty"`
+	TimeAdded   time.Time `json:"timeAdded" bson:"timeAdded,omitempty"`
+}
+
+// IsValidRole reports whether role is a known workspace role.
+func IsValidRole(role string) bool {
+	return roleRanks[role] > 0
+}
+
+// RoleAtLeast reports whether role grants at least the permissions of minRole.
+func RoleAtLeast(role, minRole string) bool {
+	return roleRanks[role] > 0 && roleRanks[role] >= roleRanks[minRole]
+}
+
+type Service struct {
+	usersService         *users.Service
+	notificationsService *notifications.Service
+	dbCollection         *mongo.Collection
+	membersCollection    *mongo.Collection
+	log                  *logrus.Logger
+}
+
+func NewService(usersService *users.Service, notificationsService *notifications.Service, db *mongo.Database, log *logrus.Logger) *Service {
+	return &Service{
+		usersService:         usersService,
+		notificationsService: notificationsService,
+		dbCollection:         db.Collection("workspaces"),
+		membersCollection:    db.Collection("workspaceMembers"),
+		log:                  log,
+	}
+}
+
+// CreateWorkspace creates a workspace owned by the user.
+func (s *Service) CreateWorkspace(ctx context.Context, name string, owner *users.User) (*Workspace, error) {
+	workspace := Workspace{
+		ID:        primitive.NewObjectID().Hex(),
+		Name:      name,
+		OwnerID:   owner.ID,
+		TimeAdded: time.Now(),
+	}
+	log := s.log.WithContext(ctx).WithField("workspace", workspace)
+	_, err := s.dbCollection.InsertOne(ctx, workspace)
+	if err != nil {
+		log.WithError(err).Error("failed to save workspace to db")
+		return nil, err
+	}
+	_, err = s.membersCollection.InsertOne(ctx, Member{
+		ID:          primitive.NewObjectID().Hex(),
+		WorkspaceID: workspace.ID,
+		UserID:      owner.ID,
+		Email:       strings.ToLower(owner.Email),
+		Role:        RoleOwner,
+		Status:      StatusActive,
+		TimeAdded:   time.Now(),
+	})
+	if err != nil {
+		log.WithError(err).Error("failed to save workspace owner to db")
+		return nil, err
+	}
+	err = s.usersService.AddUserToWorkspace(ctx, owner.ID, workspace.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &workspace, nil
+}
+
+func (s *Service) GetWorkspace(ctx context.Context, workspaceID string) (*Workspace, error) {
+	var workspace Workspace
+	log := s.log.WithContext(ctx).WithField("workspaceId", workspaceID)
+	err := s.dbCollection.FindOne(ctx, bson.M{"_id": workspaceID}).Decode(&workspace)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrWorkspaceNotFound
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve workspace from db by id")
+		return nil, err
+	}
+	return &workspace, nil
+}
+
+// GetUserWorkspaces returns the workspaces the user is an active member of.
+func (s *Service) GetUserWorkspaces(ctx context.Context, userID string) ([]Workspace, error) {
+	log := s.log.WithContext(ctx).WithField("userId", userID)
+	members, err := s.findMembers(ctx, bson.M{"userId": userID, "status": StatusActive})
+	if err != nil {
+		return nil, err
+	}
+	workspaceIDs := make([]string, 0, len(members))
+	for _, member := range members {
+		workspaceIDs = append(workspaceIDs, member.WorkspaceID)
+	}
+	cursor, err := s.dbCollection.Find(ctx, bson.M{"_id": bson.M{"$in": workspaceIDs}})
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve workspaces from db")
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var workspaces []Workspace
+	err = cursor.All(ctx, &workspaces)
+	if err != nil {
+		log.WithError(err).Error("failed to decode retrieved workspaces")
+		return nil, err
+	}
+	return workspaces, nil
+}
+
+// GetMember returns the active membership of the user in the workspace.
+func (s *Service) GetMember(ctx context.Context, workspaceID, userID string) (*Member, error) {
+	var member Member
+	log := s.log.WithContext(ctx).WithField("workspaceId", workspaceID).WithField("userId", userID)
+	filter := bson.M{"workspaceId": workspaceID, "userId": userID, "status": StatusActive}
+	err := s.membersCollection.FindOne(ctx, filter).Decode(&member)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrMemberNotFound
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve workspace member from db")
+		return nil, err
+	}
+	return &member, nil
+}
+
+// GetMembers returns the workspace members, including pending invitations.
+func (s *Service) GetMembers(ctx context.Context, workspaceID string) ([]Member, error) {
+	return s.findMembers(ctx, bson.M{"workspaceId": workspaceID})
+}
+
+// GetInvitations returns the pending workspace invitations sent to the email.
+func (s *Service) GetInvitations(ctx context.Context, email string) ([]Member, error) {
+	return s.findMembers(ctx, bson.M{"email": strings.ToLower(email), "status": StatusInvited})
+}
+
+// InviteMember invites a user to the workspace by email, the user joins the
+// workspace once the invitation is accepted.
+func (s *Service) InviteMember(ctx context.Context, workspaceID, email, role, invitedBy string) (*Member, error) {
+	email = strings.ToLower(email)
+	log := s.log.WithContext(ctx).WithField("workspaceId", workspaceID).WithField("email", email)
+	filter := bson.M{"workspaceId": workspaceID, "email": email}
+	var existing Member
+	err := s.membersCollection.FindOne(ctx, filter).Decode(&existing)
+	if err == nil && existing.Status == StatusActive {
+		return nil, fmt.Errorf("%s is already a member of the workspace", email)
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"role":      role,
+			"status":    StatusInvited,
+			"invitedBy": invitedBy,
+		},
+		"$setOnInsert": bson.M{
+			"_id":       primitive.NewObjectID().Hex(),
+			"timeAdded": time.Now(),
+		},
+	}
+	updateOpt := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var member Member
+	err = s.membersCollection.FindOneAndUpdate(ctx, filter, update, updateOpt).Decode(&member)
+	if err != nil {
+		log.WithError(err).Error("failed to save workspace invitation to db")
+		return nil, err
+	}
+	// the invited user is not a member of the workspace yet, so the lookup is unscoped.
+	user, _ := s.usersService.GetUserByEmail(tenant.WithWorkspace(ctx, ""), email)
+	if user != nil {
+		s.notificationsService.CreateNotification(ctx, notifications.Notification{
+			UserID:  user.ID,
+			Type:    notifications.TypeWorkspaceInvitation,
+			Message: fmt.Sprintf("you were invited to join a workspace as %s", role),
+			ActorID: invitedBy,
+		})
+	}
+	return &member, nil
+}
+
+// AcceptInvitation makes the user an active member of the workspace it was invited to.
+func (s *Service) AcceptInvitation(ctx context.Context, workspaceID string, user *users.User) (*Member, error) {
+	log := s.log.WithContext(ctx).WithField("workspaceId", workspaceID).WithField("userId", user.ID)
+	filter := bson.M{"workspaceId": workspaceID, "email": strings.ToLower(user.Email), "status": StatusInvited}
+	update := bson.M{"$set": bson.M{"userId": user.ID, "status": StatusActive}}
+	var member Member
+	err := s.membersCollection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).
+		Decode(&member)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInvitationNotFound
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to accept workspace invitation in db")
+		return nil, err
+	}
+	err = s.usersService.AddUserToWorkspace(ctx, user.ID, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+func (s *Service) GetMemberByID(ctx context.Context, workspaceID, memberID string) (*Member, error) {
+	var member Member
+	log := s.log.WithContext(ctx).WithField("workspaceId", workspaceID).WithField("memberId", memberID)
+	err := s.membersCollection.FindOne(ctx, bson.M{"_id": memberID, "workspaceId": workspaceID}).Decode(&member)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrMemberNotFound
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve workspace member from db by id")
+		return nil, err
+	}
+	return &member, nil
+}
+
+// RemoveMember removes a member or a pending invitation from the workspace.
+func (s *Service) RemoveMember(ctx context.Context, workspaceID, memberID string) (*Member, error) {
+	var member Member
+	log := s.log.WithContext(ctx).WithField("workspaceId", workspaceID).WithField("memberId", memberID)
+	filter := bson.M{"_id": memberID, "workspaceId": workspaceID}
+	err := s.membersCollection.FindOneAndDelete(ctx, filter).Decode(&member)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrMemberNotFound
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to delete workspace member from db")
+		return nil, err
+	}
+	if member.UserID != "" {
+		err = s.usersService.RemoveUserFromWorkspace(ctx, member.UserID, workspaceID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &member, nil
+}
+
+func (s *Service) findMembers(ctx context.Context, filter bson.M) ([]Member, error) {
+	log := s.log.WithContext(ctx).WithField("filter", filter)
+	cursor, err := s.membersCollection.Find(ctx, filter)
+	if err != nil {
+		log.WithError(err).Error("failed to retrieve workspace members from db")
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	var members []Member
+	err = cursor.All(ctx, &members)
+	if err != nil {
+		log.WithError(err).Error("failed to decode retrieved workspace members")
+		return nil, err
+	}
+	return members, nil
+}